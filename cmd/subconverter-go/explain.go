@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/John-Robertt/subconverter-go/internal/httpapi"
+	"github.com/John-Robertt/subconverter-go/internal/model"
+)
+
+// stringList is a repeatable string flag (-sub a -sub b).
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// runExplainCommand implements `subconverter-go explain ...`: it runs the same
+// pipeline as POST /api/explain and prints the JSON report (or error) to stdout.
+func runExplainCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var subs, vars stringList
+	fs.Var(&subs, "sub", "订阅 URL（可重复）")
+	fs.Var(&vars, "var", "覆盖 profile vars 的 NAME=VALUE（可重复，同 /sub 的 var.NAME）")
+	profileURL := fs.String("profile", "", "profile YAML 的 URL")
+	target := fs.String("target", "", "按 target 选取 profile 中带 targets 限定的条目（profile 使用 targets 时必填）")
+	domain := fs.String("domain", "", "要模拟的目标域名")
+	ip := fs.String("ip", "", "要模拟的目标 IP")
+	port := fs.Int("port", 0, "要模拟的目标端口")
+	process := fs.String("process", "", "要模拟的进程名或进程路径")
+	convertTimeout := fs.Duration("convert-timeout", 60*time.Second, "总超时（包含远程拉取）")
+	fetchTimeout := fs.Duration("fetch-timeout", 15*time.Second, "单次远程拉取的超时")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "explain: 不支持的位置参数：%s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+
+	req := httpapi.ExplainRequest{Subs: subs, Profile: *profileURL, Target: *target}
+	for _, kv := range vars {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			fmt.Fprintf(stderr, "explain: -var 需要 NAME=VALUE：%s\n", kv)
+			return 2
+		}
+		if _, dup := req.Vars[name]; dup {
+			fmt.Fprintf(stderr, "explain: -var 重复：%s\n", name)
+			return 2
+		}
+		if req.Vars == nil {
+			req.Vars = make(map[string]string)
+		}
+		req.Vars[name] = value
+	}
+	req.Domain = *domain
+	req.IP = *ip
+	req.Port = *port
+	req.Process = *process
+
+	rep, err := httpapi.Explain(context.Background(), req, httpapi.Options{
+		ConvertTimeout: *convertTimeout,
+		FetchTimeout:   *fetchTimeout,
+	})
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err != nil {
+		_, app := httpapi.ErrorFromErr(err)
+		_ = enc.Encode(model.ErrorResponse{Error: app})
+		return 1
+	}
+	_ = enc.Encode(rep)
+	return 0
+}
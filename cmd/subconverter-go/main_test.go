@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -103,3 +105,108 @@ func TestInitErrorLogStore_FilePathFailsWithContainerHint(t *testing.T) {
 		t.Fatalf("err=%q, want container hint", err.Error())
 	}
 }
+
+// explainUpstream serves one subscription node and a profile; withTargets
+// restricts one rule to clash so the profile uses targets:.
+func explainUpstream(t *testing.T, withTargets bool) *httptest.Server {
+	t.Helper()
+	rule := `  - "DOMAIN-SUFFIX,example.com,PROXY"`
+	if withTargets {
+		rule = "  - rule: \"DOMAIN-SUFFIX,example.com,PROXY\"\n    targets: [clash]"
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sub.txt":
+			_, _ = w.Write([]byte("ss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8388#HK\n"))
+		case "/profile.yaml":
+			_, _ = w.Write([]byte("version: 1\n" +
+				"custom_proxy_group:\n" +
+				"  - \"PROXY`select`[]@all[]DIRECT\"\n" +
+				"rule:\n" + rule + "\n" +
+				"  - \"MATCH,DIRECT\"\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// runExplainJSON runs the explain subcommand and decodes its stdout.
+func runExplainJSON(t *testing.T, args ...string) (int, map[string]any, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := runExplainCommand(args, &stdout, &stderr)
+	var out map[string]any
+	if stdout.Len() > 0 {
+		if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+			t.Fatalf("stdout is not JSON: %v\n%s", err, stdout.String())
+		}
+	}
+	return code, out, stderr.String()
+}
+
+func explainErrorCode(out map[string]any) (string, string) {
+	e, _ := out["error"].(map[string]any)
+	code, _ := e["code"].(string)
+	msg, _ := e["message"].(string)
+	return code, msg
+}
+
+func TestRunExplainCommand_InvalidArguments(t *testing.T) {
+	ts := explainUpstream(t, false)
+	sub, prof := ts.URL+"/sub.txt", ts.URL+"/profile.yaml"
+
+	for name, tc := range map[string]struct {
+		args    []string
+		message string
+	}{
+		"missing profile": {[]string{"-sub", sub, "-domain", "www.example.com"}, "profile"},
+		"no domain or ip": {[]string{"-sub", sub, "-profile", prof}, "domain/ip"},
+		"positional args": {[]string{"-sub", sub, "-profile", prof, "www.example.com"}, ""},
+		"unknown flag":    {[]string{"-nope"}, ""},
+	} {
+		code, out, stderr := runExplainJSON(t, tc.args...)
+		if tc.message == "" {
+			// Flag errors are usage errors on stderr, not JSON.
+			if code != 2 || out != nil || stderr == "" {
+				t.Fatalf("%s: code=%d out=%v stderr=%q", name, code, out, stderr)
+			}
+			continue
+		}
+		got, msg := explainErrorCode(out)
+		if code != 1 || got != "INVALID_ARGUMENT" || !strings.Contains(msg, tc.message) {
+			t.Fatalf("%s: code=%d error=%v", name, code, out)
+		}
+	}
+}
+
+func TestRunExplainCommand_TargetRequired(t *testing.T) {
+	ts := explainUpstream(t, true)
+	args := []string{"-sub", ts.URL + "/sub.txt", "-profile", ts.URL + "/profile.yaml", "-domain", "www.example.com"}
+
+	code, out, _ := runExplainJSON(t, args...)
+	if got, msg := explainErrorCode(out); code != 1 || got != "INVALID_ARGUMENT" || !strings.Contains(msg, "target") {
+		t.Fatalf("code=%d out=%v", code, out)
+	}
+
+	code, out, _ = runExplainJSON(t, append(args, "-target", "clash")...)
+	if code != 0 || out["error"] != nil {
+		t.Fatalf("with target: code=%d out=%v", code, out)
+	}
+}
+
+func TestRunExplainCommand_OK(t *testing.T) {
+	ts := explainUpstream(t, false)
+	code, out, stderr := runExplainJSON(t, "-sub", ts.URL+"/sub.txt", "-profile", ts.URL+"/profile.yaml", "-domain", "www.example.com")
+	if code != 0 || stderr != "" {
+		t.Fatalf("code=%d stderr=%q out=%v", code, stderr, out)
+	}
+	match, _ := out["match"].(map[string]any)
+	if got, want := match["action"], "PROXY"; got != want {
+		t.Fatalf("match.action=%v, want %q (report: %v)", got, want, out)
+	}
+	if got, _ := out["proxies"].([]any); len(got) != 2 || got[0] != "HK" || got[1] != "DIRECT" {
+		t.Fatalf("proxies=%v, want [HK DIRECT]", out["proxies"])
+	}
+}
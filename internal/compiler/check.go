@@ -0,0 +1,79 @@
+package compiler
+
+import (
+	"slices"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/profile"
+)
+
+// CheckOptions configures Check.
+type CheckOptions struct {
+	// Target selects the profile items that apply (see Options.Target).
+	Target string
+
+	// Subs is an optional sample subscription. Without it only the checks
+	// that do not depend on proxy names run.
+	Subs []model.Proxy
+
+	// Ignore lists names declared by profile entries that failed to parse
+	// (profile.Validation.BrokenNames); references to them are not reported.
+	Ignore map[string]struct{}
+	// SkipMatch skips the fallback rule check (a MATCH rule failed to parse).
+	SkipMatch bool
+}
+
+// Check runs the compile-stage reference checks on a parsed profile and
+// returns every problem found, where Compile stops at the first.
+//
+// Rule and ruleset actions are checked against the group names, including the
+// CHAIN-<name> diagnostic groups the proxy_chain entries produce. With a
+// sample subscription, group names are also checked against the proxy names
+// and, when nothing else failed, the profile is compiled against it, so
+// problems only the proxies reveal (empty groups, unknown relay hops, empty
+// chain selections) are reported as well.
+func Check(prof *profile.Spec, opt CheckOptions) []error {
+	view, err := prof.ForTarget(opt.Target)
+	if err != nil {
+		return []error{&CompileError{AppError: model.AppError{
+			Code:    "PROFILE_VALIDATE_ERROR",
+			Message: err.Error(),
+			Stage:   "compile",
+			Hint:    "pass target to pick the definitions for one client",
+		}}}
+	}
+
+	groupNameSet := make(map[string]struct{}, len(view.Groups)+len(view.ProxyChains))
+	for _, g := range view.Groups {
+		groupNameSet[g.Name] = struct{}{}
+	}
+	for _, cs := range view.ProxyChains {
+		groupNameSet[autoDiagnosticGroupName(cs.Proxy)] = struct{}{}
+	}
+
+	var errs []error
+	if len(opt.Subs) > 0 {
+		subs, err := compileSubscriptionProxies(opt.Subs)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			errs = append(errs, groupProxyConflicts(groupNameSet, subs)...)
+		}
+	}
+	errs = append(errs, rulesetActionErrors(groupNameSet, view, opt.Ignore)...)
+	errs = append(errs, ruleErrors(groupNameSet, view, opt.Ignore, opt.SkipMatch)...)
+
+	if len(errs) == 0 && len(opt.Subs) > 0 && len(opt.Ignore) == 0 && !opt.SkipMatch {
+		// Inline ruleset contents are not fetched for a check; they are
+		// validated when converting.
+		sample := *prof
+		sample.Ruleset = slices.Clone(prof.Ruleset)
+		for i := range sample.Ruleset {
+			sample.Ruleset[i].Inline = false
+		}
+		if _, err := CompileWithOptions(opt.Subs, &sample, Options{Target: opt.Target}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -5,10 +5,13 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/John-Robertt/subconverter-go/internal/model"
 	"github.com/John-Robertt/subconverter-go/internal/profile"
+	"github.com/John-Robertt/subconverter-go/internal/rules"
 )
 
 type Result struct {
@@ -16,6 +19,11 @@ type Result struct {
 	Groups      []model.Group
 	Rules       []model.Rule
 	RulesetRefs []RulesetRef
+
+	// Passed through from the profile for the optional template anchors.
+	Hosts          []model.Host
+	MITMHostnames  []string
+	ProxyProviders []model.ProxyProvider
 }
 
 type CompileError struct {
@@ -35,13 +43,35 @@ func (e *CompileError) Error() string {
 
 func (e *CompileError) Unwrap() error { return e.Cause }
 
+// compileErrorAt returns a compile error located at the profile item
+// declared at pos.
+func compileErrorAt(pos profile.Position, app model.AppError) *CompileError {
+	pos.Locate(&app)
+	return &CompileError{AppError: app}
+}
+
 // NormalizeSubscriptionProxies applies v1 determinism rules to subscription proxies:
 // normalization + dedup + deterministic naming + ordering.
 func NormalizeSubscriptionProxies(subs []model.Proxy) ([]model.Proxy, error) {
 	return compileSubscriptionProxies(subs)
 }
 
+// Options carries runtime inputs that are not part of the profile itself.
+type Options struct {
+	// RulesetText maps ruleset URL -> fetched content.
+	// Required for every inline ruleset (profile.RulesetSpec.Inline); ignored for remote ones.
+	RulesetText map[string]string
+
+	// Target selects the profile items that apply (items restricted with
+	// `targets:`). Empty keeps every item.
+	Target string
+}
+
 func Compile(subs []model.Proxy, prof *profile.Spec) (*Result, error) {
+	return CompileWithOptions(subs, prof, Options{})
+}
+
+func CompileWithOptions(subs []model.Proxy, prof *profile.Spec, opt Options) (*Result, error) {
 	if prof == nil {
 		return nil, &CompileError{AppError: model.AppError{
 			Code:    "PROFILE_VALIDATE_ERROR",
@@ -49,6 +79,17 @@ func Compile(subs []model.Proxy, prof *profile.Spec) (*Result, error) {
 			Stage:   "compile",
 		}}
 	}
+	prof, err := prof.ForTarget(opt.Target)
+	if err != nil {
+		return nil, &CompileError{
+			AppError: model.AppError{
+				Code:    "PROFILE_VALIDATE_ERROR",
+				Message: err.Error(),
+				Stage:   "compile",
+				Hint:    "pass target to pick the definitions for one client",
+			},
+		}
+	}
 
 	subProxies, err := compileSubscriptionProxies(subs)
 	if err != nil {
@@ -70,7 +111,7 @@ func Compile(subs []model.Proxy, prof *profile.Spec) (*Result, error) {
 		return nil, err
 	}
 
-	customProxies, err := compileCustomProxies(prof.CustomProxies)
+	customProxies, err := compileCustomProxies(prof.CustomProxies, prof.CustomProxyPos)
 	if err != nil {
 		return nil, err
 	}
@@ -92,19 +133,28 @@ func Compile(subs []model.Proxy, prof *profile.Spec) (*Result, error) {
 	allProxies = append(allProxies, subProxies...)
 	allProxies = append(allProxies, derivedProxies...)
 
+	relayProxies, err := compileRelayCustomProxies(customProxies, prof.Groups, userGroupNameSet, allProxies)
+	if err != nil {
+		return nil, err
+	}
+	allProxies = append(allProxies, relayProxies...)
+
 	userGroups, err := compileGroups(allProxies, subProxies, prof.Groups, false)
 	if err != nil {
 		return nil, err
 	}
 	autoGroups := buildDiagnosticGroups(customProxies, derivedByCustom)
 	groups := append(userGroups, autoGroups...)
+	if err := checkDialerCycles(prof.ProxyChains, allProxies, groups); err != nil {
+		return nil, err
+	}
 
 	groupNameSet := make(map[string]struct{}, len(groups))
 	for _, g := range groups {
 		groupNameSet[g.Name] = struct{}{}
 	}
 
-	rulesOut, rulesetRefs, err := compileRules(groupNameSet, prof)
+	rulesOut, rulesetRefs, err := compileRules(groupNameSet, prof, opt.RulesetText)
 	if err != nil {
 		return nil, err
 	}
@@ -114,6 +164,10 @@ func Compile(subs []model.Proxy, prof *profile.Spec) (*Result, error) {
 		Groups:      groups,
 		Rules:       rulesOut,
 		RulesetRefs: rulesetRefs,
+
+		Hosts:          prof.Hosts,
+		MITMHostnames:  prof.MITMHostnames,
+		ProxyProviders: prof.ProxyProviders,
 	}, nil
 }
 
@@ -121,6 +175,15 @@ type RulesetRef struct {
 	Raw    string
 	Action string
 	URL    string
+
+	// Provider hints from the directive; empty/zero means the target default.
+	Behavior    string
+	Format      string
+	IntervalSec int
+
+	// Inline rulesets are rendered as their expanded Rules (in place of a RULE-SET reference).
+	Inline bool
+	Rules  []model.Rule
 }
 
 func compileSubscriptionProxies(in []model.Proxy) ([]model.Proxy, error) {
@@ -182,20 +245,21 @@ func compileSubscriptionProxies(in []model.Proxy) ([]model.Proxy, error) {
 	return deduped, nil
 }
 
-func compileCustomProxies(in []model.Proxy) ([]model.Proxy, error) {
+// compileCustomProxies normalizes the custom proxies; pos is aligned with in
+// (see profile.Spec.CustomProxyPos).
+func compileCustomProxies(in []model.Proxy, pos []profile.Position) ([]model.Proxy, error) {
 	out := make([]model.Proxy, 0, len(in))
-	for _, p := range in {
+	for i, p := range in {
 		p2, err := normalizeCustomProxy(p)
 		if err != nil {
-			return nil, &CompileError{
-				AppError: model.AppError{
-					Code:    "CUSTOM_PROXY_VALIDATE_ERROR",
-					Message: "custom_proxy 字段不合法",
-					Stage:   "compile",
-					Snippet: p.Name,
-				},
-				Cause: err,
-			}
+			ce := compileErrorAt(profile.PositionAt(pos, i), model.AppError{
+				Code:    "CUSTOM_PROXY_VALIDATE_ERROR",
+				Message: "custom_proxy 字段不合法",
+				Stage:   "compile",
+				Snippet: p.Name,
+			})
+			ce.Cause = err
+			return nil, ce
 		}
 		out = append(out, p2)
 	}
@@ -231,6 +295,7 @@ func normalizeSubscriptionProxy(p model.Proxy) (model.Proxy, error) {
 
 	p.Username = ""
 	p.ViaProxyID = ""
+	p.ViaGroup = ""
 	p.PluginName = strings.TrimSpace(p.PluginName)
 	if len(p.PluginOpts) > 0 {
 		opts := make([]model.KV, 0, len(p.PluginOpts))
@@ -252,6 +317,7 @@ func normalizeCustomProxy(p model.Proxy) (model.Proxy, error) {
 	p.Password = strings.TrimSpace(p.Password)
 	p.PluginName = strings.TrimSpace(p.PluginName)
 	p.ViaProxyID = ""
+	p.ViaGroup = ""
 	if p.Name == "" {
 		return model.Proxy{}, errors.New("empty name")
 	}
@@ -362,6 +428,15 @@ func derivedProxyID(p model.Proxy, viaProxyID string) string {
 }
 
 func compileGroups(matchProxies []model.Proxy, allProxyRefs []model.Proxy, groupSpecs []profile.GroupSpec, allowEmpty bool) ([]model.Group, error) {
+	groupNames := make(map[string]struct{}, len(groupSpecs))
+	for _, gs := range groupSpecs {
+		groupNames[gs.Name] = struct{}{}
+	}
+	proxyIDByName := make(map[string]string, len(matchProxies))
+	for _, p := range matchProxies {
+		proxyIDByName[p.Name] = p.ID
+	}
+
 	out := make([]model.Group, 0, len(groupSpecs))
 	for _, gs := range groupSpecs {
 		switch gs.Type {
@@ -369,13 +444,26 @@ func compileGroups(matchProxies []model.Proxy, allProxyRefs []model.Proxy, group
 			var members []model.MemberRef
 			if len(gs.Members) > 0 {
 				members = make([]model.MemberRef, 0, len(gs.Members)+len(allProxyRefs))
-				for _, m := range gs.Members {
-					if m == "@all" {
+				for i, m := range gs.Members {
+					var re *regexp.Regexp
+					if i < len(gs.MemberRegex) {
+						re = gs.MemberRegex[i]
+					}
+					switch {
+					case m == profile.SelectMemberAll:
 						members = append(members, allProxyMemberRefs(allProxyRefs)...)
-						continue
+					case re != nil && strings.HasPrefix(m, profile.SelectMemberAllExceptPrefix):
+						// Like @all (subscription proxies only), minus the names matching re.
+						members = append(members, filterProxyMemberRefs(allProxyRefs, re, false)...)
+					case re != nil:
+						// Like the regex form: any final proxy, derived ones included.
+						members = append(members, filterProxyMemberRefs(matchProxies, re, true)...)
+					default:
+						members = append(members, explicitMemberRef(m))
 					}
-					members = append(members, explicitMemberRef(m))
 				}
+				// A proxy may be picked up by several members; keep its first position.
+				members = uniqueMemberRefs(members)
 			} else if gs.Regex != nil {
 				members = make([]model.MemberRef, 0)
 				for _, p := range matchProxies {
@@ -385,15 +473,15 @@ func compileGroups(matchProxies []model.Proxy, allProxyRefs []model.Proxy, group
 				}
 			}
 			if len(members) == 0 && !allowEmpty {
-				return nil, &CompileError{AppError: model.AppError{
+				return nil, compileErrorAt(gs.Pos, model.AppError{
 					Code:    "GROUP_PARSE_ERROR",
 					Message: fmt.Sprintf("select 组为空：%s", gs.Name),
 					Stage:   "compile",
 					Snippet: gs.Raw,
-				}}
+				})
 			}
-			out = append(out, model.Group{Name: gs.Name, Type: "select", Members: members})
-		case "url-test":
+			out = append(out, model.Group{Name: gs.Name, Type: "select", Members: members, Options: gs.Options})
+		case "url-test", "fallback", "load-balance":
 			members := make([]model.MemberRef, 0)
 			for _, p := range matchProxies {
 				if gs.Regex != nil && gs.Regex.MatchString(p.Name) {
@@ -401,29 +489,55 @@ func compileGroups(matchProxies []model.Proxy, allProxyRefs []model.Proxy, group
 				}
 			}
 			if len(members) == 0 && !allowEmpty {
-				return nil, &CompileError{AppError: model.AppError{
+				return nil, compileErrorAt(gs.Pos, model.AppError{
 					Code:    "GROUP_PARSE_ERROR",
-					Message: fmt.Sprintf("url-test 组匹配为空：%s", gs.Name),
+					Message: fmt.Sprintf("%s 组匹配为空：%s", gs.Type, gs.Name),
 					Stage:   "compile",
 					Snippet: gs.Raw,
-				}}
+				})
 			}
 			out = append(out, model.Group{
 				Name:         gs.Name,
-				Type:         "url-test",
+				Type:         gs.Type,
 				Members:      members,
 				TestURL:      gs.TestURL,
 				IntervalSec:  gs.IntervalSec,
 				ToleranceMS:  gs.ToleranceMS,
 				HasTolerance: gs.HasTolerance,
+				Strategy:     gs.Strategy,
+				Options:      gs.Options,
 			})
+		case "relay":
+			members := make([]model.MemberRef, 0, len(gs.Members))
+			for _, hop := range gs.Members {
+				if _, ok := groupNames[hop]; ok {
+					members = append(members, model.MemberRef{Kind: model.MemberRefGroup, Value: hop})
+					continue
+				}
+				if id, ok := proxyIDByName[hop]; ok {
+					members = append(members, proxyMemberRef(id))
+					continue
+				}
+				if allowEmpty {
+					// Pre-pass: derived/custom hops do not exist yet.
+					continue
+				}
+				return nil, compileErrorAt(gs.Pos, model.AppError{
+					Code:    "REFERENCE_NOT_FOUND",
+					Message: fmt.Sprintf("relay 跳点引用不存在：%s", hop),
+					Stage:   "compile",
+					Snippet: gs.Raw,
+					Hint:    "hop must be a group name, a final proxy name or a custom_proxy name",
+				})
+			}
+			out = append(out, model.Group{Name: gs.Name, Type: "relay", Members: members, Options: gs.Options})
 		default:
-			return nil, &CompileError{AppError: model.AppError{
+			return nil, compileErrorAt(gs.Pos, model.AppError{
 				Code:    "GROUP_UNSUPPORTED_TYPE",
 				Message: fmt.Sprintf("不支持的策略组类型：%s", gs.Type),
 				Stage:   "compile",
 				Snippet: gs.Raw,
-			}}
+			})
 		}
 	}
 	return out, nil
@@ -437,6 +551,30 @@ func allProxyMemberRefs(proxies []model.Proxy) []model.MemberRef {
 	return refs
 }
 
+// filterProxyMemberRefs returns refs to the proxies whose name matching re equals keep.
+func filterProxyMemberRefs(proxies []model.Proxy, re *regexp.Regexp, keep bool) []model.MemberRef {
+	refs := make([]model.MemberRef, 0, len(proxies))
+	for _, p := range proxies {
+		if re.MatchString(p.Name) == keep {
+			refs = append(refs, proxyMemberRef(p.ID))
+		}
+	}
+	return refs
+}
+
+func uniqueMemberRefs(in []model.MemberRef) []model.MemberRef {
+	seen := make(map[model.MemberRef]struct{}, len(in))
+	out := make([]model.MemberRef, 0, len(in))
+	for _, m := range in {
+		if _, ok := seen[m]; ok {
+			continue
+		}
+		seen[m] = struct{}{}
+		out = append(out, m)
+	}
+	return out
+}
+
 func proxyMemberRef(id string) model.MemberRef {
 	return model.MemberRef{Kind: model.MemberRefProxy, Value: id}
 }
@@ -448,6 +586,13 @@ func explicitMemberRef(raw string) model.MemberRef {
 	return model.MemberRef{Kind: model.MemberRefGroup, Value: raw}
 }
 
+// chainHops are the underlying hops proxy_chain selects for one custom_proxy.
+type chainHops struct {
+	subs    map[string]struct{} // subscription proxy IDs (type=all|regex|group)
+	groups  []string            // dialer groups (type=dialer), first-seen order
+	customs []profile.ChainSpec // type=custom chains, one per via, first-seen order
+}
+
 func compileDerivedProxies(subs []model.Proxy, customs []model.Proxy, preGroups []model.Group, chains []profile.ChainSpec, userGroupNames map[string]struct{}) ([]model.Proxy, map[string][]model.Proxy, map[string]struct{}, error) {
 	if len(chains) == 0 || len(customs) == 0 {
 		return nil, map[string][]model.Proxy{}, map[string]struct{}{}, nil
@@ -461,43 +606,80 @@ func compileDerivedProxies(subs []model.Proxy, customs []model.Proxy, preGroups
 	for _, g := range preGroups {
 		preGroupByName[g.Name] = g
 	}
+	if cs, cycle := profile.ChainCycle(chains); cycle != nil {
+		return nil, nil, nil, compileErrorAt(cs.Pos, model.AppError{
+			Code:    "CHAIN_CYCLE",
+			Message: fmt.Sprintf("proxy_chain 存在循环：%s", strings.Join(cycle, " -> ")),
+			Stage:   "compile",
+			Snippet: cs.Raw,
+		})
+	}
 
-	selectedByCustom := make(map[string]map[string]struct{}, len(customs))
+	hopsByCustom := make(map[string]*chainHops, len(customs))
 	for _, chain := range chains {
 		customProxy, ok := customByName[chain.Proxy]
 		if !ok {
-			return nil, nil, nil, &CompileError{AppError: model.AppError{
+			return nil, nil, nil, compileErrorAt(chain.Pos, model.AppError{
 				Code:    "CHAIN_PROXY_NOT_FOUND",
 				Message: fmt.Sprintf("proxy_chain proxy 引用不存在：%s", chain.Proxy),
 				Stage:   "compile",
 				Snippet: chain.Raw,
-			}}
-		}
-		ids, err := selectChainProxyIDs(chain, subs, preGroupByName)
-		if err != nil {
-			return nil, nil, nil, err
-		}
-		if len(ids) == 0 {
-			return nil, nil, nil, &CompileError{AppError: model.AppError{
-				Code:    "CHAIN_SELECTOR_EMPTY",
-				Message: "proxy_chain 选择结果为空",
-				Stage:   "compile",
-				Snippet: chain.Raw,
-			}}
+			})
 		}
-		selected := selectedByCustom[customProxy.Name]
-		if selected == nil {
-			selected = make(map[string]struct{}, len(ids))
-			selectedByCustom[customProxy.Name] = selected
+		hops := hopsByCustom[customProxy.Name]
+		if hops == nil {
+			hops = &chainHops{subs: make(map[string]struct{})}
+			hopsByCustom[customProxy.Name] = hops
 		}
-		for _, id := range ids {
-			selected[id] = struct{}{}
+		switch chain.Type {
+		case "dialer":
+			if _, ok := userGroupNames[chain.Group]; !ok {
+				return nil, nil, nil, compileErrorAt(chain.Pos, model.AppError{
+					Code:    "CHAIN_GROUP_NOT_FOUND",
+					Message: fmt.Sprintf("proxy_chain group 引用不存在：%s", chain.Group),
+					Stage:   "compile",
+					Snippet: chain.Raw,
+				})
+			}
+			if !slices.Contains(hops.groups, chain.Group) {
+				hops.groups = append(hops.groups, chain.Group)
+			}
+		case "custom":
+			if _, ok := customByName[chain.Via]; !ok {
+				return nil, nil, nil, compileErrorAt(chain.Pos, model.AppError{
+					Code:    "CHAIN_PROXY_NOT_FOUND",
+					Message: fmt.Sprintf("proxy_chain via 引用不存在：%s", chain.Via),
+					Stage:   "compile",
+					Snippet: chain.Raw,
+				})
+			}
+			if !slices.ContainsFunc(hops.customs, func(cs profile.ChainSpec) bool { return cs.Via == chain.Via }) {
+				hops.customs = append(hops.customs, chain)
+			}
+		default:
+			ids, err := selectChainProxyIDs(chain, subs, preGroupByName)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if len(ids) == 0 {
+				return nil, nil, nil, compileErrorAt(chain.Pos, model.AppError{
+					Code:    "CHAIN_SELECTOR_EMPTY",
+					Message: "proxy_chain 选择结果为空",
+					Stage:   "compile",
+					Snippet: chain.Raw,
+				})
+			}
+			for _, id := range ids {
+				hops.subs[id] = struct{}{}
+			}
 		}
 	}
 
+	// Every custom_proxy with a chain gets derived nodes (an empty selection
+	// fails above or below).
 	autoGroupNames := make(map[string]struct{})
 	for _, custom := range customs {
-		if len(selectedByCustom[custom.Name]) == 0 {
+		if hopsByCustom[custom.Name] == nil {
 			continue
 		}
 		autoGroupNames[autoDiagnosticGroupName(custom.Name)] = struct{}{}
@@ -521,31 +703,196 @@ func compileDerivedProxies(subs []model.Proxy, customs []model.Proxy, preGroups
 		usedNames[name] = struct{}{}
 	}
 
-	out := make([]model.Proxy, 0)
+	// A custom_proxy chained through another one is derived after it, so the
+	// hop names exist; chains are acyclic (checked above).
 	derivedByCustom := make(map[string][]model.Proxy, len(customs))
-	for _, custom := range customs {
-		selected := selectedByCustom[custom.Name]
-		if len(selected) == 0 {
-			continue
+	var derive func(custom model.Proxy) ([]model.Proxy, error)
+	derive = func(custom model.Proxy) ([]model.Proxy, error) {
+		if out, ok := derivedByCustom[custom.Name]; ok {
+			return out, nil
+		}
+		hops := hopsByCustom[custom.Name]
+		if hops == nil {
+			return nil, nil
+		}
+		out := make([]model.Proxy, 0, len(hops.subs)+len(hops.groups))
+		add := func(viaKey, viaName string) model.Proxy {
+			derived := custom
+			derived.ID = derivedProxyID(custom, viaKey)
+			derived.Name = nextAvailableName(fmt.Sprintf("%s via %s", custom.Name, viaName), usedNames)
+			return derived
 		}
-		derivedForCustom := make([]model.Proxy, 0, len(selected))
 		for _, sub := range subs {
-			if _, ok := selected[sub.ID]; !ok {
+			if _, ok := hops.subs[sub.ID]; !ok {
 				continue
 			}
-			derived := custom
-			derived.ID = derivedProxyID(custom, sub.ID)
+			derived := add(sub.ID, sub.Name)
 			derived.ViaProxyID = sub.ID
-			derived.Name = nextAvailableName(fmt.Sprintf("%s via %s", custom.Name, sub.Name), usedNames)
 			out = append(out, derived)
-			derivedForCustom = append(derivedForCustom, derived)
 		}
-		derivedByCustom[custom.Name] = derivedForCustom
+		for _, g := range hops.groups {
+			derived := add(dialerGroupKey(g), g)
+			derived.ViaGroup = g
+			out = append(out, derived)
+		}
+		for _, cs := range hops.customs {
+			vias, err := derive(customByName[cs.Via])
+			if err != nil {
+				return nil, err
+			}
+			if len(vias) == 0 {
+				return nil, compileErrorAt(cs.Pos, model.AppError{
+					Code:    "CHAIN_SELECTOR_EMPTY",
+					Message: fmt.Sprintf("proxy_chain via 的 custom_proxy 没有派生节点：%s", cs.Via),
+					Stage:   "compile",
+					Snippet: cs.Raw,
+					Hint:    "add a proxy_chain entry for " + cs.Via,
+				})
+			}
+			for _, via := range vias {
+				derived := add(via.ID, via.Name)
+				derived.ViaProxyID = via.ID
+				out = append(out, derived)
+			}
+		}
+		derivedByCustom[custom.Name] = out
+		return out, nil
 	}
 
+	out := make([]model.Proxy, 0)
+	for _, custom := range customs {
+		if _, err := derive(custom); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	for _, custom := range customs {
+		out = append(out, derivedByCustom[custom.Name]...)
+	}
 	return out, derivedByCustom, autoGroupNames, nil
 }
 
+// dialerGroupKey stands in for the hop proxy ID of a node dialing through a
+// group (see derivedProxyID); proxy IDs are hex, so it cannot collide.
+func dialerGroupKey(group string) string { return "group:" + group }
+
+// checkDialerCycles rejects a dialer group whose members (recursively, and
+// following their own hops) include a node dialing through that same group.
+func checkDialerCycles(chains []profile.ChainSpec, proxies []model.Proxy, groups []model.Group) error {
+	proxyByID := make(map[string]model.Proxy, len(proxies))
+	for _, p := range proxies {
+		proxyByID[p.ID] = p
+	}
+	groupByName := make(map[string]model.Group, len(groups))
+	for _, g := range groups {
+		groupByName[g.Name] = g
+	}
+
+	for _, chain := range chains {
+		if chain.Type != "dialer" {
+			continue
+		}
+		seenGroups := map[string]bool{}
+		seenProxies := map[string]bool{}
+		var loop string
+		var visitGroup func(name string) bool
+		var visitProxy func(id string) bool
+		visitGroup = func(name string) bool {
+			if seenGroups[name] {
+				return false
+			}
+			seenGroups[name] = true
+			for _, m := range groupByName[name].Members {
+				switch m.Kind {
+				case model.MemberRefProxy:
+					if visitProxy(m.Value) {
+						return true
+					}
+				case model.MemberRefGroup:
+					if visitGroup(m.Value) {
+						return true
+					}
+				}
+			}
+			return false
+		}
+		visitProxy = func(id string) bool {
+			if seenProxies[id] {
+				return false
+			}
+			seenProxies[id] = true
+			p := proxyByID[id]
+			switch {
+			case p.ViaGroup == chain.Group:
+				loop = p.Name
+				return true
+			case p.ViaGroup != "":
+				return visitGroup(p.ViaGroup)
+			case p.ViaProxyID != "":
+				return visitProxy(p.ViaProxyID)
+			}
+			return false
+		}
+		if visitGroup(chain.Group) {
+			return compileErrorAt(chain.Pos, model.AppError{
+				Code:    "CHAIN_CYCLE",
+				Message: fmt.Sprintf("proxy_chain 的 dialer 组包含经由该组拨号的节点：%s", loop),
+				Stage:   "compile",
+				Snippet: chain.Raw,
+				Hint:    "exclude the derived nodes from group " + chain.Group,
+			})
+		}
+	}
+	return nil
+}
+
+// compileRelayCustomProxies returns the custom proxies used as relay hops. They
+// are output standalone (no ViaProxyID) in custom_proxy declaration order.
+func compileRelayCustomProxies(customs []model.Proxy, groupSpecs []profile.GroupSpec, groupNames map[string]struct{}, proxies []model.Proxy) ([]model.Proxy, error) {
+	referencedBy := make(map[string]profile.GroupSpec)
+	for _, gs := range groupSpecs {
+		if gs.Type != "relay" {
+			continue
+		}
+		for _, hop := range gs.Members {
+			if _, ok := groupNames[hop]; ok {
+				continue
+			}
+			if _, ok := referencedBy[hop]; !ok {
+				referencedBy[hop] = gs
+			}
+		}
+	}
+	if len(referencedBy) == 0 {
+		return nil, nil
+	}
+
+	proxyNames := make(map[string]struct{}, len(proxies))
+	for _, p := range proxies {
+		proxyNames[p.Name] = struct{}{}
+	}
+	out := make([]model.Proxy, 0)
+	for _, custom := range customs {
+		gs, ok := referencedBy[custom.Name]
+		if !ok {
+			continue
+		}
+		if _, ok := proxyNames[custom.Name]; ok {
+			return nil, compileErrorAt(gs.Pos, model.AppError{
+				Code:    "PROFILE_VALIDATE_ERROR",
+				Message: fmt.Sprintf("relay 跳点同时匹配节点名与 custom_proxy：%s", custom.Name),
+				Stage:   "compile",
+				Snippet: gs.Raw,
+			})
+		}
+		p := custom
+		p.ID = proxyIDFromKey(customProxyKey(custom))
+		p.ViaProxyID = ""
+		p.ViaGroup = ""
+		out = append(out, p)
+	}
+	return out, nil
+}
+
 func selectChainProxyIDs(chain profile.ChainSpec, subs []model.Proxy, groups map[string]model.Group) ([]string, error) {
 	ids := make([]string, 0)
 	switch chain.Type {
@@ -562,16 +909,21 @@ func selectChainProxyIDs(chain profile.ChainSpec, subs []model.Proxy, groups map
 	case "group":
 		expanded, err := expandGroupProxyIDs(chain.Group, groups, make(map[string]bool))
 		if err != nil {
+			// Point at the proxy_chain entry whose group failed to expand.
+			var ce *CompileError
+			if errors.As(err, &ce) && ce.AppError.Line == 0 {
+				chain.Pos.Locate(&ce.AppError)
+			}
 			return nil, err
 		}
 		ids = append(ids, expanded...)
 	default:
-		return nil, &CompileError{AppError: model.AppError{
+		return nil, compileErrorAt(chain.Pos, model.AppError{
 			Code:    "CHAIN_PARSE_ERROR",
 			Message: fmt.Sprintf("不支持的 proxy_chain.type：%s", chain.Type),
 			Stage:   "compile",
 			Snippet: chain.Raw,
-		}}
+		})
 	}
 	return uniqueStrings(ids), nil
 }
@@ -642,24 +994,36 @@ func buildDiagnosticGroups(customs []model.Proxy, derivedByCustom map[string][]m
 	return out
 }
 
+// autoDiagnosticGroupPrefix is reserved for automatic diagnostic groups.
+const autoDiagnosticGroupPrefix = "CHAIN-"
+
 func autoDiagnosticGroupName(customProxyName string) string {
-	return "CHAIN-" + customProxyName
+	return autoDiagnosticGroupPrefix + customProxyName
 }
 
 func validateGroupProxyNamespace(groupNames map[string]struct{}, proxies []model.Proxy) error {
+	if errs := groupProxyConflicts(groupNames, proxies); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// groupProxyConflicts reports every proxy whose name is also a group name.
+func groupProxyConflicts(groupNames map[string]struct{}, proxies []model.Proxy) []error {
 	if len(groupNames) == 0 || len(proxies) == 0 {
 		return nil
 	}
+	var errs []error
 	for _, p := range proxies {
 		if _, ok := groupNames[p.Name]; ok {
-			return &CompileError{AppError: model.AppError{
+			errs = append(errs, &CompileError{AppError: model.AppError{
 				Code:    "PROFILE_VALIDATE_ERROR",
 				Message: fmt.Sprintf("策略组名与节点名冲突：%s", p.Name),
 				Stage:   "compile",
-			}}
+			}})
 		}
 	}
-	return nil
+	return errs
 }
 
 func nextAvailableName(base string, used map[string]struct{}) string {
@@ -700,73 +1064,120 @@ func uniqueStrings(in []string) []string {
 	return out
 }
 
-func compileRules(groupNameSet map[string]struct{}, prof *profile.Spec) ([]model.Rule, []RulesetRef, error) {
+func compileRules(groupNameSet map[string]struct{}, prof *profile.Spec, rulesetText map[string]string) ([]model.Rule, []RulesetRef, error) {
+	if errs := rulesetActionErrors(groupNameSet, prof, nil); len(errs) > 0 {
+		return nil, nil, errs[0]
+	}
+
+	rulesetRefs := make([]RulesetRef, 0, len(prof.Ruleset))
 	for _, rs := range prof.Ruleset {
-		if rs.Action == "DIRECT" || rs.Action == "REJECT" {
-			continue
+		ref := RulesetRef{
+			Raw:         rs.Raw,
+			Action:      rs.Action,
+			URL:         rs.URL,
+			Behavior:    rs.Behavior,
+			Format:      rs.Format,
+			IntervalSec: rs.IntervalSec,
+			Inline:      rs.Inline,
 		}
-		if _, ok := groupNameSet[rs.Action]; !ok {
-			return nil, nil, &CompileError{AppError: model.AppError{
-				Code:    "REFERENCE_NOT_FOUND",
-				Message: fmt.Sprintf("ruleset ACTION 引用不存在：%s", rs.Action),
-				Stage:   "compile",
-				Snippet: rs.Raw,
-			}}
+		if rs.Inline {
+			text, ok := rulesetText[rs.URL]
+			if !ok {
+				return nil, nil, &CompileError{AppError: model.AppError{
+					Code:    "RULESET_PARSE_ERROR",
+					Message: "inline ruleset 内容缺失",
+					Stage:   "compile",
+					URL:     rs.URL,
+					Snippet: rs.Raw,
+				}}
+			}
+			expanded, err := rules.ParseRulesetText(rs.URL, text, rs.Action, rs.Behavior, rs.Format)
+			if err != nil {
+				return nil, nil, err
+			}
+			ref.Rules = expanded
 		}
+		rulesetRefs = append(rulesetRefs, ref)
 	}
 
-	rulesetRefs := make([]RulesetRef, 0, len(prof.Ruleset))
+	if errs := ruleErrors(groupNameSet, prof, nil, false); len(errs) > 0 {
+		return nil, nil, errs[0]
+	}
+	return prof.Rules, rulesetRefs, nil
+}
+
+// rulesetActionErrors reports every ruleset whose ACTION is not a group or
+// DIRECT / REJECT. Actions listed in ignore are not reported.
+func rulesetActionErrors(groupNameSet map[string]struct{}, prof *profile.Spec, ignore map[string]struct{}) []error {
+	var errs []error
 	for _, rs := range prof.Ruleset {
-		rulesetRefs = append(rulesetRefs, RulesetRef{Raw: rs.Raw, Action: rs.Action, URL: rs.URL})
+		if isActionDefined(rs.Action, groupNameSet, ignore) {
+			continue
+		}
+		errs = append(errs, compileErrorAt(rs.Pos, model.AppError{
+			Code:    "REFERENCE_NOT_FOUND",
+			Message: fmt.Sprintf("ruleset ACTION 引用不存在：%s", rs.Action),
+			Stage:   "compile",
+			Snippet: rs.Raw,
+		}))
 	}
+	return errs
+}
 
-	out := prof.Rules
+// ruleErrors checks the fallback rule (exactly one MATCH, last) unless
+// skipMatch, then reports every rule whose ACTION is undefined. The fallback
+// problem comes first, as compile has always reported it first.
+func ruleErrors(groupNameSet map[string]struct{}, prof *profile.Spec, ignore map[string]struct{}, skipMatch bool) []error {
+	var errs []error
 	matchCount := 0
 	matchIndex := -1
-	for i, r := range out {
+	for i, r := range prof.Rules {
 		if r.Type == "MATCH" {
 			matchCount++
 			matchIndex = i
 		}
 	}
-	if matchCount != 1 {
-		return nil, nil, &CompileError{AppError: model.AppError{
+	switch {
+	case skipMatch:
+	case matchCount != 1:
+		// Located at the last MATCH (none: no position).
+		errs = append(errs, compileErrorAt(profile.PositionAt(prof.RulePos, matchIndex), model.AppError{
 			Code:    "RULE_PARSE_ERROR",
 			Message: fmt.Sprintf("兜底规则 MATCH 数量不合法（got=%d, want=1）", matchCount),
 			Stage:   "compile",
-		}}
-	}
-	if matchIndex != len(out)-1 {
-		return nil, nil, &CompileError{AppError: model.AppError{
+		}))
+	case matchIndex != len(prof.Rules)-1:
+		errs = append(errs, compileErrorAt(profile.PositionAt(prof.RulePos, matchIndex), model.AppError{
 			Code:    "RULE_PARSE_ERROR",
 			Message: "兜底规则 MATCH 必须是最后一条",
 			Stage:   "compile",
-		}}
+		}))
 	}
-
-	for _, r := range out {
-		if r.Action == "DIRECT" || r.Action == "REJECT" {
+	for i, r := range prof.Rules {
+		if isActionDefined(r.Action, groupNameSet, ignore) {
 			continue
 		}
-		if _, ok := groupNameSet[r.Action]; !ok {
-			return nil, nil, &CompileError{AppError: model.AppError{
-				Code:    "REFERENCE_NOT_FOUND",
-				Message: fmt.Sprintf("规则 ACTION 引用不存在：%s", r.Action),
-				Stage:   "compile",
-				Snippet: ruleSnippet(r),
-			}}
-		}
+		errs = append(errs, compileErrorAt(profile.PositionAt(prof.RulePos, i), model.AppError{
+			Code:    "REFERENCE_NOT_FOUND",
+			Message: fmt.Sprintf("规则 ACTION 引用不存在：%s", r.Action),
+			Stage:   "compile",
+			Snippet: ruleSnippet(r),
+		}))
 	}
-
-	return out, rulesetRefs, nil
+	return errs
 }
 
-func ruleSnippet(r model.Rule) string {
-	if r.Type == "MATCH" {
-		return fmt.Sprintf("MATCH,%s", r.Action)
+func isActionDefined(action string, groupNameSet, ignore map[string]struct{}) bool {
+	if action == "DIRECT" || action == "REJECT" {
+		return true
 	}
-	if (r.Type == "IP-CIDR" || r.Type == "IP-CIDR6") && r.NoResolve {
-		return fmt.Sprintf("%s,%s,%s,no-resolve", r.Type, r.Value, r.Action)
+	if _, ok := groupNameSet[action]; ok {
+		return true
 	}
-	return fmt.Sprintf("%s,%s,%s", r.Type, r.Value, r.Action)
+	_, ok := ignore[action]
+	return ok
+}
+
+func ruleSnippet(r model.Rule) string {
+	return rules.FormatRule(r)
 }
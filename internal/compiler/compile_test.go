@@ -194,6 +194,137 @@ func TestCompile_URLTestEmpty(t *testing.T) {
 	}
 }
 
+func TestCompile_ErrorsLocateProfileItems(t *testing.T) {
+	subs := []model.Proxy{
+		{Type: "ss", Name: "HK", Server: "example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+	}
+	yml := "version: 1\n" +
+		"template:\n" +
+		"  clash: \"https://example.com/base.yaml\"\n" +
+		"custom_proxy_group:\n" +
+		"  - \"PROXY`select`[]AUTO\"\n" +
+		"  - \"AUTO`url-test`(JP)`https://www.gstatic.com/generate_204`300\"\n" +
+		"rule:\n" +
+		"  - \"DOMAIN,example.com,NOPE\"\n" +
+		"  - \"MATCH,PROXY\"\n"
+	prof, err := profile.ParseProfileYAML("https://example.com/profile.yaml", yml, "clash")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		prof         *profile.Spec
+		code         string
+		line, column int
+	}{
+		{"empty group", prof, "GROUP_PARSE_ERROR", 6, 5},
+		{"rule action", withoutGroupRegex(prof), "REFERENCE_NOT_FOUND", 8, 5},
+	} {
+		_, err := Compile(subs, tc.prof)
+		var ce *CompileError
+		if !errors.As(err, &ce) {
+			t.Fatalf("%s: expected *CompileError, got %T: %v", tc.name, err, err)
+		}
+		e := ce.AppError
+		if e.Code != tc.code || e.URL != "https://example.com/profile.yaml" || e.Line != tc.line || e.Column != tc.column {
+			t.Fatalf("%s: got %+v, want %s at %d:%d", tc.name, e, tc.code, tc.line, tc.column)
+		}
+	}
+}
+
+// withoutGroupRegex makes every url-test group match all proxies.
+func withoutGroupRegex(p *profile.Spec) *profile.Spec {
+	out := *p
+	out.Groups = append([]profile.GroupSpec(nil), p.Groups...)
+	for i := range out.Groups {
+		if out.Groups[i].Regex != nil {
+			out.Groups[i].Regex = regexp.MustCompile(".*")
+		}
+	}
+	return &out
+}
+
+func TestCompile_FallbackAndLoadBalanceGroups(t *testing.T) {
+	subs := []model.Proxy{
+		{Type: "ss", Name: "HK", Server: "hk.example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+		{Type: "ss", Name: "SG", Server: "sg.example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+	}
+	prof := &profile.Spec{
+		Version: 1,
+		Groups: []profile.GroupSpec{
+			{Raw: "FB`fallback`SG`https://www.gstatic.com/generate_204`300", Name: "FB", Type: "fallback", RegexRaw: "SG", Regex: regexp.MustCompile("SG"), TestURL: "https://www.gstatic.com/generate_204", IntervalSec: 300},
+			{Raw: "LB`load-balance`.*`https://www.gstatic.com/generate_204`600`round-robin", Name: "LB", Type: "load-balance", RegexRaw: ".*", Regex: regexp.MustCompile(".*"), TestURL: "https://www.gstatic.com/generate_204", IntervalSec: 600, Strategy: "round-robin"},
+			{Raw: "EMPTY`fallback`ZZZ`https://www.gstatic.com/generate_204`300", Name: "EMPTY", Type: "fallback", RegexRaw: "ZZZ", Regex: regexp.MustCompile("ZZZ"), TestURL: "https://www.gstatic.com/generate_204", IntervalSec: 300},
+		},
+		Rules: []model.Rule{
+			{Type: "MATCH", Action: "LB"},
+		},
+	}
+
+	_, err := Compile(subs, prof)
+	var ce *CompileError
+	if !errors.As(err, &ce) || ce.AppError.Code != "GROUP_PARSE_ERROR" || ce.AppError.Message != "fallback 组匹配为空：EMPTY" {
+		t.Fatalf("expected empty fallback error, got %T: %v", err, err)
+	}
+
+	prof.Groups = prof.Groups[:2]
+	got, err := Compile(subs, prof)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fb, lb := got.Groups[0], got.Groups[1]
+	if fb.Type != "fallback" || !reflect.DeepEqual(fb.Members, []model.MemberRef{proxyRef(got.Proxies[1].ID)}) || fb.IntervalSec != 300 {
+		t.Fatalf("fallback=%+v", fb)
+	}
+	if lb.Type != "load-balance" || len(lb.Members) != 2 || lb.Strategy != "round-robin" || lb.TestURL != "https://www.gstatic.com/generate_204" {
+		t.Fatalf("load-balance=%+v", lb)
+	}
+}
+
+func TestCompile_RelayGroups(t *testing.T) {
+	subs := []model.Proxy{
+		{Type: "ss", Name: "HK", Server: "hk.example.com", Port: 1, Cipher: "aes-128-gcm", Password: "pass"},
+		{Type: "ss", Name: "SG", Server: "sg.example.com", Port: 2, Cipher: "aes-128-gcm", Password: "pass"},
+	}
+	prof := &profile.Spec{
+		Version: 1,
+		CustomProxies: []model.Proxy{
+			{Name: "UNUSED", Type: "http", Server: "unused.example.com", Port: 3128},
+			{Name: "US-EXIT", Type: "socks5", Server: "us.example.com", Port: 1080},
+		},
+		Groups: []profile.GroupSpec{
+			{Raw: "ENTRY`select`[]HK[]SG", Name: "ENTRY", Type: "select", Members: []string{"HK", "SG"}},
+			{Raw: "CHAIN`relay`[]ENTRY[]SG[]US-EXIT", Name: "CHAIN", Type: "relay", Members: []string{"ENTRY", "SG", "US-EXIT"}},
+		},
+		Rules: []model.Rule{{Type: "MATCH", Action: "CHAIN"}},
+	}
+
+	got, err := Compile(subs, prof)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Only custom proxies used as hops are emitted, after subscription proxies.
+	if len(got.Proxies) != 3 || got.Proxies[2].Name != "US-EXIT" || got.Proxies[2].ViaProxyID != "" {
+		t.Fatalf("proxies=%+v", got.Proxies)
+	}
+	want := []model.MemberRef{
+		{Kind: model.MemberRefGroup, Value: "ENTRY"},
+		proxyRef(got.Proxies[1].ID),
+		proxyRef(got.Proxies[2].ID),
+	}
+	if relay := got.Groups[1]; relay.Type != "relay" || !reflect.DeepEqual(relay.Members, want) {
+		t.Fatalf("relay=%+v", relay)
+	}
+
+	prof.Groups[1] = profile.GroupSpec{Raw: "CHAIN`relay`[]HK[]MISSING", Name: "CHAIN", Type: "relay", Members: []string{"HK", "MISSING"}}
+	_, err = Compile(subs, prof)
+	var ce *CompileError
+	if !errors.As(err, &ce) || ce.AppError.Code != "REFERENCE_NOT_FOUND" {
+		t.Fatalf("expected REFERENCE_NOT_FOUND, got %T: %v", err, err)
+	}
+}
+
 func TestCompile_SelectRegexMembers(t *testing.T) {
 	subs := []model.Proxy{
 		{Type: "ss", Name: "HK-A", Server: "hk.example.com", Port: 1, Cipher: "aes-128-gcm", Password: "pass"},
@@ -229,6 +360,43 @@ func TestCompile_SelectRegexMembers(t *testing.T) {
 	}
 }
 
+func TestCompile_SelectMixedMembersOrderedAndDeduped(t *testing.T) {
+	subs := []model.Proxy{
+		{Type: "ss", Name: "HK-A", Server: "hk.example.com", Port: 1, Cipher: "aes-128-gcm", Password: "pass"},
+		{Type: "ss", Name: "剩余流量：10GB", Server: "info.example.com", Port: 2, Cipher: "aes-128-gcm", Password: "pass"},
+		{Type: "ss", Name: "SG", Server: "sg.example.com", Port: 3, Cipher: "aes-128-gcm", Password: "pass"},
+	}
+	prof := &profile.Spec{
+		Version: 1,
+		Groups: []profile.GroupSpec{
+			{
+				Raw:         "PROXY`select`[]DIRECT[]@regex:(SG|HK)[]AUTO[]@all-except:(过期|剩余)",
+				Name:        "PROXY",
+				Type:        "select",
+				Members:     []string{"DIRECT", "@regex:(SG|HK)", "AUTO", "@all-except:(过期|剩余)"},
+				MemberRegex: []*regexp.Regexp{nil, regexp.MustCompile("(SG|HK)"), nil, regexp.MustCompile("(过期|剩余)")},
+			},
+			{Raw: "AUTO`url-test`.*`https://www.gstatic.com/generate_204`300", Name: "AUTO", Type: "url-test", RegexRaw: ".*", Regex: regexp.MustCompile(".*"), TestURL: "https://www.gstatic.com/generate_204", IntervalSec: 300},
+		},
+		Rules: []model.Rule{{Type: "MATCH", Action: "PROXY"}},
+	}
+
+	got, err := Compile(subs, prof)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// @regex keeps proxy order; @all-except adds nothing new after dedup.
+	want := []model.MemberRef{
+		{Kind: model.MemberRefBuiltin, Value: "DIRECT"},
+		proxyRef(got.Proxies[0].ID),
+		proxyRef(got.Proxies[2].ID),
+		{Kind: model.MemberRefGroup, Value: "AUTO"},
+	}
+	if !reflect.DeepEqual(got.Groups[0].Members, want) {
+		t.Fatalf("members=%+v, want=%+v", got.Groups[0].Members, want)
+	}
+}
+
 func TestCompile_ProxyChain_DerivedProxiesAndDiagnosticGroup(t *testing.T) {
 	subs := []model.Proxy{
 		{Type: "ss", Name: "HK", Server: "hk.example.com", Port: 1, Cipher: "aes-128-gcm", Password: "pass"},
@@ -316,6 +484,95 @@ func TestCompile_ProxyChain_SelectorEmpty(t *testing.T) {
 	}
 }
 
+func TestCompile_ProxyChain_MultiHopAndDialerGroup(t *testing.T) {
+	subs := []model.Proxy{
+		{Type: "ss", Name: "HK", Server: "hk.example.com", Port: 1, Cipher: "aes-128-gcm", Password: "pass"},
+		{Type: "ss", Name: "SG", Server: "sg.example.com", Port: 2, Cipher: "aes-128-gcm", Password: "pass"},
+	}
+	prof := &profile.Spec{
+		Version: 1,
+		// EGRESS is declared first but chains through HOP, which is derived first.
+		CustomProxies: []model.Proxy{
+			{Name: "EGRESS", Type: "socks5", Server: "egress.example.com", Port: 1080},
+			{Name: "HOP", Type: "http", Server: "hop.example.com", Port: 8080},
+		},
+		Groups: []profile.GroupSpec{
+			{Raw: "PROXY`select`[]@all", Name: "PROXY", Type: "select", Members: []string{"@all"}},
+		},
+		ProxyChains: []profile.ChainSpec{
+			{Raw: "proxy=EGRESS type=custom via=HOP", Proxy: "EGRESS", Type: "custom", Via: "HOP"},
+			{Raw: "proxy=HOP type=regex pattern=HK", Proxy: "HOP", Type: "regex", Pattern: "HK", Regex: regexp.MustCompile("HK")},
+			{Raw: "proxy=HOP type=dialer group=PROXY", Proxy: "HOP", Type: "dialer", Group: "PROXY"},
+		},
+		Rules: []model.Rule{{Type: "MATCH", Action: "CHAIN-EGRESS"}},
+	}
+
+	got, err := Compile(subs, prof)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, p := range got.Proxies {
+		names = append(names, p.Name)
+	}
+	want := []string{"HK", "SG", "EGRESS via HOP via HK", "EGRESS via HOP via PROXY", "HOP via HK", "HOP via PROXY"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("names=%q, want=%q", names, want)
+	}
+	hopViaHK, hopViaGroup := got.Proxies[4], got.Proxies[5]
+	if hopViaHK.ViaProxyID != got.Proxies[0].ID || hopViaGroup.ViaGroup != "PROXY" || hopViaGroup.ViaProxyID != "" {
+		t.Fatalf("hop proxies=%+v %+v", hopViaHK, hopViaGroup)
+	}
+	if got.Proxies[2].ViaProxyID != hopViaHK.ID || got.Proxies[3].ViaProxyID != hopViaGroup.ID {
+		t.Fatalf("egress proxies=%+v %+v", got.Proxies[2], got.Proxies[3])
+	}
+
+	again, err := Compile(subs, prof)
+	if err != nil || !reflect.DeepEqual(again.Proxies, got.Proxies) {
+		t.Fatalf("compile is not deterministic: %v", err)
+	}
+}
+
+func TestCompile_ProxyChain_Cycles(t *testing.T) {
+	subs := []model.Proxy{{Type: "ss", Name: "HK", Server: "hk.example.com", Port: 1, Cipher: "aes-128-gcm", Password: "pass"}}
+	customs := []model.Proxy{
+		{Name: "A", Type: "http", Server: "a.example.com", Port: 8080},
+		{Name: "B", Type: "http", Server: "b.example.com", Port: 8080},
+	}
+	for name, tc := range map[string]struct {
+		groups []profile.GroupSpec
+		chains []profile.ChainSpec
+	}{
+		"custom loop": {
+			chains: []profile.ChainSpec{
+				{Raw: "proxy=A type=custom via=B", Proxy: "A", Type: "custom", Via: "B"},
+				{Raw: "proxy=B type=custom via=A", Proxy: "B", Type: "custom", Via: "A"},
+			},
+		},
+		"dialer group selects its own derived node": {
+			groups: []profile.GroupSpec{
+				{Raw: "POOL`select`(.)", Name: "POOL", Type: "select", RegexRaw: ".", Regex: regexp.MustCompile(".")},
+			},
+			chains: []profile.ChainSpec{
+				{Raw: "proxy=A type=dialer group=POOL", Proxy: "A", Type: "dialer", Group: "POOL"},
+			},
+		},
+	} {
+		prof := &profile.Spec{
+			Version:       1,
+			CustomProxies: customs,
+			Groups:        tc.groups,
+			ProxyChains:   tc.chains,
+			Rules:         []model.Rule{{Type: "MATCH", Action: "DIRECT"}},
+		}
+		_, err := Compile(subs, prof)
+		var ce *CompileError
+		if !errors.As(err, &ce) || ce.AppError.Code != "CHAIN_CYCLE" {
+			t.Fatalf("%s: expected CHAIN_CYCLE, got %T: %v", name, err, err)
+		}
+	}
+}
+
 func proxyRef(id string) model.MemberRef {
 	return model.MemberRef{Kind: model.MemberRefProxy, Value: id}
 }
@@ -323,3 +580,153 @@ func proxyRef(id string) model.MemberRef {
 func builtinRef(name string) model.MemberRef {
 	return model.MemberRef{Kind: model.MemberRefBuiltin, Value: name}
 }
+
+func TestCompile_InlineRulesetExpanded(t *testing.T) {
+	subs := []model.Proxy{
+		{Type: "ss", Name: "HK", Server: "example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+	}
+	prof := &profile.Spec{
+		Version: 1,
+		Groups: []profile.GroupSpec{
+			{Raw: "PROXY`select`[]@all", Name: "PROXY", Type: "select", Members: []string{"@all"}},
+		},
+		Ruleset: []profile.RulesetSpec{
+			{Raw: "PROXY,inline:https://example.com/a.list", Action: "PROXY", URL: "https://example.com/a.list", Inline: true},
+			{Raw: "DIRECT,https://example.com/b.list", Action: "DIRECT", URL: "https://example.com/b.list"},
+		},
+		Rules: []model.Rule{{Type: "MATCH", Action: "DIRECT"}},
+	}
+
+	_, err := Compile(subs, prof)
+	var ce *CompileError
+	if !errors.As(err, &ce) || ce.AppError.Code != "RULESET_PARSE_ERROR" {
+		t.Fatalf("expected RULESET_PARSE_ERROR without ruleset content, got %T: %v", err, err)
+	}
+
+	got, err := CompileWithOptions(subs, prof, Options{RulesetText: map[string]string{
+		"https://example.com/a.list": "DOMAIN-SUFFIX,example.com\nIP-CIDR,1.1.1.0/24,no-resolve\n",
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.RulesetRefs) != 2 {
+		t.Fatalf("ruleset refs=%d, want=2", len(got.RulesetRefs))
+	}
+	if !reflect.DeepEqual(got.RulesetRefs[0].Rules, []model.Rule{
+		{Type: "DOMAIN-SUFFIX", Value: "example.com", Action: "PROXY"},
+		{Type: "IP-CIDR", Value: "1.1.1.0/24", Action: "PROXY", NoResolve: true},
+	}) {
+		t.Fatalf("inline rules=%+v", got.RulesetRefs[0].Rules)
+	}
+	if got.RulesetRefs[1].Inline || got.RulesetRefs[1].Rules != nil {
+		t.Fatalf("remote ruleset should stay a reference: %+v", got.RulesetRefs[1])
+	}
+}
+
+func TestCompile_TargetFiltersProfileItems(t *testing.T) {
+	subs := []model.Proxy{
+		{Type: "ss", Name: "HK", Server: "hk.example.com", Port: 1, Cipher: "aes-128-gcm", Password: "pass"},
+	}
+	prof := &profile.Spec{
+		Version: 1,
+		Groups: []profile.GroupSpec{
+			{Raw: "PROXY`select`[]@all", Name: "PROXY", Type: "select", Members: []string{"@all"}},
+			{Raw: "APP`select`[]PROXY", Name: "APP", Type: "select", Members: []string{"PROXY"}, Targets: profile.Targets{"clash"}},
+			{Raw: "APP`select`[]DIRECT", Name: "APP", Type: "select", Members: []string{"DIRECT"}, Targets: profile.Targets{"surge"}},
+		},
+		Ruleset: []profile.RulesetSpec{{Raw: "APP,https://example.com/app.list", Action: "APP", URL: "https://example.com/app.list", Targets: profile.Targets{"clash"}}},
+		Rules: []model.Rule{
+			{Type: "PROCESS-NAME", Value: "curl", Action: "APP"},
+			{Type: "MATCH", Action: "PROXY"},
+		},
+		RuleTargets: []profile.Targets{{"clash", "surge"}, nil},
+	}
+
+	clash, err := CompileWithOptions(subs, prof, Options{Target: "clash"})
+	if err != nil {
+		t.Fatalf("clash: unexpected error: %v", err)
+	}
+	if len(clash.Groups) != 2 || clash.Groups[1].Members[0].Value != "PROXY" || len(clash.RulesetRefs) != 1 || len(clash.Rules) != 2 {
+		t.Fatalf("clash result=%+v", clash)
+	}
+
+	// The PROCESS-NAME rule is limited to clash/surge, so APP is not needed on quanx.
+	quanx, err := CompileWithOptions(subs, prof, Options{Target: "quanx"})
+	if err != nil {
+		t.Fatalf("quanx: unexpected error: %v", err)
+	}
+	if len(quanx.Groups) != 1 || len(quanx.Rules) != 1 {
+		t.Fatalf("quanx result=%+v", quanx)
+	}
+
+	surge, err := CompileWithOptions(subs, prof, Options{Target: "surge"})
+	if err != nil {
+		t.Fatalf("surge: unexpected error: %v", err)
+	}
+	if len(surge.Groups) != 2 || surge.Groups[1].Members[0].Value != "DIRECT" || len(surge.RulesetRefs) != 0 {
+		t.Fatalf("surge result=%+v", surge)
+	}
+
+	_, err = Compile(subs, prof)
+	var ce *CompileError
+	if !errors.As(err, &ce) || ce.AppError.Code != "PROFILE_VALIDATE_ERROR" {
+		t.Fatalf("expected PROFILE_VALIDATE_ERROR without target, got %T: %v", err, err)
+	}
+}
+
+func TestCheck_ReportsEveryProblem(t *testing.T) {
+	prof := &profile.Spec{
+		Version: 1,
+		Groups: []profile.GroupSpec{
+			{Raw: "PROXY`select`[]AUTO", Name: "PROXY", Type: "select", Members: []string{"AUTO"}},
+			{Raw: "AUTO`url-test`JP`https://www.gstatic.com/generate_204`300", Name: "AUTO", Type: "url-test", RegexRaw: "JP", Regex: regexp.MustCompile("JP"), TestURL: "https://www.gstatic.com/generate_204", IntervalSec: 300},
+		},
+		Ruleset: []profile.RulesetSpec{
+			{Raw: "MEDIA,https://example.com/m.list", Action: "MEDIA", URL: "https://example.com/m.list"},
+		},
+		Rules: []model.Rule{
+			{Type: "DOMAIN", Value: "a.example.com", Action: "GAMES"},
+			{Type: "DOMAIN", Value: "b.example.com", Action: "BROKEN"},
+			{Type: "MATCH", Action: "PROXY"},
+			{Type: "DOMAIN", Value: "c.example.com", Action: "DIRECT"},
+		},
+	}
+
+	codes := func(errs []error) []string {
+		var out []string
+		for _, err := range errs {
+			var ce *CompileError
+			if !errors.As(err, &ce) {
+				t.Fatalf("expected CompileError, got %T: %v", err, err)
+			}
+			out = append(out, ce.AppError.Code+" "+ce.AppError.Message)
+		}
+		return out
+	}
+
+	got := codes(Check(prof, CheckOptions{Ignore: map[string]struct{}{"BROKEN": {}}}))
+	want := []string{
+		"REFERENCE_NOT_FOUND ruleset ACTION 引用不存在：MEDIA",
+		"RULE_PARSE_ERROR 兜底规则 MATCH 必须是最后一条",
+		"REFERENCE_NOT_FOUND 规则 ACTION 引用不存在：GAMES",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("errors=%q, want=%q", got, want)
+	}
+
+	// With the references fixed, a sample subscription reveals the empty group.
+	prof.Ruleset[0].Action = "PROXY"
+	prof.Rules = []model.Rule{{Type: "MATCH", Action: "PROXY"}}
+	subs := []model.Proxy{
+		{Type: "ss", Name: "HK", Server: "example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+		{Type: "ss", Name: "AUTO", Server: "example.com", Port: 8389, Cipher: "aes-128-gcm", Password: "pass"},
+	}
+	got = codes(Check(prof, CheckOptions{Subs: subs}))
+	if want := []string{"PROFILE_VALIDATE_ERROR 策略组名与节点名冲突：AUTO"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("errors=%q, want=%q", got, want)
+	}
+	got = codes(Check(prof, CheckOptions{Subs: subs[:1]}))
+	if want := []string{"GROUP_PARSE_ERROR url-test 组匹配为空：AUTO"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("errors=%q, want=%q", got, want)
+	}
+}
@@ -0,0 +1,332 @@
+package compiler
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+)
+
+// Graph node kinds.
+const (
+	GraphNodeGroup   = "group"
+	GraphNodeBuiltin = "builtin"
+)
+
+// Graph issue severities.
+const (
+	GraphSeverityError   = "error"
+	GraphSeverityWarning = "warning"
+	GraphSeverityInfo    = "info"
+)
+
+// GroupGraph is the policy group topology of a compiled Result plus the
+// problems found in it. Nodes follow group order (builtins last); edges follow
+// member order.
+type GroupGraph struct {
+	Nodes  []GraphNode  `json:"nodes"`
+	Edges  []GraphEdge  `json:"edges"`
+	Issues []GraphIssue `json:"issues"`
+}
+
+type GraphNode struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"` // GraphNodeGroup | GraphNodeBuiltin
+	Type string `json:"type,omitempty"`
+	// Proxies is the number of direct proxy members.
+	Proxies int `json:"proxies"`
+	// RuleRefs is the number of rules (a ruleset counts once) whose action is this node.
+	RuleRefs int `json:"rule_refs"`
+}
+
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type GraphIssue struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	// Groups is the cycle path (first group repeated at the end) or the single group concerned.
+	Groups []string `json:"groups"`
+}
+
+// AnalyzeGroups builds the group reference graph of res and reports:
+//   - GROUP_REFERENCE_CYCLE: groups referencing each other in a loop
+//   - RULE_TARGET_EMPTY_GROUP: a rule action whose group expands to no proxy or builtin
+//   - GROUP_UNREACHABLE: a group no rule can reach, directly or through other groups
+//   - GROUP_NOT_RULE_TARGET: a group reachable only through other groups
+//
+// Automatic diagnostic groups (CHAIN-*) are never reported as unreachable.
+func AnalyzeGroups(res *Result) GroupGraph {
+	g := GroupGraph{Nodes: []GraphNode{}, Edges: []GraphEdge{}, Issues: []GraphIssue{}}
+	if res == nil {
+		return g
+	}
+
+	groupByName := make(map[string]model.Group, len(res.Groups))
+	nodeIndex := make(map[string]int, len(res.Groups)+2)
+	for _, grp := range res.Groups {
+		groupByName[grp.Name] = grp
+		nodeIndex[grp.Name] = len(g.Nodes)
+		g.Nodes = append(g.Nodes, GraphNode{Name: grp.Name, Kind: GraphNodeGroup, Type: grp.Type})
+	}
+	builtinNode := func(name string) int {
+		if i, ok := nodeIndex[name]; ok {
+			return i
+		}
+		nodeIndex[name] = len(g.Nodes)
+		g.Nodes = append(g.Nodes, GraphNode{Name: name, Kind: GraphNodeBuiltin})
+		return nodeIndex[name]
+	}
+
+	for _, grp := range res.Groups {
+		for _, m := range grp.Members {
+			switch m.Kind {
+			case model.MemberRefProxy:
+				g.Nodes[nodeIndex[grp.Name]].Proxies++
+			case model.MemberRefBuiltin:
+				builtinNode(m.Value)
+				g.Edges = append(g.Edges, GraphEdge{From: grp.Name, To: m.Value})
+			case model.MemberRefGroup:
+				g.Edges = append(g.Edges, GraphEdge{From: grp.Name, To: m.Value})
+			}
+		}
+	}
+
+	// Rule targets in rule order (rulesets first, as rendered).
+	targets := make([]string, 0)
+	addTarget := func(action string) {
+		i, ok := nodeIndex[action]
+		if !ok {
+			if action != "DIRECT" && action != "REJECT" {
+				return
+			}
+			i = builtinNode(action)
+		}
+		if g.Nodes[i].RuleRefs == 0 {
+			targets = append(targets, action)
+		}
+		g.Nodes[i].RuleRefs++
+	}
+	for _, rs := range res.RulesetRefs {
+		addTarget(rs.Action)
+	}
+	for _, r := range res.Rules {
+		addTarget(r.Action)
+	}
+
+	for _, cycle := range findGroupCycles(res.Groups, groupByName) {
+		g.Issues = append(g.Issues, GraphIssue{
+			Severity: GraphSeverityError,
+			Code:     "GROUP_REFERENCE_CYCLE",
+			Message:  fmt.Sprintf("策略组引用存在循环：%s", strings.Join(cycle, " -> ")),
+			Groups:   cycle,
+		})
+	}
+
+	for _, name := range targets {
+		grp, ok := groupByName[name]
+		if !ok || groupHasLeaf(grp, groupByName, make(map[string]bool)) {
+			continue
+		}
+		g.Issues = append(g.Issues, GraphIssue{
+			Severity: GraphSeverityError,
+			Code:     "RULE_TARGET_EMPTY_GROUP",
+			Message:  fmt.Sprintf("规则指向的策略组展开后没有任何节点：%s", name),
+			Groups:   []string{name},
+		})
+	}
+
+	reachable := make(map[string]bool, len(res.Groups))
+	var walk func(name string)
+	walk = func(name string) {
+		if reachable[name] {
+			return
+		}
+		reachable[name] = true
+		for _, m := range groupByName[name].Members {
+			if m.Kind == model.MemberRefGroup {
+				walk(m.Value)
+			}
+		}
+	}
+	for _, name := range targets {
+		if _, ok := groupByName[name]; ok {
+			walk(name)
+		}
+	}
+	for _, grp := range res.Groups {
+		switch {
+		case strings.HasPrefix(grp.Name, autoDiagnosticGroupPrefix):
+		case !reachable[grp.Name]:
+			g.Issues = append(g.Issues, GraphIssue{
+				Severity: GraphSeverityWarning,
+				Code:     "GROUP_UNREACHABLE",
+				Message:  fmt.Sprintf("策略组不会被任何规则使用：%s", grp.Name),
+				Groups:   []string{grp.Name},
+			})
+		case g.Nodes[nodeIndex[grp.Name]].RuleRefs == 0:
+			g.Issues = append(g.Issues, GraphIssue{
+				Severity: GraphSeverityInfo,
+				Code:     "GROUP_NOT_RULE_TARGET",
+				Message:  fmt.Sprintf("策略组没有被规则直接引用，仅通过其他策略组使用：%s", grp.Name),
+				Groups:   []string{grp.Name},
+			})
+		}
+	}
+	return g
+}
+
+// findGroupCycles returns each elementary cycle found by a DFS in group order,
+// rotated to start at its earliest declared group.
+func findGroupCycles(groups []model.Group, groupByName map[string]model.Group) [][]string {
+	order := make(map[string]int, len(groups))
+	for i, grp := range groups {
+		order[grp.Name] = i
+	}
+
+	const (
+		unvisited = iota
+		onStack
+		done
+	)
+	state := make(map[string]int, len(groups))
+	seen := make(map[string]struct{})
+	stack := make([]string, 0)
+	out := make([][]string, 0)
+
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = onStack
+		stack = append(stack, name)
+		for _, m := range groupByName[name].Members {
+			if m.Kind != model.MemberRefGroup {
+				continue
+			}
+			if _, ok := groupByName[m.Value]; !ok {
+				continue
+			}
+			switch state[m.Value] {
+			case unvisited:
+				visit(m.Value)
+			case onStack:
+				cycle := slices.Clone(stack[slices.Index(stack, m.Value):])
+				start := 0
+				for i, n := range cycle {
+					if order[n] < order[cycle[start]] {
+						start = i
+					}
+				}
+				cycle = append(cycle[start:], cycle[:start]...)
+				key := strings.Join(cycle, "\x00")
+				if _, ok := seen[key]; !ok {
+					seen[key] = struct{}{}
+					out = append(out, append(cycle, cycle[0]))
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+	for _, grp := range groups {
+		if state[grp.Name] == unvisited {
+			visit(grp.Name)
+		}
+	}
+	return out
+}
+
+// groupHasLeaf reports whether grp expands to at least one proxy or builtin.
+// Groups pulling in provider proxies are assumed non-empty.
+func groupHasLeaf(grp model.Group, groupByName map[string]model.Group, visiting map[string]bool) bool {
+	if grp.Options.IncludeAllProviders {
+		return true
+	}
+	if visiting[grp.Name] {
+		return false
+	}
+	visiting[grp.Name] = true
+	defer delete(visiting, grp.Name)
+	for _, m := range grp.Members {
+		switch m.Kind {
+		case model.MemberRefProxy, model.MemberRefBuiltin:
+			return true
+		case model.MemberRefGroup:
+			if nested, ok := groupByName[m.Value]; ok && groupHasLeaf(nested, groupByName, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DOT renders the graph in Graphviz DOT. Rule targets hang off a "rules" node
+// labeled with their rule counts; groups with error issues are drawn red and
+// unreachable groups dashed.
+func (g GroupGraph) DOT() string {
+	problem := make(map[string]string, len(g.Issues))
+	for _, is := range g.Issues {
+		for _, name := range is.Groups {
+			switch {
+			case is.Severity == GraphSeverityError:
+				problem[name] = "color=red"
+			case is.Code == "GROUP_UNREACHABLE" && problem[name] == "":
+				problem[name] = "style=dashed"
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph groups {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  \"rules\" [shape=plaintext, label=\"rules\"];\n")
+	for _, n := range g.Nodes {
+		attrs := []string{}
+		if n.Kind == GraphNodeBuiltin {
+			attrs = append(attrs, "shape=ellipse", "label="+dotQuote(n.Name))
+		} else {
+			label := n.Name + "\n" + n.Type
+			if n.Proxies > 0 {
+				label += " · " + strconv.Itoa(n.Proxies) + " proxies"
+			}
+			attrs = append(attrs, "shape=box", "label="+dotQuote(label))
+		}
+		if p := problem[n.Name]; p != "" {
+			attrs = append(attrs, p)
+		}
+		fmt.Fprintf(&b, "  %s [%s];\n", dotQuote(dotNodeID(n.Kind, n.Name)), strings.Join(attrs, ", "))
+	}
+	kindByName := make(map[string]string, len(g.Nodes))
+	for _, n := range g.Nodes {
+		kindByName[n.Name] = n.Kind
+	}
+	for _, n := range g.Nodes {
+		if n.RuleRefs > 0 {
+			fmt.Fprintf(&b, "  \"rules\" -> %s [label=%s];\n", dotQuote(dotNodeID(n.Kind, n.Name)), dotQuote(strconv.Itoa(n.RuleRefs)))
+		}
+	}
+	for _, e := range g.Edges {
+		toKind := kindByName[e.To]
+		if toKind == "" {
+			toKind = GraphNodeGroup
+		}
+		fmt.Fprintf(&b, "  %s -> %s;\n", dotQuote(dotNodeID(GraphNodeGroup, e.From)), dotQuote(dotNodeID(toKind, e.To)))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotNodeID namespaces node IDs so a group named "rules" or "DIRECT" cannot
+// collide with the pseudo node or a builtin.
+func dotNodeID(kind, name string) string {
+	return kind + ":" + name
+}
+
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", "")
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,76 @@
+package compiler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+)
+
+func TestAnalyzeGroups_IssuesAndTopology(t *testing.T) {
+	res := &Result{
+		Proxies: []model.Proxy{{ID: "p1", Name: "HK"}},
+		Groups: []model.Group{
+			{Name: "PROXY", Type: "select", Members: []model.MemberRef{{Kind: model.MemberRefGroup, Value: "AUTO"}, {Kind: model.MemberRefBuiltin, Value: "DIRECT"}}},
+			{Name: "AUTO", Type: "url-test", Members: []model.MemberRef{proxyRef("p1")}},
+			{Name: "LOOP-A", Type: "select", Members: []model.MemberRef{{Kind: model.MemberRefGroup, Value: "LOOP-B"}}},
+			{Name: "LOOP-B", Type: "select", Members: []model.MemberRef{{Kind: model.MemberRefGroup, Value: "LOOP-A"}}},
+			{Name: "SPARE", Type: "select", Members: []model.MemberRef{proxyRef("p1")}},
+			{Name: "CHAIN-CORP", Type: "select", Members: []model.MemberRef{proxyRef("p1")}},
+		},
+		RulesetRefs: []RulesetRef{{Action: "LOOP-B"}},
+		Rules: []model.Rule{
+			{Type: "DOMAIN", Value: "a.example", Action: "PROXY"},
+			{Type: "DOMAIN", Value: "b.example", Action: "PROXY"},
+			{Type: "MATCH", Action: "REJECT"},
+		},
+	}
+
+	g := AnalyzeGroups(res)
+
+	wantNodes := []GraphNode{
+		{Name: "PROXY", Kind: GraphNodeGroup, Type: "select", RuleRefs: 2},
+		{Name: "AUTO", Kind: GraphNodeGroup, Type: "url-test", Proxies: 1},
+		{Name: "LOOP-A", Kind: GraphNodeGroup, Type: "select"},
+		{Name: "LOOP-B", Kind: GraphNodeGroup, Type: "select", RuleRefs: 1},
+		{Name: "SPARE", Kind: GraphNodeGroup, Type: "select", Proxies: 1},
+		{Name: "CHAIN-CORP", Kind: GraphNodeGroup, Type: "select", Proxies: 1},
+		{Name: "DIRECT", Kind: GraphNodeBuiltin},
+		{Name: "REJECT", Kind: GraphNodeBuiltin, RuleRefs: 1},
+	}
+	if !reflect.DeepEqual(g.Nodes, wantNodes) {
+		t.Fatalf("nodes=%+v\nwant=%+v", g.Nodes, wantNodes)
+	}
+	if len(g.Edges) != 4 || g.Edges[0] != (GraphEdge{From: "PROXY", To: "AUTO"}) {
+		t.Fatalf("edges=%+v", g.Edges)
+	}
+
+	codes := make([]string, 0, len(g.Issues))
+	for _, is := range g.Issues {
+		codes = append(codes, is.Code+":"+strings.Join(is.Groups, ">"))
+	}
+	wantCodes := []string{
+		"GROUP_REFERENCE_CYCLE:LOOP-A>LOOP-B>LOOP-A",
+		"RULE_TARGET_EMPTY_GROUP:LOOP-B",
+		"GROUP_NOT_RULE_TARGET:AUTO",
+		"GROUP_NOT_RULE_TARGET:LOOP-A",
+		"GROUP_UNREACHABLE:SPARE",
+	}
+	if !reflect.DeepEqual(codes, wantCodes) {
+		t.Fatalf("issues=%q\nwant=%q", codes, wantCodes)
+	}
+
+	dot := g.DOT()
+	for _, want := range []string{
+		`"group:AUTO" [shape=box, label="AUTO\nurl-test · 1 proxies"];`,
+		`"group:LOOP-B" [shape=box, label="LOOP-B\nselect", color=red];`,
+		`"group:SPARE" [shape=box, label="SPARE\nselect · 1 proxies", style=dashed];`,
+		`"rules" -> "group:PROXY" [label="2"];`,
+		`"group:PROXY" -> "builtin:DIRECT";`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Fatalf("dot missing %q:\n%s", want, dot)
+		}
+	}
+}
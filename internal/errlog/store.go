@@ -45,6 +45,7 @@ const (
 	ResourceSubscription ResourceKind = "subscription"
 	ResourceProfile      ResourceKind = "profile"
 	ResourceTemplate     ResourceKind = "template"
+	ResourceRuleset      ResourceKind = "ruleset"
 )
 
 type FailureRecord struct {
@@ -54,6 +55,7 @@ type FailureRecord struct {
 	HTTP          HTTPInfo           `json:"http"`
 	Convert       ConvertInfo        `json:"convert"`
 	Error         FailureError       `json:"error"`
+	Errors        []model.AppError   `json:"errors,omitempty"` // every error of a diagnostics request (Error.App is the first)
 	Resources     []ResourceSnapshot `json:"resources,omitempty"`
 	Summary       Summary            `json:"summary"`
 }
@@ -169,6 +171,13 @@ func (c *Collector) SetCompiledCounts(proxyCount, groupCount, ruleCount int) {
 }
 
 func (c *Collector) BuildFailure(now time.Time, status int, app model.AppError, cause error) FailureRecord {
+	return c.BuildFailures(now, status, []model.AppError{app}, cause)
+}
+
+// BuildFailures records a request that failed with several errors; apps[0]
+// (with status and cause) is the primary error. apps must not be empty.
+func (c *Collector) BuildFailures(now time.Time, status int, apps []model.AppError, cause error) FailureRecord {
+	app := apps[0]
 	if now.IsZero() {
 		now = time.Now()
 	}
@@ -188,6 +197,12 @@ func (c *Collector) BuildFailure(now time.Time, status int, app model.AppError,
 			Cause:  sanitizeTextURLs(errorString(cause)),
 		},
 	}
+	if len(apps) > 1 {
+		rec.Errors = make([]model.AppError, len(apps))
+		for i, a := range apps {
+			rec.Errors[i] = sanitizeAppError(a)
+		}
+	}
 	rec.RequestID = c.requestID
 	return rec
 }
@@ -145,6 +145,27 @@ func TestWriteFailure_JSONLAndRedaction(t *testing.T) {
 	}
 }
 
+func TestBuildFailures_RecordsEveryError(t *testing.T) {
+	collector := NewCollector("req-2", httptest.NewRequest("POST", "/api/convert", nil))
+	apps := []model.AppError{
+		{Code: "GROUP_PARSE_ERROR", Message: "a", Stage: "parse_profile", Line: 3},
+		{Code: "SUB_PARSE_ERROR", Message: "b", Stage: "parse_sub", URL: "https://example.com/sub?token=secret"},
+	}
+
+	rec := collector.BuildFailures(time.Time{}, 422, apps, nil)
+	if rec.Error.App.Code != "GROUP_PARSE_ERROR" || rec.Error.Status != 422 {
+		t.Fatalf("primary error=%+v", rec.Error)
+	}
+	if len(rec.Errors) != 2 || rec.Errors[1].URL != "https://example.com/sub?token=%3Credacted%3E" {
+		t.Fatalf("errors=%+v", rec.Errors)
+	}
+
+	// A single error keeps the record shape of BuildFailure.
+	if rec := collector.BuildFailures(time.Time{}, 422, apps[:1], nil); rec.Errors != nil {
+		t.Fatalf("single error should not fill errors: %+v", rec.Errors)
+	}
+}
+
 func TestWriteFailureFailure_DegradesStoreUntilProbeRecovers(t *testing.T) {
 	dir := t.TempDir()
 	failLogWrite := true
@@ -0,0 +1,282 @@
+package explain
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/compiler"
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/rules"
+)
+
+// Query describes a single connection to simulate. Empty fields mean the
+// connection does not carry that attribute (e.g. no Domain = connect by IP).
+type Query struct {
+	Domain  string `json:"domain,omitempty"`
+	IP      string `json:"ip,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	Process string `json:"process,omitempty"`
+}
+
+// Options carries runtime inputs that are not part of the compiled result.
+type Options struct {
+	// RulesetText maps ruleset URL -> fetched content for remote (non-inline)
+	// rulesets. Inline rulesets are already expanded in compiler.RulesetRef.Rules.
+	// Remote rulesets without content are reported as undetermined.
+	RulesetText map[string]string
+}
+
+// MaxUndetermined caps Report.Undetermined; Report.UndeterminedCount keeps the total.
+const MaxUndetermined = 20
+
+const (
+	SourceRuleset = "ruleset"
+	SourceRule    = "rule"
+)
+
+// Report is the result of walking the compiled rules for a Query.
+type Report struct {
+	Query Query     `json:"query"`
+	Match RuleTrace `json:"match"`
+
+	// Undetermined lists rules before Match whose outcome depends on data the
+	// simulator does not have (DNS, GeoIP database, source address...).
+	// If any of them matches on a real client, the client stops there instead.
+	Undetermined      []RuleTrace `json:"undetermined,omitempty"`
+	UndeterminedCount int         `json:"undetermined_count"`
+
+	// Policy is Match.Action resolved through the group graph.
+	Policy PolicyNode `json:"policy"`
+	// Proxies is the de-duplicated list of leaves reachable from Policy
+	// (proxy names and DIRECT/REJECT), in depth-first member order.
+	Proxies []string `json:"proxies"`
+}
+
+// RuleTrace locates one rule in the effective rule order.
+type RuleTrace struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+	Source string `json:"source"` // "ruleset" | "rule"
+
+	// Ruleset/URL are set for rules coming from a ruleset directive.
+	Ruleset string `json:"ruleset,omitempty"`
+	URL     string `json:"url,omitempty"`
+
+	// Index is the 1-based position of the rule within its source
+	// (ruleset entries or the profile rule list).
+	Index int `json:"index"`
+
+	Reason string `json:"reason,omitempty"`
+}
+
+const (
+	NodeProxy   = "proxy"
+	NodeGroup   = "group"
+	NodeBuiltin = "builtin"
+)
+
+// PolicyNode is one node of the policy tree rooted at the matched ACTION.
+type PolicyNode struct {
+	Name    string       `json:"name"`
+	Kind    string       `json:"kind"`           // "proxy" | "group" | "builtin"
+	Type    string       `json:"type,omitempty"` // group type
+	Members []PolicyNode `json:"members,omitempty"`
+
+	// Cycle marks a group already being expanded on the current path; its
+	// members are not repeated.
+	Cycle bool `json:"cycle,omitempty"`
+}
+
+type ExplainError struct {
+	AppError model.AppError
+	Cause    error
+}
+
+func (e *ExplainError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	if e.Cause == nil {
+		return fmt.Sprintf("%s: %s", e.AppError.Code, e.AppError.Message)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.AppError.Code, e.AppError.Message, e.Cause)
+}
+
+func (e *ExplainError) Unwrap() error { return e.Cause }
+
+// Explain walks res in the effective rule order (rulesets, then profile rules;
+// see SPEC_DETERMINISM.md) and reports the first rule that matches q.
+func Explain(res *compiler.Result, q Query, opt Options) (*Report, error) {
+	if res == nil {
+		return nil, &ExplainError{AppError: model.AppError{
+			Code:    "INTERNAL_ERROR",
+			Message: "编译结果不能为空",
+			Stage:   "explain",
+		}}
+	}
+	c, err := newConn(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &Report{Query: q}
+	var matched *RuleTrace
+	consider := func(r model.Rule, trace RuleTrace) bool {
+		switch v, reason := c.eval(r); v {
+		case outcomeMatch:
+			trace.Rule = rules.FormatRule(r)
+			trace.Action = r.Action
+			matched = &trace
+			return true
+		case outcomeUnknown:
+			rep.UndeterminedCount++
+			if len(rep.Undetermined) < MaxUndetermined {
+				trace.Rule = rules.FormatRule(r)
+				trace.Action = r.Action
+				trace.Reason = reason
+				rep.Undetermined = append(rep.Undetermined, trace)
+			}
+		}
+		return false
+	}
+
+walk:
+	for _, ref := range res.RulesetRefs {
+		expanded, reason := rulesetRules(ref, opt.RulesetText)
+		if reason != "" {
+			rep.UndeterminedCount++
+			if len(rep.Undetermined) < MaxUndetermined {
+				rep.Undetermined = append(rep.Undetermined, RuleTrace{
+					Rule:    "RULE-SET," + ref.URL + "," + ref.Action,
+					Action:  ref.Action,
+					Source:  SourceRuleset,
+					Ruleset: ref.Raw,
+					URL:     ref.URL,
+					Reason:  reason,
+				})
+			}
+			continue
+		}
+		for i, r := range expanded {
+			if consider(r, RuleTrace{Source: SourceRuleset, Ruleset: ref.Raw, URL: ref.URL, Index: i + 1}) {
+				break walk
+			}
+		}
+	}
+	if matched == nil {
+		for i, r := range res.Rules {
+			if consider(r, RuleTrace{Source: SourceRule, Index: i + 1}) {
+				break
+			}
+		}
+	}
+	if matched == nil {
+		// Compiled results always end with MATCH; reaching here is a bug.
+		return nil, &ExplainError{AppError: model.AppError{
+			Code:    "INTERNAL_ERROR",
+			Message: "没有任何规则命中（缺少 MATCH）",
+			Stage:   "explain",
+		}}
+	}
+	rep.Match = *matched
+
+	rep.Policy, rep.Proxies = resolvePolicy(res, matched.Action)
+	return rep, nil
+}
+
+func rulesetRules(ref compiler.RulesetRef, rulesetText map[string]string) ([]model.Rule, string) {
+	if ref.Inline {
+		return ref.Rules, ""
+	}
+	if ref.Format == rules.FormatMRS {
+		return nil, "mrs 二进制 ruleset 无法在服务端解析"
+	}
+	text, ok := rulesetText[ref.URL]
+	if !ok {
+		return nil, "ruleset 内容未拉取"
+	}
+	expanded, err := rules.ParseRulesetText(ref.URL, text, ref.Action, ref.Behavior, ref.Format)
+	if err != nil {
+		// Remote rulesets are not validated by the service (clients may accept
+		// types we do not parse); report instead of failing the explanation.
+		return nil, "ruleset 解析失败：" + err.Error()
+	}
+	return expanded, ""
+}
+
+func resolvePolicy(res *compiler.Result, action string) (PolicyNode, []string) {
+	groups := make(map[string]model.Group, len(res.Groups))
+	for _, g := range res.Groups {
+		groups[g.Name] = g
+	}
+	proxyNames := make(map[string]string, len(res.Proxies))
+	for _, p := range res.Proxies {
+		proxyNames[p.ID] = p.Name
+	}
+
+	var leaves []string
+	seenLeaf := make(map[string]struct{})
+	addLeaf := func(name string) {
+		if _, ok := seenLeaf[name]; ok {
+			return
+		}
+		seenLeaf[name] = struct{}{}
+		leaves = append(leaves, name)
+	}
+
+	visiting := make(map[string]bool)
+	var expand func(name string) PolicyNode
+	expand = func(name string) PolicyNode {
+		g, ok := groups[name]
+		if !ok {
+			addLeaf(name)
+			return PolicyNode{Name: name, Kind: NodeBuiltin}
+		}
+		node := PolicyNode{Name: g.Name, Kind: NodeGroup, Type: g.Type}
+		if visiting[name] {
+			node.Cycle = true
+			return node
+		}
+		visiting[name] = true
+		defer delete(visiting, name)
+
+		node.Members = make([]PolicyNode, 0, len(g.Members))
+		for _, m := range g.Members {
+			switch m.Kind {
+			case model.MemberRefProxy:
+				n := proxyNames[m.Value]
+				if n == "" {
+					n = m.Value
+				}
+				addLeaf(n)
+				node.Members = append(node.Members, PolicyNode{Name: n, Kind: NodeProxy})
+			case model.MemberRefGroup:
+				node.Members = append(node.Members, expand(m.Value))
+			default:
+				addLeaf(m.Value)
+				node.Members = append(node.Members, PolicyNode{Name: m.Value, Kind: NodeBuiltin})
+			}
+		}
+		return node
+	}
+
+	root := expand(action)
+	if leaves == nil {
+		leaves = []string{}
+	}
+	return root, leaves
+}
+
+// normalizeDomain lowercases and strips a trailing root dot.
+func normalizeDomain(s string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), ".")
+}
+
+func parseQueryIP(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
@@ -0,0 +1,166 @@
+package explain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/John-Robertt/subconverter-go/internal/compiler"
+	"github.com/John-Robertt/subconverter-go/internal/model"
+)
+
+func sampleResult() *compiler.Result {
+	return &compiler.Result{
+		Proxies: []model.Proxy{
+			{ID: "p1", Name: "HK-01"},
+			{ID: "p2", Name: "SG-01"},
+		},
+		Groups: []model.Group{
+			{Name: "PROXY", Type: "select", Members: []model.MemberRef{
+				{Kind: model.MemberRefGroup, Value: "AUTO"},
+				{Kind: model.MemberRefProxy, Value: "p2"},
+				{Kind: model.MemberRefBuiltin, Value: "DIRECT"},
+			}},
+			{Name: "AUTO", Type: "url-test", Members: []model.MemberRef{
+				{Kind: model.MemberRefProxy, Value: "p1"},
+				{Kind: model.MemberRefProxy, Value: "p2"},
+			}},
+		},
+		RulesetRefs: []compiler.RulesetRef{
+			{Raw: "DIRECT,inline:https://example.com/lan.list", Action: "DIRECT", URL: "https://example.com/lan.list", Inline: true, Rules: []model.Rule{
+				{Type: "IP-CIDR", Value: "192.168.0.0/16", Action: "DIRECT", NoResolve: true},
+			}},
+			{Raw: "PROXY,https://example.com/media.list", Action: "PROXY", URL: "https://example.com/media.list"},
+		},
+		Rules: []model.Rule{
+			{Type: "GEOIP", Value: "CN", Action: "DIRECT"},
+			{Type: "AND", Action: "DIRECT", Sub: []model.Rule{
+				{Type: "DST-PORT", Value: "8000-9000"},
+				{Type: "PROCESS-NAME", Value: "curl"},
+			}},
+			{Type: "MATCH", Action: "PROXY"},
+		},
+	}
+}
+
+func TestExplain_RemoteRulesetMatchAndPolicyTree(t *testing.T) {
+	res := sampleResult()
+	rep, err := Explain(res, Query{Domain: "www.Netflix.com."}, Options{RulesetText: map[string]string{
+		"https://example.com/media.list": "DOMAIN-KEYWORD,youtube\nDOMAIN-SUFFIX,netflix.com\n",
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantMatch := RuleTrace{
+		Rule:    "DOMAIN-SUFFIX,netflix.com,PROXY",
+		Action:  "PROXY",
+		Source:  SourceRuleset,
+		Ruleset: "PROXY,https://example.com/media.list",
+		URL:     "https://example.com/media.list",
+		Index:   2,
+	}
+	if rep.Match != wantMatch {
+		t.Fatalf("match=%+v, want=%+v", rep.Match, wantMatch)
+	}
+	// The no-resolve IP rule cannot match a domain-only connection; nothing is undetermined.
+	if rep.UndeterminedCount != 0 {
+		t.Fatalf("undetermined=%+v", rep.Undetermined)
+	}
+	wantPolicy := PolicyNode{Name: "PROXY", Kind: NodeGroup, Type: "select", Members: []PolicyNode{
+		{Name: "AUTO", Kind: NodeGroup, Type: "url-test", Members: []PolicyNode{
+			{Name: "HK-01", Kind: NodeProxy},
+			{Name: "SG-01", Kind: NodeProxy},
+		}},
+		{Name: "SG-01", Kind: NodeProxy},
+		{Name: "DIRECT", Kind: NodeBuiltin},
+	}}
+	if !reflect.DeepEqual(rep.Policy, wantPolicy) {
+		t.Fatalf("policy=%+v, want=%+v", rep.Policy, wantPolicy)
+	}
+	if want := []string{"HK-01", "SG-01", "DIRECT"}; !reflect.DeepEqual(rep.Proxies, want) {
+		t.Fatalf("proxies=%q, want=%q", rep.Proxies, want)
+	}
+}
+
+func TestExplain_UndeterminedRulesBeforeMatch(t *testing.T) {
+	res := sampleResult()
+	// Remote ruleset content missing + GEOIP without a GeoIP database.
+	rep, err := Explain(res, Query{IP: "1.1.1.1", Port: 8080, Process: "/usr/bin/curl"}, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rep.Match.Rule != "AND,((DST-PORT,8000-9000),(PROCESS-NAME,curl)),DIRECT" || rep.Match.Index != 2 || rep.Match.Source != SourceRule {
+		t.Fatalf("match=%+v", rep.Match)
+	}
+	if rep.UndeterminedCount != 2 || len(rep.Undetermined) != 2 {
+		t.Fatalf("undetermined=%+v", rep.Undetermined)
+	}
+	if rep.Undetermined[0].Rule != "RULE-SET,https://example.com/media.list,PROXY" || rep.Undetermined[1].Rule != "GEOIP,CN,DIRECT" {
+		t.Fatalf("undetermined=%+v", rep.Undetermined)
+	}
+	if !reflect.DeepEqual(rep.Policy, PolicyNode{Name: "DIRECT", Kind: NodeBuiltin}) || !reflect.DeepEqual(rep.Proxies, []string{"DIRECT"}) {
+		t.Fatalf("policy=%+v proxies=%q", rep.Policy, rep.Proxies)
+	}
+}
+
+func TestExplain_IPRules(t *testing.T) {
+	res := sampleResult()
+	rep, err := Explain(res, Query{IP: "192.168.1.10"}, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rep.Match.Rule != "IP-CIDR,192.168.0.0/16,DIRECT,no-resolve" || rep.Match.Source != SourceRuleset || rep.Match.Index != 1 {
+		t.Fatalf("match=%+v", rep.Match)
+	}
+
+	// Domain without IP: a resolving IP rule is undetermined (client does DNS).
+	res.Rules = append([]model.Rule{{Type: "IP-CIDR", Value: "10.0.0.0/8", Action: "DIRECT"}}, res.Rules...)
+	rep, err = Explain(res, Query{Domain: "example.org"}, Options{RulesetText: map[string]string{"https://example.com/media.list": ""}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rep.Match.Rule != "MATCH,PROXY" || rep.UndeterminedCount != 2 {
+		t.Fatalf("match=%+v undetermined=%+v", rep.Match, rep.Undetermined)
+	}
+}
+
+func TestExplain_DomainRegexCompiledOncePerPattern(t *testing.T) {
+	c, err := newConn(Query{Domain: "r1.googlevideo.com"})
+	if err != nil {
+		t.Fatalf("newConn: %v", err)
+	}
+	wildcard := model.Rule{Type: "DOMAIN-REGEX", Value: `^[^.]+\.googlevideo\.com$`, Action: "PROXY"}
+	for range 3 {
+		if v, _ := c.eval(wildcard); v != outcomeMatch {
+			t.Fatalf("outcome=%v, want match", v)
+		}
+	}
+	// Go RE2 has no lookahead: undetermined, and the failure is cached too.
+	if v, reason := c.eval(model.Rule{Type: "DOMAIN-REGEX", Value: `^(?!ad)`, Action: "REJECT"}); v != outcomeUnknown || reason == "" {
+		t.Fatalf("outcome=%v reason=%q, want unknown", v, reason)
+	}
+	if len(c.regexps) != 2 {
+		t.Fatalf("cached patterns=%d, want 2", len(c.regexps))
+	}
+
+	noDomain, err := newConn(Query{IP: "1.2.3.4"})
+	if err != nil {
+		t.Fatalf("newConn: %v", err)
+	}
+	if v, _ := noDomain.eval(wildcard); v != outcomeNoMatch || len(noDomain.regexps) != 0 {
+		t.Fatalf("outcome=%v cached=%d, want no match without compiling", v, len(noDomain.regexps))
+	}
+}
+
+func TestExplain_InvalidQuery(t *testing.T) {
+	for _, q := range []Query{{}, {IP: "not-an-ip"}, {Port: 70000}, {Domain: "a b"}} {
+		_, err := Explain(sampleResult(), q, Options{})
+		var ee *ExplainError
+		if !errors.As(err, &ee) {
+			t.Fatalf("query=%+v: expected ExplainError, got %T: %v", q, err, err)
+		}
+		if ee.AppError.Code != "INVALID_ARGUMENT" {
+			t.Fatalf("query=%+v: code=%q", q, ee.AppError.Code)
+		}
+	}
+}
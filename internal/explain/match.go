@@ -0,0 +1,252 @@
+package explain
+
+import (
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+)
+
+type outcome int
+
+const (
+	outcomeNoMatch outcome = iota
+	outcomeMatch
+	outcomeUnknown
+)
+
+// conn is the normalized form of a Query.
+type conn struct {
+	domain  string
+	ip      netip.Addr
+	port    int
+	process string // full value as given (PROCESS-PATH)
+	name    string // base name (PROCESS-NAME)
+
+	// regexps caches compiled DOMAIN-REGEX patterns (nil for patterns Go
+	// cannot compile), so each pattern is compiled once per query.
+	regexps map[string]*regexp.Regexp
+}
+
+// ValidateQuery reports whether q can be simulated (same checks as Explain).
+func ValidateQuery(q Query) error {
+	_, err := newConn(q)
+	return err
+}
+
+func newConn(q Query) (conn, error) {
+	c := conn{regexps: make(map[string]*regexp.Regexp)}
+	if strings.TrimSpace(q.Domain) == "" && strings.TrimSpace(q.IP) == "" && q.Port == 0 && strings.TrimSpace(q.Process) == "" {
+		return c, queryError("domain/ip/port/process 至少提供一个", "")
+	}
+	if q.Domain != "" {
+		c.domain = normalizeDomain(q.Domain)
+		if c.domain == "" || strings.ContainsAny(c.domain, " \t,/") {
+			return c, queryError("domain 不合法", q.Domain)
+		}
+	}
+	if strings.TrimSpace(q.IP) != "" {
+		addr, err := parseQueryIP(q.IP)
+		if err != nil {
+			return c, queryError("ip 不合法", q.IP)
+		}
+		c.ip = addr
+	}
+	if q.Port != 0 {
+		if q.Port < 1 || q.Port > 65535 {
+			return c, queryError("port 超出范围（1-65535）", strconv.Itoa(q.Port))
+		}
+		c.port = q.Port
+	}
+	if p := strings.TrimSpace(q.Process); p != "" {
+		c.process = p
+		c.name = p[strings.LastIndexAny(p, `/\`)+1:]
+	}
+	return c, nil
+}
+
+func queryError(message, snippet string) error {
+	return &ExplainError{AppError: model.AppError{
+		Code:    "INVALID_ARGUMENT",
+		Message: message,
+		Stage:   "validate_request",
+		Snippet: snippet,
+	}}
+}
+
+// eval decides whether r matches the connection. Attributes absent from the
+// query count as absent from the connection; outcomeUnknown is reserved for
+// rules whose result depends on data the simulator does not have.
+func (c conn) eval(r model.Rule) (outcome, string) {
+	switch r.Type {
+	case "MATCH":
+		return outcomeMatch, ""
+	case "AND", "OR", "NOT":
+		return c.evalLogical(r)
+	case "DOMAIN":
+		return c.domainRule(func(d string) bool { return d == normalizeDomain(r.Value) })
+	case "DOMAIN-SUFFIX":
+		return c.domainRule(func(d string) bool {
+			suffix := normalizeDomain(r.Value)
+			return d == suffix || strings.HasSuffix(d, "."+suffix)
+		})
+	case "DOMAIN-KEYWORD":
+		return c.domainRule(func(d string) bool { return strings.Contains(d, strings.ToLower(r.Value)) })
+	case "DOMAIN-REGEX":
+		if c.domain == "" {
+			return outcomeNoMatch, ""
+		}
+		re := c.regexp(r.Value)
+		if re == nil {
+			return outcomeUnknown, "正则无法按 Go RE2 语法编译"
+		}
+		return c.domainRule(re.MatchString)
+	case "GEOSITE":
+		if c.domain == "" {
+			return outcomeNoMatch, ""
+		}
+		return outcomeUnknown, "GEOSITE 依赖客户端的 geosite 数据库"
+	case "URL-REGEX":
+		if c.domain == "" {
+			return outcomeNoMatch, ""
+		}
+		return outcomeUnknown, "URL-REGEX 依赖完整请求 URL"
+	case "IP-CIDR", "IP-CIDR6":
+		prefix, err := netip.ParsePrefix(r.Value)
+		if err != nil {
+			return outcomeUnknown, "CIDR 无法解析"
+		}
+		return c.ipRule(r.NoResolve, func(ip netip.Addr) (outcome, string) {
+			if prefix.Contains(ip) {
+				return outcomeMatch, ""
+			}
+			return outcomeNoMatch, ""
+		})
+	case "GEOIP":
+		return c.ipRule(r.NoResolve, func(netip.Addr) (outcome, string) {
+			return outcomeUnknown, "GEOIP 依赖客户端的 GeoIP 数据库"
+		})
+	case "IP-ASN":
+		return c.ipRule(r.NoResolve, func(netip.Addr) (outcome, string) {
+			return outcomeUnknown, "IP-ASN 依赖客户端的 ASN 数据库"
+		})
+	case "DST-PORT":
+		if c.port == 0 {
+			return outcomeNoMatch, ""
+		}
+		if portInRange(c.port, r.Value) {
+			return outcomeMatch, ""
+		}
+		return outcomeNoMatch, ""
+	case "PROCESS-NAME":
+		if c.name != "" && strings.EqualFold(c.name, r.Value) {
+			return outcomeMatch, ""
+		}
+		return outcomeNoMatch, ""
+	case "PROCESS-PATH":
+		if c.process != "" && strings.EqualFold(c.process, r.Value) {
+			return outcomeMatch, ""
+		}
+		return outcomeNoMatch, ""
+	case "SRC-IP-CIDR", "SRC-PORT":
+		return outcomeUnknown, r.Type + " 依赖连接来源地址"
+	case "NETWORK":
+		return outcomeUnknown, "NETWORK 依赖连接协议（TCP/UDP）"
+	default:
+		return outcomeUnknown, "不支持模拟的规则类型：" + r.Type
+	}
+}
+
+// regexp returns the compiled pattern, or nil when it does not compile.
+func (c conn) regexp(pattern string) *regexp.Regexp {
+	re, ok := c.regexps[pattern]
+	if !ok {
+		re, _ = regexp.Compile(pattern)
+		c.regexps[pattern] = re
+	}
+	return re
+}
+
+func (c conn) domainRule(match func(string) bool) (outcome, string) {
+	if c.domain == "" {
+		return outcomeNoMatch, ""
+	}
+	if match(c.domain) {
+		return outcomeMatch, ""
+	}
+	return outcomeNoMatch, ""
+}
+
+// ipRule applies an IP-based rule. Without a query IP, a domain connection
+// would be resolved by the client unless the rule carries no-resolve.
+func (c conn) ipRule(noResolve bool, match func(netip.Addr) (outcome, string)) (outcome, string) {
+	if c.ip.IsValid() {
+		return match(c.ip)
+	}
+	if c.domain == "" || noResolve {
+		return outcomeNoMatch, ""
+	}
+	return outcomeUnknown, "需要 DNS 解析（可在查询中提供 ip）"
+}
+
+func (c conn) evalLogical(r model.Rule) (outcome, string) {
+	switch r.Type {
+	case "NOT":
+		if len(r.Sub) != 1 {
+			return outcomeUnknown, "NOT 子规则数量不合法"
+		}
+		v, reason := c.eval(r.Sub[0])
+		switch v {
+		case outcomeMatch:
+			return outcomeNoMatch, ""
+		case outcomeNoMatch:
+			return outcomeMatch, ""
+		}
+		return outcomeUnknown, reason
+	case "AND":
+		result, reason := outcomeMatch, ""
+		for _, sub := range r.Sub {
+			switch v, why := c.eval(sub); v {
+			case outcomeNoMatch:
+				return outcomeNoMatch, ""
+			case outcomeUnknown:
+				if result != outcomeUnknown {
+					result, reason = outcomeUnknown, why
+				}
+			}
+		}
+		return result, reason
+	default: // OR
+		result, reason := outcomeNoMatch, ""
+		for _, sub := range r.Sub {
+			switch v, why := c.eval(sub); v {
+			case outcomeMatch:
+				return outcomeMatch, ""
+			case outcomeUnknown:
+				if result != outcomeUnknown {
+					result, reason = outcomeUnknown, why
+				}
+			}
+		}
+		return result, reason
+	}
+}
+
+// portInRange reports whether port matches "N" or "A-B" (validated by the parser).
+func portInRange(port int, value string) bool {
+	lo, hi, isRange := strings.Cut(value, "-")
+	start, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return false
+	}
+	end := start
+	if isRange {
+		end, err = strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil {
+			return false
+		}
+	}
+	return port >= start && port <= end
+}
@@ -20,6 +20,7 @@ const (
 	KindSubscription Kind = iota
 	KindProfile
 	KindTemplate
+	KindRuleset
 )
 
 func (k Kind) stage() string {
@@ -30,13 +31,17 @@ func (k Kind) stage() string {
 		return "fetch_profile"
 	case KindTemplate:
 		return "fetch_template"
+	case KindRuleset:
+		return "fetch_ruleset"
 	default:
 		// Unknown kind is a programmer error; still return something stable.
 		return "fetch"
 	}
 }
 
-func (k Kind) defaultMaxBytes() int64 {
+// DefaultMaxBytes is the size cap of kind when Options.MaxBytes is zero. It
+// also bounds content of that kind supplied inline in a request.
+func (k Kind) DefaultMaxBytes() int64 {
 	// Defaults from docs/spec/SPEC_FETCH.md.
 	switch k {
 	case KindSubscription:
@@ -45,6 +50,8 @@ func (k Kind) defaultMaxBytes() int64 {
 		return 1 * 1024 * 1024
 	case KindTemplate:
 		return 2 * 1024 * 1024
+	case KindRuleset:
+		return 5 * 1024 * 1024
 	default:
 		return 1 * 1024 * 1024
 	}
@@ -97,7 +104,7 @@ func FetchTextWithOptions(ctx context.Context, kind Kind, rawURL string, opt Opt
 	}
 	maxBytes := opt.MaxBytes
 	if maxBytes == 0 {
-		maxBytes = kind.defaultMaxBytes()
+		maxBytes = kind.DefaultMaxBytes()
 	}
 	if maxBytes <= 0 {
 		return "", &FetchError{
@@ -133,3 +133,29 @@ func TestFetchText_RedirectToNonHTTP(t *testing.T) {
 		t.Fatalf("code=%q, want=%q", fe.AppError.Code, "INVALID_ARGUMENT")
 	}
 }
+
+func TestResolveIncludeURL(t *testing.T) {
+	for _, tc := range []struct {
+		base, ref, want string
+	}{
+		{"https://example.com/a/main.yaml", " part.yaml ", "https://example.com/a/part.yaml"},
+		{"https://example.com/a/main.yaml", "../b/part.yaml", "https://example.com/b/part.yaml"},
+		{"builtin:default", "https://example.com/part.ini", "https://example.com/part.ini"},
+	} {
+		got, err := ResolveIncludeURL(tc.base, tc.ref)
+		if err != nil || got != tc.want {
+			t.Fatalf("ResolveIncludeURL(%q, %q)=%q, %v; want %q", tc.base, tc.ref, got, err, tc.want)
+		}
+	}
+
+	for _, tc := range []struct{ base, ref string }{
+		{"https://example.com/main.yaml", "  "},
+		{"https://example.com/main.yaml", "file:///etc/passwd"},
+		{"builtin:default", "part.ini"},
+		{"", "part.yaml"},
+	} {
+		if got, err := ResolveIncludeURL(tc.base, tc.ref); err == nil {
+			t.Fatalf("ResolveIncludeURL(%q, %q)=%q, want error", tc.base, tc.ref, got)
+		}
+	}
+}
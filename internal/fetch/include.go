@@ -0,0 +1,44 @@
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Loader returns the content of an included document (a partial profile or a
+// template snippet). Its errors are returned unchanged, so they should
+// already attribute the URL (as FetchError does).
+type Loader func(url string) (string, error)
+
+// Include limits, counted over the whole include tree of one document.
+const (
+	MaxIncludeDepth = 8
+	MaxIncludes     = 32
+)
+
+// ResolveIncludeURL resolves ref against the including document's URL, so
+// included documents can reference siblings with relative paths. The result
+// must be an http/https URL.
+func ResolveIncludeURL(base, ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", errors.New("empty include URL")
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	if !u.IsAbs() {
+		b, err := url.Parse(base)
+		if err != nil || !b.IsAbs() {
+			return "", fmt.Errorf("relative include URL without an absolute base: %s", ref)
+		}
+		u = b.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("scheme must be http/https")
+	}
+	return u.String(), nil
+}
@@ -5,14 +5,17 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/John-Robertt/subconverter-go/internal/compiler"
 	"github.com/John-Robertt/subconverter-go/internal/errlog"
+	"github.com/John-Robertt/subconverter-go/internal/explain"
 	"github.com/John-Robertt/subconverter-go/internal/fetch"
 	"github.com/John-Robertt/subconverter-go/internal/model"
 	"github.com/John-Robertt/subconverter-go/internal/profile"
 	"github.com/John-Robertt/subconverter-go/internal/render"
+	"github.com/John-Robertt/subconverter-go/internal/rules"
 	"github.com/John-Robertt/subconverter-go/internal/sub/ss"
 	"github.com/John-Robertt/subconverter-go/internal/template"
 )
@@ -56,17 +59,50 @@ func writeErrorFromErr(w http.ResponseWriter, r *http.Request, err error, collec
 		return
 	}
 
-	ce := classifyError(err)
-	metricsIncAppError(ce.app.Stage, ce.app.Code)
+	// A diagnostics run reports every error; the first one decides the status.
+	errs := splitErrors(err)
+	ce := classifyError(errs[0])
+	apps := make([]model.AppError, 0, len(errs))
+	for _, e := range errs {
+		app := classifyError(e).app
+		if slices.Contains(apps, app) {
+			continue
+		}
+		metricsIncAppError(app.Stage, app.Code)
+		apps = append(apps, app)
+	}
 
 	if collector != nil && store != nil {
-		record := collector.BuildFailure(time.Now(), ce.status, ce.app, ce.cause)
+		record := collector.BuildFailures(time.Now(), ce.status, apps, ce.cause)
 		if writeErr := store.WriteFailure(record); writeErr != nil {
 			log.Printf("write error snapshot failed: %v", writeErr)
 		}
 	}
 
-	WriteError(w, ce.status, ce.app)
+	if len(apps) == 1 {
+		WriteError(w, ce.status, ce.app)
+		return
+	}
+	WriteJSON(w, ce.status, model.ErrorResponse{Error: apps[0], Errors: apps})
+}
+
+// splitErrors flattens errors.Join trees (as returned by diagnostics runs)
+// into their leaves, in order. Other errors are returned as the only element.
+func splitErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var out []error
+	for _, e := range joined.Unwrap() {
+		if e != nil {
+			out = append(out, splitErrors(e)...)
+		}
+	}
+	if len(out) == 0 {
+		return []error{err}
+	}
+	return out
 }
 
 func classifyError(err error) classifiedError {
@@ -85,6 +121,10 @@ func classifyError(err error) classifiedError {
 	if errors.As(err, &se) {
 		return classifiedError{status: http.StatusUnprocessableEntity, app: se.AppError, cause: se.Cause}
 	}
+	var rse *rules.ParseError
+	if errors.As(err, &rse) {
+		return classifiedError{status: http.StatusUnprocessableEntity, app: rse.AppError, cause: rse.Cause}
+	}
 
 	var pe *profile.ParseError
 	if errors.As(err, &pe) {
@@ -101,6 +141,15 @@ func classifyError(err error) classifiedError {
 		return classifiedError{status: http.StatusUnprocessableEntity, app: re.AppError, cause: re.Cause}
 	}
 
+	var ee *explain.ExplainError
+	if errors.As(err, &ee) {
+		status := http.StatusInternalServerError
+		if ee.AppError.Code == "INVALID_ARGUMENT" {
+			status = http.StatusBadRequest
+		}
+		return classifiedError{status: status, app: ee.AppError, cause: ee.Cause}
+	}
+
 	var te *template.TemplateError
 	if errors.As(err, &te) {
 		return classifiedError{status: http.StatusUnprocessableEntity, app: te.AppError, cause: te.Cause}
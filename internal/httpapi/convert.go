@@ -7,8 +7,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -31,18 +33,36 @@ type convertRequest struct {
 	Profile  string
 	FileName string // optional: output attachment file base name (without path)
 	Encode   string // only for mode=list: "base64" | "raw"
+	// Vars overrides profile `vars:` (mode=config only).
+	Vars map[string]string
+	// ProfileContent / TemplateContent are inlined in a POST body instead of
+	// fetched (ProfileContent replaces Profile; TemplateContent replaces the
+	// profile's template URL for Target).
+	ProfileContent  string
+	TemplateContent string
+	// Diagnostics reports every error the profile, subscription, compile and
+	// render stages find instead of stopping at the first (POST only).
+	Diagnostics bool
 }
 
 type convertRequestJSON struct {
-	Mode     string   `json:"mode"`
-	Target   string   `json:"target"`
-	Subs     []string `json:"subs"`
-	Profile  string   `json:"profile"`
-	FileName string   `json:"fileName"`
-	Encode   string   `json:"encode"`
+	Mode     string            `json:"mode"`
+	Target   string            `json:"target"`
+	Subs     []string          `json:"subs"`
+	Profile  string            `json:"profile"`
+	FileName string            `json:"fileName"`
+	Encode   string            `json:"encode"`
+	Vars     map[string]string `json:"vars"`
+
+	ProfileContent  string `json:"profileContent"`
+	TemplateContent string `json:"templateContent"`
+	Diagnostics     bool   `json:"diagnostics"`
 }
 
-func runConvert(ctx context.Context, r *http.Request, req convertRequest, opt Options, collector *errlog.Collector) (string, error) {
+// runConvert produces the response body. Response headers that depend on the
+// converted profile (Clash profile-update-interval etc.) are set on header,
+// which may be nil.
+func runConvert(ctx context.Context, r *http.Request, req convertRequest, opt Options, collector *errlog.Collector, header http.Header) (string, error) {
 	opt = opt.withDefaults()
 
 	// Keep a hard upper bound so handlers don't hang forever if upstream misbehaves.
@@ -51,7 +71,7 @@ func runConvert(ctx context.Context, r *http.Request, req convertRequest, opt Op
 
 	switch req.Mode {
 	case "list":
-		subs, err := fetchAndParseSubs(ctx, req.Subs, opt.FetchTimeout, collector)
+		subs, err := fetchAndParseSubs(ctx, req.Subs, opt.FetchTimeout, collector, req.Diagnostics)
 		if err != nil {
 			return "", err
 		}
@@ -80,31 +100,58 @@ func runConvert(ctx context.Context, r *http.Request, req convertRequest, opt Op
 		}
 	case "config":
 		type profResult struct {
-			prof     *profile.Spec
-			snapshot *errlog.ResourceSnapshot
-			err      error
+			prof      *profile.Spec
+			v         profile.Validation // diagnostics only
+			snapshots []errlog.ResourceSnapshot
+			err       error
 		}
 		profCh := make(chan profResult, 1)
 		go func() {
-			p, snapshot, err := fetchAndParseProfile(ctx, req.Profile, string(req.Target), opt.FetchTimeout)
-			profCh <- profResult{prof: p, snapshot: snapshot, err: err}
+			src := profileSource{
+				URL:     req.Profile,
+				Content: req.ProfileContent,
+				Vars:    req.Vars,
+			}
+			var pr profResult
+			if req.Diagnostics {
+				pr.v, pr.snapshots, pr.err = fetchAndValidateProfile(ctx, src, string(req.Target), opt.FetchTimeout)
+			} else {
+				pr.prof, pr.snapshots, pr.err = fetchAndParseProfile(ctx, src, string(req.Target), opt.FetchTimeout)
+			}
+			profCh <- pr
 		}()
 
-		subs, err := fetchAndParseSubs(ctx, req.Subs, opt.FetchTimeout, collector)
-		if err != nil {
-			return "", err
+		subs, subErr := fetchAndParseSubs(ctx, req.Subs, opt.FetchTimeout, collector, req.Diagnostics)
+		if subErr != nil && !req.Diagnostics {
+			return "", subErr
 		}
 
 		pr := <-profCh
-		if collector != nil && pr.snapshot != nil {
-			collector.AddResource(*pr.snapshot)
+		if collector != nil {
+			for _, snapshot := range pr.snapshots {
+				collector.AddResource(snapshot)
+			}
 		}
 		if pr.err != nil {
+			if subErr != nil {
+				return "", errors.Join(pr.err, subErr)
+			}
 			return "", pr.err
 		}
 		prof := pr.prof
+		if req.Diagnostics {
+			if errs := diagnoseInputs(pr.v, subs, subErr, string(req.Target)); len(errs) > 0 {
+				return "", errors.Join(errs...)
+			}
+			prof = pr.v.Spec
+		}
 
-		res, err := compiler.Compile(subs, prof)
+		rulesetText, err := fetchRulesets(ctx, prof, forTarget(string(req.Target), isInlineRuleset), opt.FetchTimeout, collector)
+		if err != nil {
+			return "", err
+		}
+
+		res, err := compiler.CompileWithOptions(subs, prof, compiler.Options{RulesetText: rulesetText, Target: string(req.Target)})
 		if err != nil {
 			return "", err
 		}
@@ -112,21 +159,37 @@ func runConvert(ctx context.Context, r *http.Request, req convertRequest, opt Op
 			collector.SetCompiledCounts(len(res.Proxies), len(res.Groups), len(res.Rules))
 		}
 
-		blocks, err := render.Render(req.Target, res)
+		blocks, err := render.RenderWithOptions(req.Target, res, render.Options{UnsupportedRules: prof.UnsupportedRules, CollectErrors: req.Diagnostics})
 		if err != nil {
 			return "", err
 		}
 
-		templateURL := prof.Template[string(req.Target)]
-		templateText, err := fetch.FetchTextWithOptions(ctx, fetch.KindTemplate, templateURL, fetch.Options{Timeout: opt.FetchTimeout})
-		if err != nil {
-			return "", err
+		templateURL, templateText := "", req.TemplateContent
+		if templateText == "" {
+			templateURL = prof.TemplateFor(string(req.Target))
+			if template.IsBuiltin(templateURL) {
+				templateText, err = template.Builtin(req.Target, templateURL)
+			} else {
+				templateText, err = fetch.FetchTextWithOptions(ctx, fetch.KindTemplate, templateURL, fetch.Options{Timeout: opt.FetchTimeout})
+			}
+			if err != nil {
+				return "", err
+			}
 		}
 		if collector != nil {
 			collector.AddResource(errlog.NewResourceSnapshot(errlog.ResourceTemplate, templateURL, templateText))
 		}
+		templateText, err = template.Preprocess(templateText, template.PreprocessOptions{
+			Target:      req.Target,
+			TemplateURL: templateURL,
+			Vars:        prof.Vars,
+			LoadInclude: templateIncludeLoader(ctx, opt.FetchTimeout, collector),
+		})
+		if err != nil {
+			return "", err
+		}
 
-		out, err := template.InjectAnchors(templateText, blocks, template.AnchorOptions{
+		out, err := template.Inject(templateText, blocks, template.AnchorOptions{
 			Target:      req.Target,
 			TemplateURL: templateURL,
 		})
@@ -134,12 +197,10 @@ func runConvert(ctx context.Context, r *http.Request, req convertRequest, opt Op
 			return "", err
 		}
 
-		if req.Target == render.TargetSurge {
-			currentURL, err := buildSurgeManagedConfigURL(r, req, prof.PublicBaseURL)
-			if err != nil {
-				return "", err
-			}
-			out, err = template.EnsureSurgeManagedConfig(out, currentURL, templateURL)
+		// Inline content cannot be refetched through a GET URL, so it gets no
+		// managed-config line / update URL.
+		if req.ProfileContent == "" && req.TemplateContent == "" {
+			out, err = applyManagedConfig(r, req, prof, out, templateURL, header)
 			if err != nil {
 				return "", err
 			}
@@ -151,7 +212,32 @@ func runConvert(ctx context.Context, r *http.Request, req convertRequest, opt Op
 	}
 }
 
-func fetchAndParseSubs(ctx context.Context, subURLs []string, fetchTimeout time.Duration, collector *errlog.Collector) ([]model.Proxy, error) {
+// diagnoseInputs lists every problem of the profile and subscriptions of a
+// diagnostics request, including the compile-stage reference checks. Sample
+// proxies are only checked when every subscription parsed, so that a broken
+// subscription does not also surface as empty groups.
+func diagnoseInputs(v profile.Validation, subs []model.Proxy, subErr error, target string) []error {
+	errs := slices.Clone(v.Errors)
+	if subErr != nil {
+		errs = append(errs, splitErrors(subErr)...)
+		subs = nil
+	}
+	if v.Spec != nil {
+		errs = append(errs, compiler.Check(v.Spec, compiler.CheckOptions{
+			Target:    target,
+			Subs:      subs,
+			Ignore:    v.BrokenNames,
+			SkipMatch: v.BrokenMatch,
+		})...)
+	}
+	return errs
+}
+
+// fetchAndParseSubs fetches and parses the subscriptions in order. With
+// collectAll (diagnostics) it does not stop at the first failure: the proxies
+// that parsed are returned together with every fetch / line error
+// (errors.Join, in URL then line order).
+func fetchAndParseSubs(ctx context.Context, subURLs []string, fetchTimeout time.Duration, collector *errlog.Collector, collectAll bool) ([]model.Proxy, error) {
 	// Fast fail: validate and trim.
 	urls := make([]string, 0, len(subURLs))
 	for _, raw := range subURLs {
@@ -162,9 +248,113 @@ func fetchAndParseSubs(ctx context.Context, subURLs []string, fetchTimeout time.
 		urls = append(urls, u)
 	}
 
-	// Dedup within the same request: identical URLs are fetched once.
-	// This does not change the compiled result because the compiler will
-	// deduplicate proxies by semantic key; repeating the same URL is redundant.
+	// Identical URLs are fetched once. This does not change the compiled
+	// result because the compiler deduplicates proxies by semantic key.
+	type result struct {
+		proxies  []model.Proxy
+		snapshot errlog.ResourceSnapshot
+		err      error
+	}
+	parse := ss.ParseSubscriptionText
+	if collectAll {
+		parse = ss.ParseSubscriptionTextAll
+	}
+	out := make([]model.Proxy, 0)
+	var errs []error
+	err := fetchOrdered(ctx, urls,
+		func(ctx context.Context, u string) (r result) {
+			text, err := fetch.FetchTextWithOptions(ctx, fetch.KindSubscription, u, fetch.Options{Timeout: fetchTimeout})
+			if err != nil {
+				r.err = err
+				return r
+			}
+			r.snapshot = errlog.NewResourceSnapshot(errlog.ResourceSubscription, u, text)
+			r.proxies, r.err = parse(u, text)
+			return r
+		},
+		func(_ string, r result) error {
+			if collector != nil && r.snapshot.Kind != "" {
+				collector.AddResource(r.snapshot)
+			}
+			if r.err != nil {
+				if !collectAll {
+					return r.err
+				}
+				errs = append(errs, r.err)
+			}
+			out = append(out, r.proxies...)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return out, errors.Join(errs...)
+	}
+
+	if len(out) == 0 {
+		return nil, &compiler.CompileError{
+			AppError: model.AppError{
+				Code:    "SUB_PARSE_ERROR",
+				Message: "订阅中没有任何可用节点",
+				Stage:   "compile",
+			},
+		}
+	}
+	if collector != nil {
+		collector.SetParsedProxyCount(len(out))
+	}
+	return out, nil
+}
+
+// fetchRulesets fetches every ruleset of the profile selected by want (deduplicated by URL).
+// Parsing happens in the compiler so that errors are attributed to the directive ACTION.
+func fetchRulesets(ctx context.Context, prof *profile.Spec, want func(profile.RulesetSpec) bool, fetchTimeout time.Duration, collector *errlog.Collector) (map[string]string, error) {
+	urls := make([]string, 0)
+	for _, rs := range prof.Ruleset {
+		if want(rs) {
+			urls = append(urls, rs.URL)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	type result struct {
+		text string
+		err  error
+	}
+	out := make(map[string]string, len(urls))
+	err := fetchOrdered(ctx, urls,
+		func(ctx context.Context, u string) (r result) {
+			r.text, r.err = fetch.FetchTextWithOptions(ctx, fetch.KindRuleset, u, fetch.Options{Timeout: fetchTimeout})
+			return r
+		},
+		func(u string, r result) error {
+			if r.err != nil {
+				return r.err
+			}
+			if collector != nil {
+				collector.AddResource(errlog.NewResourceSnapshot(errlog.ResourceRuleset, u, r.text))
+			}
+			out[u] = r.text
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// maxConcurrentFetches limits the upstream requests fetchOrdered runs at once
+// to avoid hammering upstreams.
+const maxConcurrentFetches = 4
+
+// fetchOrdered runs fetchOne for every distinct URL concurrently, but hands
+// the results to consume in first-seen URL order, so error semantics stay
+// deterministic. The first error returned by consume cancels the remaining
+// fetches and is returned as is.
+func fetchOrdered[R any](ctx context.Context, urls []string, fetchOne func(ctx context.Context, u string) R, consume func(u string, r R) error) error {
 	unique := make([]string, 0, len(urls))
 	seen := make(map[string]struct{}, len(urls))
 	for _, u := range urls {
@@ -175,32 +365,18 @@ func fetchAndParseSubs(ctx context.Context, subURLs []string, fetchTimeout time.
 		unique = append(unique, u)
 	}
 
-	// Fetch+parse concurrently, but consume results in the deterministic
-	// (first-seen) URL order to keep error semantics intuitive.
-	type result struct {
-		proxies  []model.Proxy
-		snapshot errlog.ResourceSnapshot
-		err      error
-	}
-	results := make([]result, len(unique))
+	results := make([]R, len(unique))
 	done := make([]chan struct{}, len(unique))
 	for i := range done {
 		done[i] = make(chan struct{})
 	}
-
-	// Limit concurrency to avoid hammering upstreams.
-	limit := 4
-	if len(unique) < limit {
-		limit = len(unique)
-	}
-	sem := make(chan struct{}, limit)
+	sem := make(chan struct{}, min(maxConcurrentFetches, max(len(unique), 1)))
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	var wg sync.WaitGroup
 	for i, u := range unique {
-		i, u := i, u
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -209,68 +385,112 @@ func fetchAndParseSubs(ctx context.Context, subURLs []string, fetchTimeout time.
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			text, err := fetch.FetchTextWithOptions(ctx, fetch.KindSubscription, u, fetch.Options{Timeout: fetchTimeout})
-			if err != nil {
-				results[i].err = err
-				return
-			}
-			results[i].snapshot = errlog.NewResourceSnapshot(errlog.ResourceSubscription, u, text)
-			proxies, err := ss.ParseSubscriptionText(u, text)
-			if err != nil {
-				results[i].err = err
-				return
-			}
-			results[i].proxies = proxies
+			results[i] = fetchOne(ctx, u)
 		}()
 	}
 
-	out := make([]model.Proxy, 0)
-	for i := range unique {
+	for i, u := range unique {
 		<-done[i]
-		if collector != nil && results[i].snapshot.Kind != "" {
-			collector.AddResource(results[i].snapshot)
-		}
-		if results[i].err != nil {
-			// Stop remaining fetches ASAP; preserve stable "first failing URL"
-			// semantics (based on the deterministic URL order above).
+		if err := consume(u, results[i]); err != nil {
+			// Stop remaining fetches ASAP; the first failing URL stays stable.
 			cancel()
 			wg.Wait()
-			return nil, results[i].err
+			return err
 		}
-		out = append(out, results[i].proxies...)
 	}
 	wg.Wait()
+	return nil
+}
 
-	if len(out) == 0 {
-		return nil, &compiler.CompileError{
-			AppError: model.AppError{
-				Code:    "SUB_PARSE_ERROR",
-				Message: "订阅中没有任何可用节点",
-				Stage:   "compile",
-			},
-		}
-	}
-	if collector != nil {
-		collector.SetParsedProxyCount(len(out))
-	}
-	return out, nil
+func isInlineRuleset(rs profile.RulesetSpec) bool { return rs.Inline }
+
+// forTarget narrows want to the rulesets that apply to target (see profile targets).
+func forTarget(target string, want func(profile.RulesetSpec) bool) func(profile.RulesetSpec) bool {
+	return func(rs profile.RulesetSpec) bool { return rs.Targets.Includes(target) && want(rs) }
 }
 
-func fetchAndParseProfile(ctx context.Context, profileURL string, requiredTarget string, fetchTimeout time.Duration) (*profile.Spec, *errlog.ResourceSnapshot, error) {
-	profileURL = strings.TrimSpace(profileURL)
-	if profileURL == "" {
-		return nil, nil, requestError("INVALID_ARGUMENT", "profile 不能为空", "")
-	}
-	text, err := fetch.FetchTextWithOptions(ctx, fetch.KindProfile, profileURL, fetch.Options{Timeout: fetchTimeout})
+// profileSource is where a profile comes from: a URL, or content inlined in
+// the request (URL then stays empty).
+type profileSource struct {
+	URL     string
+	Content string
+	// Vars overrides the profile's `vars:` defaults.
+	Vars map[string]string
+}
+
+// fetchAndParseProfile fetches (unless inlined) and parses the profile,
+// fetching its includes with the profile kind. The snapshots cover the
+// profile and every include fetched, in fetch order.
+func fetchAndParseProfile(ctx context.Context, src profileSource, requiredTarget string, fetchTimeout time.Duration) (*profile.Spec, []errlog.ResourceSnapshot, error) {
+	text, snapshots, err := fetchProfileText(ctx, src, fetchTimeout)
 	if err != nil {
 		return nil, nil, err
 	}
-	snapshot := errlog.NewResourceSnapshot(errlog.ResourceProfile, profileURL, text)
-	prof, err := profile.ParseProfileYAML(profileURL, text, requiredTarget)
+	loadInclude := includeLoader(ctx, fetchTimeout, &snapshots)
+	prof, err := profile.ParseProfileYAMLWithOptions(strings.TrimSpace(src.URL), text, requiredTarget, profile.ParseOptions{LoadInclude: loadInclude, Vars: src.Vars})
+	if err != nil {
+		return nil, snapshots, err
+	}
+	return prof, snapshots, nil
+}
+
+// fetchAndValidateProfile is fetchAndParseProfile collecting every profile
+// error (profile.ValidateProfileYAML). Only a failure to fetch the profile
+// itself is returned as the error.
+func fetchAndValidateProfile(ctx context.Context, src profileSource, requiredTarget string, fetchTimeout time.Duration) (profile.Validation, []errlog.ResourceSnapshot, error) {
+	text, snapshots, err := fetchProfileText(ctx, src, fetchTimeout)
 	if err != nil {
-		return nil, &snapshot, err
+		return profile.Validation{}, nil, err
+	}
+	loadInclude := includeLoader(ctx, fetchTimeout, &snapshots)
+	v := profile.ValidateProfileYAML(strings.TrimSpace(src.URL), text, requiredTarget, profile.ParseOptions{LoadInclude: loadInclude, Vars: src.Vars})
+	return v, snapshots, nil
+}
+
+// fetchProfileText returns the profile content (fetched unless inlined) with
+// its snapshot.
+func fetchProfileText(ctx context.Context, src profileSource, fetchTimeout time.Duration) (string, []errlog.ResourceSnapshot, error) {
+	profileURL := strings.TrimSpace(src.URL)
+	text := src.Content
+	if text == "" {
+		if profileURL == "" {
+			return "", nil, requestError("INVALID_ARGUMENT", "profile 不能为空", "")
+		}
+		var err error
+		text, err = fetch.FetchTextWithOptions(ctx, fetch.KindProfile, profileURL, fetch.Options{Timeout: fetchTimeout})
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return text, []errlog.ResourceSnapshot{errlog.NewResourceSnapshot(errlog.ResourceProfile, profileURL, text)}, nil
+}
+
+// includeLoader fetches included profiles with the profile kind, recording a
+// snapshot of each.
+func includeLoader(ctx context.Context, fetchTimeout time.Duration, snapshots *[]errlog.ResourceSnapshot) fetch.Loader {
+	return func(u string) (string, error) {
+		text, err := fetch.FetchTextWithOptions(ctx, fetch.KindProfile, u, fetch.Options{Timeout: fetchTimeout})
+		if err != nil {
+			return "", err
+		}
+		*snapshots = append(*snapshots, errlog.NewResourceSnapshot(errlog.ResourceProfile, u, text))
+		return text, nil
+	}
+}
+
+// templateIncludeLoader fetches #@INCLUDE@# snippets and records them in the
+// error snapshot like the template itself.
+func templateIncludeLoader(ctx context.Context, fetchTimeout time.Duration, collector *errlog.Collector) fetch.Loader {
+	return func(u string) (string, error) {
+		text, err := fetch.FetchTextWithOptions(ctx, fetch.KindTemplate, u, fetch.Options{Timeout: fetchTimeout})
+		if err != nil {
+			return "", err
+		}
+		if collector != nil {
+			collector.AddResource(errlog.NewResourceSnapshot(errlog.ResourceTemplate, u, text))
+		}
+		return text, nil
 	}
-	return prof, &snapshot, nil
 }
 
 func renderSSListRaw(proxies []model.Proxy) (string, error) {
@@ -336,9 +556,49 @@ func pctEncode(s string) string {
 	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
 }
 
-func buildSurgeManagedConfigURL(r *http.Request, req convertRequest, publicBaseURL string) (string, error) {
-	if req.Mode != "config" || req.Target != render.TargetSurge {
-		return "", requestError("INVALID_ARGUMENT", "仅 mode=config&target=surge 需要 managed-config URL", "")
+// applyManagedConfig tells clients how to refresh the output: Surge gets
+// #!MANAGED-CONFIG and Shadowrocket gets [General] update-url, both pointing
+// back at the current conversion URL. Clash refreshes from the URL it was
+// imported from, so it only gets a profile-update-interval response header
+// (plus profile-web-page-url when managed_config.web_page_url is set: that
+// header is the provider's homepage, not the update URL). QuanX has no
+// equivalent, so managed_config does not apply to it.
+func applyManagedConfig(r *http.Request, req convertRequest, prof *profile.Spec, out string, templateURL string, header http.Header) (string, error) {
+	mc := prof.ManagedConfig
+	switch req.Target {
+	case render.TargetSurge, render.TargetShadowrocket:
+		currentURL, err := buildManagedConfigURL(r, req, prof.PublicBaseURL)
+		if err != nil {
+			return "", err
+		}
+		if req.Target == render.TargetShadowrocket {
+			return template.EnsureShadowrocketUpdateURL(out, currentURL, templateURL)
+		}
+		return template.EnsureSurgeManagedConfigWithOptions(out, currentURL, templateURL, template.ManagedConfigOptions{
+			IntervalSec: mc.IntervalSec,
+			Strict:      mc.Strict,
+			HasStrict:   mc.HasStrict,
+		})
+	case render.TargetClash:
+		if header != nil {
+			interval := mc.IntervalSec
+			if interval <= 0 {
+				interval = template.DefaultManagedConfigInterval
+			}
+			// Clash clients read the interval in hours; round up so it never
+			// refreshes more often than configured.
+			header.Set("profile-update-interval", strconv.Itoa(max(1, (interval+3599)/3600)))
+			if mc.WebPageURL != "" {
+				header.Set("profile-web-page-url", mc.WebPageURL)
+			}
+		}
+	}
+	return out, nil
+}
+
+func buildManagedConfigURL(r *http.Request, req convertRequest, publicBaseURL string) (string, error) {
+	if req.Mode != "config" {
+		return "", requestError("INVALID_ARGUMENT", "仅 mode=config 需要 managed-config URL", "")
 	}
 	if len(req.Subs) == 0 || strings.TrimSpace(req.Profile) == "" {
 		return "", requestError("INVALID_ARGUMENT", "生成 managed-config URL 需要 sub/profile", "")
@@ -361,18 +621,23 @@ func buildSurgeManagedConfigURL(r *http.Request, req convertRequest, publicBaseU
 
 	// Deterministic query serialization (SPEC_DETERMINISM.md):
 	// 1) mode=config
-	// 2) target=surge
+	// 2) target=<target>
 	// 3) fileName=... (optional)
 	// 4) sub=... in input order
 	// 5) profile=...
+	// 6) var.NAME=... sorted by NAME (optional)
 	prefix := []kv{
 		{k: "mode", v: "config"},
-		{k: "target", v: "surge"},
+		{k: "target", v: string(req.Target)},
 	}
 	if strings.TrimSpace(req.FileName) != "" {
 		prefix = append(prefix, kv{k: "fileName", v: strings.TrimSpace(req.FileName)})
 	}
-	u.RawQuery = serializeQuery(prefix, req.Subs, req.Profile)
+	suffix := make([]kv, 0, len(req.Vars))
+	for _, name := range slices.Sorted(maps.Keys(req.Vars)) {
+		suffix = append(suffix, kv{k: varQueryPrefix + name, v: req.Vars[name]})
+	}
+	u.RawQuery = serializeQuery(prefix, req.Subs, req.Profile, suffix...)
 	u.Fragment = ""
 	return u.String(), nil
 }
@@ -382,13 +647,14 @@ type kv struct {
 	v string
 }
 
-func serializeQuery(prefix []kv, subs []string, profileURL string) string {
-	parts := make([]kv, 0, len(prefix)+len(subs)+1)
+func serializeQuery(prefix []kv, subs []string, profileURL string, suffix ...kv) string {
+	parts := make([]kv, 0, len(prefix)+len(subs)+1+len(suffix))
 	parts = append(parts, prefix...)
 	for _, s := range subs {
 		parts = append(parts, kv{k: "sub", v: s})
 	}
 	parts = append(parts, kv{k: "profile", v: profileURL})
+	parts = append(parts, suffix...)
 
 	var b strings.Builder
 	for i, p := range parts {
@@ -423,6 +689,9 @@ func parseConvertGET(r *http.Request) (convertRequest, error) {
 		switch key {
 		case "mode", "target", "sub", "profile", "encode", "fileName", "filename":
 		default:
+			if strings.HasPrefix(key, varQueryPrefix) {
+				continue
+			}
 			return convertRequest{}, requestError("INVALID_ARGUMENT", fmt.Sprintf("不支持的 query 参数：%s", key), "")
 		}
 	}
@@ -456,6 +725,11 @@ func parseConvertGET(r *http.Request) (convertRequest, error) {
 		if _, ok := q["profile"]; ok {
 			return convertRequest{}, requestError("INVALID_ARGUMENT", "mode=list 不支持 profile", "")
 		}
+		for key := range q {
+			if strings.HasPrefix(key, varQueryPrefix) {
+				return convertRequest{}, requestError("INVALID_ARGUMENT", "mode=list 不支持 var.*", key)
+			}
+		}
 		encode, err := singleQuery(q, "encode", false)
 		if err != nil {
 			return convertRequest{}, err
@@ -497,15 +771,57 @@ func parseConvertGET(r *http.Request) (convertRequest, error) {
 	if err != nil {
 		return convertRequest{}, err
 	}
+	vars, err := varsQuery(q)
+	if err != nil {
+		return convertRequest{}, err
+	}
 	return convertRequest{
 		Mode:     "config",
 		Target:   target,
 		Subs:     subs2,
 		Profile:  profileURL,
 		FileName: fileName,
+		Vars:     vars,
 	}, nil
 }
 
+// varQueryPrefix marks profile variable overrides in the query: var.NAME=value.
+const varQueryPrefix = "var."
+
+// varsQuery collects var.NAME parameters (nil when there are none).
+func varsQuery(q url.Values) (map[string]string, error) {
+	var vars map[string]string
+	for key := range q {
+		name, ok := strings.CutPrefix(key, varQueryPrefix)
+		if !ok {
+			continue
+		}
+		v, err := singleQuery(q, key, false)
+		if err != nil {
+			return nil, err
+		}
+		if vars == nil {
+			vars = make(map[string]string)
+		}
+		vars[name] = v
+	}
+	return vars, validateVars(vars)
+}
+
+func validateVars(vars map[string]string) error {
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		if !profile.ValidVarName(name) {
+			return requestError("INVALID_ARGUMENT", fmt.Sprintf("变量名不合法：%s", name), "expected: var.NAME=value, NAME matches [A-Za-z_][A-Za-z0-9_]*")
+		}
+		// Values end up inside template lines (#@VAR@#); a line break would
+		// smuggle extra config lines past the anchor/section checks.
+		if strings.ContainsAny(vars[name], "\r\n\x00") {
+			return requestError("INVALID_ARGUMENT", fmt.Sprintf("变量值不能包含换行或控制字符：%s", name), "")
+		}
+	}
+	return nil
+}
+
 func parseConvertPOST(r *http.Request) (convertRequest, error) {
 	var body convertRequestJSON
 	dec := json.NewDecoder(r.Body)
@@ -540,9 +856,15 @@ func parseConvertPOST(r *http.Request) (convertRequest, error) {
 		if strings.TrimSpace(body.Target) != "" {
 			return convertRequest{}, requestError("INVALID_ARGUMENT", "mode=list 不支持 target", "")
 		}
-		if strings.TrimSpace(body.Profile) != "" {
+		if strings.TrimSpace(body.Profile) != "" || body.ProfileContent != "" {
 			return convertRequest{}, requestError("INVALID_ARGUMENT", "mode=list 不支持 profile", "")
 		}
+		if body.TemplateContent != "" {
+			return convertRequest{}, requestError("INVALID_ARGUMENT", "mode=list 不支持 templateContent", "")
+		}
+		if len(body.Vars) > 0 {
+			return convertRequest{}, requestError("INVALID_ARGUMENT", "mode=list 不支持 vars", "")
+		}
 		encode := strings.TrimSpace(body.Encode)
 		if encode == "" {
 			encode = "base64"
@@ -550,7 +872,7 @@ func parseConvertPOST(r *http.Request) (convertRequest, error) {
 		if encode != "base64" && encode != "raw" {
 			return convertRequest{}, requestError("INVALID_ARGUMENT", "不支持的 encode（仅支持 base64/raw）", encode)
 		}
-		return convertRequest{Mode: "list", Subs: subs, Encode: encode, FileName: strings.TrimSpace(body.FileName)}, nil
+		return convertRequest{Mode: "list", Subs: subs, Encode: encode, FileName: strings.TrimSpace(body.FileName), Diagnostics: body.Diagnostics}, nil
 	}
 
 	// mode=config
@@ -563,10 +885,40 @@ func parseConvertPOST(r *http.Request) (convertRequest, error) {
 		return convertRequest{}, err
 	}
 	profileURL := strings.TrimSpace(body.Profile)
-	if profileURL == "" {
-		return convertRequest{}, requestError("INVALID_ARGUMENT", "profile 不能为空", "")
+	switch {
+	case profileURL != "" && body.ProfileContent != "":
+		return convertRequest{}, requestError("INVALID_ARGUMENT", "profile 与 profileContent 只能二选一", "")
+	case profileURL == "" && strings.TrimSpace(body.ProfileContent) == "":
+		return convertRequest{}, requestError("INVALID_ARGUMENT", "profile 不能为空", "expected: profile (URL) or profileContent (YAML)")
+	}
+	if err := inlineContentSize("profileContent", body.ProfileContent, fetch.KindProfile); err != nil {
+		return convertRequest{}, err
+	}
+	if err := inlineContentSize("templateContent", body.TemplateContent, fetch.KindTemplate); err != nil {
+		return convertRequest{}, err
+	}
+	if err := validateVars(body.Vars); err != nil {
+		return convertRequest{}, err
+	}
+	return convertRequest{
+		Mode:            "config",
+		Target:          target,
+		Subs:            subs,
+		Profile:         profileURL,
+		FileName:        strings.TrimSpace(body.FileName),
+		Vars:            body.Vars,
+		ProfileContent:  body.ProfileContent,
+		TemplateContent: body.TemplateContent,
+		Diagnostics:     body.Diagnostics,
+	}, nil
+}
+
+// inlineContentSize applies the fetch size cap of kind to inlined content.
+func inlineContentSize(field, content string, kind fetch.Kind) error {
+	if maxBytes := kind.DefaultMaxBytes(); int64(len(content)) > maxBytes {
+		return requestError("TOO_LARGE", fmt.Sprintf("%s 过大（>%d bytes）", field, maxBytes), "")
 	}
-	return convertRequest{Mode: "config", Target: target, Subs: subs, Profile: profileURL, FileName: strings.TrimSpace(body.FileName)}, nil
+	return nil
 }
 
 func parseTarget(s string) (render.Target, error) {
@@ -10,9 +10,12 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
 
+	"github.com/John-Robertt/subconverter-go/internal/compiler"
+	"github.com/John-Robertt/subconverter-go/internal/explain"
 	"github.com/John-Robertt/subconverter-go/internal/model"
 )
 
@@ -46,7 +49,7 @@ func TestE2E_Materials_ConfigAndList(t *testing.T) {
 	}
 
 	{
-		wantSR := expectedShadowrocketConfig(profileURL)
+		wantSR := expectedShadowrocketConfig(subURL, profileURL)
 		gotSR := doGET(t, mux, "/sub?mode=config&target=shadowrocket&sub="+url.QueryEscape(subURL)+"&profile="+url.QueryEscape(profileURL))
 		if gotSR != wantSR {
 			t.Fatalf("shadowrocket output mismatch\n--- got ---\n%s\n--- want ---\n%s", gotSR, wantSR)
@@ -166,6 +169,495 @@ func TestE2E_ProxyChainUnsupportedTarget(t *testing.T) {
 	}
 }
 
+func TestE2E_InlineRulesetExpanded(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sub.txt":
+			_, _ = w.Write([]byte("ss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8388#HK\n"))
+		case "/base.conf":
+			_, _ = w.Write([]byte("[Proxy]\n#@PROXIES@#\n\n[Proxy Group]\n#@GROUPS@#\n\n[Rule]\n#@RULES@#\n"))
+		case "/LAN.list":
+			_, _ = w.Write([]byte("# LAN\nIP-CIDR,192.168.0.0/16,no-resolve\nDOMAIN-SUFFIX,local\n"))
+		case "/profile.yaml":
+			base := "http://" + r.Host
+			body := "" +
+				"version: 1\n" +
+				"template:\n" +
+				"  surge: \"" + base + "/base.conf\"\n" +
+				"public_base_url: \"https://public.example.com/sub\"\n" +
+				"custom_proxy_group:\n" +
+				"  - \"PROXY`select`[]@all\"\n" +
+				"ruleset:\n" +
+				"  - \"DIRECT,inline:" + base + "/LAN.list\"\n" +
+				"  - \"PROXY,https://rules.example.com/Proxy.list\"\n" +
+				"rule:\n" +
+				"  - \"MATCH,PROXY\"\n"
+			_, _ = w.Write([]byte(body))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+
+	mux := NewMux()
+	got := doGET(t, mux, "/sub?mode=config&target=surge&sub="+url.QueryEscape(up.URL+"/sub.txt")+"&profile="+url.QueryEscape(up.URL+"/profile.yaml"))
+	want := "" +
+		"IP-CIDR,192.168.0.0/16,DIRECT,no-resolve\n" +
+		"DOMAIN-SUFFIX,local,DIRECT\n" +
+		"RULE-SET,https://rules.example.com/Proxy.list,PROXY\n" +
+		"FINAL,PROXY\n"
+	if !strings.Contains(got, "[Rule]\n"+want) {
+		t.Fatalf("inline ruleset not expanded in place, got:\n%s", got)
+	}
+}
+
+func TestE2E_ProfileInclude(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sub.txt":
+			_, _ = w.Write([]byte("ss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8388#HK\n"))
+		case "/base.conf":
+			_, _ = w.Write([]byte("[Proxy]\n#@PROXIES@#\n\n[Proxy Group]\n#@GROUPS@#\n\n[Rule]\n#@RULES@#\n"))
+		case "/parts/groups.yaml":
+			_, _ = w.Write([]byte("custom_proxy_group:\n  - \"PROXY`select`[]@all\"\nrule:\n  - \"DOMAIN,a.example,DIRECT\"\n"))
+		case "/profile.yaml":
+			_, _ = w.Write([]byte("" +
+				"version: 1\n" +
+				"template:\n" +
+				"  surge: \"http://" + r.Host + "/base.conf\"\n" +
+				"include:\n" +
+				"  - \"parts/groups.yaml\"\n" +
+				"rule:\n" +
+				"  - \"MATCH,PROXY\"\n"))
+		case "/loop.yaml":
+			_, _ = w.Write([]byte("" +
+				"version: 1\n" +
+				"template:\n" +
+				"  surge: \"http://" + r.Host + "/base.conf\"\n" +
+				"include:\n" +
+				"  - \"loop.yaml\"\n" +
+				"rule:\n" +
+				"  - \"MATCH,DIRECT\"\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+
+	mux := NewMux()
+	got := doGET(t, mux, "/sub?mode=config&target=surge&sub="+url.QueryEscape(up.URL+"/sub.txt")+"&profile="+url.QueryEscape(up.URL+"/profile.yaml"))
+	if !strings.Contains(got, "[Rule]\nDOMAIN,a.example,DIRECT\nFINAL,PROXY\n") || !strings.Contains(got, "PROXY = select, HK") {
+		t.Fatalf("include not merged, got:\n%s", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/sub?mode=config&target=surge&sub="+url.QueryEscape(up.URL+"/sub.txt")+"&profile="+url.QueryEscape(up.URL+"/loop.yaml"), nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "PROFILE_INCLUDE_CYCLE") {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestE2E_ProfileVars(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sub.txt":
+			_, _ = w.Write([]byte("ss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8388#HK\nss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8389#SG\n"))
+		case "/tpl/base.conf":
+			_, _ = w.Write([]byte("[General]\n#@INCLUDE general.conf@#\n\n[Proxy]\n#@PROXIES@#\n\n[Proxy Group]\n#@GROUPS@#\n\n[Rule]\n#@RULES@#\n"))
+		case "/tpl/general.conf":
+			_, _ = w.Write([]byte("#@IF REGION=SG@#\ndns-server = 8.8.8.8\n#@ELSE@#\ndns-server = 223.5.5.5\n#@ENDIF@#\nregion = #@VAR REGION@#\n"))
+		case "/profile.yaml":
+			_, _ = w.Write([]byte("" +
+				"version: 1\n" +
+				"template:\n" +
+				"  surge: \"http://" + r.Host + "/tpl/base.conf\"\n" +
+				"vars:\n" +
+				"  REGION: HK\n" +
+				"  MIRROR: rules.example.com\n" +
+				"custom_proxy_group:\n" +
+				"  - \"PROXY`select`(${REGION})\"\n" +
+				"ruleset:\n" +
+				"  - \"PROXY,https://${MIRROR}/Proxy.list\"\n" +
+				"rule:\n" +
+				"  - \"MATCH,PROXY\"\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+
+	mux := NewMux()
+	base := "/sub?mode=config&target=surge&sub=" + url.QueryEscape(up.URL+"/sub.txt") + "&profile=" + url.QueryEscape(up.URL+"/profile.yaml")
+	got := doGET(t, mux, base+"&var.REGION=SG&var.MIRROR=mirror.example.net")
+	if !strings.Contains(got, "PROXY = select, SG\n") || !strings.Contains(got, "RULE-SET,https://mirror.example.net/Proxy.list,PROXY") {
+		t.Fatalf("vars not applied, got:\n%s", got)
+	}
+	if !strings.Contains(got, "[General]\ndns-server = 8.8.8.8\nregion = SG\n") {
+		t.Fatalf("template directives not applied, got:\n%s", got)
+	}
+	if !strings.Contains(got, "&var.MIRROR=mirror.example.net&var.REGION=SG interval=") {
+		t.Fatalf("managed-config URL should carry sorted var.*, got:\n%s", got)
+	}
+
+	for q, want := range map[string]int{
+		"&var.COUNTRY=JP": http.StatusUnprocessableEntity, // not declared under vars
+		"&var.1BAD=x":     http.StatusBadRequest,
+		// Line breaks would inject config lines through #@VAR@#.
+		"&var.REGION=SG%0A%5BRule%5D%0AMATCH,REJECT": http.StatusBadRequest,
+	} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, base+q, nil))
+		if rr.Code != want {
+			t.Fatalf("%s: status=%d, want=%d body=%s", q, rr.Code, want, rr.Body.String())
+		}
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"mode":    "config",
+		"target":  "surge",
+		"subs":    []string{up.URL + "/sub.txt"},
+		"profile": up.URL + "/profile.yaml",
+		"vars":    map[string]string{"REGION": "SG\r\nFINAL,REJECT"},
+	})
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/convert", bytes.NewReader(body)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("POST vars with line break: status=%d, want=400 body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestE2E_ManagedConfig(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sub.txt":
+			_, _ = w.Write([]byte("ss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8388#HK\n"))
+		case "/profile.yaml":
+			_, _ = w.Write([]byte("" +
+				"version: 1\n" +
+				"public_base_url: \"https://public.example.com/sub\"\n" +
+				"managed_config: {interval: 5400, strict: true, web_page_url: \"https://provider.example.com/\"}\n" +
+				"custom_proxy_group:\n" +
+				"  - \"PROXY`select`[]@all\"\n" +
+				"rule:\n" +
+				"  - \"MATCH,PROXY\"\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+
+	mux := NewMux()
+	query := "&sub=" + url.QueryEscape(up.URL+"/sub.txt") + "&profile=" + url.QueryEscape(up.URL+"/profile.yaml")
+	current := func(target string) string {
+		return "https://public.example.com/sub?mode=config&target=" + target + "&sub=" + pctEncodeExpect(up.URL+"/sub.txt") + "&profile=" + pctEncodeExpect(up.URL+"/profile.yaml")
+	}
+
+	got := doGET(t, mux, "/sub?mode=config&target=surge"+query)
+	if want := "#!MANAGED-CONFIG " + current("surge") + " interval=5400 strict=true\n"; !strings.HasPrefix(got, want) {
+		t.Fatalf("surge: want prefix %q, got:\n%s", want, got)
+	}
+
+	got = doGET(t, mux, "/sub?mode=config&target=shadowrocket"+query)
+	if want := "[General]\nupdate-url = " + current("shadowrocket") + "\n"; !strings.Contains(got, want) {
+		t.Fatalf("shadowrocket: want %q, got:\n%s", want, got)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/sub?mode=config&target=clash"+query, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("clash: status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	// 5400s rounds up to 2 hours.
+	if got, want := rr.Header().Get("profile-update-interval"), "2"; got != want {
+		t.Fatalf("clash: profile-update-interval=%q, want=%q", got, want)
+	}
+	if got, want := rr.Header().Get("profile-web-page-url"), "https://provider.example.com/"; got != want {
+		t.Fatalf("clash: profile-web-page-url=%q, want=%q", got, want)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/sub?mode=config&target=quanx"+query, nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("profile-update-interval") != "" || strings.Contains(rr.Body.String(), "public.example.com") {
+		t.Fatalf("quanx: status=%d headers=%v body=%s", rr.Code, rr.Header(), rr.Body.String())
+	}
+}
+
+func TestE2E_InlineProfileAndTemplate(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sub.txt":
+			_, _ = w.Write([]byte("ss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8388#HK\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+
+	profileYAML := "" +
+		"version: 1\n" +
+		"custom_proxy_group:\n" +
+		"  - \"PROXY`select`[]@all\"\n" +
+		"rule:\n" +
+		"  - \"MATCH,PROXY\"\n"
+	mux := NewMux()
+	got := doPOSTJSON(t, mux, "/api/convert", map[string]any{
+		"mode":            "config",
+		"target":          "surge",
+		"subs":            []string{up.URL + "/sub.txt"},
+		"profileContent":  profileYAML,
+		"templateContent": "[Proxy]\n#@PROXIES@#\n\n[Proxy Group]\n#@GROUPS@#\n\n[Rule]\n#@RULES@#\n",
+	})
+	if !strings.HasPrefix(got, "[Proxy]\nHK = ss,") || !strings.Contains(got, "[Rule]\nFINAL,PROXY\n") {
+		t.Fatalf("unexpected output (inline content gets no managed-config line):\n%s", got)
+	}
+
+	// No template anywhere: the built-in default is used.
+	for target, want := range map[string]string{
+		"clash": "proxies:\n  - name: \"HK\"\n",
+		"quanx": "[server_local]\nshadowsocks = example.com:8388",
+	} {
+		got := doPOSTJSON(t, mux, "/api/convert", map[string]any{
+			"mode":           "config",
+			"target":         target,
+			"subs":           []string{up.URL + "/sub.txt"},
+			"profileContent": profileYAML,
+		})
+		if !strings.HasPrefix(got, "# Built-in default template") || !strings.Contains(got, want) {
+			t.Fatalf("%s: unexpected built-in output:\n%s", target, got)
+		}
+	}
+
+	post := func(payload map[string]any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(payload)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/convert", bytes.NewReader(b)))
+		return rr
+	}
+	for name, tc := range map[string]struct {
+		payload map[string]any
+		code    string
+	}{
+		"both profile sources": {
+			payload: map[string]any{"mode": "config", "target": "clash", "subs": []string{up.URL + "/sub.txt"}, "profile": up.URL + "/profile.yaml", "profileContent": profileYAML},
+			code:    "INVALID_ARGUMENT",
+		},
+		"template too large": {
+			payload: map[string]any{"mode": "config", "target": "clash", "subs": []string{up.URL + "/sub.txt"}, "profileContent": profileYAML, "templateContent": strings.Repeat("#", 2<<20+1)},
+			code:    "TOO_LARGE",
+		},
+	} {
+		rr := post(tc.payload)
+		if rr.Code == http.StatusOK || !strings.Contains(rr.Body.String(), tc.code) {
+			t.Fatalf("%s: status=%d body=%s", name, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestE2E_ProfileUpgrade(t *testing.T) {
+	var up *httptest.Server
+	up = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sub.txt":
+			_, _ = w.Write([]byte("ss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8388#HK\n"))
+		case "/base.yaml":
+			_, _ = w.Write([]byte("proxies:\n  #@PROXIES@#\nproxy-groups:\n  #@GROUPS@#\nrule-providers:\n  #@RULE_PROVIDERS@#\nrules:\n  #@RULES@#\n"))
+		case "/profile.yaml":
+			_, _ = w.Write([]byte("" +
+				"version: 1\n" +
+				"template:\n" +
+				"  clash: \"" + up.URL + "/base.yaml\"\n" +
+				"custom_proxy_group:\n" +
+				"  - \"PROXY`select`[]AUTO[]DIRECT\"\n" +
+				"  - \"AUTO`url-test`(HK)`https://www.gstatic.com/generate_204`300`hidden\"\n" +
+				"rule:\n" +
+				"  - \"DOMAIN-SUFFIX,example.com,DIRECT\"\n" +
+				"  - \"MATCH,PROXY\"\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+
+	mux := NewMux()
+	v2 := doPOSTJSON(t, mux, "/api/profile/upgrade", map[string]any{"profile": up.URL + "/profile.yaml"})
+	if !strings.Contains(v2, "version: 2") || !strings.Contains(v2, "name: AUTO") {
+		t.Fatalf("unexpected upgrade output:\n%s", v2)
+	}
+
+	want := doPOSTJSON(t, mux, "/api/convert", map[string]any{"mode": "config", "target": "clash", "subs": []string{up.URL + "/sub.txt"}, "profile": up.URL + "/profile.yaml"})
+	got := doPOSTJSON(t, mux, "/api/convert", map[string]any{"mode": "config", "target": "clash", "subs": []string{up.URL + "/sub.txt"}, "profileContent": v2})
+	if got != want {
+		t.Fatalf("upgraded profile renders differently:\n--- v1\n%s\n--- v2\n%s", want, got)
+	}
+
+	b, _ := json.Marshal(map[string]any{"profileContent": "version: 1\nrule:\n  - \"MATCH\"\n"})
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/profile/upgrade", bytes.NewReader(b)))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), `"line":3`) {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestE2E_Explain(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sub.txt":
+			_, _ = w.Write([]byte("ss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8388#HK\n"))
+		case "/Media.list":
+			_, _ = w.Write([]byte("DOMAIN-SUFFIX,netflix.com\n"))
+		case "/profile.yaml":
+			base := "http://" + r.Host
+			body := "" +
+				"version: 1\n" +
+				"template:\n" +
+				"  clash: \"" + base + "/base.yaml\"\n" +
+				"custom_proxy_group:\n" +
+				"  - \"MEDIA`select`[]PROXY[]DIRECT\"\n" +
+				"  - \"PROXY`select`[]@all\"\n" +
+				"ruleset:\n" +
+				"  - \"MEDIA," + base + "/Media.list\"\n" +
+				"rule:\n" +
+				"  - \"MATCH,DIRECT\"\n"
+			_, _ = w.Write([]byte(body))
+		case "/scoped.yaml":
+			_, _ = w.Write([]byte("" +
+				"version: 2\n" +
+				"rule:\n" +
+				"  - {type: DOMAIN-SUFFIX, value: netflix.com, action: REJECT, targets: [surge]}\n" +
+				"  - {type: MATCH, action: DIRECT}\n"))
+		case "/vars.yaml":
+			_, _ = w.Write([]byte("" +
+				"version: 2\n" +
+				"vars: {ACTION: DIRECT}\n" +
+				"ruleset:\n" +
+				"  - {action: \"${ACTION}\", url: \"http://" + r.Host + "/Media.list\"}\n" +
+				"rule:\n" +
+				"  - {type: MATCH, action: REJECT}\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+
+	// vars overrides are applied like /sub's var.NAME, and validated the same way.
+	for _, tc := range []struct {
+		vars   map[string]string
+		status int
+		action string
+	}{
+		{nil, http.StatusOK, "DIRECT"},
+		{map[string]string{"ACTION": "REJECT"}, http.StatusOK, "REJECT"},
+		{map[string]string{"ACTION": "REJECT\nMATCH"}, http.StatusBadRequest, ""},
+		{map[string]string{"UNDECLARED": "x"}, http.StatusUnprocessableEntity, ""},
+	} {
+		b, _ := json.Marshal(map[string]any{"subs": []string{up.URL + "/sub.txt"}, "profile": up.URL + "/vars.yaml", "domain": "www.netflix.com", "vars": tc.vars})
+		rr := httptest.NewRecorder()
+		NewMux().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/explain", bytes.NewReader(b)))
+		if rr.Code != tc.status {
+			t.Fatalf("vars=%v: status=%d, want=%d body=%s", tc.vars, rr.Code, tc.status, rr.Body.String())
+		}
+		var rep explain.Report
+		if tc.action != "" && (json.Unmarshal(rr.Body.Bytes(), &rep) != nil || rep.Match.Rule != "DOMAIN-SUFFIX,netflix.com,"+tc.action) {
+			t.Fatalf("vars=%v: body=%s, want action %s", tc.vars, rr.Body.String(), tc.action)
+		}
+	}
+
+	// A profile with `targets:` needs a target: the merged view is no real config.
+	for target, want := range map[string]int{"": http.StatusBadRequest, "surge": http.StatusOK} {
+		b, _ := json.Marshal(map[string]any{"subs": []string{up.URL + "/sub.txt"}, "profile": up.URL + "/scoped.yaml", "domain": "www.netflix.com", "target": target})
+		rr := httptest.NewRecorder()
+		NewMux().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/explain", bytes.NewReader(b)))
+		if rr.Code != want {
+			t.Fatalf("target=%q: status=%d, want=%d body=%s", target, rr.Code, want, rr.Body.String())
+		}
+	}
+
+	b, _ := json.Marshal(map[string]any{
+		"subs":    []string{up.URL + "/sub.txt"},
+		"profile": up.URL + "/profile.yaml",
+		"domain":  "www.netflix.com",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/explain", bytes.NewReader(b))
+	rr := httptest.NewRecorder()
+	NewMux().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var rep explain.Report
+	if err := json.Unmarshal(rr.Body.Bytes(), &rep); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rep.Match.Rule != "DOMAIN-SUFFIX,netflix.com,MEDIA" || rep.Match.URL != up.URL+"/Media.list" {
+		t.Fatalf("match=%+v", rep.Match)
+	}
+	if got, want := strings.Join(rep.Proxies, ","), "HK,DIRECT"; got != want {
+		t.Fatalf("proxies=%q, want=%q", got, want)
+	}
+
+	// Query validation happens before any fetch.
+	b, _ = json.Marshal(map[string]any{"subs": []string{up.URL + "/sub.txt"}, "profile": up.URL + "/profile.yaml"})
+	req = httptest.NewRequest(http.MethodPost, "/api/explain", bytes.NewReader(b))
+	rr = httptest.NewRecorder()
+	NewMux().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestE2E_Graph(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sub.txt":
+			_, _ = w.Write([]byte("ss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8388#HK\n"))
+		case "/profile.yaml":
+			_, _ = w.Write([]byte("" +
+				"version: 1\n" +
+				"template:\n" +
+				"  clash: \"http://" + r.Host + "/base.yaml\"\n" +
+				"custom_proxy_group:\n" +
+				"  - \"PROXY`select`[]@all[]DIRECT\"\n" +
+				"  - \"SPARE`select`[]@all\"\n" +
+				"rule:\n" +
+				"  - \"MATCH,PROXY\"\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+
+	post := func(format string) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(map[string]any{"subs": []string{up.URL + "/sub.txt"}, "profile": up.URL + "/profile.yaml", "format": format})
+		rr := httptest.NewRecorder()
+		NewMux().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/graph", bytes.NewReader(b)))
+		return rr
+	}
+
+	rr := post("")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var graph compiler.GroupGraph
+	if err := json.Unmarshal(rr.Body.Bytes(), &graph); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(graph.Issues) != 1 || graph.Issues[0].Code != "GROUP_UNREACHABLE" || graph.Issues[0].Groups[0] != "SPARE" {
+		t.Fatalf("issues=%+v", graph.Issues)
+	}
+
+	rr = post("dot")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/vnd.graphviz") {
+		t.Fatalf("status=%d content-type=%q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(rr.Body.String(), "digraph groups {") {
+		t.Fatalf("dot body=%s", rr.Body.String())
+	}
+
+	if rr = post("svg"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
 func doGET(t *testing.T, mux http.Handler, path string) string {
 	t.Helper()
 	req := httptest.NewRequest(http.MethodGet, path, nil)
@@ -358,13 +850,15 @@ func expectedClashConfig(profileURL string) string {
 		"  - \"MATCH,PROXY\"\n"
 }
 
-func expectedShadowrocketConfig(profileURL string) string {
+func expectedShadowrocketConfig(subURL, profileURL string) string {
 	base := materialsBase(profileURL)
 	lan := base + "/materials/rulesets/LAN.list"
 	banad := base + "/materials/rulesets/BanAD.list"
 	proxy := base + "/materials/rulesets/Proxy.list"
+	updateURL := "https://public.example.com/sub?mode=config&target=shadowrocket&sub=" + pctEncodeExpect(subURL) + "&profile=" + pctEncodeExpect(profileURL)
 	return "" +
 		"[General]\n" +
+		"update-url = " + updateURL + "\n" +
 		"loglevel = notify\n" +
 		"\n" +
 		"[Proxy]\n" +
@@ -494,3 +988,167 @@ func firstDiff(a, b string) int {
 	}
 	return -1
 }
+
+func TestE2E_ProfileValidate(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sub.txt":
+			_, _ = w.Write([]byte("ss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8388#HK\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+
+	content := "" +
+		"version: 1\n" +
+		"template:\n" +
+		"  clash: builtin:nope\n" +
+		"custom_proxy_group:\n" +
+		"  - \"PROXY`select`[]AUTO[]Missing\"\n" +
+		"  - \"AUTO`url-test`(JP)`https://www.gstatic.com/generate_204`300\"\n" +
+		"  - \"BAD`nope`x\"\n" +
+		"rule:\n" +
+		"  - \"DOMAIN-SUFFIX,example.com,NOPE\"\n" +
+		"  - \"MATCH,PROXY\"\n"
+
+	mux := NewMux()
+	validate := func(body map[string]any) ValidateResponse {
+		t.Helper()
+		b, _ := json.Marshal(body)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/profile/validate", bytes.NewReader(b)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+		}
+		var resp ValidateResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := validate(map[string]any{"profileContent": content})
+	if resp.Valid {
+		t.Fatalf("expected errors, got %+v", resp)
+	}
+	codes := make([]string, 0, len(resp.Errors))
+	for _, e := range resp.Errors {
+		codes = append(codes, e.Code)
+	}
+	if want := []string{"PROFILE_VALIDATE_ERROR", "GROUP_UNSUPPORTED_TYPE", "GROUP_PARSE_ERROR", "REFERENCE_NOT_FOUND"}; !slices.Equal(codes, want) {
+		t.Fatalf("codes=%v, want %v (%+v)", codes, want, resp.Errors)
+	}
+	if e := resp.Errors[1]; e.Line != 7 || e.Column != 5 {
+		t.Fatalf("unexpected position: %+v", e)
+	}
+
+	// A sample subscription surfaces the problems that depend on proxy names.
+	clean := "" +
+		"version: 1\n" +
+		"template:\n" +
+		"  clash: \"" + up.URL + "/base.yaml\"\n" +
+		"custom_proxy_group:\n" +
+		"  - \"PROXY`select`[]AUTO[]DIRECT\"\n" +
+		"  - \"AUTO`url-test`(JP)`https://www.gstatic.com/generate_204`300\"\n" +
+		"rule:\n" +
+		"  - \"MATCH,PROXY\"\n"
+	if resp := validate(map[string]any{"profileContent": clean}); !resp.Valid || len(resp.Errors) != 0 {
+		t.Fatalf("expected valid profile, got %+v", resp)
+	}
+	resp = validate(map[string]any{"profileContent": clean, "subs": []string{up.URL + "/sub.txt"}})
+	if resp.Valid || len(resp.Errors) != 1 || resp.Errors[0].Stage != "compile" {
+		t.Fatalf("expected one compile error, got %+v", resp)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/profile/schema", nil))
+	if rr.Code != http.StatusOK || !json.Valid(rr.Body.Bytes()) {
+		t.Fatalf("schema: status=%d", rr.Code)
+	}
+}
+
+func TestE2E_ConvertDiagnostics(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sub.txt":
+			_, _ = w.Write([]byte("ss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8388#HK\nvmess://abc\n"))
+		case "/good.txt":
+			_, _ = w.Write([]byte("ss://YWVzLTEyOC1nY206cGFzc3dvcmQ=@example.com:8388#HK\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+
+	mux := NewMux()
+	post := func(payload map[string]any) (int, model.ErrorResponse) {
+		t.Helper()
+		b, _ := json.Marshal(payload)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/convert", bytes.NewReader(b)))
+		var resp model.ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+		}
+		return rr.Code, resp
+	}
+	codes := func(errs []model.AppError) []string {
+		out := make([]string, 0, len(errs))
+		for _, e := range errs {
+			out = append(out, e.Code)
+		}
+		return out
+	}
+
+	broken := "" +
+		"version: 1\n" +
+		"custom_proxy_group:\n" +
+		"  - \"PROXY`select`[]DIRECT\"\n" +
+		"  - \"BAD`nope`x\"\n" +
+		"rule:\n" +
+		"  - \"DOMAIN-SUFFIX,example.com,NOPE\"\n" +
+		"  - \"MATCH,PROXY\"\n"
+	payload := map[string]any{
+		"mode":            "config",
+		"target":          "clash",
+		"subs":            []string{up.URL + "/sub.txt"},
+		"profileContent":  broken,
+		"templateContent": "#@PROXIES@#\n#@GROUPS@#\n#@RULES@#\n",
+	}
+
+	// Without diagnostics only the first error is reported.
+	status, resp := post(payload)
+	if status != http.StatusUnprocessableEntity || resp.Errors != nil {
+		t.Fatalf("status=%d resp=%+v", status, resp)
+	}
+
+	payload["diagnostics"] = true
+	status, resp = post(payload)
+	if want := []string{"GROUP_UNSUPPORTED_TYPE", "SUB_UNSUPPORTED_SCHEME", "REFERENCE_NOT_FOUND"}; !slices.Equal(codes(resp.Errors), want) {
+		t.Fatalf("codes=%v, want %v (%+v)", codes(resp.Errors), want, resp.Errors)
+	}
+	if status != http.StatusUnprocessableEntity || resp.Error != resp.Errors[0] {
+		t.Fatalf("status=%d error=%+v", status, resp.Error)
+	}
+
+	// Render errors are collected too.
+	status, resp = post(map[string]any{
+		"mode":   "config",
+		"target": "quanx",
+		"subs":   []string{up.URL + "/good.txt"},
+		"profileContent": "" +
+			"version: 1\n" +
+			"custom_proxy_group:\n" +
+			"  - \"PROXY`select`[]DIRECT\"\n" +
+			"rule:\n" +
+			"  - \"PROCESS-NAME,curl,DIRECT\"\n" +
+			"  - \"DOMAIN-REGEX,^a,DIRECT\"\n" +
+			"  - \"MATCH,PROXY\"\n",
+		"templateContent": "#@PROXIES@#\n#@GROUPS@#\n#@RULES@#\n",
+		"diagnostics":     true,
+	})
+	if want := []string{"UNSUPPORTED_RULE_TYPE", "UNSUPPORTED_RULE_TYPE"}; status != http.StatusUnprocessableEntity || !slices.Equal(codes(resp.Errors), want) {
+		t.Fatalf("status=%d errors=%+v", status, resp.Errors)
+	}
+}
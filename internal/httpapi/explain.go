@@ -0,0 +1,206 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/compiler"
+	"github.com/John-Robertt/subconverter-go/internal/errlog"
+	"github.com/John-Robertt/subconverter-go/internal/explain"
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/profile"
+	"github.com/John-Robertt/subconverter-go/internal/rules"
+)
+
+// ExplainRequest is the input of POST /api/explain and of the CLI `explain` subcommand.
+type ExplainRequest struct {
+	Subs    []string `json:"subs"`
+	Profile string   `json:"profile"`
+	// Target optionally selects the profile items restricted with `targets:`.
+	Target string `json:"target,omitempty"`
+	// Vars overrides profile `vars:` like /sub's var.NAME parameters.
+	Vars map[string]string `json:"vars,omitempty"`
+	explain.Query
+}
+
+// Explain runs fetch + parse + compile for req and simulates rule matching for
+// req.Query. Errors use the same types as the convert pipeline (see ErrorFromErr).
+func Explain(ctx context.Context, req ExplainRequest, opt Options) (*explain.Report, error) {
+	req, err := normalizeExplainRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return runExplain(ctx, req, opt, nil)
+}
+
+// ErrorFromErr maps a pipeline error to its HTTP status and AppError payload.
+func ErrorFromErr(err error) (int, model.AppError) {
+	ce := classifyError(err)
+	return ce.status, ce.app
+}
+
+func (h convertHandler) handleExplain(w http.ResponseWriter, r *http.Request) {
+	collector := errlog.NewCollector(ensureRequestID(r), r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20 /* 1 MiB */)
+
+	req, err := parseExplainPOST(r)
+	if err != nil {
+		writeErrorFromErr(w, r, err, collector, h.opt.ErrorLog)
+		return
+	}
+	collector.SetRequest("explain", req.Target, req.Subs, req.Profile, "", "")
+
+	rep, err := runExplain(r.Context(), req, h.opt, collector)
+	if err != nil {
+		writeErrorFromErr(w, r, err, collector, h.opt.ErrorLog)
+		return
+	}
+	WriteJSON(w, http.StatusOK, rep)
+}
+
+func parseExplainPOST(r *http.Request) (ExplainRequest, error) {
+	var body ExplainRequest
+	if err := decodeJSONBody(r, &body); err != nil {
+		return ExplainRequest{}, err
+	}
+	return normalizeExplainRequest(body)
+}
+
+func normalizeExplainRequest(req ExplainRequest) (ExplainRequest, error) {
+	subs, prof, err := normalizeSources(req.Subs, req.Profile)
+	if err != nil {
+		return ExplainRequest{}, err
+	}
+	req.Subs, req.Profile = subs, prof
+	if req.Target, err = normalizeOptionalTarget(req.Target); err != nil {
+		return ExplainRequest{}, err
+	}
+	if err := validateVars(req.Vars); err != nil {
+		return ExplainRequest{}, err
+	}
+	// Reject bad queries before fetching anything.
+	if err := explain.ValidateQuery(req.Query); err != nil {
+		return ExplainRequest{}, err
+	}
+	return req, nil
+}
+
+// normalizeOptionalTarget validates the optional target of target-agnostic endpoints.
+func normalizeOptionalTarget(target string) (string, error) {
+	if strings.TrimSpace(target) == "" {
+		return "", nil
+	}
+	t, err := parseTarget(target)
+	return string(t), err
+}
+
+// normalizeSources trims and validates the subs/profile pair of a JSON request.
+func normalizeSources(subs []string, prof string) ([]string, string, error) {
+	if len(subs) == 0 {
+		return nil, "", requestError("INVALID_ARGUMENT", "subs 不能为空", "")
+	}
+	out := make([]string, 0, len(subs))
+	for _, s := range subs {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil, "", requestError("INVALID_ARGUMENT", "subs 不能为空", "")
+		}
+		out = append(out, s)
+	}
+	prof = strings.TrimSpace(prof)
+	if prof == "" {
+		return nil, "", requestError("INVALID_ARGUMENT", "profile 不能为空", "")
+	}
+	return out, prof, nil
+}
+
+// decodeJSONBody decodes exactly one JSON value with unknown fields rejected.
+func decodeJSONBody(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return requestError("INVALID_ARGUMENT", "JSON body 解析失败", err.Error())
+	}
+	var extra any
+	if err := dec.Decode(&extra); err == nil {
+		return requestError("INVALID_ARGUMENT", "JSON body 不允许多段", "")
+	} else if !errors.Is(err, io.EOF) {
+		return requestError("INVALID_ARGUMENT", "JSON body 解析失败", err.Error())
+	}
+	return nil
+}
+
+func runExplain(ctx context.Context, req ExplainRequest, opt Options, collector *errlog.Collector) (*explain.Report, error) {
+	opt = opt.withDefaults()
+
+	ctx, cancel := context.WithTimeout(ctx, opt.ConvertTimeout)
+	defer cancel()
+
+	// Unlike convert, remote rulesets are fetched too: the simulation must see
+	// their contents. mrs is a binary format and is reported as undetermined.
+	res, rulesetText, err := fetchAndCompile(ctx, req.Subs, profileSource{URL: req.Profile, Vars: req.Vars}, req.Target, true, func(rs profile.RulesetSpec) bool {
+		return rs.Format != rules.FormatMRS
+	}, opt, collector)
+	if err != nil {
+		return nil, err
+	}
+	return explain.Explain(res, req.Query, explain.Options{RulesetText: rulesetText})
+}
+
+// fetchAndCompile runs the render-free part of the pipeline: fetch subs and
+// profile concurrently, fetch the rulesets selected by want, then compile.
+// target only filters profile items (empty keeps all); no template is required.
+// With targetRequired, an empty target is rejected when the profile uses
+// `targets:`: the all-targets view mixes items no single client receives.
+// It returns the fetched ruleset text alongside the result.
+func fetchAndCompile(ctx context.Context, subURLs []string, src profileSource, target string, targetRequired bool, want func(profile.RulesetSpec) bool, opt Options, collector *errlog.Collector) (*compiler.Result, map[string]string, error) {
+	type profResult struct {
+		prof      *profile.Spec
+		snapshots []errlog.ResourceSnapshot
+		err       error
+	}
+	profCh := make(chan profResult, 1)
+	go func() {
+		// No template key is required: nothing is rendered.
+		p, snapshots, err := fetchAndParseProfile(ctx, src, "", opt.FetchTimeout)
+		profCh <- profResult{prof: p, snapshots: snapshots, err: err}
+	}()
+
+	subs, err := fetchAndParseSubs(ctx, subURLs, opt.FetchTimeout, collector, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pr := <-profCh
+	if collector != nil {
+		for _, snapshot := range pr.snapshots {
+			collector.AddResource(snapshot)
+		}
+	}
+	if pr.err != nil {
+		return nil, nil, pr.err
+	}
+	prof := pr.prof
+	if targetRequired && target == "" && prof.UsesTargets() {
+		return nil, nil, requestError("INVALID_ARGUMENT", "profile 含 targets: 限定的条目，需要指定 target", "expected: target=clash|shadowrocket|surge|quanx")
+	}
+
+	rulesetText, err := fetchRulesets(ctx, prof, forTarget(target, want), opt.FetchTimeout, collector)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := compiler.CompileWithOptions(subs, prof, compiler.Options{RulesetText: rulesetText, Target: target})
+	if err != nil {
+		return nil, nil, err
+	}
+	if collector != nil {
+		collector.SetCompiledCounts(len(res.Proxies), len(res.Groups), len(res.Rules))
+	}
+	return res, rulesetText, nil
+}
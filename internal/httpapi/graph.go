@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/compiler"
+	"github.com/John-Robertt/subconverter-go/internal/errlog"
+)
+
+// GraphRequest is the input of POST /api/graph.
+type GraphRequest struct {
+	Subs    []string `json:"subs"`
+	Profile string   `json:"profile"`
+	// Target optionally selects the profile items restricted with `targets:`.
+	Target string `json:"target,omitempty"`
+	// Format is "json" (default) or "dot".
+	Format string `json:"format"`
+}
+
+func (h convertHandler) handleGraph(w http.ResponseWriter, r *http.Request) {
+	collector := errlog.NewCollector(ensureRequestID(r), r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20 /* 1 MiB */)
+
+	var req GraphRequest
+	err := decodeJSONBody(r, &req)
+	if err == nil {
+		req, err = normalizeGraphRequest(req)
+	}
+	if err != nil {
+		writeErrorFromErr(w, r, err, collector, h.opt.ErrorLog)
+		return
+	}
+	collector.SetRequest("graph", req.Target, req.Subs, req.Profile, "", "")
+
+	graph, err := runGraph(r.Context(), req, h.opt, collector)
+	if err != nil {
+		writeErrorFromErr(w, r, err, collector, h.opt.ErrorLog)
+		return
+	}
+	if req.Format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(graph.DOT()))
+		return
+	}
+	WriteJSON(w, http.StatusOK, graph)
+}
+
+func normalizeGraphRequest(req GraphRequest) (GraphRequest, error) {
+	subs, prof, err := normalizeSources(req.Subs, req.Profile)
+	if err != nil {
+		return GraphRequest{}, err
+	}
+	req.Subs, req.Profile = subs, prof
+	if req.Target, err = normalizeOptionalTarget(req.Target); err != nil {
+		return GraphRequest{}, err
+	}
+	req.Format = strings.ToLower(strings.TrimSpace(req.Format))
+	switch req.Format {
+	case "":
+		req.Format = "json"
+	case "json", "dot":
+	default:
+		return GraphRequest{}, requestError("INVALID_ARGUMENT", "format 只支持 json 或 dot", req.Format)
+	}
+	return req, nil
+}
+
+func runGraph(ctx context.Context, req GraphRequest, opt Options, collector *errlog.Collector) (compiler.GroupGraph, error) {
+	opt = opt.withDefaults()
+
+	ctx, cancel := context.WithTimeout(ctx, opt.ConvertTimeout)
+	defer cancel()
+
+	// Like convert, only inline rulesets are fetched (compile needs their
+	// content); a remote ruleset contributes just its action. Without a
+	// target the graph shows every target's groups together.
+	res, _, err := fetchAndCompile(ctx, req.Subs, profileSource{URL: req.Profile}, req.Target, false, isInlineRuleset, opt, collector)
+	if err != nil {
+		return compiler.GroupGraph{}, err
+	}
+	return compiler.AnalyzeGroups(res), nil
+}
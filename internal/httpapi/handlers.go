@@ -29,7 +29,7 @@ func (h convertHandler) handleSub(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	out, err := runConvert(r.Context(), r, req, h.opt, collector)
+	out, err := runConvert(r.Context(), r, req, h.opt, collector, w.Header())
 	if err != nil {
 		writeErrorFromErr(w, r, err, collector, h.opt.ErrorLog)
 		return
@@ -40,8 +40,8 @@ func (h convertHandler) handleSub(w http.ResponseWriter, r *http.Request) {
 func (h convertHandler) handleConvert(w http.ResponseWriter, r *http.Request) {
 	collector := errlog.NewCollector(ensureRequestID(r), r)
 
-	// Prevent abusive payload sizes.
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<20 /* 1 MiB */)
+	// Prevent abusive payload sizes; leaves room for inline profile/template content.
+	r.Body = http.MaxBytesReader(w, r.Body, 4<<20 /* 4 MiB */)
 
 	req, err := parseConvertPOST(r)
 	if err != nil {
@@ -55,7 +55,7 @@ func (h convertHandler) handleConvert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	out, err := runConvert(r.Context(), r, req, h.opt, collector)
+	out, err := runConvert(r.Context(), r, req, h.opt, collector, w.Header())
 	if err != nil {
 		writeErrorFromErr(w, r, err, collector, h.opt.ErrorLog)
 		return
@@ -17,5 +17,10 @@ func NewMuxWithOptions(opt Options) *http.ServeMux {
 	mux.HandleFunc("GET /metrics", handleMetrics)
 	mux.HandleFunc("GET /sub", h.handleSub)
 	mux.HandleFunc("POST /api/convert", h.handleConvert)
+	mux.HandleFunc("POST /api/explain", h.handleExplain)
+	mux.HandleFunc("POST /api/graph", h.handleGraph)
+	mux.HandleFunc("GET /api/profile/schema", handleProfileSchema)
+	mux.HandleFunc("POST /api/profile/upgrade", h.handleProfileUpgrade)
+	mux.HandleFunc("POST /api/profile/validate", h.handleProfileValidate)
 	return mux
 }
@@ -0,0 +1,76 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/errlog"
+	"github.com/John-Robertt/subconverter-go/internal/fetch"
+	"github.com/John-Robertt/subconverter-go/internal/profile"
+)
+
+// UpgradeRequest is the input of POST /api/profile/upgrade: a version 1
+// profile by URL or inline (exactly one of the two).
+type UpgradeRequest struct {
+	Profile        string `json:"profile"`
+	ProfileContent string `json:"profileContent"`
+}
+
+func (h convertHandler) handleProfileUpgrade(w http.ResponseWriter, r *http.Request) {
+	collector := errlog.NewCollector(ensureRequestID(r), r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, 4<<20 /* 4 MiB */)
+
+	var req UpgradeRequest
+	err := decodeJSONBody(r, &req)
+	if err == nil {
+		req, err = normalizeUpgradeRequest(req)
+	}
+	if err != nil {
+		writeErrorFromErr(w, r, err, collector, h.opt.ErrorLog)
+		return
+	}
+	collector.SetRequest("upgrade", "", nil, req.Profile, "", "")
+
+	out, err := runUpgrade(r.Context(), req, h.opt, collector)
+	if err != nil {
+		writeErrorFromErr(w, r, err, collector, h.opt.ErrorLog)
+		return
+	}
+	WriteText(w, http.StatusOK, out)
+}
+
+func normalizeUpgradeRequest(req UpgradeRequest) (UpgradeRequest, error) {
+	req.Profile = strings.TrimSpace(req.Profile)
+	switch {
+	case req.Profile != "" && req.ProfileContent != "":
+		return UpgradeRequest{}, requestError("INVALID_ARGUMENT", "profile 与 profileContent 只能二选一", "")
+	case req.Profile == "" && strings.TrimSpace(req.ProfileContent) == "":
+		return UpgradeRequest{}, requestError("INVALID_ARGUMENT", "profile 不能为空", "expected: profile (URL) or profileContent (YAML)")
+	}
+	if err := inlineContentSize("profileContent", req.ProfileContent, fetch.KindProfile); err != nil {
+		return UpgradeRequest{}, err
+	}
+	return req, nil
+}
+
+// runUpgrade converts the profile document itself; includes are left as
+// references (upgrade each included fragment separately).
+func runUpgrade(ctx context.Context, req UpgradeRequest, opt Options, collector *errlog.Collector) (string, error) {
+	opt = opt.withDefaults()
+
+	ctx, cancel := context.WithTimeout(ctx, opt.ConvertTimeout)
+	defer cancel()
+
+	text := req.ProfileContent
+	if text == "" {
+		var err error
+		text, err = fetch.FetchTextWithOptions(ctx, fetch.KindProfile, req.Profile, fetch.Options{Timeout: opt.FetchTimeout})
+		if err != nil {
+			return "", err
+		}
+	}
+	collector.AddResource(errlog.NewResourceSnapshot(errlog.ResourceProfile, req.Profile, text))
+	return profile.UpgradeYAML(req.Profile, text)
+}
@@ -0,0 +1,160 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/compiler"
+	"github.com/John-Robertt/subconverter-go/internal/errlog"
+	"github.com/John-Robertt/subconverter-go/internal/fetch"
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/profile"
+)
+
+// ValidateRequest is the input of POST /api/profile/validate: a profile by URL
+// or inline (exactly one of the two), optionally checked against a sample
+// subscription.
+type ValidateRequest struct {
+	Profile        string   `json:"profile"`
+	ProfileContent string   `json:"profileContent"`
+	Subs           []string `json:"subs,omitempty"`
+	// Target optionally restricts validation to one client.
+	Target string            `json:"target,omitempty"`
+	Vars   map[string]string `json:"vars,omitempty"`
+}
+
+// ValidateResponse lists every problem found; Valid is true when Errors is empty.
+type ValidateResponse struct {
+	Valid  bool             `json:"valid"`
+	Errors []model.AppError `json:"errors"`
+}
+
+func (h convertHandler) handleProfileValidate(w http.ResponseWriter, r *http.Request) {
+	collector := errlog.NewCollector(ensureRequestID(r), r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, 4<<20 /* 4 MiB */)
+
+	var req ValidateRequest
+	err := decodeJSONBody(r, &req)
+	if err == nil {
+		req, err = normalizeValidateRequest(req)
+	}
+	if err != nil {
+		writeErrorFromErr(w, r, err, collector, h.opt.ErrorLog)
+		return
+	}
+	collector.SetRequest("validate", req.Target, req.Subs, req.Profile, "", "")
+
+	resp, err := runValidate(r.Context(), req, h.opt, collector)
+	if err != nil {
+		writeErrorFromErr(w, r, err, collector, h.opt.ErrorLog)
+		return
+	}
+	WriteJSON(w, http.StatusOK, resp)
+}
+
+func handleProfileSchema(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(profile.JSONSchema())
+}
+
+func normalizeValidateRequest(req ValidateRequest) (ValidateRequest, error) {
+	req.Profile = strings.TrimSpace(req.Profile)
+	switch {
+	case req.Profile != "" && req.ProfileContent != "":
+		return ValidateRequest{}, requestError("INVALID_ARGUMENT", "profile 与 profileContent 只能二选一", "")
+	case req.Profile == "" && strings.TrimSpace(req.ProfileContent) == "":
+		return ValidateRequest{}, requestError("INVALID_ARGUMENT", "profile 不能为空", "expected: profile (URL) or profileContent (YAML)")
+	}
+	if err := inlineContentSize("profileContent", req.ProfileContent, fetch.KindProfile); err != nil {
+		return ValidateRequest{}, err
+	}
+	var err error
+	if req.Target, err = normalizeOptionalTarget(req.Target); err != nil {
+		return ValidateRequest{}, err
+	}
+	if err := validateVars(req.Vars); err != nil {
+		return ValidateRequest{}, err
+	}
+	for i, s := range req.Subs {
+		if req.Subs[i] = strings.TrimSpace(s); req.Subs[i] == "" {
+			return ValidateRequest{}, requestError("INVALID_ARGUMENT", "sub 不能为空", "")
+		}
+	}
+	return req, nil
+}
+
+// runValidate collects profile errors instead of stopping at the first one.
+// Only failures to load the inputs (profile, includes, sample subscription)
+// are returned as an error.
+func runValidate(ctx context.Context, req ValidateRequest, opt Options, collector *errlog.Collector) (ValidateResponse, error) {
+	opt = opt.withDefaults()
+
+	ctx, cancel := context.WithTimeout(ctx, opt.ConvertTimeout)
+	defer cancel()
+
+	v, snapshots, err := fetchAndValidateProfile(ctx, profileSource{URL: req.Profile, Content: req.ProfileContent, Vars: req.Vars}, req.Target, opt.FetchTimeout)
+	if err != nil {
+		return ValidateResponse{}, err
+	}
+	for _, s := range snapshots {
+		collector.AddResource(s)
+	}
+
+	errs := v.Errors
+	for _, err := range errs {
+		// A failed include fetch is not a profile problem: fail like convert.
+		if pe := (*profile.ParseError)(nil); !errors.As(err, &pe) {
+			return ValidateResponse{}, err
+		}
+	}
+
+	if v.Spec != nil {
+		var subs []model.Proxy
+		if len(req.Subs) > 0 {
+			var err error
+			if subs, err = fetchAndParseSubs(ctx, req.Subs, opt.FetchTimeout, collector, false); err != nil {
+				return ValidateResponse{}, err
+			}
+		}
+		for _, target := range validateTargets(v.Spec, req.Target) {
+			errs = append(errs, compiler.Check(v.Spec, compiler.CheckOptions{
+				Target:    target,
+				Subs:      subs,
+				Ignore:    v.BrokenNames,
+				SkipMatch: v.BrokenMatch,
+			})...)
+		}
+	}
+
+	resp := ValidateResponse{Errors: []model.AppError{}}
+	for _, err := range errs {
+		app := classifyError(err).app
+		if !slices.Contains(resp.Errors, app) {
+			resp.Errors = append(resp.Errors, app)
+		}
+	}
+	resp.Valid = len(resp.Errors) == 0
+	return resp, nil
+}
+
+// validateTargets lists the target views to compile: the requested one, or
+// every target with a template (target-agnostic when there is none).
+func validateTargets(prof *profile.Spec, target string) []string {
+	if target != "" {
+		return []string{target}
+	}
+	if len(prof.Template) == 0 {
+		return []string{""}
+	}
+	targets := make([]string, 0, len(prof.Template))
+	for t := range prof.Template {
+		targets = append(targets, t)
+	}
+	slices.Sort(targets)
+	return targets
+}
@@ -9,10 +9,15 @@ type AppError struct {
 
 	URL     string `json:"url,omitempty"`
 	Line    int    `json:"line,omitempty"`    // 1-based; 0 means "not set"
+	Column  int    `json:"column,omitempty"`  // 1-based, with Line
 	Snippet string `json:"snippet,omitempty"` // <= 200 chars recommended by spec
 	Hint    string `json:"hint,omitempty"`
 }
 
+// ErrorResponse carries the (first) error. Errors lists every error, in
+// pipeline order, when a diagnostics request found more than one; Error is
+// then Errors[0].
 type ErrorResponse struct {
-	Error AppError `json:"error"`
+	Error  AppError   `json:"error"`
+	Errors []AppError `json:"errors,omitempty"`
 }
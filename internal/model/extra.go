@@ -0,0 +1,17 @@
+package model
+
+// Host is a static DNS mapping from the profile's `hosts`: Domain resolves to
+// Value (an IP address or another domain).
+type Host struct {
+	Domain string
+	Value  string
+}
+
+// ProxyProvider is a remote proxy list the client fetches by itself
+// (profile `proxy_providers`; Clash proxy-providers).
+type ProxyProvider struct {
+	Name string
+	URL  string
+	// IntervalSec is the refresh interval; 0 means the renderer default.
+	IntervalSec int
+}
@@ -11,16 +11,47 @@ type MemberRef struct {
 	Value string
 }
 
+const (
+	LoadBalanceConsistentHashing = "consistent-hashing"
+	LoadBalanceRoundRobin        = "round-robin"
+)
+
+// GroupOptions are optional per-group attributes. Renderers emit the ones the
+// target supports and drop the rest.
+type GroupOptions struct {
+	Hidden bool
+	Icon   string // http(s) URL
+
+	// url-test / fallback / load-balance only
+	Lazy      bool
+	HasLazy   bool
+	TimeoutMS int // 0 = target default
+
+	DisableUDP          bool
+	IncludeAllProviders bool
+
+	// relay only: Clash renders the chain as mihomo dialer-proxy hops
+	// instead of the deprecated type: relay.
+	DialerProxy bool
+}
+
 type Group struct {
 	Name string
-	Type string // "select" | "url-test"
+	Type string // "select" | "url-test" | "fallback" | "load-balance" | "relay"
 
+	// Members of a relay group are its hops in order (entry first, exit last).
 	Members []MemberRef
 
-	// url-test only
+	// url-test / fallback / load-balance
 	TestURL     string
 	IntervalSec int
 
+	// url-test only
 	ToleranceMS  int
 	HasTolerance bool
+
+	// load-balance only: LoadBalanceConsistentHashing | LoadBalanceRoundRobin
+	Strategy string
+
+	Options GroupOptions
 }
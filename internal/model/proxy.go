@@ -24,9 +24,13 @@ type Proxy struct {
 	Username string
 	Cipher   string
 	Password string
-	// ViaProxyID points to the subscription proxy used to access a derived proxy.
-	// Empty means this proxy is a direct subscription proxy.
+	// ViaProxyID points to the proxy used to access a derived proxy: a
+	// subscription proxy, or another derived proxy for multi-hop chains.
+	// Empty (with ViaGroup) means this proxy is dialed directly.
 	ViaProxyID string
+	// ViaGroup names the policy group used to access a derived proxy instead
+	// (proxy_chain type=dialer). At most one of ViaProxyID / ViaGroup is set.
+	ViaGroup string
 
 	// PluginName/PluginOpts come from the "plugin" query parameter in ss://.
 	// PluginOpts must preserve order (no map) to keep behavior deterministic.
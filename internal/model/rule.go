@@ -1,8 +1,11 @@
 package model
 
 type Rule struct {
-	Type      string // e.g. "DOMAIN-SUFFIX", "IP-CIDR", "MATCH"
-	Value     string // domain/suffix/keyword/cidr/cc
-	Action    string // DIRECT/REJECT/group name
-	NoResolve bool   // only meaningful for IP-CIDR/IP-CIDR6
+	Type      string // e.g. "DOMAIN-SUFFIX", "IP-CIDR", "MATCH", "AND"
+	Value     string // domain/suffix/keyword/cidr/cc/port/...; empty for MATCH and logical rules
+	Action    string // DIRECT/REJECT/group name; empty for logical sub-rules
+	NoResolve bool   // only meaningful for IP-CIDR/IP-CIDR6/IP-ASN
+
+	// Sub holds the operands of a logical rule (AND/OR/NOT). Operands carry no Action.
+	Sub []Rule
 }
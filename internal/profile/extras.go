@@ -0,0 +1,150 @@
+package profile
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+)
+
+// rawProxyProvider is a proxy_providers entry.
+type rawProxyProvider struct {
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	Interval int    `yaml:"interval"`
+}
+
+// rawManagedConfig is the `managed_config:` block.
+type rawManagedConfig struct {
+	Interval   int    `yaml:"interval"`
+	Strict     *bool  `yaml:"strict"`
+	WebPageURL string `yaml:"web_page_url"`
+}
+
+// ManagedConfigSpec tunes the update parameters written for managed configs
+// (Surge #!MANAGED-CONFIG, Shadowrocket update-url, Clash response headers).
+type ManagedConfigSpec struct {
+	// IntervalSec is the update interval in seconds; 0 keeps the template
+	// value (or the 86400 default).
+	IntervalSec int
+	Strict      bool
+	HasStrict   bool
+	// WebPageURL is the provider homepage sent as Clash's
+	// profile-web-page-url header; empty omits the header.
+	WebPageURL string
+}
+
+func parseManagedConfig(sourceURL string, raw *rawManagedConfig, sink *errorSink) (ManagedConfigSpec, error) {
+	if raw == nil {
+		return ManagedConfigSpec{}, nil
+	}
+	if raw.Interval < 0 {
+		if err := sink.add(extrasError(sourceURL, "managed_config.interval 不能为负数", fmt.Sprint(raw.Interval), "interval is in seconds, e.g. 43200")); err != nil {
+			return ManagedConfigSpec{}, err
+		}
+		return ManagedConfigSpec{}, nil
+	}
+	mc := ManagedConfigSpec{IntervalSec: raw.Interval}
+	if raw.WebPageURL != "" {
+		if err := validateHTTPURL(raw.WebPageURL); err != nil {
+			if err := sink.add(extrasError(sourceURL, "managed_config.web_page_url 必须是 http/https URL", raw.WebPageURL, "the provider's homepage, e.g. https://example.com")); err != nil {
+				return ManagedConfigSpec{}, err
+			}
+			return ManagedConfigSpec{}, nil
+		}
+		mc.WebPageURL = strings.TrimSpace(raw.WebPageURL)
+	}
+	if raw.Strict != nil {
+		mc.Strict, mc.HasStrict = *raw.Strict, true
+	}
+	return mc, nil
+}
+
+// parseHosts validates `hosts` and returns the mappings sorted by domain, so
+// neither the output nor the first error depends on YAML map order.
+func parseHosts(sourceURL string, raw map[string]string, sink *errorSink) ([]model.Host, error) {
+	hosts := make([]model.Host, 0, len(raw))
+	for _, domain := range slices.Sorted(maps.Keys(raw)) {
+		d, v := strings.TrimSpace(domain), strings.TrimSpace(raw[domain])
+		if !hostTokenOK(d) || !hostTokenOK(v) {
+			if err := sink.add(extrasError(sourceURL, "hosts 条目不合法", fmt.Sprintf("%s: %s", domain, raw[domain]), "expected: <domain>: <ip or domain>, without spaces, ',', '/' or '='")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		hosts = append(hosts, model.Host{Domain: d, Value: v})
+	}
+	return hosts, nil
+}
+
+// parseMITMHostnames validates `mitm_hostnames`; duplicates keep the first
+// occurrence.
+func parseMITMHostnames(sourceURL string, raw []string, sink *errorSink) ([]string, error) {
+	out := make([]string, 0, len(raw))
+	seen := make(map[string]struct{}, len(raw))
+	for _, h := range raw {
+		h = strings.TrimSpace(h)
+		if !hostTokenOK(strings.TrimPrefix(h, "-")) {
+			if err := sink.add(extrasError(sourceURL, "mitm_hostnames 条目不合法", h, "expected a hostname such as *.example.com or -exclude.example.com")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, ok := seen[h]; ok {
+			continue
+		}
+		seen[h] = struct{}{}
+		out = append(out, h)
+	}
+	return out, nil
+}
+
+func parseProxyProviders(sourceURL string, raw []rawProxyProvider, sink *errorSink) ([]model.ProxyProvider, error) {
+	out := make([]model.ProxyProvider, 0, len(raw))
+	seen := make(map[string]struct{}, len(raw))
+	for _, p := range raw {
+		name := strings.TrimSpace(p.Name)
+		var msg, snippet, hint string
+		switch {
+		case name == "" || strings.ContainsAny(name, "\r\n\x00\"'"):
+			msg, snippet, hint = "proxy_providers.name 不合法", p.Name, "name must be non-empty without quotes or control characters"
+		case validateHTTPURL(p.URL) != nil || strings.ContainsAny(p.URL, "\r\n\x00"):
+			msg, snippet, hint = "proxy_providers.url 必须是 http/https URL", p.URL, ""
+		case p.Interval < 0:
+			msg, snippet, hint = "proxy_providers.interval 不能为负数", name, ""
+		}
+		if msg == "" {
+			if _, ok := seen[name]; ok {
+				msg, snippet = "proxy_providers.name 重复", name
+			}
+		}
+		if msg != "" {
+			if err := sink.add(extrasError(sourceURL, msg, snippet, hint)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		seen[name] = struct{}{}
+		out = append(out, model.ProxyProvider{Name: name, URL: strings.TrimSpace(p.URL), IntervalSec: p.Interval})
+	}
+	return out, nil
+}
+
+// hostTokenOK reports whether s can be written unquoted in every target's
+// hosts / hostname list.
+func hostTokenOK(s string) bool {
+	return s != "" && !strings.ContainsAny(s, " \t\r\n\x00,/=\"'")
+}
+
+func extrasError(sourceURL, msg, snippet, hint string) error {
+	return &ParseError{AppError: model.AppError{
+		Code:    "PROFILE_VALIDATE_ERROR",
+		Message: msg,
+		Stage:   "parse_profile",
+		URL:     sourceURL,
+		Snippet: snippet,
+		Hint:    hint,
+	}}
+}
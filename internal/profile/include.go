@@ -0,0 +1,163 @@
+package profile
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/fetch"
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+// rawItems are the profile lists a partial profile may contribute.
+type rawItems struct {
+	CustomProxy      []rawCustomProxy      `yaml:"custom_proxy"`
+	CustomProxyGroup []rawGroupDirective   `yaml:"custom_proxy_group"`
+	ProxyChain       []rawChainSpec        `yaml:"proxy_chain"`
+	Ruleset          []rawRulesetDirective `yaml:"ruleset"`
+	Rule             []rawRuleDirective    `yaml:"rule"`
+}
+
+// rawPartialProfile is an included document: items plus nested includes only.
+type rawPartialProfile struct {
+	Include  []string `yaml:"include"`
+	rawItems `yaml:",inline"`
+}
+
+func (it *rawItems) setOrigin(origin string) {
+	for i := range it.CustomProxy {
+		it.CustomProxy[i].origin = origin
+	}
+	for i := range it.CustomProxyGroup {
+		it.CustomProxyGroup[i].origin = origin
+	}
+	for i := range it.ProxyChain {
+		it.ProxyChain[i].origin = origin
+	}
+	for i := range it.Ruleset {
+		it.Ruleset[i].origin = origin
+	}
+	for i := range it.Rule {
+		it.Rule[i].origin = origin
+	}
+}
+
+// setPositions records the line / column of each custom_proxy and proxy_chain
+// entry (directive entries record theirs while decoding). content has already
+// been decoded into it, so the sequences line up.
+func (it *rawItems) setPositions(content string) {
+	var doc yaml.Node
+	if yaml.Unmarshal([]byte(content), &doc) != nil || len(doc.Content) == 0 {
+		return
+	}
+	root := doc.Content[0]
+	if seq := mappingValue(root, "custom_proxy"); seq != nil {
+		for i := range it.CustomProxy {
+			if i < len(seq.Content) {
+				it.CustomProxy[i].line, it.CustomProxy[i].column = seq.Content[i].Line, seq.Content[i].Column
+			}
+		}
+	}
+	if seq := mappingValue(root, "proxy_chain"); seq != nil {
+		for i := range it.ProxyChain {
+			if i < len(seq.Content) {
+				it.ProxyChain[i].line, it.ProxyChain[i].column = seq.Content[i].Line, seq.Content[i].Column
+			}
+		}
+	}
+}
+
+func (it *rawItems) append(other rawItems) {
+	it.CustomProxy = append(it.CustomProxy, other.CustomProxy...)
+	it.CustomProxyGroup = append(it.CustomProxyGroup, other.CustomProxyGroup...)
+	it.ProxyChain = append(it.ProxyChain, other.ProxyChain...)
+	it.Ruleset = append(it.Ruleset, other.Ruleset...)
+	it.Rule = append(it.Rule, other.Rule...)
+}
+
+// includeResolver merges includes depth-first: each document contributes its
+// includes' items (in declaration order) before its own. A URL reached twice
+// without a cycle is merged only at its first occurrence.
+type includeResolver struct {
+	load   fetch.Loader
+	merged map[string]struct{}
+}
+
+func (r *includeResolver) resolve(items rawItems, origin string, includes []string, stack []string) (rawItems, error) {
+	items.setOrigin(origin)
+	var out rawItems
+	for _, ref := range includes {
+		u, err := fetch.ResolveIncludeURL(origin, ref)
+		if err != nil {
+			return rawItems{}, &ParseError{
+				AppError: model.AppError{
+					Code:    "PROFILE_VALIDATE_ERROR",
+					Message: "include URL 不合法",
+					Stage:   "parse_profile",
+					URL:     origin,
+					Snippet: ref,
+				},
+				Cause: err,
+			}
+		}
+		if slices.Contains(stack, u) {
+			return rawItems{}, &ParseError{AppError: model.AppError{
+				Code:    "PROFILE_INCLUDE_CYCLE",
+				Message: "include 存在循环引用",
+				Stage:   "parse_profile",
+				URL:     origin,
+				Snippet: truncateSnippet(strings.Join(append(slices.Clone(stack), u), " -> "), 200),
+			}}
+		}
+		if _, ok := r.merged[u]; ok {
+			continue
+		}
+		if len(stack) > fetch.MaxIncludeDepth || len(r.merged) >= fetch.MaxIncludes {
+			return rawItems{}, &ParseError{AppError: model.AppError{
+				Code:    "PROFILE_INCLUDE_ERROR",
+				Message: fmt.Sprintf("include 超出限制（深度 %d，总数 %d）", fetch.MaxIncludeDepth, fetch.MaxIncludes),
+				Stage:   "parse_profile",
+				URL:     origin,
+				Snippet: u,
+			}}
+		}
+		if r.load == nil {
+			return rawItems{}, &ParseError{AppError: model.AppError{
+				Code:    "PROFILE_INCLUDE_ERROR",
+				Message: "当前调用方式不支持 include",
+				Stage:   "parse_profile",
+				URL:     origin,
+				Snippet: u,
+			}}
+		}
+		r.merged[u] = struct{}{}
+
+		text, err := r.load(u)
+		if err != nil {
+			return rawItems{}, err
+		}
+		var part rawPartialProfile
+		if err := yamlDecodeStrict(text, &part); err != nil {
+			return rawItems{}, &ParseError{
+				AppError: model.AppError{
+					Code:    "PROFILE_PARSE_ERROR",
+					Message: "include 的 profile 片段解析失败",
+					Stage:   "parse_profile",
+					URL:     u,
+					Snippet: truncateSnippet(text, 200),
+					Hint:    "included profiles may only contain include, custom_proxy, custom_proxy_group, proxy_chain, ruleset, rule",
+				},
+				Cause: err,
+			}
+		}
+		part.setPositions(text)
+		sub, err := r.resolve(part.rawItems, u, part.Include, append(stack, u))
+		if err != nil {
+			return rawItems{}, err
+		}
+		out.append(sub)
+	}
+	out.append(items)
+	return out, nil
+}
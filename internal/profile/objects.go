@@ -0,0 +1,677 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/rules"
+	"gopkg.in/yaml.v3"
+)
+
+// groupObject is a custom_proxy_group entry in structured form. It is the
+// version 2 schema, and a version 1 directive is split into it before
+// validation, so both versions share buildGroup. Every value stays a string
+// until buildGroup so ${VAR} references survive splitting (see UpgradeYAML).
+type groupObject struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+
+	// select members, or relay hops in order
+	Members []string `yaml:"members"`
+	// select-regex or url-test / fallback / load-balance
+	Regex     string `yaml:"regex"`
+	URL       string `yaml:"url"`
+	Interval  string `yaml:"interval"`
+	Tolerance string `yaml:"tolerance"`
+	Strategy  string `yaml:"strategy"`
+
+	Icon                string `yaml:"icon"`
+	Hidden              string `yaml:"hidden"`
+	Lazy                string `yaml:"lazy"`
+	Timeout             string `yaml:"timeout"`
+	DisableUDP          string `yaml:"disable_udp"`
+	IncludeAllProviders string `yaml:"include_all_providers"`
+	DialerProxy         string `yaml:"dialer_proxy"`
+
+	Targets []string `yaml:"targets"`
+}
+
+// rulesetObject is a ruleset entry in structured form (see groupObject).
+type rulesetObject struct {
+	Action   string   `yaml:"action"`
+	URL      string   `yaml:"url"`
+	Inline   string   `yaml:"inline"`
+	Behavior string   `yaml:"behavior"`
+	Format   string   `yaml:"format"`
+	Interval string   `yaml:"interval"`
+	Targets  []string `yaml:"targets"`
+}
+
+// ruleObject is a rule entry in structured form. Logical rules (AND / OR /
+// NOT) list their operands under rules; operands carry no action.
+type ruleObject struct {
+	Type      string       `yaml:"type"`
+	Value     string       `yaml:"value"`
+	Action    string       `yaml:"action"`
+	NoResolve bool         `yaml:"no_resolve"`
+	Rules     []ruleObject `yaml:"rules"`
+	Targets   []string     `yaml:"targets"`
+}
+
+// fieldError attributes a validation error to a structured field.
+type fieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *fieldError) Error() string { return e.Field + ": " + e.Err.Error() }
+
+func (e *fieldError) Unwrap() error { return e.Err }
+
+func inField(field string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fieldError{Field: field, Err: err}
+}
+
+// decodeObject decodes a structured entry, rejecting unknown fields.
+func decodeObject(node *yaml.Node, out any) error {
+	b, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	return yamlDecodeStrict(string(b), out)
+}
+
+// groupOptionKeys maps directive attribute keys to their groupObject fields.
+var groupOptionKeys = map[string]func(*groupObject) *string{
+	"icon":                  func(o *groupObject) *string { return &o.Icon },
+	"hidden":                func(o *groupObject) *string { return &o.Hidden },
+	"lazy":                  func(o *groupObject) *string { return &o.Lazy },
+	"timeout":               func(o *groupObject) *string { return &o.Timeout },
+	"disable-udp":           func(o *groupObject) *string { return &o.DisableUDP },
+	"include-all-providers": func(o *groupObject) *string { return &o.IncludeAllProviders },
+	"dialer-proxy":          func(o *groupObject) *string { return &o.DialerProxy },
+}
+
+// groupFlagOptions are the boolean attributes that may be written bare (`hidden)
+// or as `hidden=true|false`.
+var groupFlagOptions = map[string]struct{}{
+	"hidden":                {},
+	"lazy":                  {},
+	"disable-udp":           {},
+	"include-all-providers": {},
+	"dialer-proxy":          {},
+}
+
+func isHealthCheckGroupType(typ string) bool {
+	return typ == "url-test" || typ == "fallback" || typ == "load-balance"
+}
+
+// splitGroupDirective splits a version 1 directive into its fields without
+// validating their values.
+func splitGroupDirective(raw string) (groupObject, error) {
+	parts, err := splitGroupOptions(strings.Split(raw, "`"))
+	if err != nil {
+		return groupObject{}, err
+	}
+	o := parts.obj
+	if len(parts.positional) < 2 {
+		return groupObject{}, &directiveError{
+			Code:    "GROUP_PARSE_ERROR",
+			Message: "custom_proxy_group 指令格式不合法",
+			Hint:    "expected: <NAME>`select`[]... or <NAME>`url-test|fallback|load-balance`<REGEX>`<URL>`<INTERVAL>[`<TOLERANCE|STRATEGY>]",
+		}
+	}
+	p := parts.positional
+	o.Name = strings.TrimSpace(p[0])
+	o.Type = strings.TrimSpace(p[1])
+
+	switch o.Type {
+	case "select":
+		if len(p) != 3 {
+			return groupObject{}, errors.New("select group must be: <NAME>`select`[]<MEMBER_1>[]<MEMBER_2>... or <NAME>`select`<REGEX>")
+		}
+		third := strings.TrimSpace(p[2])
+		if third == "" {
+			return groupObject{}, errors.New("select group requires member list or regex")
+		}
+		if !strings.HasPrefix(third, "[]") {
+			o.Regex = third
+			break
+		}
+		o.Members = splitMemberList(third)
+	case "url-test":
+		if len(p) != 5 && len(p) != 6 {
+			return groupObject{}, errors.New("url-test group must be: <NAME>`url-test`<REGEX>`<URL>`<INTERVAL_SEC>[`<TOLERANCE_MS>]")
+		}
+		o.Regex, o.URL, o.Interval = p[2], p[3], p[4]
+		if len(p) == 6 {
+			if o.Tolerance = strings.TrimSpace(p[5]); o.Tolerance == "" {
+				return groupObject{}, errors.New("url-test tolerance must be a non-negative integer")
+			}
+		}
+	case "fallback":
+		if len(p) != 5 {
+			return groupObject{}, errors.New("fallback group must be: <NAME>`fallback`<REGEX>`<URL>`<INTERVAL_SEC>")
+		}
+		o.Regex, o.URL, o.Interval = p[2], p[3], p[4]
+	case "load-balance":
+		if len(p) != 5 && len(p) != 6 {
+			return groupObject{}, errors.New("load-balance group must be: <NAME>`load-balance`<REGEX>`<URL>`<INTERVAL_SEC>[`consistent-hashing|round-robin]")
+		}
+		o.Regex, o.URL, o.Interval = p[2], p[3], p[4]
+		if len(p) == 6 {
+			o.Strategy = strings.TrimSpace(p[5])
+		}
+	case "relay":
+		if len(p) != 3 || !strings.HasPrefix(strings.TrimSpace(p[2]), "[]") {
+			return groupObject{}, errors.New("relay group must be: <NAME>`relay`[]<HOP_1>[]<HOP_2>...")
+		}
+		o.Members = splitMemberList(strings.TrimSpace(p[2]))
+	}
+	return o, nil
+}
+
+func splitMemberList(s string) []string {
+	toks := strings.Split(s, "[]")[1:]
+	for i := range toks {
+		toks[i] = strings.TrimSpace(toks[i])
+	}
+	return toks
+}
+
+type groupDirectiveParts struct {
+	positional []string
+	obj        groupObject // attributes only
+}
+
+// splitGroupOptions peels trailing attribute parts off a group directive. Only
+// parts after the type's fixed positional fields are considered, so regexes
+// and test URLs containing "=" are never taken for attributes.
+func splitGroupOptions(parts []string) (groupDirectiveParts, error) {
+	out := groupDirectiveParts{positional: parts}
+	if len(parts) < 2 {
+		return out, nil
+	}
+	fixed := 3
+	if isHealthCheckGroupType(strings.TrimSpace(parts[1])) {
+		fixed = 5
+	}
+
+	start := len(parts)
+	for start > fixed {
+		p := strings.TrimSpace(parts[start-1])
+		key, _, hasValue := strings.Cut(p, "=")
+		if _, ok := groupFlagOptions[key]; !ok && !hasValue {
+			break
+		}
+		start--
+	}
+
+	seen := make(map[string]struct{})
+	for _, p := range parts[start:] {
+		p = strings.TrimSpace(p)
+		key, value, hasValue := strings.Cut(p, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if _, ok := seen[key]; ok {
+			return out, &directiveError{Code: "GROUP_PARSE_ERROR", Message: fmt.Sprintf("策略组属性重复：%s", key)}
+		}
+		seen[key] = struct{}{}
+
+		field, ok := groupOptionKeys[key]
+		if !ok {
+			return out, &directiveError{
+				Code:    "GROUP_PARSE_ERROR",
+				Message: fmt.Sprintf("不支持的策略组属性：%s", key),
+				Hint:    "supported: hidden, icon=<URL>, lazy, timeout=<MS>, disable-udp, include-all-providers, dialer-proxy",
+			}
+		}
+		if _, flag := groupFlagOptions[key]; flag && !hasValue {
+			value = "true"
+		}
+		*field(&out.obj) = value
+	}
+	out.positional = parts[:start]
+	return out, nil
+}
+
+// buildGroup validates a structured group. raw is kept as GroupSpec.Raw.
+func buildGroup(raw string, o groupObject) (GroupSpec, error) {
+	name := strings.TrimSpace(o.Name)
+	typ := strings.TrimSpace(o.Type)
+	if strings.ContainsAny(name, "\r\n\x00") {
+		return GroupSpec{}, inField("name", errors.New("group name contains control chars"))
+	}
+	if name == "" || typ == "" {
+		return GroupSpec{}, errors.New("group name/type must not be empty")
+	}
+	if err := checkGroupFields(typ, o); err != nil {
+		return GroupSpec{}, err
+	}
+
+	var gs GroupSpec
+	var err error
+	switch typ {
+	case "select":
+		gs, err = buildSelectGroup(raw, name, o)
+	case "url-test":
+		gs, err = buildHealthCheckGroup(raw, name, typ, o)
+		if err == nil && o.Tolerance != "" {
+			tol, perr := strconv.Atoi(strings.TrimSpace(o.Tolerance))
+			if perr != nil || tol < 0 {
+				return GroupSpec{}, inField("tolerance", errors.New("url-test tolerance must be a non-negative integer"))
+			}
+			gs.ToleranceMS, gs.HasTolerance = tol, true
+		}
+	case "fallback":
+		gs, err = buildHealthCheckGroup(raw, name, typ, o)
+	case "load-balance":
+		gs, err = buildHealthCheckGroup(raw, name, typ, o)
+		if err == nil {
+			gs.Strategy = model.LoadBalanceConsistentHashing
+			switch strategy := strings.TrimSpace(o.Strategy); strategy {
+			case "":
+			case model.LoadBalanceConsistentHashing, model.LoadBalanceRoundRobin:
+				gs.Strategy = strategy
+			default:
+				return GroupSpec{}, inField("strategy", &directiveError{
+					Code:    "GROUP_PARSE_ERROR",
+					Message: fmt.Sprintf("不支持的 load-balance 策略：%s", strategy),
+					Hint:    "expected: consistent-hashing | round-robin",
+				})
+			}
+		}
+	case "relay":
+		gs, err = buildRelayGroup(raw, name, o.Members)
+	default:
+		return GroupSpec{}, inField("type", &directiveError{Code: "GROUP_UNSUPPORTED_TYPE", Message: fmt.Sprintf("不支持的策略组类型：%s", typ)})
+	}
+	if err != nil {
+		return GroupSpec{}, err
+	}
+
+	if gs.Options, err = buildGroupOptions(o); err != nil {
+		return GroupSpec{}, err
+	}
+	if (gs.Options.HasLazy || gs.Options.TimeoutMS > 0) && !isHealthCheckGroupType(typ) {
+		return GroupSpec{}, &directiveError{
+			Code:    "GROUP_PARSE_ERROR",
+			Message: fmt.Sprintf("%s 组不支持 lazy/timeout 属性", typ),
+			Hint:    "lazy/timeout only apply to url-test / fallback / load-balance",
+		}
+	}
+	if gs.Options.DialerProxy && typ != "relay" {
+		return GroupSpec{}, &directiveError{
+			Code:    "GROUP_PARSE_ERROR",
+			Message: fmt.Sprintf("%s 组不支持 dialer-proxy 属性", typ),
+			Hint:    "dialer-proxy only applies to relay groups",
+		}
+	}
+	return gs, nil
+}
+
+// checkGroupFields rejects fields the group type does not use. Directives
+// cannot produce them; structured entries can.
+func checkGroupFields(typ string, o groupObject) error {
+	used := map[string]bool{}
+	switch typ {
+	case "select":
+		used["members"], used["regex"] = true, true
+	case "relay":
+		used["members"] = true
+	case "url-test":
+		used["regex"], used["url"], used["interval"], used["tolerance"] = true, true, true, true
+	case "fallback":
+		used["regex"], used["url"], used["interval"] = true, true, true
+	case "load-balance":
+		used["regex"], used["url"], used["interval"], used["strategy"] = true, true, true, true
+	default:
+		return nil
+	}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"members", o.Members != nil},
+		{"regex", o.Regex != ""},
+		{"url", o.URL != ""},
+		{"interval", o.Interval != ""},
+		{"tolerance", o.Tolerance != ""},
+		{"strategy", o.Strategy != ""},
+	} {
+		if f.set && !used[f.name] {
+			return inField(f.name, &directiveError{Code: "GROUP_PARSE_ERROR", Message: fmt.Sprintf("%s 组不支持字段 %s", typ, f.name)})
+		}
+	}
+	return nil
+}
+
+func buildSelectGroup(raw, name string, o groupObject) (GroupSpec, error) {
+	if o.Regex != "" {
+		if o.Members != nil {
+			return GroupSpec{}, &directiveError{Code: "GROUP_PARSE_ERROR", Message: "select 组的 members 与 regex 只能二选一"}
+		}
+		re, err := regexp.Compile(o.Regex)
+		if err != nil {
+			return GroupSpec{}, inField("regex", &directiveError{
+				Code:    "GROUP_PARSE_ERROR",
+				Message: "select 正则不可编译",
+				Hint:    "expected: <NAME>`select`(REGEX) or <NAME>`select`[]MEMBER...",
+				Cause:   err,
+			})
+		}
+		return GroupSpec{Raw: raw, Name: name, Type: "select", RegexRaw: o.Regex, Regex: re}, nil
+	}
+	if o.Members == nil {
+		return GroupSpec{}, errors.New("select group requires member list or regex")
+	}
+
+	members := make([]string, 0, len(o.Members))
+	var memberRegex []*regexp.Regexp
+	for i, tok := range o.Members {
+		tok = strings.TrimSpace(tok)
+		field := fmt.Sprintf("members[%d]", i)
+		if tok == "" {
+			return GroupSpec{}, inField(field, errors.New("empty member in select group"))
+		}
+		members = append(members, tok)
+		pattern, ok := strings.CutPrefix(tok, SelectMemberRegexPrefix)
+		if !ok {
+			pattern, ok = strings.CutPrefix(tok, SelectMemberAllExceptPrefix)
+		}
+		if !ok {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil || pattern == "" {
+			return GroupSpec{}, inField(field, &directiveError{
+				Code:    "GROUP_PARSE_ERROR",
+				Message: fmt.Sprintf("select 成员正则不可编译：%s", tok),
+				Hint:    "expected: []@regex:<REGEX> or []@all-except:<REGEX>",
+				Cause:   err,
+			})
+		}
+		if memberRegex == nil {
+			memberRegex = make([]*regexp.Regexp, len(o.Members))
+		}
+		memberRegex[i] = re
+	}
+	if len(members) == 0 {
+		return GroupSpec{}, inField("members", errors.New("select group requires at least 1 member"))
+	}
+	return GroupSpec{Raw: raw, Name: name, Type: "select", Members: members, MemberRegex: memberRegex}, nil
+}
+
+// buildHealthCheckGroup validates the shared regex / url / interval fields of
+// url-test / fallback / load-balance groups.
+func buildHealthCheckGroup(raw, name, typ string, o groupObject) (GroupSpec, error) {
+	if o.Regex == "" || o.URL == "" || o.Interval == "" {
+		return GroupSpec{}, fmt.Errorf("%s regex/url/interval must not be empty", typ)
+	}
+	re, err := regexp.Compile(o.Regex)
+	if err != nil {
+		return GroupSpec{}, inField("regex", &directiveError{Code: "GROUP_PARSE_ERROR", Message: typ + " 正则不可编译", Cause: err})
+	}
+	if err := validateHTTPURL(o.URL); err != nil {
+		return GroupSpec{}, inField("url", err)
+	}
+	intervalSec, err := strconv.Atoi(strings.TrimSpace(o.Interval))
+	if err != nil || intervalSec <= 0 {
+		return GroupSpec{}, inField("interval", fmt.Errorf("%s interval must be a positive integer", typ))
+	}
+	return GroupSpec{Raw: raw, Name: name, Type: typ, RegexRaw: o.Regex, Regex: re, TestURL: o.URL, IntervalSec: intervalSec}, nil
+}
+
+func buildRelayGroup(raw, name string, members []string) (GroupSpec, error) {
+	hops := make([]string, 0, len(members))
+	seen := make(map[string]struct{}, len(members))
+	for i, tok := range members {
+		tok = strings.TrimSpace(tok)
+		field := fmt.Sprintf("members[%d]", i)
+		if tok == "" {
+			return GroupSpec{}, inField(field, errors.New("empty hop in relay group"))
+		}
+		if tok == "DIRECT" || tok == "REJECT" || strings.HasPrefix(tok, "@") {
+			return GroupSpec{}, inField(field, &directiveError{
+				Code:    "GROUP_PARSE_ERROR",
+				Message: fmt.Sprintf("relay 跳点只能是节点名或策略组名：%s", tok),
+				Hint:    "expected: <NAME>`relay`[]<ENTRY>[]<TRANSIT>...[]<EXIT>",
+			})
+		}
+		if _, ok := seen[tok]; ok {
+			return GroupSpec{}, inField(field, &directiveError{Code: "GROUP_PARSE_ERROR", Message: fmt.Sprintf("relay 跳点重复：%s", tok)})
+		}
+		seen[tok] = struct{}{}
+		hops = append(hops, tok)
+	}
+	if len(hops) < 2 {
+		return GroupSpec{}, inField("members", errors.New("relay group requires at least 2 hops"))
+	}
+	return GroupSpec{Raw: raw, Name: name, Type: "relay", Members: hops}, nil
+}
+
+func buildGroupOptions(o groupObject) (model.GroupOptions, error) {
+	var opts model.GroupOptions
+	flags := []struct {
+		field, value string
+		set          func(bool)
+	}{
+		{"hidden", o.Hidden, func(v bool) { opts.Hidden = v }},
+		{"lazy", o.Lazy, func(v bool) { opts.Lazy, opts.HasLazy = v, true }},
+		{"disable-udp", o.DisableUDP, func(v bool) { opts.DisableUDP = v }},
+		{"include-all-providers", o.IncludeAllProviders, func(v bool) { opts.IncludeAllProviders = v }},
+		{"dialer-proxy", o.DialerProxy, func(v bool) { opts.DialerProxy = v }},
+	}
+	for _, f := range flags {
+		if f.value == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(strings.TrimSpace(f.value))
+		if err != nil {
+			return opts, inField(f.field, &directiveError{Code: "GROUP_PARSE_ERROR", Message: fmt.Sprintf("策略组属性 %s 必须为 true/false", f.field), Cause: err})
+		}
+		f.set(b)
+	}
+	if icon := strings.TrimSpace(o.Icon); icon != "" {
+		if err := validateHTTPURL(icon); err != nil || strings.ContainsAny(icon, ", \t\"") {
+			return opts, inField("icon", &directiveError{
+				Code:    "GROUP_PARSE_ERROR",
+				Message: fmt.Sprintf("策略组 icon 必须是不含逗号/空白/引号的 http/https URL：%s", icon),
+				Cause:   err,
+			})
+		}
+		opts.Icon = icon
+	}
+	if o.Timeout != "" {
+		ms, err := strconv.Atoi(strings.TrimSpace(o.Timeout))
+		if err != nil || ms <= 0 {
+			return opts, inField("timeout", &directiveError{Code: "GROUP_PARSE_ERROR", Message: "策略组 timeout 必须为正整数（毫秒）", Cause: err})
+		}
+		opts.TimeoutMS = ms
+	}
+	return opts, nil
+}
+
+// splitRulesetDirective splits "ACTION,[inline:]URL[,key=value]..." without
+// validating the values. Options are peeled off from the right and only
+// behavior/format/interval are recognized, so a URL whose query contains
+// commas stays intact.
+func splitRulesetDirective(raw string) (rulesetObject, error) {
+	a, rest, ok := strings.Cut(raw, ",")
+	if !ok {
+		return rulesetObject{}, errors.New("expected: ACTION,[inline:]URL[,behavior=...][,format=...][,interval=...]")
+	}
+	o := rulesetObject{Action: strings.TrimSpace(a)}
+
+	fields := strings.Split(rest, ",")
+	seen := make(map[string]struct{}, 3)
+	for len(fields) > 1 {
+		f := strings.TrimSpace(fields[len(fields)-1])
+		k, v, ok := strings.Cut(f, "=")
+		k = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		if k != "behavior" && k != "format" && k != "interval" {
+			break
+		}
+		if !ok || v == "" {
+			return rulesetObject{}, fmt.Errorf("invalid option %q (expected: key=value)", f)
+		}
+		if _, dup := seen[k]; dup {
+			return rulesetObject{}, fmt.Errorf("duplicate option: %s", k)
+		}
+		seen[k] = struct{}{}
+		switch k {
+		case "behavior":
+			o.Behavior = v
+		case "format":
+			o.Format = v
+		case "interval":
+			o.Interval = v
+		}
+		fields = fields[:len(fields)-1]
+	}
+
+	o.URL = strings.TrimSpace(strings.Join(fields, ","))
+	if u, ok := strings.CutPrefix(o.URL, rulesetInlinePrefix); ok {
+		o.URL, o.Inline = strings.TrimSpace(u), "true"
+	}
+	return o, nil
+}
+
+// buildRuleset validates a structured ruleset. raw is kept as RulesetSpec.Raw.
+func buildRuleset(raw string, o rulesetObject) (RulesetSpec, error) {
+	action := strings.TrimSpace(o.Action)
+	urlStr := strings.TrimSpace(o.URL)
+	if action == "" || urlStr == "" {
+		return RulesetSpec{}, errors.New("ACTION/URL must not be empty")
+	}
+	if err := validateHTTPURL(urlStr); err != nil {
+		return RulesetSpec{}, inField("url", err)
+	}
+	rs := RulesetSpec{Raw: raw, Action: action, URL: urlStr}
+	if o.Inline != "" {
+		inline, err := strconv.ParseBool(strings.TrimSpace(o.Inline))
+		if err != nil {
+			return RulesetSpec{}, inField("inline", errors.New("inline must be true/false"))
+		}
+		rs.Inline = inline
+	}
+	if v := strings.ToLower(strings.TrimSpace(o.Behavior)); v != "" {
+		if v != rules.BehaviorClassical && v != rules.BehaviorDomain && v != rules.BehaviorIPCIDR {
+			return RulesetSpec{}, inField("behavior", fmt.Errorf("unsupported behavior: %s (expected: classical|domain|ipcidr)", v))
+		}
+		rs.Behavior = v
+	}
+	if v := strings.ToLower(strings.TrimSpace(o.Format)); v != "" {
+		if v != rules.FormatText && v != rules.FormatYAML && v != rules.FormatMRS {
+			return RulesetSpec{}, inField("format", fmt.Errorf("unsupported format: %s (expected: text|yaml|mrs)", v))
+		}
+		rs.Format = v
+	}
+	if v := strings.TrimSpace(o.Interval); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return RulesetSpec{}, inField("interval", fmt.Errorf("interval must be a positive integer (seconds): %s", v))
+		}
+		rs.IntervalSec = n
+	}
+
+	// mrs is a binary domain/ipcidr set: it has no classical encoding and cannot be expanded.
+	if rs.Format == rules.FormatMRS {
+		if rs.Behavior == "" || rs.Behavior == rules.BehaviorClassical {
+			return RulesetSpec{}, inField("format", errors.New("format=mrs requires behavior=domain|ipcidr"))
+		}
+		if rs.Inline {
+			return RulesetSpec{}, inField("inline", errors.New("format=mrs cannot be used with inline:"))
+		}
+	}
+	return rs, nil
+}
+
+// ruleLine returns the Clash classical form of a structured rule; it is
+// validated by rules.ParseInlineRule like any version 1 rule.
+func (o ruleObject) ruleLine() string {
+	return rules.FormatRule(o.rule())
+}
+
+func (o ruleObject) rule() model.Rule {
+	r := model.Rule{
+		Type:      strings.ToUpper(strings.TrimSpace(o.Type)),
+		Value:     strings.TrimSpace(o.Value),
+		Action:    strings.TrimSpace(o.Action),
+		NoResolve: o.NoResolve,
+	}
+	for _, sub := range o.Rules {
+		r.Sub = append(r.Sub, sub.rule())
+	}
+	return r
+}
+
+// ruleToObject is the inverse of ruleObject.rule for parsed rules.
+func ruleToObject(r model.Rule) ruleObject {
+	o := ruleObject{Type: r.Type, Value: r.Value, Action: r.Action, NoResolve: r.NoResolve}
+	for _, sub := range r.Sub {
+		o.Rules = append(o.Rules, ruleToObject(sub))
+	}
+	return o
+}
+
+// groupDirective formats a structured group as the equivalent version 1
+// directive, used as GroupSpec.Raw (error snippets, explain output).
+func groupDirective(o groupObject) string {
+	parts := []string{o.Name, o.Type}
+	switch {
+	case o.Members != nil:
+		parts = append(parts, "[]"+strings.Join(o.Members, "[]"))
+	case o.Regex != "":
+		parts = append(parts, o.Regex)
+	}
+	if isHealthCheckGroupType(o.Type) {
+		parts = append(parts, o.URL, o.Interval)
+		if o.Tolerance != "" {
+			parts = append(parts, o.Tolerance)
+		}
+		if o.Strategy != "" {
+			parts = append(parts, o.Strategy)
+		}
+	}
+	for _, opt := range []struct{ key, value string }{
+		{"lazy", o.Lazy},
+		{"timeout", o.Timeout},
+		{"disable-udp", o.DisableUDP},
+		{"include-all-providers", o.IncludeAllProviders},
+		{"dialer-proxy", o.DialerProxy},
+		{"hidden", o.Hidden},
+		{"icon", o.Icon},
+	} {
+		if opt.value != "" {
+			parts = append(parts, opt.key+"="+opt.value)
+		}
+	}
+	return strings.Join(parts, "`")
+}
+
+// rulesetDirective formats a structured ruleset as the equivalent version 1 directive.
+func rulesetDirective(o rulesetObject) string {
+	u := o.URL
+	if b, _ := strconv.ParseBool(strings.TrimSpace(o.Inline)); b {
+		u = rulesetInlinePrefix + u
+	}
+	parts := []string{o.Action, u}
+	for _, opt := range []struct{ key, value string }{
+		{"behavior", o.Behavior},
+		{"format", o.Format},
+		{"interval", o.Interval},
+	} {
+		if opt.value != "" {
+			parts = append(parts, opt.key+"="+opt.value)
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -4,12 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/url"
 	"regexp"
+	"slices"
 	"sort"
-	"strconv"
 	"strings"
 
+	"github.com/John-Robertt/subconverter-go/internal/fetch"
 	"github.com/John-Robertt/subconverter-go/internal/model"
 	"github.com/John-Robertt/subconverter-go/internal/rules"
 	"gopkg.in/yaml.v3"
@@ -20,47 +22,132 @@ type Spec struct {
 
 	Template      map[string]string
 	PublicBaseURL string
+	// Vars are the `vars:` defaults with the request overrides applied; the
+	// template directives (#@VAR@#, #@IF@#) read them.
+	Vars map[string]string
 
 	CustomProxies []model.Proxy
 	Groups        []GroupSpec
 	ProxyChains   []ChainSpec
 	Ruleset       []RulesetSpec
 	Rules         []model.Rule // inline rules
+
+	// CustomProxyTargets / RuleTargets are aligned with CustomProxies / Rules
+	// (nil when no item is restricted with `targets:`). See ForTarget.
+	CustomProxyTargets []Targets
+	RuleTargets        []Targets
+
+	// CustomProxyPos / RulePos are aligned with CustomProxies / Rules (nil
+	// for a Spec that was not parsed from YAML).
+	CustomProxyPos []Position
+	RulePos        []Position
+
+	// UnsupportedRules is the render policy for rules a target cannot express: "fail" | "drop".
+	UnsupportedRules string
+
+	// Hosts (sorted by domain), MITMHostnames and ProxyProviders only feed
+	// the optional template anchors; they do not affect compilation.
+	Hosts          []model.Host
+	MITMHostnames  []string
+	ProxyProviders []model.ProxyProvider
+
+	// ManagedConfig is the `managed_config:` block (zero value when absent).
+	ManagedConfig ManagedConfigSpec
+}
+
+// Position is where a profile item is declared: the URL of the document (the
+// profile or an included fragment) and the 1-based line / column of its list
+// entry. The zero value means unknown.
+type Position struct {
+	URL    string
+	Line   int
+	Column int
+}
+
+// Locate sets the URL / line / column of e to p (URL only when p has one).
+func (p Position) Locate(e *model.AppError) {
+	if p.URL != "" {
+		e.URL = p.URL
+	}
+	e.Line, e.Column = p.Line, p.Column
 }
 
 type GroupSpec struct {
 	Raw  string
 	Name string
-	Type string // "select" | "url-test"
+	Type string // "select" | "url-test" | "fallback" | "load-balance" | "relay"
 
-	// select
+	// select members, or relay hops in order (entry first, exit last)
 	Members []string
+	// MemberRegex is aligned with Members: the compiled filter of an
+	// "@regex:" / "@all-except:" select member, nil for every other member.
+	MemberRegex []*regexp.Regexp
 
-	// select-regex or url-test
+	// select-regex or url-test / fallback / load-balance
 	RegexRaw    string
 	Regex       *regexp.Regexp
 	TestURL     string
 	IntervalSec int
 
+	// url-test only
 	ToleranceMS  int
 	HasTolerance bool
+
+	// load-balance only (defaults to consistent-hashing)
+	Strategy string
+
+	// Trailing `key=value` / bare-flag attributes, e.g. `icon=https://...`hidden
+	Options model.GroupOptions
+
+	// Targets restricts the group to some targets (empty = all).
+	Targets Targets
+
+	Pos Position
 }
 
 type ChainSpec struct {
 	Raw     string
 	Proxy   string
-	Type    string // all | regex | group
+	Type    string // all | regex | group | dialer | custom
 	Pattern string
-	Group   string
+	Group   string // type=group: nodes to expand; type=dialer: the group used as the hop
+	Via     string // type=custom: the custom_proxy whose derived nodes are the hops
 	Regex   *regexp.Regexp
+	Targets Targets
+
+	Pos Position
 }
 
 type RulesetSpec struct {
 	Raw    string
 	Action string
 	URL    string
+
+	// Inline asks the server to fetch the ruleset and expand its rules into #@RULES@#
+	// instead of emitting a remote RULE-SET reference ("ACTION,inline:URL").
+	Inline bool
+
+	// Optional "key=value" fields after the URL. Empty/zero means the default
+	// (classical / text / renderer default interval).
+	Behavior    string // classical | domain | ipcidr
+	Format      string // text | yaml | mrs
+	IntervalSec int
+
+	Targets Targets
+
+	Pos Position
 }
 
+// rulesetInlinePrefix marks a ruleset URL for server-side expansion.
+const rulesetInlinePrefix = "inline:"
+
+// Select member tokens that expand to proxies at compile time.
+const (
+	SelectMemberAll             = "@all"
+	SelectMemberRegexPrefix     = "@regex:"
+	SelectMemberAllExceptPrefix = "@all-except:"
+)
+
 type ParseError struct {
 	AppError model.AppError
 	Cause    error
@@ -98,14 +185,17 @@ func (e *directiveError) Error() string {
 func (e *directiveError) Unwrap() error { return e.Cause }
 
 type rawProfile struct {
-	Version          int               `yaml:"version"`
-	Template         map[string]string `yaml:"template"`
-	PublicBaseURL    string            `yaml:"public_base_url"`
-	CustomProxy      []rawCustomProxy  `yaml:"custom_proxy"`
-	CustomProxyGroup []string          `yaml:"custom_proxy_group"`
-	ProxyChain       []rawChainSpec    `yaml:"proxy_chain"`
-	Ruleset          []string          `yaml:"ruleset"`
-	Rule             []string          `yaml:"rule"`
+	Version          int                `yaml:"version"`
+	Template         map[string]string  `yaml:"template"`
+	PublicBaseURL    string             `yaml:"public_base_url"`
+	Include          []string           `yaml:"include"`
+	Vars             map[string]string  `yaml:"vars"`
+	UnsupportedRules string             `yaml:"unsupported_rules"`
+	Hosts            map[string]string  `yaml:"hosts"`
+	MITMHostnames    []string           `yaml:"mitm_hostnames"`
+	ProxyProviders   []rawProxyProvider `yaml:"proxy_providers"`
+	ManagedConfig    *rawManagedConfig  `yaml:"managed_config"`
+	rawItems         `yaml:",inline"`
 }
 
 type rawCustomProxy struct {
@@ -118,20 +208,58 @@ type rawCustomProxy struct {
 	Cipher     string            `yaml:"cipher"`
 	Plugin     string            `yaml:"plugin"`
 	PluginOpts map[string]string `yaml:"plugin_opts"`
+	Targets    []string          `yaml:"targets"`
+
+	origin       string // URL of the document declaring the item (see include)
+	line, column int    // set by rawItems.setPositions
+}
+
+func (p rawCustomProxy) pos() Position {
+	return Position{URL: p.origin, Line: p.line, Column: p.column}
 }
 
 type rawChainSpec struct {
-	Proxy   string `yaml:"proxy"`
-	Type    string `yaml:"type"`
-	Pattern string `yaml:"pattern"`
-	Group   string `yaml:"group"`
+	Proxy   string   `yaml:"proxy"`
+	Type    string   `yaml:"type"`
+	Pattern string   `yaml:"pattern"`
+	Group   string   `yaml:"group"`
+	Via     string   `yaml:"via"`
+	Targets []string `yaml:"targets"`
+
+	origin       string
+	line, column int
+}
+
+func (c rawChainSpec) pos() Position { return Position{URL: c.origin, Line: c.line, Column: c.column} }
+
+// ParseOptions configures ParseProfileYAMLWithOptions.
+type ParseOptions struct {
+	// LoadInclude fetches the partial profiles listed under `include:`.
+	// Nil rejects profiles that use include.
+	LoadInclude fetch.Loader
+
+	// Vars overrides the defaults declared under `vars:` (request-time
+	// parameters). Overriding an undeclared variable is an error.
+	Vars map[string]string
 }
 
 // ParseProfileYAML parses and validates a profile YAML document.
 //
-// requiredTarget is optional. If non-empty, template must contain that key.
+// requiredTarget is optional. If non-empty, items are validated for that
+// target; a missing template key falls back to the built-in default.
 // stage is always "parse_profile" to match docs/spec/SPEC_HTTP_API.md.
 func ParseProfileYAML(sourceURL string, content string, requiredTarget string) (*Spec, error) {
+	return ParseProfileYAMLWithOptions(sourceURL, content, requiredTarget, ParseOptions{})
+}
+
+// ParseProfileYAMLWithOptions is ParseProfileYAML with include support.
+// Included items are merged before the profile's own items (see
+// includeResolver); item errors carry the URL of the document declaring them.
+func ParseProfileYAMLWithOptions(sourceURL string, content string, requiredTarget string, opt ParseOptions) (*Spec, error) {
+	return parseProfile(sourceURL, content, requiredTarget, opt, &errorSink{})
+}
+
+func parseProfile(sourceURL string, content string, requiredTarget string, opt ParseOptions, sink *errorSink) (*Spec, error) {
 	var rp rawProfile
 	if err := yamlDecodeStrict(content, &rp); err != nil {
 		return nil, &ParseError{
@@ -140,74 +268,42 @@ func ParseProfileYAML(sourceURL string, content string, requiredTarget string) (
 				Message: "profile YAML 解析失败",
 				Stage:   "parse_profile",
 				URL:     sourceURL,
+				Line:    yamlErrorLine(err),
 				Snippet: truncateSnippet(content, 200),
 			},
 			Cause: err,
 		}
 	}
+	rp.setPositions(content)
 
-	if rp.Version != 1 {
+	if rp.Version != 1 && rp.Version != 2 {
 		return nil, &ParseError{AppError: model.AppError{
 			Code:    "PROFILE_VALIDATE_ERROR",
-			Message: "profile version 必须为 1",
+			Message: "profile version 必须为 1 或 2",
 			Stage:   "parse_profile",
 			URL:     sourceURL,
 		}}
 	}
 
-	if len(rp.Template) == 0 {
+	vars, err := resolveVars(rp.Vars, opt.Vars)
+	if err != nil {
 		return nil, &ParseError{AppError: model.AppError{
 			Code:    "PROFILE_VALIDATE_ERROR",
-			Message: "template 不能为空",
+			Message: err.Error(),
 			Stage:   "parse_profile",
 			URL:     sourceURL,
-			Hint:    "expected: template: {clash: ..., shadowrocket: ..., surge: ...}",
+			Hint:    "declare it under vars: {NAME: default}",
 		}}
 	}
 
-	allowedTargets := map[string]struct{}{
-		"clash":        {},
-		"shadowrocket": {},
-		"surge":        {},
-		"quanx":        {},
-	}
-	for k, v := range rp.Template {
-		if _, ok := allowedTargets[k]; !ok {
-			return nil, &ParseError{AppError: model.AppError{
-				Code:    "PROFILE_VALIDATE_ERROR",
-				Message: fmt.Sprintf("template key 不支持：%s", k),
-				Stage:   "parse_profile",
-				URL:     sourceURL,
-			}}
-		}
-		if err := validateHTTPURL(v); err != nil {
-			return nil, &ParseError{
-				AppError: model.AppError{
-					Code:    "PROFILE_VALIDATE_ERROR",
-					Message: fmt.Sprintf("template.%s URL 不合法", k),
-					Stage:   "parse_profile",
-					URL:     sourceURL,
-					Snippet: v,
-				},
-				Cause: err,
-			}
-		}
-	}
-	if requiredTarget != "" {
-		if _, ok := rp.Template[requiredTarget]; !ok {
-			return nil, &ParseError{AppError: model.AppError{
-				Code:    "PROFILE_VALIDATE_ERROR",
-				Message: fmt.Sprintf("template 缺少 target=%s", requiredTarget),
-				Stage:   "parse_profile",
-				URL:     sourceURL,
-			}}
-		}
+	if err := parseTemplates(&rp, sourceURL, vars, sink); err != nil {
+		return nil, err
 	}
 
 	publicBaseURL := strings.TrimSpace(rp.PublicBaseURL)
 	if publicBaseURL != "" {
 		if err := validatePublicBaseURL(publicBaseURL); err != nil {
-			return nil, &ParseError{
+			if err := sink.add(&ParseError{
 				AppError: model.AppError{
 					Code:    "PROFILE_VALIDATE_ERROR",
 					Message: "public_base_url 不合法",
@@ -216,302 +312,535 @@ func ParseProfileYAML(sourceURL string, content string, requiredTarget string) (
 					Snippet: publicBaseURL,
 				},
 				Cause: err,
+			}); err != nil {
+				return nil, err
 			}
 		}
 	}
 
+	resolver := &includeResolver{load: opt.LoadInclude, merged: make(map[string]struct{})}
+	items, err := resolver.resolve(rp.rawItems, sourceURL, rp.Include, []string{sourceURL})
+	if err != nil {
+		return nil, err
+	}
+	rp.rawItems = items
+
 	customProxies := make([]model.Proxy, 0, len(rp.CustomProxy))
-	customProxyNames := make(map[string]struct{}, len(rp.CustomProxy))
+	customProxyPos := make([]Position, 0, len(rp.CustomProxy))
+	var customProxyTargets []Targets
 	for _, raw := range rp.CustomProxy {
-		p, err := parseCustomProxy(raw, requiredTarget)
+		p, targets, err := parseCustomProxyItem(raw, requiredTarget)
 		if err != nil {
-			var de *directiveError
-			if errors.As(err, &de) {
-				return nil, &ParseError{
-					AppError: model.AppError{
-						Code:    de.Code,
-						Message: de.Message,
-						Stage:   "parse_profile",
-						URL:     sourceURL,
-						Snippet: customProxySnippet(raw),
-						Hint:    de.Hint,
-					},
-					Cause: de.Cause,
-				}
+			sink.broken(raw.Name)
+			if err := sink.add(err); err != nil {
+				return nil, err
 			}
-			return nil, &ParseError{
-				AppError: model.AppError{
-					Code:    "CUSTOM_PROXY_VALIDATE_ERROR",
-					Message: "custom_proxy 解析失败",
-					Stage:   "parse_profile",
-					URL:     sourceURL,
-					Snippet: customProxySnippet(raw),
-				},
-				Cause: err,
-			}
-		}
-		if _, ok := customProxyNames[p.Name]; ok {
-			return nil, &ParseError{AppError: model.AppError{
-				Code:    "CUSTOM_PROXY_VALIDATE_ERROR",
-				Message: fmt.Sprintf("重复的 custom_proxy.name：%s", p.Name),
-				Stage:   "parse_profile",
-				URL:     sourceURL,
-				Snippet: customProxySnippet(raw),
-			}}
+			continue
 		}
-		customProxyNames[p.Name] = struct{}{}
+		customProxyTargets = appendTargets(customProxyTargets, len(customProxies), targets)
 		customProxies = append(customProxies, p)
+		customProxyPos = append(customProxyPos, raw.pos())
 	}
 
 	groups := make([]GroupSpec, 0, len(rp.CustomProxyGroup))
-	for _, raw := range rp.CustomProxyGroup {
-		raw = strings.TrimSpace(raw)
-		if raw == "" {
+	for _, item := range rp.CustomProxyGroup {
+		g, ok, err := parseGroupItem(item, rp.Version, vars)
+		if err != nil {
+			sink.broken(item.groupName())
+			if err := sink.add(err); err != nil {
+				return nil, err
+			}
 			continue
 		}
-		g, err := parseGroupDirective(raw)
+		if ok {
+			groups = append(groups, g)
+		}
+	}
+
+	proxyChains := make([]ChainSpec, 0, len(rp.ProxyChain))
+	for _, raw := range rp.ProxyChain {
+		cs, err := parseChainItem(raw)
 		if err != nil {
-			var de *directiveError
-			if errors.As(err, &de) {
-				return nil, &ParseError{
-					AppError: model.AppError{
-						Code:    de.Code,
-						Message: de.Message,
-						Stage:   "parse_profile",
-						URL:     sourceURL,
-						Snippet: raw,
-						Hint:    de.Hint,
-					},
-					Cause: de.Cause,
-				}
-			}
-			return nil, &ParseError{
-				AppError: model.AppError{
-					Code:    "GROUP_PARSE_ERROR",
-					Message: "custom_proxy_group 解析失败",
-					Stage:   "parse_profile",
-					URL:     sourceURL,
-					Snippet: raw,
-				},
-				Cause: err,
+			if err := sink.add(err); err != nil {
+				return nil, err
 			}
+			continue
 		}
-		groups = append(groups, g)
+		proxyChains = append(proxyChains, cs)
 	}
 
-	groupNames := make(map[string]struct{}, len(groups))
-	for _, g := range groups {
-		if g.Name == "" {
-			return nil, &ParseError{AppError: model.AppError{
-				Code:    "GROUP_PARSE_ERROR",
-				Message: "策略组名不能为空",
-				Stage:   "parse_profile",
-				URL:     sourceURL,
-				Snippet: g.Raw,
-			}}
+	rulesets := make([]RulesetSpec, 0, len(rp.Ruleset))
+	for _, item := range rp.Ruleset {
+		rs, ok, err := parseRulesetItem(item, rp.Version, vars)
+		if err != nil {
+			if err := sink.add(err); err != nil {
+				return nil, err
+			}
+			continue
 		}
-		if g.Name == "DIRECT" || g.Name == "REJECT" {
-			return nil, &ParseError{AppError: model.AppError{
-				Code:    "PROFILE_VALIDATE_ERROR",
-				Message: "策略组名不能使用保留名 DIRECT/REJECT",
-				Stage:   "parse_profile",
-				URL:     sourceURL,
-				Snippet: g.Raw,
-			}}
+		if ok {
+			rulesets = append(rulesets, rs)
 		}
-		if strings.HasPrefix(g.Name, "CHAIN-") {
-			return nil, &ParseError{AppError: model.AppError{
-				Code:    "PROFILE_VALIDATE_ERROR",
-				Message: "策略组名不能使用保留前缀 CHAIN-",
-				Stage:   "parse_profile",
-				URL:     sourceURL,
-				Snippet: g.Raw,
-			}}
+	}
+
+	inlineRules := make([]model.Rule, 0, len(rp.Rule))
+	rulePos := make([]Position, 0, len(rp.Rule))
+	var ruleTargets []Targets
+	for _, item := range rp.Rule {
+		r, targets, ok, err := parseRuleItem(item, rp.Version)
+		if err != nil {
+			if err := sink.add(err); err != nil {
+				return nil, err
+			}
+			// A broken fallback rule must not also be reported as missing.
+			if r.Type == "MATCH" {
+				sink.brokenMatch = true
+			}
+			continue
 		}
-		if _, ok := groupNames[g.Name]; ok {
-			return nil, &ParseError{AppError: model.AppError{
-				Code:    "PROFILE_VALIDATE_ERROR",
-				Message: fmt.Sprintf("重复的策略组名：%s", g.Name),
-				Stage:   "parse_profile",
-				URL:     sourceURL,
-				Snippet: g.Raw,
-			}}
+		if ok {
+			ruleTargets = appendTargets(ruleTargets, len(inlineRules), targets)
+			inlineRules = append(inlineRules, r)
+			rulePos = append(rulePos, item.pos())
 		}
-		if _, ok := customProxyNames[g.Name]; ok {
-			return nil, &ParseError{AppError: model.AppError{
-				Code:    "CUSTOM_PROXY_VALIDATE_ERROR",
-				Message: fmt.Sprintf("custom_proxy.name 与策略组名冲突：%s", g.Name),
-				Stage:   "parse_profile",
-				URL:     sourceURL,
-				Snippet: g.Raw,
-			}}
+	}
+
+	unsupportedRules := strings.ToLower(strings.TrimSpace(rp.UnsupportedRules))
+	switch unsupportedRules {
+	case "":
+		unsupportedRules = "fail"
+	case "fail", "drop":
+	default:
+		if err := sink.add(&ParseError{AppError: model.AppError{
+			Code:    "PROFILE_VALIDATE_ERROR",
+			Message: "unsupported_rules 取值不合法",
+			Stage:   "parse_profile",
+			URL:     sourceURL,
+			Snippet: rp.UnsupportedRules,
+			Hint:    "expected: fail | drop",
+		}}); err != nil {
+			return nil, err
 		}
-		groupNames[g.Name] = struct{}{}
 	}
 
-	for _, g := range groups {
-		if g.Type != "select" {
-			continue
+	hosts, err := parseHosts(sourceURL, rp.Hosts, sink)
+	if err != nil {
+		return nil, err
+	}
+	mitmHostnames, err := parseMITMHostnames(sourceURL, rp.MITMHostnames, sink)
+	if err != nil {
+		return nil, err
+	}
+	proxyProviders, err := parseProxyProviders(sourceURL, rp.ProxyProviders, sink)
+	if err != nil {
+		return nil, err
+	}
+	managedConfig, err := parseManagedConfig(sourceURL, rp.ManagedConfig, sink)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &Spec{
+		Version:            rp.Version,
+		Template:           rp.Template,
+		Vars:               vars,
+		PublicBaseURL:      publicBaseURL,
+		CustomProxies:      customProxies,
+		CustomProxyTargets: customProxyTargets,
+		CustomProxyPos:     customProxyPos,
+		Groups:             groups,
+		ProxyChains:        proxyChains,
+		Ruleset:            rulesets,
+		Rules:              inlineRules,
+		RuleTargets:        ruleTargets,
+		RulePos:            rulePos,
+		UnsupportedRules:   unsupportedRules,
+		Hosts:              hosts,
+		MITMHostnames:      mitmHostnames,
+		ProxyProviders:     proxyProviders,
+		ManagedConfig:      managedConfig,
+	}
+
+	// Names and references are checked per target: items restricted with
+	// `targets:` may reuse a name or reference something that only exists
+	// for the same targets.
+	views := []string{requiredTarget}
+	if requiredTarget == "" {
+		views = slices.Sorted(maps.Keys(rp.Template))
+	}
+	if len(views) == 0 {
+		// Inline template without a target: check the unfiltered profile.
+		views = []string{""}
+	}
+	for _, target := range views {
+		view, err := spec.ForTarget(target)
+		if err == nil {
+			err = validateTargetView(view, sourceURL, requiredTarget, sink)
 		}
-		for _, m := range g.Members {
-			if m == "@all" || m == "DIRECT" || m == "REJECT" {
-				continue
-			}
-			if _, ok := groupNames[m]; !ok {
-				return nil, &ParseError{AppError: model.AppError{
-					Code:    "GROUP_PARSE_ERROR",
-					Message: fmt.Sprintf("策略组引用不存在：%s", m),
-					Stage:   "parse_profile",
-					URL:     sourceURL,
-					Snippet: g.Raw,
-				}}
-			}
+		if err := sink.add(err); err != nil {
+			return nil, err
 		}
 	}
+	return spec, nil
+}
 
-	proxyChains := make([]ChainSpec, 0, len(rp.ProxyChain))
-	for _, raw := range rp.ProxyChain {
-		cs, err := parseChainSpec(raw)
-		if err != nil {
-			var de *directiveError
-			if errors.As(err, &de) {
-				return nil, &ParseError{
-					AppError: model.AppError{
-						Code:    de.Code,
-						Message: de.Message,
-						Stage:   "parse_profile",
-						URL:     sourceURL,
-						Snippet: chainSnippet(raw),
-						Hint:    de.Hint,
-					},
-					Cause: de.Cause,
-				}
+// parseTemplates validates `template:` in place, expanding variables. A
+// target without a reference uses the built-in default (see Spec.TemplateFor).
+func parseTemplates(rp *rawProfile, sourceURL string, vars map[string]string, sink *errorSink) error {
+	for _, k := range slices.Sorted(maps.Keys(rp.Template)) {
+		v := rp.Template[k]
+		var err error
+		if _, ok := knownTargets[k]; !ok {
+			delete(rp.Template, k)
+			err = &ParseError{AppError: model.AppError{
+				Code:    "PROFILE_VALIDATE_ERROR",
+				Message: fmt.Sprintf("template key 不支持：%s", k),
+				Stage:   "parse_profile",
+				URL:     sourceURL,
+			}}
+		} else if v, err = expandVars(v, vars); err != nil {
+			err = varsError(Position{URL: sourceURL}, rp.Template[k], err)
+		} else if strings.HasPrefix(v, BuiltinTemplatePrefix) {
+			if v != BuiltinDefaultTemplate {
+				err = &ParseError{AppError: model.AppError{
+					Code:    "PROFILE_VALIDATE_ERROR",
+					Message: fmt.Sprintf("template.%s 内置模板不存在", k),
+					Stage:   "parse_profile",
+					URL:     sourceURL,
+					Snippet: v,
+					Hint:    "expected: " + BuiltinDefaultTemplate,
+				}}
+			} else {
+				rp.Template[k] = v
 			}
-			return nil, &ParseError{
+		} else if err = validateHTTPURL(v); err != nil {
+			err = &ParseError{
 				AppError: model.AppError{
-					Code:    "CHAIN_PARSE_ERROR",
-					Message: "proxy_chain 解析失败",
+					Code:    "PROFILE_VALIDATE_ERROR",
+					Message: fmt.Sprintf("template.%s URL 不合法", k),
 					Stage:   "parse_profile",
 					URL:     sourceURL,
-					Snippet: chainSnippet(raw),
+					Snippet: v,
 				},
 				Cause: err,
 			}
+		} else {
+			rp.Template[k] = v
 		}
-		if _, ok := customProxyNames[cs.Proxy]; !ok {
-			return nil, &ParseError{AppError: model.AppError{
-				Code:    "CHAIN_PROXY_NOT_FOUND",
-				Message: fmt.Sprintf("proxy_chain proxy 引用不存在：%s", cs.Proxy),
-				Stage:   "parse_profile",
-				URL:     sourceURL,
-				Snippet: cs.Raw,
-			}}
-		}
-		if cs.Type == "group" {
-			if _, ok := groupNames[cs.Group]; !ok {
-				return nil, &ParseError{AppError: model.AppError{
-					Code:    "CHAIN_GROUP_NOT_FOUND",
-					Message: fmt.Sprintf("proxy_chain group 引用不存在：%s", cs.Group),
-					Stage:   "parse_profile",
-					URL:     sourceURL,
-					Snippet: cs.Raw,
-				}}
-			}
+		if err := sink.add(err); err != nil {
+			return err
 		}
-		proxyChains = append(proxyChains, cs)
 	}
+	return nil
+}
 
-	if len(proxyChains) > 0 && requiredTarget != "" && requiredTarget != "clash" && requiredTarget != "surge" {
-		return nil, &ParseError{AppError: model.AppError{
-			Code:    "UNSUPPORTED_TARGET_FEATURE",
-			Message: fmt.Sprintf("target=%s 当前不支持 proxy_chain", requiredTarget),
-			Stage:   "parse_profile",
-			URL:     sourceURL,
-			Hint:    "proxy_chain only supports clash/surge",
-		}}
+// Built-in template references. They are defined here rather than in
+// internal/template (which depends on this package) so that the parser and
+// the template loader share one definition.
+const (
+	// BuiltinTemplatePrefix marks a template reference that is served from
+	// the binary instead of being fetched.
+	BuiltinTemplatePrefix = "builtin:"
+	// BuiltinDefaultTemplate selects the template embedded in the binary; it
+	// is the only built-in.
+	BuiltinDefaultTemplate = BuiltinTemplatePrefix + "default"
+)
+
+// TemplateFor returns the template reference of target: a URL, or
+// BuiltinDefaultTemplate when the profile does not set one.
+func (s *Spec) TemplateFor(target string) string {
+	if t := s.Template[target]; t != "" {
+		return t
 	}
+	return BuiltinDefaultTemplate
+}
 
-	rulesets := make([]RulesetSpec, 0, len(rp.Ruleset))
-	for _, raw := range rp.Ruleset {
-		raw = strings.TrimSpace(raw)
-		if raw == "" {
-			continue
-		}
-		rs, err := parseRulesetDirective(raw)
-		if err != nil {
-			return nil, &ParseError{
+func parseCustomProxyItem(raw rawCustomProxy, requiredTarget string) (model.Proxy, Targets, error) {
+	p, err := parseCustomProxy(raw, requiredTarget)
+	if err != nil {
+		var de *directiveError
+		if errors.As(err, &de) {
+			return model.Proxy{}, nil, &ParseError{
 				AppError: model.AppError{
-					Code:    "RULESET_PARSE_ERROR",
-					Message: "ruleset 指令解析失败",
+					Code:    de.Code,
+					Message: de.Message,
 					Stage:   "parse_profile",
-					URL:     sourceURL,
-					Snippet: raw,
+					URL:     raw.origin,
+					Line:    raw.line,
+					Column:  raw.column,
+					Snippet: customProxySnippet(raw),
+					Hint:    de.Hint,
 				},
-				Cause: err,
+				Cause: de.Cause,
 			}
 		}
-		rulesets = append(rulesets, rs)
+		return model.Proxy{}, nil, &ParseError{
+			AppError: model.AppError{
+				Code:    "CUSTOM_PROXY_VALIDATE_ERROR",
+				Message: "custom_proxy 解析失败",
+				Stage:   "parse_profile",
+				URL:     raw.origin,
+				Line:    raw.line,
+				Column:  raw.column,
+				Snippet: customProxySnippet(raw),
+			},
+			Cause: err,
+		}
 	}
+	targets, err := parseTargets(raw.Targets)
+	if err != nil {
+		return model.Proxy{}, nil, targetsError(raw.pos(), customProxySnippet(raw), err)
+	}
+	return p, targets, nil
+}
 
-	inlineRules := make([]model.Rule, 0, len(rp.Rule))
-	hasMatch := false
-	for _, raw := range rp.Rule {
-		raw = strings.TrimSpace(raw)
+// parseGroupItem parses one custom_proxy_group entry; ok is false for an
+// empty directive (skipped).
+func parseGroupItem(item rawGroupDirective, version int, vars map[string]string) (GroupSpec, bool, error) {
+	if err := checkItemForm(version, "custom_proxy_group", "group", item.rawDirective, true); err != nil {
+		return GroupSpec{}, false, err
+	}
+	var g GroupSpec
+	var err error
+	raw := strings.TrimSpace(item.Value)
+	if item.object != nil {
+		var o groupObject
+		if err := decodeObject(item.object, &o); err != nil {
+			return GroupSpec{}, false, itemError("GROUP_PARSE_ERROR", "custom_proxy_group 解析失败", item.rawDirective, "", err)
+		}
+		if err := expandGroupObject(&o, vars); err != nil {
+			return GroupSpec{}, false, varsError(item.pos(), groupDirective(o), err)
+		}
+		item.Targets = o.Targets
+		raw = groupDirective(o)
+		g, err = buildGroup(raw, o)
+	} else {
 		if raw == "" {
-			continue
+			return GroupSpec{}, false, nil
 		}
-		r, err := rules.ParseInlineRule(raw)
-		if err != nil {
-			var re *rules.RuleError
-			if errors.As(err, &re) {
-				return nil, &ParseError{
-					AppError: model.AppError{
-						Code:    re.Code,
-						Message: re.Message,
-						Stage:   "parse_profile",
-						URL:     sourceURL,
-						Snippet: raw,
-						Hint:    re.Hint,
-					},
-					Cause: re.Cause,
-				}
+		var o groupObject
+		if o, err = splitGroupDirective(raw); err == nil {
+			if raw, err = expandGroupDirective(&o, raw, vars); err != nil {
+				return GroupSpec{}, false, varsError(item.pos(), item.Value, err)
 			}
-			return nil, &ParseError{
+			g, err = buildGroup(raw, o)
+		}
+	}
+	if err != nil {
+		return GroupSpec{}, false, itemError("GROUP_PARSE_ERROR", "custom_proxy_group 解析失败", item.rawDirective, raw, err)
+	}
+	if g.Targets, err = parseTargets(item.Targets); err != nil {
+		return GroupSpec{}, false, targetsError(item.pos(), raw, err)
+	}
+	g.Pos = item.pos()
+	return g, true, nil
+}
+
+func parseChainItem(raw rawChainSpec) (ChainSpec, error) {
+	cs, err := parseChainSpec(raw)
+	if err != nil {
+		var de *directiveError
+		if errors.As(err, &de) {
+			return ChainSpec{}, &ParseError{
 				AppError: model.AppError{
-					Code:    "RULE_PARSE_ERROR",
-					Message: "rule 指令解析失败",
+					Code:    de.Code,
+					Message: de.Message,
 					Stage:   "parse_profile",
-					URL:     sourceURL,
-					Snippet: raw,
+					URL:     raw.origin,
+					Line:    raw.line,
+					Column:  raw.column,
+					Snippet: chainSnippet(raw),
+					Hint:    de.Hint,
 				},
-				Cause: err,
+				Cause: de.Cause,
 			}
 		}
-		if r.Type == "MATCH" {
-			hasMatch = true
+		return ChainSpec{}, &ParseError{
+			AppError: model.AppError{
+				Code:    "CHAIN_PARSE_ERROR",
+				Message: "proxy_chain 解析失败",
+				Stage:   "parse_profile",
+				URL:     raw.origin,
+				Line:    raw.line,
+				Column:  raw.column,
+				Snippet: chainSnippet(raw),
+			},
+			Cause: err,
 		}
-		inlineRules = append(inlineRules, r)
 	}
-	if !hasMatch {
-		return nil, &ParseError{AppError: model.AppError{
+	if cs.Targets, err = parseTargets(raw.Targets); err != nil {
+		return ChainSpec{}, targetsError(raw.pos(), chainSnippet(raw), err)
+	}
+	cs.Pos = raw.pos()
+	return cs, nil
+}
+
+// parseRulesetItem parses one ruleset entry; ok is false for an empty
+// directive (skipped).
+func parseRulesetItem(item rawRulesetDirective, version int, vars map[string]string) (RulesetSpec, bool, error) {
+	if err := checkItemForm(version, "ruleset", "ruleset", item.rawDirective, true); err != nil {
+		return RulesetSpec{}, false, err
+	}
+	var rs RulesetSpec
+	var err error
+	raw := strings.TrimSpace(item.Value)
+	if item.object != nil {
+		var o rulesetObject
+		if err := decodeObject(item.object, &o); err != nil {
+			return RulesetSpec{}, false, itemError("RULESET_PARSE_ERROR", "ruleset 解析失败", item.rawDirective, "", err)
+		}
+		if err := expandAll(vars, &o.Action, &o.URL, &o.Inline, &o.Behavior, &o.Format, &o.Interval); err != nil {
+			return RulesetSpec{}, false, varsError(item.pos(), rulesetDirective(o), err)
+		}
+		item.Targets = o.Targets
+		raw = rulesetDirective(o)
+		rs, err = buildRuleset(raw, o)
+	} else {
+		if raw == "" {
+			return RulesetSpec{}, false, nil
+		}
+		var o rulesetObject
+		if o, err = splitRulesetDirective(raw); err == nil {
+			if raw, err = expandRulesetDirective(&o, raw, vars); err != nil {
+				return RulesetSpec{}, false, varsError(item.pos(), item.Value, err)
+			}
+			rs, err = buildRuleset(raw, o)
+		}
+	}
+	if err != nil {
+		return RulesetSpec{}, false, itemError("RULESET_PARSE_ERROR", "ruleset 指令解析失败", item.rawDirective, raw, err)
+	}
+	if rs.Targets, err = parseTargets(item.Targets); err != nil {
+		return RulesetSpec{}, false, targetsError(item.pos(), raw, err)
+	}
+	rs.Pos = item.pos()
+	return rs, true, nil
+}
+
+// parseRuleItem parses one rule entry; ok is false for an empty line
+// (skipped). On error the returned rule carries the type when it is known.
+func parseRuleItem(item rawRuleDirective, version int) (model.Rule, Targets, bool, error) {
+	if err := checkItemForm(version, "rule", "rule", item.rawDirective, false); err != nil {
+		return model.Rule{}, nil, false, err
+	}
+	raw := strings.TrimSpace(item.Value)
+	if item.object != nil {
+		var o ruleObject
+		if err := decodeObject(item.object, &o); err != nil {
+			return model.Rule{}, nil, false, itemError("RULE_PARSE_ERROR", "rule 解析失败", item.rawDirective, "", err)
+		}
+		item.Targets = o.Targets
+		raw = o.ruleLine()
+	} else if raw == "" {
+		return model.Rule{}, nil, false, nil
+	}
+	typ, _, _ := strings.Cut(raw, ",")
+	r, err := rules.ParseInlineRule(raw)
+	if err != nil {
+		return model.Rule{Type: strings.ToUpper(strings.TrimSpace(typ))}, nil, false, itemError("RULE_PARSE_ERROR", "rule 指令解析失败", item.rawDirective, raw, err)
+	}
+	targets, err := parseTargets(item.Targets)
+	if err != nil {
+		return model.Rule{}, nil, false, targetsError(item.pos(), raw, err)
+	}
+	return r, targets, true, nil
+}
+
+// appendTargets records targets for item i, allocating the aligned slice on
+// the first restricted item so unrestricted profiles keep it nil.
+func appendTargets(ts []Targets, i int, t Targets) []Targets {
+	if ts == nil && t == nil {
+		return nil
+	}
+	if ts == nil {
+		ts = make([]Targets, i, i+1)
+	}
+	return append(ts, t)
+}
+
+// checkItemForm enforces the entry form of the profile version: version 1
+// takes directive strings, version 2 takes objects (rules may stay strings).
+func checkItemForm(version int, list, key string, d rawDirective, objectOnly bool) error {
+	code, msg, hint := "", "", ""
+	switch {
+	case version == 1 && d.object != nil:
+		code = "PROFILE_PARSE_ERROR"
+		msg = fmt.Sprintf("%s 条目缺少 %s 字段（对象写法需要 version: 2）", list, key)
+		hint = "version 1 entries are strings, or {" + key + ": \"...\", targets: [...]}"
+	case version == 2 && d.object == nil && objectOnly:
+		code = "PROFILE_VALIDATE_ERROR"
+		msg = fmt.Sprintf("version 2 的 %s 必须写成对象", list)
+		hint = "convert a version 1 profile with POST /api/profile/upgrade"
+	default:
+		return nil
+	}
+	return &ParseError{AppError: model.AppError{
+		Code:    code,
+		Message: msg,
+		Stage:   "parse_profile",
+		URL:     d.origin,
+		Line:    d.line,
+		Column:  d.column,
+		Snippet: truncateSnippet(strings.TrimSpace(d.Value), 200),
+		Hint:    hint,
+	}}
+}
+
+// itemError reports a failed group / ruleset / rule entry. Directive and rule
+// errors keep their own code and message; for object entries the failing
+// field is named and the entry's line is set.
+func itemError(code, message string, d rawDirective, snippet string, err error) error {
+	app := model.AppError{
+		Code:    code,
+		Message: message,
+		Stage:   "parse_profile",
+		URL:     d.origin,
+		Line:    d.line,
+		Column:  d.column,
+		Snippet: truncateSnippet(snippet, 200),
+	}
+	cause := err
+	var de *directiveError
+	var re *rules.RuleError
+	switch {
+	case errors.As(err, &de):
+		app.Code, app.Message, app.Hint, cause = de.Code, de.Message, de.Hint, de.Cause
+	case errors.As(err, &re):
+		app.Code, app.Message, app.Hint, cause = re.Code, re.Message, re.Hint, re.Cause
+	}
+	var fe *fieldError
+	if d.object != nil && errors.As(err, &fe) {
+		app.Message = fmt.Sprintf("%s（字段 %s）", app.Message, fe.Field)
+	}
+	return &ParseError{AppError: app, Cause: cause}
+}
+
+func varsError(pos Position, snippet string, err error) error {
+	e := &ParseError{
+		AppError: model.AppError{
 			Code:    "PROFILE_VALIDATE_ERROR",
-			Message: "缺少兜底规则 MATCH,<ACTION>",
+			Message: err.Error(),
 			Stage:   "parse_profile",
-			URL:     sourceURL,
-			Hint:    "add at end of rule: MATCH,PROXY",
-		}}
+			Snippet: truncateSnippet(strings.TrimSpace(snippet), 200),
+			Hint:    "declare it under vars: {NAME: default}",
+		},
 	}
+	pos.Locate(&e.AppError)
+	return e
+}
 
-	return &Spec{
-		Version:       rp.Version,
-		Template:      rp.Template,
-		PublicBaseURL: publicBaseURL,
-		CustomProxies: customProxies,
-		Groups:        groups,
-		ProxyChains:   proxyChains,
-		Ruleset:       rulesets,
-		Rules:         inlineRules,
-	}, nil
+func targetsError(pos Position, snippet string, err error) error {
+	e := &ParseError{
+		AppError: model.AppError{
+			Code:    "PROFILE_VALIDATE_ERROR",
+			Message: err.Error(),
+			Stage:   "parse_profile",
+			Snippet: snippet,
+			Hint:    "expected: targets: [clash, surge, shadowrocket, quanx]",
+		},
+	}
+	pos.Locate(&e.AppError)
+	return e
 }
 
 func yamlDecodeStrict(content string, out any) error {
@@ -561,114 +890,6 @@ func validatePublicBaseURL(s string) error {
 	return nil
 }
 
-func parseRulesetDirective(raw string) (RulesetSpec, error) {
-	a, u, ok := strings.Cut(raw, ",")
-	if !ok {
-		return RulesetSpec{}, errors.New("expected: ACTION,URL")
-	}
-	action := strings.TrimSpace(a)
-	urlStr := strings.TrimSpace(u)
-	if action == "" || urlStr == "" {
-		return RulesetSpec{}, errors.New("ACTION/URL must not be empty")
-	}
-	if err := validateHTTPURL(urlStr); err != nil {
-		return RulesetSpec{}, err
-	}
-	return RulesetSpec{Raw: raw, Action: action, URL: urlStr}, nil
-}
-
-func parseGroupDirective(raw string) (GroupSpec, error) {
-	parts := strings.Split(raw, "`")
-	if len(parts) < 2 {
-		return GroupSpec{}, &directiveError{
-			Code:    "GROUP_PARSE_ERROR",
-			Message: "custom_proxy_group 指令格式不合法",
-			Hint:    "expected: <NAME>`select`[]... or <NAME>`url-test`<REGEX>`<URL>`<INTERVAL>[`<TOLERANCE>]",
-		}
-	}
-
-	name := strings.TrimSpace(parts[0])
-	typ := strings.TrimSpace(parts[1])
-	if strings.ContainsAny(name, "\r\n\x00") {
-		return GroupSpec{}, errors.New("group name contains control chars")
-	}
-	if name == "" || typ == "" {
-		return GroupSpec{}, errors.New("group name/type must not be empty")
-	}
-
-	switch typ {
-	case "select":
-		if len(parts) != 3 {
-			return GroupSpec{}, errors.New("select group must be: <NAME>`select`[]<MEMBER_1>[]<MEMBER_2>... or <NAME>`select`<REGEX>")
-		}
-
-		third := strings.TrimSpace(parts[2])
-		if third == "" {
-			return GroupSpec{}, errors.New("select group requires member list or regex")
-		}
-
-		if !strings.HasPrefix(third, "[]") {
-			re, err := regexp.Compile(third)
-			if err != nil {
-				return GroupSpec{}, &directiveError{
-					Code:    "GROUP_PARSE_ERROR",
-					Message: "select 正则不可编译",
-					Hint:    "expected: <NAME>`select`(REGEX) or <NAME>`select`[]MEMBER...",
-					Cause:   err,
-				}
-			}
-			return GroupSpec{Raw: raw, Name: name, Type: "select", RegexRaw: third, Regex: re}, nil
-		}
-
-		toks := strings.Split(third, "[]")
-		members := make([]string, 0, len(toks))
-		for _, tok := range toks[1:] {
-			tok = strings.TrimSpace(tok)
-			if tok == "" {
-				return GroupSpec{}, errors.New("empty member in select group")
-			}
-			members = append(members, tok)
-		}
-		if len(members) == 0 {
-			return GroupSpec{}, errors.New("select group requires at least 1 member")
-		}
-		return GroupSpec{Raw: raw, Name: name, Type: "select", Members: members}, nil
-	case "url-test":
-		if len(parts) != 5 && len(parts) != 6 {
-			return GroupSpec{}, errors.New("url-test group must be: <NAME>`url-test`<REGEX>`<URL>`<INTERVAL_SEC>[`<TOLERANCE_MS>]")
-		}
-		regexRaw := parts[2]
-		testURL := parts[3]
-		intervalRaw := parts[4]
-		if regexRaw == "" || testURL == "" || intervalRaw == "" {
-			return GroupSpec{}, errors.New("url-test regex/url/interval must not be empty")
-		}
-		re, err := regexp.Compile(regexRaw)
-		if err != nil {
-			return GroupSpec{}, &directiveError{Code: "GROUP_PARSE_ERROR", Message: "url-test 正则不可编译", Cause: err}
-		}
-		if err := validateHTTPURL(testURL); err != nil {
-			return GroupSpec{}, err
-		}
-		intervalSec, err := strconv.Atoi(strings.TrimSpace(intervalRaw))
-		if err != nil || intervalSec <= 0 {
-			return GroupSpec{}, errors.New("url-test interval must be a positive integer")
-		}
-		var tol int
-		var hasTol bool
-		if len(parts) == 6 {
-			hasTol = true
-			tol, err = strconv.Atoi(strings.TrimSpace(parts[5]))
-			if err != nil || tol < 0 {
-				return GroupSpec{}, errors.New("url-test tolerance must be a non-negative integer")
-			}
-		}
-		return GroupSpec{Raw: raw, Name: name, Type: "url-test", RegexRaw: regexRaw, Regex: re, TestURL: testURL, IntervalSec: intervalSec, ToleranceMS: tol, HasTolerance: hasTol}, nil
-	default:
-		return GroupSpec{}, &directiveError{Code: "GROUP_UNSUPPORTED_TYPE", Message: fmt.Sprintf("不支持的策略组类型：%s", typ)}
-	}
-}
-
 func parseCustomProxy(raw rawCustomProxy, requiredTarget string) (model.Proxy, error) {
 	name := strings.TrimSpace(raw.Name)
 	typ := strings.ToLower(strings.TrimSpace(raw.Type))
@@ -771,6 +992,7 @@ func parseChainSpec(raw rawChainSpec) (ChainSpec, error) {
 		Type:    strings.TrimSpace(raw.Type),
 		Pattern: strings.TrimSpace(raw.Pattern),
 		Group:   strings.TrimSpace(raw.Group),
+		Via:     strings.TrimSpace(raw.Via),
 	}
 	if out.Proxy == "" || out.Type == "" {
 		return ChainSpec{}, &directiveError{Code: "CHAIN_PARSE_ERROR", Message: "proxy_chain.proxy/type 不能为空"}
@@ -779,6 +1001,9 @@ func parseChainSpec(raw rawChainSpec) (ChainSpec, error) {
 		return ChainSpec{}, &directiveError{Code: "CHAIN_PARSE_ERROR", Message: "proxy_chain.proxy 含有非法控制字符"}
 	}
 
+	if out.Via != "" && out.Type != "custom" {
+		return ChainSpec{}, &directiveError{Code: "CHAIN_PARSE_ERROR", Message: fmt.Sprintf("type=%s 的 proxy_chain 不能包含 via", out.Type)}
+	}
 	switch out.Type {
 	case "all":
 		if out.Pattern != "" || out.Group != "" {
@@ -803,6 +1028,20 @@ func parseChainSpec(raw rawChainSpec) (ChainSpec, error) {
 		if out.Pattern != "" {
 			return ChainSpec{}, &directiveError{Code: "CHAIN_PARSE_ERROR", Message: "type=group 的 proxy_chain 不能包含 pattern"}
 		}
+	case "dialer":
+		if out.Group == "" {
+			return ChainSpec{}, &directiveError{Code: "CHAIN_PARSE_ERROR", Message: "type=dialer 的 proxy_chain 缺少 group"}
+		}
+		if out.Pattern != "" {
+			return ChainSpec{}, &directiveError{Code: "CHAIN_PARSE_ERROR", Message: "type=dialer 的 proxy_chain 不能包含 pattern"}
+		}
+	case "custom":
+		if out.Via == "" {
+			return ChainSpec{}, &directiveError{Code: "CHAIN_PARSE_ERROR", Message: "type=custom 的 proxy_chain 缺少 via"}
+		}
+		if out.Pattern != "" || out.Group != "" {
+			return ChainSpec{}, &directiveError{Code: "CHAIN_PARSE_ERROR", Message: "type=custom 的 proxy_chain 不能包含 pattern/group"}
+		}
 	default:
 		return ChainSpec{}, &directiveError{Code: "CHAIN_PARSE_ERROR", Message: fmt.Sprintf("不支持的 proxy_chain.type：%s", out.Type)}
 	}
@@ -822,6 +1061,9 @@ func chainSnippet(raw rawChainSpec) string {
 	if strings.TrimSpace(raw.Group) != "" {
 		parts = append(parts, "group="+strings.TrimSpace(raw.Group))
 	}
+	if strings.TrimSpace(raw.Via) != "" {
+		parts = append(parts, "via="+strings.TrimSpace(raw.Via))
+	}
 	return truncateSnippet(strings.Join(parts, " "), 200)
 }
 
@@ -1,10 +1,34 @@
 package profile
 
 import (
+	"encoding/json"
 	"errors"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
 )
 
+// parseRulesetDirective and parseGroupDirective parse a version 1 directive
+// without vars, as parseRulesetItem / parseGroupItem do.
+func parseRulesetDirective(raw string) (RulesetSpec, error) {
+	o, err := splitRulesetDirective(raw)
+	if err != nil {
+		return RulesetSpec{}, err
+	}
+	return buildRuleset(raw, o)
+}
+
+func parseGroupDirective(raw string) (GroupSpec, error) {
+	o, err := splitGroupDirective(raw)
+	if err != nil {
+		return GroupSpec{}, err
+	}
+	return buildGroup(raw, o)
+}
+
 func TestParseProfileYAML_OK(t *testing.T) {
 	yml := `
 version: 1
@@ -89,6 +113,60 @@ rule:
 	}
 }
 
+func TestValidateProfileYAML_MultiHopProxyChain(t *testing.T) {
+	yml := `version: 1
+template:
+  clash: "https://example.com/base.yaml"
+custom_proxy:
+  - {name: A, type: http, server: a.example.com, port: 8080}
+  - {name: B, type: http, server: b.example.com, port: 8080}
+custom_proxy_group:
+  - "PROXY` + "`" + `select` + "`" + `[]@all"
+proxy_chain:
+  - proxy: A
+    type: custom
+    via: B
+  - proxy: B
+    type: dialer
+    group: PROXY
+  - proxy: B
+    type: custom
+    via: A
+  - proxy: A
+    type: dialer
+    group: NOPE
+  - proxy: A
+    type: all
+    via: B
+rule:
+  - "MATCH,PROXY"
+`
+	v := ValidateProfileYAML("https://example.com/profile.yaml", yml, "", ParseOptions{})
+	type found struct {
+		Code string
+		Line int
+	}
+	var got []found
+	for _, err := range v.Errors {
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("expected ParseError, got %T: %v", err, err)
+		}
+		got = append(got, found{pe.AppError.Code, pe.AppError.Line})
+	}
+	want := []found{
+		{"CHAIN_PARSE_ERROR", 22},
+		{"CHAIN_GROUP_NOT_FOUND", 19},
+		{"CHAIN_CYCLE", 16},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("errors=%+v, want=%+v\n%v", got, want, v.Errors)
+	}
+	if !strings.Contains(v.Errors[2].Error(), "A -> B -> A") {
+		t.Fatalf("cycle path missing: %v", v.Errors[2])
+	}
+}
+
 func TestParseProfileYAML_ReservedChainPrefixRejected(t *testing.T) {
 	yml := `
 version: 1
@@ -109,6 +187,53 @@ rule:
 	}
 }
 
+func TestValidateProfileYAML_ErrorPositions(t *testing.T) {
+	part := `proxy_chain:
+  - proxy: GONE
+    type: all
+`
+	yml := `version: 1
+template:
+  clash: "https://example.com/base.yaml"
+include: ["part.yaml"]
+custom_proxy:
+  - name: CORP
+    type: bogus
+    server: proxy.example.com
+    port: 8080
+proxy_chain:
+  - proxy: CORP
+    type: nope
+custom_proxy_group:
+  - "PROXY` + "`" + `select` + "`" + `[]MISSING[]DIRECT"
+rule:
+  - "MATCH,PROXY"
+`
+	load := func(u string) (string, error) { return part, nil }
+	v := ValidateProfileYAML("https://example.com/profile.yaml", yml, "", ParseOptions{LoadInclude: load})
+	type found struct {
+		Code, URL    string
+		Line, Column int
+	}
+	var got []found
+	for _, err := range v.Errors {
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("expected ParseError, got %T: %v", err, err)
+		}
+		got = append(got, found{pe.AppError.Code, pe.AppError.URL, pe.AppError.Line, pe.AppError.Column})
+	}
+	want := []found{
+		{"CUSTOM_PROXY_VALIDATE_ERROR", "https://example.com/profile.yaml", 6, 5},
+		{"CHAIN_PARSE_ERROR", "https://example.com/profile.yaml", 11, 5},
+		{"GROUP_PARSE_ERROR", "https://example.com/profile.yaml", 14, 5},
+		{"CHAIN_PROXY_NOT_FOUND", "https://example.com/part.yaml", 2, 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("errors=%+v, want=%+v\n%v", got, want, v.Errors)
+	}
+}
+
 func TestParseProfileYAML_ProxyChainUnsupportedTarget(t *testing.T) {
 	yml := `
 version: 1
@@ -182,15 +307,30 @@ rule:
 	}
 }
 
-func TestParseProfileYAML_TemplateMissingTarget(t *testing.T) {
+func TestParseProfileYAML_TemplateMissingTargetUsesBuiltin(t *testing.T) {
 	yml := `
 version: 1
 template:
   shadowrocket: "https://example.com/base_sr.conf"
+  surge: builtin:default
 rule:
   - "MATCH,DIRECT"
 `
-	_, err := ParseProfileYAML("https://example.com/profile.yaml", yml, "clash")
+	p, err := ParseProfileYAML("https://example.com/profile.yaml", yml, "clash")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for target, want := range map[string]string{
+		"clash":        BuiltinDefaultTemplate,
+		"surge":        BuiltinDefaultTemplate,
+		"shadowrocket": "https://example.com/base_sr.conf",
+	} {
+		if got := p.TemplateFor(target); got != want {
+			t.Fatalf("TemplateFor(%s)=%q, want=%q", target, got, want)
+		}
+	}
+
+	_, err = ParseProfileYAML("https://example.com/profile.yaml", strings.Replace(yml, "builtin:default", "builtin:fancy", 1), "clash")
 	var pe *ParseError
 	if !errors.As(err, &pe) {
 		t.Fatalf("expected *ParseError, got %T: %v", err, err)
@@ -225,7 +365,7 @@ version: 1
 template:
   clash: "https://example.com/base.yaml"
 custom_proxy_group:
-  - "A` + "`" + `fallback` + "`" + `[]@all"
+  - "A` + "`" + `random` + "`" + `[]@all"
 rule:
   - "MATCH,DIRECT"
 `
@@ -239,6 +379,208 @@ rule:
 	}
 }
 
+func TestParseGroupDirective_FallbackAndLoadBalance(t *testing.T) {
+	gs, err := parseGroupDirective("FB`fallback`(HK|SG)`https://www.gstatic.com/generate_204`300")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gs.Type != "fallback" || gs.RegexRaw != "(HK|SG)" || gs.TestURL != "https://www.gstatic.com/generate_204" || gs.IntervalSec != 300 || gs.Strategy != "" {
+		t.Fatalf("fallback=%+v", gs)
+	}
+
+	gs, err = parseGroupDirective("LB`load-balance`.*`https://www.gstatic.com/generate_204`600")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gs.Type != "load-balance" || gs.Strategy != "consistent-hashing" || gs.IntervalSec != 600 {
+		t.Fatalf("load-balance=%+v", gs)
+	}
+	gs, err = parseGroupDirective("LB`load-balance`.*`https://www.gstatic.com/generate_204`600`round-robin")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gs.Strategy != "round-robin" {
+		t.Fatalf("strategy=%q", gs.Strategy)
+	}
+
+	for _, raw := range []string{
+		"FB`fallback`.*`https://www.gstatic.com/generate_204`300`50", // no tolerance on fallback
+		"FB`fallback`.*`ftp://example.com`300",
+		"LB`load-balance`.*`https://www.gstatic.com/generate_204`0",
+		"LB`load-balance`.*`https://www.gstatic.com/generate_204`300`sticky-sessions",
+	} {
+		if _, err := parseGroupDirective(raw); err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
+	}
+}
+
+func TestParseGroupDirective_Options(t *testing.T) {
+	gs, err := parseGroupDirective("AUTO`url-test`.*`https://example.com/204?a=b`300`50`lazy=false`timeout=2500`icon=https://example.com/auto.png`hidden")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := model.GroupOptions{Hidden: true, Icon: "https://example.com/auto.png", HasLazy: true, TimeoutMS: 2500}
+	if gs.Options != want || gs.TestURL != "https://example.com/204?a=b" || !gs.HasTolerance || gs.ToleranceMS != 50 {
+		t.Fatalf("group=%+v", gs)
+	}
+
+	// A select regex containing "=" is positional, not an attribute.
+	gs, err = parseGroupDirective("EQ`select`a=b`disable-udp`include-all-providers=true")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gs.RegexRaw != "a=b" || gs.Options != (model.GroupOptions{DisableUDP: true, IncludeAllProviders: true}) {
+		t.Fatalf("group=%+v", gs)
+	}
+
+	gs, err = parseGroupDirective("CHAIN`relay`[]A[]B`dialer-proxy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gs.Options != (model.GroupOptions{DialerProxy: true}) {
+		t.Fatalf("group=%+v", gs)
+	}
+
+	for _, raw := range []string{
+		"P`select`[]DIRECT`color=red",
+		"P`select`[]DIRECT`dialer-proxy",
+		"P`select`[]DIRECT`hidden`hidden",
+		"P`select`[]DIRECT`hidden=yes",
+		"P`select`[]DIRECT`icon=ftp://example.com/a.png",
+		"P`select`[]DIRECT`icon=https://example.com/a,b.png",
+		"P`select`[]DIRECT`lazy",
+		"P`relay`[]A[]B`timeout=100",
+		"AUTO`url-test`.*`https://example.com/204`300`timeout=0",
+	} {
+		_, err := parseGroupDirective(raw)
+		var de *directiveError
+		if !errors.As(err, &de) || de.Code != "GROUP_PARSE_ERROR" {
+			t.Fatalf("%q: expected GROUP_PARSE_ERROR, got %T: %v", raw, err, err)
+		}
+	}
+}
+
+func TestParseProfileYAML_RelayGroup(t *testing.T) {
+	build := func(groups ...string) string {
+		var b strings.Builder
+		b.WriteString("version: 1\ntemplate:\n  clash: \"https://example.com/base.yaml\"\ncustom_proxy_group:\n")
+		for _, g := range groups {
+			b.WriteString("  - \"" + g + "\"\n")
+		}
+		b.WriteString("rule:\n  - \"MATCH,DIRECT\"\n")
+		return b.String()
+	}
+
+	p, err := ParseProfileYAML("https://example.com/profile.yaml", build(
+		"ENTRY`select`(HK|SG)",
+		"CHAIN`relay`[]ENTRY[]JP-Transit[]US-Exit",
+	), "clash")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g := p.Groups[1]
+	if g.Type != "relay" || !reflect.DeepEqual(g.Members, []string{"ENTRY", "JP-Transit", "US-Exit"}) || g.Regex != nil {
+		t.Fatalf("relay group=%+v", g)
+	}
+
+	for _, groups := range [][]string{
+		{"CHAIN`relay`[]CHAIN[]US"},
+		{"A`relay`[]HK[]US", "B`relay`[]A[]JP"},
+		{"CHAIN`relay`[]DIRECT[]US"},
+		{"CHAIN`relay`[]@all[]US"},
+		{"CHAIN`relay`[]HK"},
+		{"CHAIN`relay`[]HK[]HK"},
+		{"CHAIN`relay`HK"},
+	} {
+		_, err := ParseProfileYAML("https://example.com/profile.yaml", build(groups...), "clash")
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.AppError.Code != "GROUP_PARSE_ERROR" {
+			t.Fatalf("groups=%q: expected GROUP_PARSE_ERROR, got %T: %v", groups, err, err)
+		}
+	}
+}
+
+func TestParseProfileYAML_Targets(t *testing.T) {
+	yml := `
+version: 1
+template:
+  clash: "https://example.com/base.yaml"
+  surge: "https://example.com/base.conf"
+custom_proxy:
+  - name: CORP
+    type: http
+    server: proxy.example.com
+    port: 8080
+    targets: [clash]
+custom_proxy_group:
+  - "PROXY` + "`" + `select` + "`" + `[]APP[]DIRECT"
+  - group: "APP` + "`" + `select` + "`" + `[]@all"
+    targets: [clash]
+  - group: "APP` + "`" + `select` + "`" + `[]DIRECT"
+    targets: [Surge]
+ruleset:
+  - ruleset: "PROXY,https://example.com/clash.list"
+    targets: [clash]
+rule:
+  - rule: "PROCESS-NAME,curl,DIRECT"
+    targets: [clash]
+  - "MATCH,PROXY"
+`
+	p, err := ParseProfileYAML("https://example.com/profile.yaml", yml, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(p.Groups) != 3 || !reflect.DeepEqual(p.Groups[2].Targets, Targets{"surge"}) || p.Groups[0].Targets != nil {
+		t.Fatalf("groups=%+v", p.Groups)
+	}
+	if !reflect.DeepEqual(p.RuleTargets, []Targets{{"clash"}, nil}) || !reflect.DeepEqual(p.CustomProxyTargets, []Targets{{"clash"}}) {
+		t.Fatalf("rule targets=%v custom proxy targets=%v", p.RuleTargets, p.CustomProxyTargets)
+	}
+
+	surge, err := p.ForTarget("surge")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(surge.Groups) != 2 || surge.Groups[1].Members[0] != "DIRECT" || len(surge.Ruleset) != 0 || len(surge.Rules) != 1 || len(surge.CustomProxies) != 0 {
+		t.Fatalf("surge view=%+v", surge)
+	}
+	if _, err := p.ForTarget(""); err == nil {
+		t.Fatalf("expected error for same-named groups without target")
+	}
+
+	for name, bad := range map[string]string{
+		"unknown target": `
+  - group: "APP` + "`" + `select` + "`" + `[]DIRECT"
+    targets: [stash]
+`,
+		"empty targets": `
+  - group: "APP` + "`" + `select` + "`" + `[]DIRECT"
+    targets: []
+`,
+		// PROXY (all targets) references APP, which does not exist for surge.
+		"missing in one view": `
+  - group: "APP` + "`" + `select` + "`" + `[]DIRECT"
+    targets: [clash]
+`,
+	} {
+		yml := "version: 1\ntemplate:\n  clash: \"https://example.com/a.yaml\"\n  surge: \"https://example.com/a.conf\"\n" +
+			"custom_proxy_group:\n  - \"PROXY`select`[]APP\"" + bad + "rule:\n  - \"MATCH,PROXY\"\n"
+		_, err := ParseProfileYAML("https://example.com/profile.yaml", yml, "")
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("%s: expected *ParseError, got %T: %v", name, err, err)
+		}
+	}
+
+	// A mapping entry only accepts its directive key and targets.
+	_, err = ParseProfileYAML("https://example.com/profile.yaml", "version: 1\ntemplate:\n  clash: \"https://example.com/a.yaml\"\nrule:\n  - group: \"MATCH,DIRECT\"\n", "")
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.AppError.Code != "PROFILE_PARSE_ERROR" {
+		t.Fatalf("expected PROFILE_PARSE_ERROR, got %T: %v", err, err)
+	}
+}
+
 func TestParseProfileYAML_InvalidRulesetDirective(t *testing.T) {
 	yml := `
 version: 1
@@ -259,6 +601,101 @@ rule:
 	}
 }
 
+func TestParseProfileYAML_InlineRuleset_OK(t *testing.T) {
+	yml := `
+version: 1
+template:
+  clash: "https://example.com/base.yaml"
+ruleset:
+  - "DIRECT,inline:https://example.com/LAN.list"
+  - "REJECT,https://example.com/AD.list"
+rule:
+  - "MATCH,DIRECT"
+`
+	p, err := ParseProfileYAML("https://example.com/profile.yaml", yml, "clash")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(p.Ruleset) != 2 {
+		t.Fatalf("rulesets=%d, want=2", len(p.Ruleset))
+	}
+	if !p.Ruleset[0].Inline || p.Ruleset[0].URL != "https://example.com/LAN.list" {
+		t.Fatalf("ruleset0=%+v", p.Ruleset[0])
+	}
+	if p.Ruleset[1].Inline {
+		t.Fatalf("ruleset1 should not be inline: %+v", p.Ruleset[1])
+	}
+}
+
+func TestParseRulesetDirective_Options(t *testing.T) {
+	rs, err := parseRulesetDirective("PROXY,https://example.com/gfw.mrs,behavior=domain,format=mrs,interval=3600")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rs.Behavior != "domain" || rs.Format != "mrs" || rs.IntervalSec != 3600 || rs.URL != "https://example.com/gfw.mrs" {
+		t.Fatalf("ruleset=%+v", rs)
+	}
+
+	// Only trailing behavior/format/interval fields are options; commas before
+	// them belong to the URL.
+	rs, err = parseRulesetDirective("PROXY,https://e.com/a?x=1,2,behavior=domain")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rs.URL != "https://e.com/a?x=1,2" || rs.Behavior != "domain" {
+		t.Fatalf("ruleset=%+v", rs)
+	}
+	rs, err = parseRulesetDirective("PROXY,https://e.com/a?x=1,2")
+	if err != nil || rs.URL != "https://e.com/a?x=1,2" {
+		t.Fatalf("ruleset=%+v err=%v", rs, err)
+	}
+
+	for _, raw := range []string{
+		"PROXY,https://example.com/a.list,behavior=foo",
+		"PROXY,https://example.com/a.list,format=json",
+		"PROXY,https://example.com/a.list,interval=0",
+		"PROXY,https://example.com/a.list,behavior",
+		"PROXY,https://example.com/a.list,interval=1,interval=2",
+		"PROXY,https://example.com/a.mrs,format=mrs",
+		"PROXY,inline:https://example.com/a.mrs,behavior=domain,format=mrs",
+	} {
+		if _, err := parseRulesetDirective(raw); err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
+	}
+}
+
+func TestParseProfileYAML_UnsupportedRulesPolicy(t *testing.T) {
+	base := `
+version: 1
+template:
+  clash: "https://example.com/base.yaml"
+rule:
+  - "MATCH,DIRECT"
+`
+	p, err := ParseProfileYAML("https://example.com/profile.yaml", base, "clash")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.UnsupportedRules != "fail" {
+		t.Fatalf("unsupported_rules=%q, want default %q", p.UnsupportedRules, "fail")
+	}
+
+	p, err = ParseProfileYAML("https://example.com/profile.yaml", base+"unsupported_rules: drop\n", "clash")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.UnsupportedRules != "drop" {
+		t.Fatalf("unsupported_rules=%q, want=%q", p.UnsupportedRules, "drop")
+	}
+
+	_, err = ParseProfileYAML("https://example.com/profile.yaml", base+"unsupported_rules: ignore\n", "clash")
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.AppError.Code != "PROFILE_VALIDATE_ERROR" {
+		t.Fatalf("expected PROFILE_VALIDATE_ERROR, got %T: %v", err, err)
+	}
+}
+
 func TestParseProfileYAML_InlineRuleMissingAction(t *testing.T) {
 	yml := `
 version: 1
@@ -309,6 +746,33 @@ rule:
 	}
 }
 
+func TestParseGroupDirective_SelectMixedMembers(t *testing.T) {
+	gs, err := parseGroupDirective("PROXY`select`[]DIRECT[]@regex:(HK|SG)[]AUTO[]@all-except:(过期|剩余)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(gs.Members, []string{"DIRECT", "@regex:(HK|SG)", "AUTO", "@all-except:(过期|剩余)"}) {
+		t.Fatalf("members=%q", gs.Members)
+	}
+	if len(gs.MemberRegex) != 4 || gs.MemberRegex[0] != nil || gs.MemberRegex[2] != nil {
+		t.Fatalf("member regex=%v", gs.MemberRegex)
+	}
+	if !gs.MemberRegex[1].MatchString("SG-01") || !gs.MemberRegex[3].MatchString("剩余流量：10GB") {
+		t.Fatalf("member regex=%v", gs.MemberRegex)
+	}
+
+	for _, raw := range []string{
+		"PROXY`select`[]@regex:(HK",
+		"PROXY`select`[]@all-except:",
+	} {
+		_, err := parseGroupDirective(raw)
+		var de *directiveError
+		if !errors.As(err, &de) || de.Code != "GROUP_PARSE_ERROR" {
+			t.Fatalf("%q: expected GROUP_PARSE_ERROR, got %T: %v", raw, err, err)
+		}
+	}
+}
+
 func TestParseProfileYAML_MissingMatchRule(t *testing.T) {
 	yml := `
 version: 1
@@ -326,3 +790,535 @@ rule:
 		t.Fatalf("code=%q, want=%q", pe.AppError.Code, "PROFILE_VALIDATE_ERROR")
 	}
 }
+
+func TestParseProfileYAMLWithOptions_Include(t *testing.T) {
+	docs := map[string]string{
+		"https://example.com/p/groups.yaml": `
+include: ["common.yaml"]
+custom_proxy_group:
+  - "PROXY` + "`" + `select` + "`" + `[]@all[]DIRECT"
+rule:
+  - "DOMAIN,b.example,PROXY"
+`,
+		"https://example.com/p/rules.yaml": `
+include: ["https://example.com/p/common.yaml"]
+rule:
+  - "DOMAIN,c.example,DIRECT"
+`,
+		"https://example.com/p/common.yaml": `
+rule:
+  - "DOMAIN,a.example,DIRECT"
+`,
+	}
+	var loaded []string
+	load := func(u string) (string, error) {
+		loaded = append(loaded, u)
+		text, ok := docs[u]
+		if !ok {
+			return "", errors.New("not found: " + u)
+		}
+		return text, nil
+	}
+	yml := `
+version: 1
+template:
+  clash: "https://example.com/base.yaml"
+include:
+  - "p/groups.yaml"
+  - "p/rules.yaml"
+rule:
+  - "MATCH,PROXY"
+`
+	p, err := ParseProfileYAMLWithOptions("https://example.com/profile.yaml", yml, "clash", ParseOptions{LoadInclude: load})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	wantLoaded := []string{"https://example.com/p/groups.yaml", "https://example.com/p/common.yaml", "https://example.com/p/rules.yaml"}
+	if !reflect.DeepEqual(loaded, wantLoaded) {
+		t.Fatalf("loaded=%v, want=%v", loaded, wantLoaded)
+	}
+	var got []string
+	for _, r := range p.Rules {
+		got = append(got, r.Value+","+r.Action)
+	}
+	want := []string{"a.example,DIRECT", "b.example,PROXY", "c.example,DIRECT", ",PROXY"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("rules=%v, want=%v", got, want)
+	}
+	if len(p.Groups) != 1 || p.Groups[0].Name != "PROXY" {
+		t.Fatalf("groups=%+v", p.Groups)
+	}
+
+	t.Run("cycle", func(t *testing.T) {
+		docs := map[string]string{
+			"https://example.com/a.yaml": "include: [b.yaml]\n",
+			"https://example.com/b.yaml": "include: [a.yaml]\n",
+		}
+		load := func(u string) (string, error) { return docs[u], nil }
+		_, err := ParseProfileYAMLWithOptions("https://example.com/profile.yaml", "version: 1\ntemplate: {clash: \"https://example.com/base.yaml\"}\ninclude: [a.yaml]\nrule: [\"MATCH,DIRECT\"]\n", "clash", ParseOptions{LoadInclude: load})
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.AppError.Code != "PROFILE_INCLUDE_CYCLE" {
+			t.Fatalf("expected PROFILE_INCLUDE_CYCLE, got %T: %v", err, err)
+		}
+		if pe.AppError.URL != "https://example.com/b.yaml" || !strings.HasSuffix(pe.AppError.Snippet, "b.yaml -> https://example.com/a.yaml") {
+			t.Fatalf("url=%q snippet=%q", pe.AppError.URL, pe.AppError.Snippet)
+		}
+	})
+
+	t.Run("item error attributes include", func(t *testing.T) {
+		load := func(u string) (string, error) { return "rule:\n  - \"DOMAIN,a.example\"\n", nil }
+		_, err := ParseProfileYAMLWithOptions("https://example.com/profile.yaml", "version: 1\ntemplate: {clash: \"https://example.com/base.yaml\"}\ninclude: [bad.yaml]\nrule: [\"MATCH,DIRECT\"]\n", "clash", ParseOptions{LoadInclude: load})
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.AppError.URL != "https://example.com/bad.yaml" {
+			t.Fatalf("expected error attributed to include, got %T: %v", err, err)
+		}
+	})
+
+	t.Run("partial profile field", func(t *testing.T) {
+		load := func(u string) (string, error) { return "version: 1\n", nil }
+		_, err := ParseProfileYAMLWithOptions("https://example.com/profile.yaml", "version: 1\ntemplate: {clash: \"https://example.com/base.yaml\"}\ninclude: [x.yaml]\nrule: [\"MATCH,DIRECT\"]\n", "clash", ParseOptions{LoadInclude: load})
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.AppError.Code != "PROFILE_PARSE_ERROR" || pe.AppError.URL != "https://example.com/x.yaml" {
+			t.Fatalf("expected PROFILE_PARSE_ERROR for include, got %T: %v", err, err)
+		}
+	})
+
+	t.Run("no loader", func(t *testing.T) {
+		_, err := ParseProfileYAML("https://example.com/profile.yaml", "version: 1\ntemplate: {clash: \"https://example.com/base.yaml\"}\ninclude: [x.yaml]\nrule: [\"MATCH,DIRECT\"]\n", "clash")
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.AppError.Code != "PROFILE_INCLUDE_ERROR" {
+			t.Fatalf("expected PROFILE_INCLUDE_ERROR, got %T: %v", err, err)
+		}
+	})
+}
+
+func TestParseProfileYAMLWithOptions_Vars(t *testing.T) {
+	yml := `
+version: 1
+template:
+  clash: "https://${MIRROR}/base.yaml"
+vars:
+  MIRROR: "example.com"
+  REGION: "HK"
+custom_proxy_group:
+  - "AUTO` + "`" + `url-test` + "`" + `(${REGION})` + "`" + `https://${MIRROR}/generate_204` + "`" + `300"
+ruleset:
+  - "AUTO,https://${MIRROR}/rules/${REGION}.list"
+rule:
+  - "MATCH,AUTO"
+`
+	p, err := ParseProfileYAML("https://example.com/profile.yaml", yml, "clash")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if p.Template["clash"] != "https://example.com/base.yaml" || p.Groups[0].RegexRaw != "(HK)" || p.Ruleset[0].URL != "https://example.com/rules/HK.list" {
+		t.Fatalf("defaults not expanded: template=%q regex=%q ruleset=%q", p.Template["clash"], p.Groups[0].RegexRaw, p.Ruleset[0].URL)
+	}
+
+	p, err = ParseProfileYAMLWithOptions("https://example.com/profile.yaml", yml, "clash", ParseOptions{Vars: map[string]string{"REGION": "SG", "MIRROR": "mirror.example.net"}})
+	if err != nil {
+		t.Fatalf("parse with overrides: %v", err)
+	}
+	if p.Groups[0].TestURL != "https://mirror.example.net/generate_204" || p.Groups[0].RegexRaw != "(SG)" || p.Ruleset[0].URL != "https://mirror.example.net/rules/SG.list" {
+		t.Fatalf("overrides not applied: group=%+v ruleset=%q", p.Groups[0], p.Ruleset[0].URL)
+	}
+
+	for name, tc := range map[string]struct {
+		yml string
+		opt ParseOptions
+	}{
+		"undeclared override": {yml: yml, opt: ParseOptions{Vars: map[string]string{"OTHER": "x"}}},
+		"undefined reference": {yml: strings.Replace(yml, "${REGION}.list", "${COUNTRY}.list", 1)},
+	} {
+		_, err := ParseProfileYAMLWithOptions("https://example.com/profile.yaml", tc.yml, "clash", tc.opt)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.AppError.Code != "PROFILE_VALIDATE_ERROR" {
+			t.Fatalf("%s: expected PROFILE_VALIDATE_ERROR, got %T: %v", name, err, err)
+		}
+	}
+}
+
+func TestParseProfileYAMLWithOptions_VarsStayInTheirField(t *testing.T) {
+	bt := "`"
+	yml := `
+version: 1
+template:
+  clash: "https://example.com/base.yaml"
+vars:
+  EXIT: "DIRECT"
+  REGION: "HK"
+  LIST: "https://example.com/HK.list"
+custom_proxy_group:
+  - "CORP` + bt + `select` + bt + `[]DIRECT"
+  - "PROXY` + bt + `select` + bt + `[]${EXIT}[]REJECT"
+  - "AUTO` + bt + `url-test` + bt + `(${REGION})` + bt + `https://example.com/204` + bt + `300"
+ruleset:
+  - "PROXY,${LIST}"
+rule:
+  - "MATCH,PROXY"
+`
+	// An override cannot add a member: "DIRECT[]CORP" is one unknown member.
+	_, err := ParseProfileYAMLWithOptions("https://example.com/profile.yaml", yml, "clash", ParseOptions{Vars: map[string]string{"EXIT": "DIRECT[]CORP"}})
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.AppError.Code != "GROUP_PARSE_ERROR" || !strings.Contains(pe.AppError.Message, "DIRECT[]CORP") {
+		t.Fatalf("member injection: expected GROUP_PARSE_ERROR for DIRECT[]CORP, got %T: %v", err, err)
+	}
+
+	p, err := ParseProfileYAMLWithOptions("https://example.com/profile.yaml", yml, "clash", ParseOptions{Vars: map[string]string{
+		"REGION": "HK|JP" + bt + "hidden" + bt + "icon=https://example.com/x.png",
+		"LIST":   "https://example.com/HK.list,behavior=domain",
+	}})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if g := p.Groups[2]; g.Options != (model.GroupOptions{}) || !strings.Contains(g.RegexRaw, "hidden") {
+		t.Fatalf("override injected an attribute: regex=%q options=%+v", g.RegexRaw, g.Options)
+	}
+	if rs := p.Ruleset[0]; rs.Behavior != "" || rs.URL != "https://example.com/HK.list,behavior=domain" {
+		t.Fatalf("override injected a ruleset option: %+v", rs)
+	}
+
+	// The type selects the positional fields, so it cannot be a variable.
+	typed := strings.Replace(yml, "AUTO"+bt+"url-test", "AUTO"+bt+"${TYPE}", 1)
+	typed = strings.Replace(typed, "vars:\n", "vars:\n  TYPE: \"url-test\"\n", 1)
+	_, err = ParseProfileYAML("https://example.com/profile.yaml", typed, "clash")
+	if !errors.As(err, &pe) || pe.AppError.Code != "PROFILE_VALIDATE_ERROR" {
+		t.Fatalf("type variable: expected PROFILE_VALIDATE_ERROR, got %T: %v", err, err)
+	}
+}
+
+func TestParseProfileYAML_Version2(t *testing.T) {
+	yml := `
+version: 2
+template:
+  clash: "https://example.com/base.yaml"
+custom_proxy_group:
+  - name: PROXY
+    type: select
+    members: ["@all", DIRECT]
+    icon: https://example.com/proxy.png
+  - name: AUTO
+    type: url-test
+    regex: (HK|SG)
+    url: https://www.gstatic.com/generate_204
+    interval: 300
+    tolerance: 50
+    lazy: false
+    targets: [clash]
+ruleset:
+  - {action: DIRECT, url: https://example.com/LAN.list, behavior: classical, interval: 86400}
+rule:
+  - {type: DOMAIN-SUFFIX, value: example.com, action: DIRECT}
+  - {type: AND, rules: [{type: NETWORK, value: UDP}, {type: DST-PORT, value: "443"}], action: REJECT}
+  - "MATCH,PROXY"
+`
+	p, err := ParseProfileYAML("https://example.com/profile.yaml", yml, "clash")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if p.Version != 2 || len(p.Groups) != 2 || len(p.Ruleset) != 1 || len(p.Rules) != 3 {
+		t.Fatalf("unexpected spec: %+v", p)
+	}
+	auto := p.Groups[1]
+	if auto.Type != "url-test" || auto.IntervalSec != 300 || auto.ToleranceMS != 50 || !auto.Options.HasLazy || auto.Options.Lazy || !reflect.DeepEqual(auto.Targets, Targets{"clash"}) {
+		t.Fatalf("AUTO=%+v", auto)
+	}
+	if p.Groups[0].Options.Icon != "https://example.com/proxy.png" || p.Groups[0].Raw != "PROXY`select`[]@all[]DIRECT`icon=https://example.com/proxy.png" {
+		t.Fatalf("PROXY=%+v", p.Groups[0])
+	}
+	if p.Ruleset[0].IntervalSec != 86400 || p.Ruleset[0].Behavior != "classical" {
+		t.Fatalf("ruleset=%+v", p.Ruleset[0])
+	}
+	if r := p.Rules[1]; r.Type != "AND" || len(r.Sub) != 2 || r.Action != "REJECT" {
+		t.Fatalf("logical rule=%+v", r)
+	}
+
+	for name, tc := range map[string]struct {
+		yml, code, message string
+		line               int
+	}{
+		"field error": {
+			yml:     strings.Replace(yml, "interval: 300", "interval: soon", 1),
+			code:    "GROUP_PARSE_ERROR",
+			message: "（字段 interval）",
+			line:    10,
+		},
+		"unknown field": {
+			yml:  strings.Replace(yml, "tolerance: 50", "tolerence: 50", 1),
+			code: "GROUP_PARSE_ERROR",
+			line: 10,
+		},
+		"directive string": {
+			yml:  strings.Replace(yml, "  - name: PROXY", "  - \"X`select`[]DIRECT\"\n  - name: PROXY", 1),
+			code: "PROFILE_VALIDATE_ERROR",
+			line: 6,
+		},
+		"object in version 1": {
+			yml:  strings.Replace(yml, "version: 2", "version: 1", 1),
+			code: "PROFILE_PARSE_ERROR",
+			line: 6,
+		},
+	} {
+		_, err := ParseProfileYAML("https://example.com/profile.yaml", tc.yml, "clash")
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.AppError.Code != tc.code || pe.AppError.Line != tc.line || !strings.Contains(pe.AppError.Message, tc.message) {
+			t.Fatalf("%s: expected %s at line %d, got %T: %v", name, tc.code, tc.line, err, err)
+		}
+	}
+}
+
+func TestUpgradeYAML(t *testing.T) {
+	bt := "`"
+	v1 := `# my profile
+version: 1
+template:
+  clash: "https://example.com/base.yaml"
+vars:
+  REGION: "HK"
+custom_proxy_group:
+  - "PROXY` + bt + `select` + bt + `[]@all[]@regex:(${REGION})[]DIRECT` + bt + `hidden"
+  - group: "AUTO` + bt + `url-test` + bt + `(${REGION})` + bt + `https://www.gstatic.com/generate_204` + bt + `300` + bt + `50` + bt + `timeout=2000"
+    targets: [clash]
+  - "LB` + bt + `load-balance` + bt + `.*` + bt + `https://www.gstatic.com/generate_204` + bt + `600` + bt + `round-robin"
+  - "CHAIN` + bt + `relay` + bt + `[]PROXY[]AUTO"
+ruleset:
+  - "DIRECT,inline:https://example.com/LAN.list,behavior=classical"
+  - "PROXY,https://example.com/${REGION}.mrs,behavior=domain,format=mrs,interval=3600"
+  - "PROXY,https://example.com/list?tags=a,b,format=text"
+rule:
+  - "IP-CIDR,10.0.0.0/8,DIRECT,no-resolve"
+  - rule: "AND,((NETWORK,UDP),(DST-PORT,443)),REJECT"
+    targets: [clash, surge]
+  - "MATCH,PROXY" # fallback
+`
+	v2, err := UpgradeYAML("https://example.com/profile.yaml", v1)
+	if err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	for _, want := range []string{"# my profile", "version: 2", "# fallback", "(${REGION})", "name: AUTO", "url: 'https://example.com/list?tags=a,b'"} {
+		if !strings.Contains(v2, want) {
+			t.Fatalf("upgraded profile missing %q:\n%s", want, v2)
+		}
+	}
+
+	want, err := ParseProfileYAML("https://example.com/profile.yaml", v1, "clash")
+	if err != nil {
+		t.Fatalf("parse v1: %v", err)
+	}
+	got, err := ParseProfileYAML("https://example.com/profile.yaml", v2, "clash")
+	if err != nil {
+		t.Fatalf("parse upgraded profile: %v\n%s", err, v2)
+	}
+	if got.Version != 2 {
+		t.Fatalf("version=%d", got.Version)
+	}
+	got.Version = 1
+	// Raw text and positions differ by construction.
+	for _, p := range []*Spec{want, got} {
+		for i := range p.Groups {
+			p.Groups[i].Raw, p.Groups[i].Pos = "", Position{}
+		}
+		for i := range p.Ruleset {
+			p.Ruleset[i].Raw, p.Ruleset[i].Pos = "", Position{}
+		}
+		p.RulePos = nil
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("upgraded profile differs:\nv1=%+v\nv2=%+v\n%s", want, got, v2)
+	}
+
+	if again, err := UpgradeYAML("https://example.com/profile.yaml", v2); err != nil || again != v2 {
+		t.Fatalf("version 2 input should be returned unchanged: %v", err)
+	}
+
+	_, err = UpgradeYAML("https://example.com/profile.yaml", strings.Replace(v1, "behavior=classical", "behavior", 1))
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.AppError.Code != "RULESET_PARSE_ERROR" || pe.AppError.Line != 14 {
+		t.Fatalf("expected RULESET_PARSE_ERROR at line 14, got %T: %v", err, err)
+	}
+}
+
+func TestJSONSchema_MatchesDecodeTypes(t *testing.T) {
+	var schema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+		Defs       map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(JSONSchema(), &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+
+	yamlKeys := func(v any) []string {
+		var keys []string
+		var walk func(reflect.Type)
+		walk = func(rt reflect.Type) {
+			for i := 0; i < rt.NumField(); i++ {
+				f := rt.Field(i)
+				tag := f.Tag.Get("yaml")
+				if tag == ",inline" {
+					walk(f.Type)
+					continue
+				}
+				if name, _, _ := strings.Cut(tag, ","); name != "" {
+					keys = append(keys, name)
+				}
+			}
+		}
+		walk(reflect.TypeOf(v))
+		sort.Strings(keys)
+		return keys
+	}
+	propKeys := func(props map[string]json.RawMessage) []string {
+		keys := make([]string, 0, len(props))
+		for k := range props {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	if got, want := propKeys(schema.Properties), yamlKeys(rawProfile{}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("top-level properties=%v, want=%v", got, want)
+	}
+	for def, v := range map[string]any{
+		"customProxy":   rawCustomProxy{},
+		"proxyChain":    rawChainSpec{},
+		"groupObject":   groupObject{},
+		"rulesetObject": rulesetObject{},
+		"ruleObject":    ruleObject{},
+	} {
+		if got, want := propKeys(schema.Defs[def].Properties), yamlKeys(v); !reflect.DeepEqual(got, want) {
+			t.Fatalf("$defs.%s properties=%v, want=%v", def, got, want)
+		}
+	}
+}
+
+func TestValidateProfileYAML_CollectsEveryError(t *testing.T) {
+	yml := `
+version: 1
+template:
+  clash: "https://example.com/base.yaml"
+  surge: "ftp://example.com/base.conf"
+custom_proxy_group:
+  - "PROXY` + "`" + `select` + "`" + `[]AUTO[]MISSING"
+  - "AUTO` + "`" + `url-test` + "`" + `(HK` + "`" + `https://www.gstatic.com/generate_204` + "`" + `300"
+ruleset:
+  - "PROXY,https://example.com/a.list,behavior=bogus"
+rule:
+  - "DOMAIN-SUFFIX,example.com"
+  - "MATCH,PROXY"
+`
+	v := ValidateProfileYAML("https://example.com/profile.yaml", yml, "", ParseOptions{})
+	type found struct {
+		Code         string
+		Line, Column int
+	}
+	var got []found
+	for _, err := range v.Errors {
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("expected ParseError, got %T: %v", err, err)
+		}
+		got = append(got, found{pe.AppError.Code, pe.AppError.Line, pe.AppError.Column})
+	}
+	// AUTO failed to parse, so PROXY's reference to it is not reported; MISSING is,
+	// once although both target views see it.
+	want := []found{
+		{"PROFILE_VALIDATE_ERROR", 0, 0},
+		{"GROUP_PARSE_ERROR", 8, 5},
+		{"RULESET_PARSE_ERROR", 10, 5},
+		{"RULE_PARSE_ERROR", 12, 5},
+		{"GROUP_PARSE_ERROR", 7, 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("errors=%+v, want=%+v\n%v", got, want, v.Errors)
+	}
+	if _, ok := v.BrokenNames["AUTO"]; !ok || v.Spec == nil || len(v.Spec.Groups) != 1 {
+		t.Fatalf("unexpected result: broken=%v spec=%+v", v.BrokenNames, v.Spec)
+	}
+
+	v = ValidateProfileYAML("https://example.com/profile.yaml", "version: 1\nrule: [\n", "", ParseOptions{})
+	var pe *ParseError
+	if len(v.Errors) != 1 || !errors.As(v.Errors[0], &pe) || pe.AppError.Code != "PROFILE_PARSE_ERROR" || pe.AppError.Line == 0 || v.Spec != nil {
+		t.Fatalf("syntax error: %v", v.Errors)
+	}
+}
+
+func TestParseProfileYAML_HostsMITMAndProxyProviders(t *testing.T) {
+	base := `
+version: 2
+template:
+  clash: "https://example.com/base_clash.yaml"
+rule:
+  - {type: MATCH, action: DIRECT}
+`
+	yml := base + `
+hosts:
+  router.lan: 192.168.1.1
+  dns.example.com: 8.8.8.8
+mitm_hostnames: ["*.example.com", "-skip.example.com", "*.example.com"]
+proxy_providers:
+  - {name: airport, url: "https://example.com/nodes.yaml", interval: 600}
+`
+	p, err := ParseProfileYAML("https://example.com/profile.yaml", yml, "clash")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantHosts := []model.Host{{Domain: "dns.example.com", Value: "8.8.8.8"}, {Domain: "router.lan", Value: "192.168.1.1"}}
+	if !reflect.DeepEqual(p.Hosts, wantHosts) {
+		t.Fatalf("hosts=%+v, want=%+v", p.Hosts, wantHosts)
+	}
+	if want := []string{"*.example.com", "-skip.example.com"}; !reflect.DeepEqual(p.MITMHostnames, want) {
+		t.Fatalf("mitm_hostnames=%v, want=%v", p.MITMHostnames, want)
+	}
+	if want := []model.ProxyProvider{{Name: "airport", URL: "https://example.com/nodes.yaml", IntervalSec: 600}}; !reflect.DeepEqual(p.ProxyProviders, want) {
+		t.Fatalf("proxy_providers=%+v, want=%+v", p.ProxyProviders, want)
+	}
+
+	for _, bad := range []string{
+		"hosts: {\"a b.example.com\": 1.1.1.1}",
+		"mitm_hostnames: [\"a.com,b.com\"]",
+		"proxy_providers: [{name: x, url: \"ftp://example.com/x\"}]",
+		"proxy_providers: [{name: x, url: \"https://a.example\"}, {name: x, url: \"https://b.example\"}]",
+	} {
+		_, err := ParseProfileYAML("https://example.com/profile.yaml", base+bad+"\n", "clash")
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.AppError.Code != "PROFILE_VALIDATE_ERROR" {
+			t.Fatalf("%s: expected PROFILE_VALIDATE_ERROR, got %T: %v", bad, err, err)
+		}
+	}
+}
+
+func TestParseProfileYAML_ManagedConfig(t *testing.T) {
+	base := `
+version: 2
+template:
+  surge: "https://example.com/base_surge.conf"
+rule:
+  - {type: MATCH, action: DIRECT}
+`
+	p, err := ParseProfileYAML("https://example.com/profile.yaml", base+"managed_config: {interval: 43200, strict: false, web_page_url: \"https://example.com/\"}\n", "surge")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (ManagedConfigSpec{IntervalSec: 43200, Strict: false, HasStrict: true, WebPageURL: "https://example.com/"}); p.ManagedConfig != want {
+		t.Fatalf("managed_config=%+v, want=%+v", p.ManagedConfig, want)
+	}
+
+	p, err = ParseProfileYAML("https://example.com/profile.yaml", base, "surge")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.ManagedConfig != (ManagedConfigSpec{}) {
+		t.Fatalf("managed_config=%+v, want zero value", p.ManagedConfig)
+	}
+
+	for _, bad := range []string{"{interval: -1}", "{web_page_url: \"ftp://example.com\"}", "{web_page_url: example.com}"} {
+		_, err = ParseProfileYAML("https://example.com/profile.yaml", base+"managed_config: "+bad+"\n", "surge")
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.AppError.Code != "PROFILE_VALIDATE_ERROR" {
+			t.Fatalf("%s: expected PROFILE_VALIDATE_ERROR, got %T: %v", bad, err, err)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package profile
+
+import (
+	_ "embed"
+	"slices"
+)
+
+//go:embed schema.json
+var jsonSchema []byte
+
+// JSONSchema returns the JSON Schema (draft 2020-12) of the profile document.
+// It describes the shape only; directives, references and target views are
+// checked by ValidateProfileYAML.
+func JSONSchema() []byte { return slices.Clone(jsonSchema) }
@@ -0,0 +1,419 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+// knownTargets are the template keys (and the valid `targets:` values).
+var knownTargets = map[string]struct{}{
+	"clash":        {},
+	"shadowrocket": {},
+	"surge":        {},
+	"quanx":        {},
+}
+
+// Targets restricts a profile item to some targets; empty means every target.
+type Targets []string
+
+// Includes reports whether the item applies to target. The empty target
+// (target-agnostic callers) includes everything.
+func (t Targets) Includes(target string) bool {
+	return len(t) == 0 || target == "" || slices.Contains(t, target)
+}
+
+func targetsAt(ts []Targets, i int) Targets {
+	if i < len(ts) {
+		return ts[i]
+	}
+	return nil
+}
+
+// UsesTargets reports whether any item of s is restricted with `targets:`.
+func (s *Spec) UsesTargets() bool {
+	if s == nil {
+		return false
+	}
+	scoped := func(t Targets) bool { return len(t) > 0 }
+	return slices.ContainsFunc(s.CustomProxyTargets, scoped) || slices.ContainsFunc(s.RuleTargets, scoped) ||
+		slices.ContainsFunc(s.Groups, func(g GroupSpec) bool { return scoped(g.Targets) }) ||
+		slices.ContainsFunc(s.ProxyChains, func(c ChainSpec) bool { return scoped(c.Targets) }) ||
+		slices.ContainsFunc(s.Ruleset, func(rs RulesetSpec) bool { return scoped(rs.Targets) })
+}
+
+// ForTarget returns the view of s that applies to target: items whose
+// `targets:` exclude it are removed. The empty target keeps every item, which
+// fails if two target-specific items share a name.
+func (s *Spec) ForTarget(target string) (*Spec, error) {
+	if s == nil {
+		return nil, nil
+	}
+	view := *s
+	view.CustomProxies, view.CustomProxyTargets, view.CustomProxyPos = filterByTargets(s.CustomProxies, s.CustomProxyTargets, s.CustomProxyPos, target)
+	view.Rules, view.RuleTargets, view.RulePos = filterByTargets(s.Rules, s.RuleTargets, s.RulePos, target)
+	view.Groups = slices.DeleteFunc(slices.Clone(s.Groups), func(g GroupSpec) bool { return !g.Targets.Includes(target) })
+	view.ProxyChains = slices.DeleteFunc(slices.Clone(s.ProxyChains), func(c ChainSpec) bool { return !c.Targets.Includes(target) })
+	view.Ruleset = slices.DeleteFunc(slices.Clone(s.Ruleset), func(rs RulesetSpec) bool { return !rs.Targets.Includes(target) })
+
+	if target == "" {
+		seen := make(map[string]struct{}, len(view.Groups)+len(view.CustomProxies))
+		for _, g := range view.Groups {
+			if _, ok := seen[g.Name]; ok {
+				return nil, fmt.Errorf("多个 targets 限定的策略组同名：%s，需要指定 target", g.Name)
+			}
+			seen[g.Name] = struct{}{}
+		}
+		clear(seen)
+		for _, p := range view.CustomProxies {
+			if _, ok := seen[p.Name]; ok {
+				return nil, fmt.Errorf("多个 targets 限定的 custom_proxy 同名：%s，需要指定 target", p.Name)
+			}
+			seen[p.Name] = struct{}{}
+		}
+	}
+	return &view, nil
+}
+
+// filterByTargets filters items and the targets / positions aligned with them.
+func filterByTargets[T any](items []T, targets []Targets, pos []Position, target string) ([]T, []Targets, []Position) {
+	if len(targets) == 0 {
+		return items, targets, pos
+	}
+	outItems := make([]T, 0, len(items))
+	outTargets := make([]Targets, 0, len(items))
+	var outPos []Position
+	if pos != nil {
+		outPos = make([]Position, 0, len(items))
+	}
+	for i, item := range items {
+		t := targetsAt(targets, i)
+		if !t.Includes(target) {
+			continue
+		}
+		outItems = append(outItems, item)
+		outTargets = append(outTargets, t)
+		if pos != nil {
+			outPos = append(outPos, PositionAt(pos, i))
+		}
+	}
+	return outItems, outTargets, outPos
+}
+
+// PositionAt returns pos[i], or the zero Position when pos is shorter (a Spec
+// built without positions).
+func PositionAt(pos []Position, i int) Position {
+	if i >= 0 && i < len(pos) {
+		return pos[i]
+	}
+	return Position{}
+}
+
+// parseTargets validates a `targets:` list.
+func parseTargets(raw []string) (Targets, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("targets 不能为空列表")
+	}
+	out := make(Targets, 0, len(raw))
+	for _, t := range raw {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if _, ok := knownTargets[t]; !ok {
+			return nil, fmt.Errorf("targets 不支持：%s", t)
+		}
+		if !slices.Contains(out, t) {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// rawDirective is a string list entry that may also be written as a mapping
+// with the directive under key and an optional `targets:` list, or (version 2)
+// as a structured object:
+//
+//   - "MATCH,PROXY"
+//   - rule: "PROCESS-NAME,curl,DIRECT"
+//     targets: [clash]
+//   - {type: DOMAIN-SUFFIX, value: example.com, action: DIRECT}
+type rawDirective struct {
+	Value   string
+	Targets []string
+
+	// object is a version 2 entry (a mapping without the directive key),
+	// decoded by the caller; line / column are the entry's position.
+	object       *yaml.Node
+	line, column int
+
+	origin string
+}
+
+func (d rawDirective) pos() Position { return Position{URL: d.origin, Line: d.line, Column: d.column} }
+
+func (d *rawDirective) decode(node *yaml.Node, key string) error {
+	d.line, d.column = node.Line, node.Column
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&d.Value)
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a string or a mapping with %q", node.Line, key)
+	}
+	if mappingValue(node, key) == nil {
+		d.object = node
+		return nil
+	}
+	hasValue := false
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		switch k.Value {
+		case key:
+			if err := v.Decode(&d.Value); err != nil {
+				return err
+			}
+			hasValue = true
+		case "targets":
+			if err := v.Decode(&d.Targets); err != nil {
+				return err
+			}
+			if d.Targets == nil {
+				d.Targets = []string{}
+			}
+		default:
+			return fmt.Errorf("line %d: field %s not allowed (expected %s, targets)", k.Line, k.Value, key)
+		}
+	}
+	if !hasValue {
+		return fmt.Errorf("line %d: missing field %q", node.Line, key)
+	}
+	return nil
+}
+
+type rawGroupDirective struct{ rawDirective }
+
+func (d *rawGroupDirective) UnmarshalYAML(node *yaml.Node) error { return d.decode(node, "group") }
+
+type rawRulesetDirective struct{ rawDirective }
+
+func (d *rawRulesetDirective) UnmarshalYAML(node *yaml.Node) error {
+	return d.decode(node, "ruleset")
+}
+
+type rawRuleDirective struct{ rawDirective }
+
+func (d *rawRuleDirective) UnmarshalYAML(node *yaml.Node) error { return d.decode(node, "rule") }
+
+// validateTargetView runs the cross-item checks of a profile on one target
+// view. References to names the sink marked broken are not reported again.
+// Errors point at the item making the reference (or the second declaration).
+func validateTargetView(view *Spec, sourceURL string, requiredTarget string, sink *errorSink) error {
+	fail := func(e model.AppError, pos Position) error {
+		e.Stage, e.URL = "parse_profile", sourceURL
+		pos.Locate(&e)
+		return sink.add(&ParseError{AppError: e})
+	}
+
+	customProxyNames := make(map[string]struct{}, len(view.CustomProxies))
+	for i, p := range view.CustomProxies {
+		if _, ok := customProxyNames[p.Name]; ok {
+			if err := fail(model.AppError{
+				Code:    "CUSTOM_PROXY_VALIDATE_ERROR",
+				Message: fmt.Sprintf("重复的 custom_proxy.name：%s", p.Name),
+				Snippet: truncateSnippet(fmt.Sprintf("name=%s type=%s server=%s port=%d", p.Name, p.Type, p.Server, p.Port), 200),
+			}, PositionAt(view.CustomProxyPos, i)); err != nil {
+				return err
+			}
+		}
+		customProxyNames[p.Name] = struct{}{}
+	}
+
+	groupNames := make(map[string]struct{}, len(view.Groups))
+	for _, g := range view.Groups {
+		var e model.AppError
+		switch _, dup := groupNames[g.Name]; {
+		case g.Name == "":
+			e = model.AppError{Code: "GROUP_PARSE_ERROR", Message: "策略组名不能为空"}
+		case g.Name == "DIRECT" || g.Name == "REJECT":
+			e = model.AppError{Code: "PROFILE_VALIDATE_ERROR", Message: "策略组名不能使用保留名 DIRECT/REJECT"}
+		case strings.HasPrefix(g.Name, "CHAIN-"):
+			e = model.AppError{Code: "PROFILE_VALIDATE_ERROR", Message: "策略组名不能使用保留前缀 CHAIN-"}
+		case dup:
+			e = model.AppError{Code: "PROFILE_VALIDATE_ERROR", Message: fmt.Sprintf("重复的策略组名：%s", g.Name)}
+		default:
+			if _, ok := customProxyNames[g.Name]; ok {
+				e = model.AppError{Code: "CUSTOM_PROXY_VALIDATE_ERROR", Message: fmt.Sprintf("custom_proxy.name 与策略组名冲突：%s", g.Name)}
+			}
+		}
+		if e.Code != "" {
+			e.Snippet = g.Raw
+			if err := fail(e, g.Pos); err != nil {
+				return err
+			}
+		}
+		groupNames[g.Name] = struct{}{}
+	}
+
+	groupTypes := make(map[string]string, len(view.Groups))
+	for _, g := range view.Groups {
+		groupTypes[g.Name] = g.Type
+	}
+	for _, g := range view.Groups {
+		if g.Type != "relay" {
+			continue
+		}
+		// Hops that are not group names are resolved against proxy names at compile time.
+		for _, hop := range g.Members {
+			msg := ""
+			switch {
+			case hop == g.Name:
+				msg = "relay 组不能引用自身"
+			case groupTypes[hop] == "relay":
+				msg = fmt.Sprintf("relay 组不能嵌套 relay 组：%s", hop)
+			}
+			if msg != "" {
+				if err := fail(model.AppError{Code: "GROUP_PARSE_ERROR", Message: msg, Snippet: g.Raw}, g.Pos); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, g := range view.Groups {
+		if g.Type != "select" {
+			continue
+		}
+		for i, m := range g.Members {
+			if m == SelectMemberAll || m == "DIRECT" || m == "REJECT" || (i < len(g.MemberRegex) && g.MemberRegex[i] != nil) {
+				continue
+			}
+			if _, ok := groupNames[m]; !ok && !sink.isBroken(m) {
+				if err := fail(model.AppError{
+					Code:    "GROUP_PARSE_ERROR",
+					Message: fmt.Sprintf("策略组引用不存在：%s", m),
+					Snippet: g.Raw,
+				}, g.Pos); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, cs := range view.ProxyChains {
+		if _, ok := customProxyNames[cs.Proxy]; !ok && !sink.isBroken(cs.Proxy) {
+			if err := fail(model.AppError{
+				Code:    "CHAIN_PROXY_NOT_FOUND",
+				Message: fmt.Sprintf("proxy_chain proxy 引用不存在：%s", cs.Proxy),
+				Snippet: cs.Raw,
+			}, cs.Pos); err != nil {
+				return err
+			}
+		}
+		if cs.Type == "custom" {
+			if _, ok := customProxyNames[cs.Via]; !ok && !sink.isBroken(cs.Via) {
+				if err := fail(model.AppError{
+					Code:    "CHAIN_PROXY_NOT_FOUND",
+					Message: fmt.Sprintf("proxy_chain via 引用不存在：%s", cs.Via),
+					Snippet: cs.Raw,
+				}, cs.Pos); err != nil {
+					return err
+				}
+			}
+		}
+		if cs.Type == "group" || cs.Type == "dialer" {
+			if _, ok := groupNames[cs.Group]; !ok && !sink.isBroken(cs.Group) {
+				if err := fail(model.AppError{
+					Code:    "CHAIN_GROUP_NOT_FOUND",
+					Message: fmt.Sprintf("proxy_chain group 引用不存在：%s", cs.Group),
+					Snippet: cs.Raw,
+				}, cs.Pos); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if cs, cycle := ChainCycle(view.ProxyChains); cycle != nil {
+		if err := fail(model.AppError{
+			Code:    "CHAIN_CYCLE",
+			Message: fmt.Sprintf("proxy_chain 存在循环：%s", strings.Join(cycle, " -> ")),
+			Snippet: cs.Raw,
+		}, cs.Pos); err != nil {
+			return err
+		}
+	}
+
+	if len(view.ProxyChains) > 0 && requiredTarget != "" && requiredTarget != "clash" && requiredTarget != "surge" && requiredTarget != "shadowrocket" {
+		if err := fail(model.AppError{
+			Code:    "UNSUPPORTED_TARGET_FEATURE",
+			Message: fmt.Sprintf("target=%s 当前不支持 proxy_chain", requiredTarget),
+			Hint:    "proxy_chain only supports clash/surge/shadowrocket",
+		}, view.ProxyChains[0].Pos); err != nil {
+			return err
+		}
+	}
+
+	if !sink.brokenMatch && !slices.ContainsFunc(view.Rules, func(r model.Rule) bool { return r.Type == "MATCH" }) {
+		return fail(model.AppError{
+			Code:    "PROFILE_VALIDATE_ERROR",
+			Message: "缺少兜底规则 MATCH,<ACTION>",
+			Hint:    "add at end of rule: MATCH,PROXY",
+		}, Position{})
+	}
+	return nil
+}
+
+// ChainCycle finds a loop among type=custom chains (A via B via ... via A).
+// It returns the chain closing the loop and the custom_proxy names along it
+// (first and last equal), or a nil cycle.
+func ChainCycle(chains []ChainSpec) (ChainSpec, []string) {
+	edges := make(map[string][]ChainSpec)
+	var order []string
+	for _, cs := range chains {
+		if cs.Type != "custom" {
+			continue
+		}
+		if _, ok := edges[cs.Proxy]; !ok {
+			order = append(order, cs.Proxy)
+		}
+		edges[cs.Proxy] = append(edges[cs.Proxy], cs)
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(order))
+	var path []string
+	var visit func(name string) (ChainSpec, []string)
+	visit = func(name string) (ChainSpec, []string) {
+		state[name] = visiting
+		path = append(path, name)
+		for _, cs := range edges[name] {
+			switch state[cs.Via] {
+			case visiting:
+				start := slices.Index(path, cs.Via)
+				return cs, append(slices.Clone(path[start:]), cs.Via)
+			case 0:
+				if closing, cycle := visit(cs.Via); cycle != nil {
+					return closing, cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		return ChainSpec{}, nil
+	}
+	for _, name := range order {
+		if state[name] == 0 {
+			if closing, cycle := visit(name); cycle != nil {
+				return closing, cycle
+			}
+		}
+	}
+	return ChainSpec{}, nil
+}
@@ -0,0 +1,246 @@
+package profile
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/rules"
+	"gopkg.in/yaml.v3"
+)
+
+// UpgradeYAML rewrites a version 1 profile (or an included partial profile,
+// which has no version) as the equivalent version 2 document: group, ruleset
+// and rule directives become structured objects, everything else (including
+// comments and ${VAR} references) is kept as written. A version 2 document is
+// returned unchanged.
+//
+// Group and ruleset directives are only split, not validated, so the result
+// fails to parse exactly where the input would. Rule directives are fully
+// parsed with rules.ParseInlineRule (their structured form needs the parsed
+// rule, including logical sub-rules): an invalid rule fails the upgrade
+// itself, and valid ones are written normalized (e.g. upper-cased types,
+// trimmed fields).
+func UpgradeYAML(sourceURL string, content string) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+		return "", &ParseError{
+			AppError: model.AppError{
+				Code:    "PROFILE_PARSE_ERROR",
+				Message: "profile YAML 解析失败",
+				Stage:   "parse_profile",
+				URL:     sourceURL,
+				Snippet: truncateSnippet(content, 200),
+			},
+			Cause: err,
+		}
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return "", &ParseError{AppError: model.AppError{
+			Code:    "PROFILE_PARSE_ERROR",
+			Message: "profile 顶层必须是 YAML 对象",
+			Stage:   "parse_profile",
+			URL:     sourceURL,
+		}}
+	}
+	root := doc.Content[0]
+
+	if v := mappingValue(root, "version"); v != nil {
+		switch strings.TrimSpace(v.Value) {
+		case "2":
+			return content, nil
+		case "1":
+			v.Value, v.Tag, v.Style = "2", "!!int", 0
+		default:
+			return "", &ParseError{AppError: model.AppError{
+				Code:    "PROFILE_VALIDATE_ERROR",
+				Message: "profile version 必须为 1 或 2",
+				Stage:   "parse_profile",
+				URL:     sourceURL,
+				Line:    v.Line,
+			}}
+		}
+	}
+
+	lists := []struct {
+		list, key, code string
+		convert         func(string) (*yaml.Node, error)
+	}{
+		{"custom_proxy_group", "group", "GROUP_PARSE_ERROR", upgradeGroup},
+		{"ruleset", "ruleset", "RULESET_PARSE_ERROR", upgradeRuleset},
+		{"rule", "rule", "RULE_PARSE_ERROR", upgradeRule},
+	}
+	for _, l := range lists {
+		seq := mappingValue(root, l.list)
+		if seq == nil || seq.Kind != yaml.SequenceNode {
+			continue
+		}
+		items := seq.Content[:0]
+		for _, item := range seq.Content {
+			var d rawDirective
+			if err := d.decode(item, l.key); err != nil {
+				return "", &ParseError{
+					AppError: model.AppError{
+						Code:    "PROFILE_PARSE_ERROR",
+						Message: l.list + " 条目格式不合法",
+						Stage:   "parse_profile",
+						URL:     sourceURL,
+						Line:    item.Line,
+					},
+					Cause: err,
+				}
+			}
+			if d.object != nil {
+				items = append(items, item)
+				continue
+			}
+			raw := strings.TrimSpace(d.Value)
+			if raw == "" {
+				continue
+			}
+			obj, err := l.convert(raw)
+			if err != nil {
+				d.origin = sourceURL
+				return "", itemError(l.code, l.list+" 指令解析失败", d, raw, err)
+			}
+			if d.Targets != nil {
+				obj.Content = append(obj.Content, yamlStr("targets"), mappingValue(item, "targets"))
+			}
+			obj.HeadComment, obj.LineComment, obj.FootComment = item.HeadComment, item.LineComment, item.FootComment
+			items = append(items, obj)
+		}
+		seq.Content = items
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func upgradeGroup(raw string) (*yaml.Node, error) {
+	o, err := splitGroupDirective(raw)
+	if err != nil {
+		return nil, err
+	}
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	addField(n, "name", yamlStr(o.Name))
+	addField(n, "type", yamlStr(o.Type))
+	if o.Members != nil {
+		members := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, m := range o.Members {
+			members.Content = append(members.Content, yamlStr(m))
+		}
+		addField(n, "members", members)
+	}
+	if o.Regex != "" {
+		addField(n, "regex", yamlStr(o.Regex))
+	}
+	for _, f := range []struct{ key, value string }{
+		{"url", o.URL},
+		{"interval", o.Interval},
+		{"tolerance", o.Tolerance},
+		{"strategy", o.Strategy},
+		{"icon", o.Icon},
+		{"hidden", o.Hidden},
+		{"lazy", o.Lazy},
+		{"timeout", o.Timeout},
+		{"disable_udp", o.DisableUDP},
+		{"include_all_providers", o.IncludeAllProviders},
+		{"dialer_proxy", o.DialerProxy},
+	} {
+		if f.value != "" {
+			addField(n, f.key, yamlScalar(f.value))
+		}
+	}
+	return n, nil
+}
+
+func upgradeRuleset(raw string) (*yaml.Node, error) {
+	o, err := splitRulesetDirective(raw)
+	if err != nil {
+		return nil, err
+	}
+	n := &yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle}
+	addField(n, "action", yamlStr(o.Action))
+	addField(n, "url", yamlStr(o.URL))
+	for _, f := range []struct{ key, value string }{
+		{"inline", o.Inline},
+		{"behavior", o.Behavior},
+		{"format", o.Format},
+		{"interval", o.Interval},
+	} {
+		if f.value != "" {
+			addField(n, f.key, yamlScalar(f.value))
+		}
+	}
+	return n, nil
+}
+
+func upgradeRule(raw string) (*yaml.Node, error) {
+	r, err := rules.ParseInlineRule(raw)
+	if err != nil {
+		return nil, err
+	}
+	n := ruleObjectNode(ruleToObject(r))
+	n.Style = yaml.FlowStyle
+	return n, nil
+}
+
+func ruleObjectNode(o ruleObject) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	addField(n, "type", yamlStr(o.Type))
+	if o.Value != "" {
+		addField(n, "value", yamlStr(o.Value))
+	}
+	if o.Rules != nil {
+		subs := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, sub := range o.Rules {
+			subs.Content = append(subs.Content, ruleObjectNode(sub))
+		}
+		addField(n, "rules", subs)
+	}
+	if o.Action != "" {
+		addField(n, "action", yamlStr(o.Action))
+	}
+	if o.NoResolve {
+		addField(n, "no_resolve", yamlScalar("true"))
+	}
+	return n
+}
+
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func addField(n *yaml.Node, key string, v *yaml.Node) {
+	n.Content = append(n.Content, yamlStr(key), v)
+}
+
+func yamlStr(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
+
+// yamlScalar tags integers and booleans so they are written unquoted; the
+// structured fields decode them back to the same string.
+func yamlScalar(s string) *yaml.Node {
+	n := yamlStr(s)
+	if _, err := strconv.Atoi(s); err == nil {
+		n.Tag = "!!int"
+	} else if s == "true" || s == "false" {
+		n.Tag = "!!bool"
+	}
+	return n
+}
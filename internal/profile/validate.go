@@ -0,0 +1,124 @@
+package profile
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// errorSink decides what an item error does: by default it stops parsing
+// (ParseProfileYAML); when collecting, it is recorded and parsing moves on to
+// the next item (ValidateProfileYAML).
+type errorSink struct {
+	collect bool
+	errs    []error
+
+	// Names declared by entries that failed to parse, and whether a fallback
+	// rule did: cross-item checks do not report them as missing.
+	brokenNames map[string]struct{}
+	brokenMatch bool
+}
+
+// add records err and returns it when parsing must stop.
+func (s *errorSink) add(err error) error {
+	if err == nil || !s.collect {
+		return err
+	}
+	s.errs = append(s.errs, err)
+	return nil
+}
+
+func (s *errorSink) broken(name string) {
+	if name = strings.TrimSpace(name); name == "" {
+		return
+	}
+	if s.brokenNames == nil {
+		s.brokenNames = make(map[string]struct{})
+	}
+	s.brokenNames[name] = struct{}{}
+}
+
+func (s *errorSink) isBroken(name string) bool {
+	_, ok := s.brokenNames[name]
+	return ok
+}
+
+// Validation is the result of ValidateProfileYAML.
+type Validation struct {
+	// Spec holds the items that parsed; nil when parsing stopped early
+	// (YAML syntax, version, vars or include errors).
+	Spec *Spec
+
+	// Errors are in document order, then cross-item checks; duplicates
+	// (the same problem in several target views) are reported once.
+	Errors []error
+
+	// BrokenNames are the names declared by group / custom_proxy entries that
+	// failed to parse; BrokenMatch is set when a MATCH rule did. Later checks
+	// should not report references to them as missing.
+	BrokenNames map[string]struct{}
+	BrokenMatch bool
+}
+
+// ValidateProfileYAML checks a profile like ParseProfileYAMLWithOptions, but
+// reports every error instead of stopping at the first: each item is parsed
+// on its own and the names and references are checked across the items that
+// parsed.
+func ValidateProfileYAML(sourceURL string, content string, requiredTarget string, opt ParseOptions) Validation {
+	sink := &errorSink{collect: true}
+	spec, err := parseProfile(sourceURL, content, requiredTarget, opt, sink)
+	errs := sink.errs
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return Validation{
+		Spec:        spec,
+		Errors:      uniqueErrors(errs),
+		BrokenNames: sink.brokenNames,
+		BrokenMatch: sink.brokenMatch,
+	}
+}
+
+func uniqueErrors(errs []error) []error {
+	seen := make(map[string]struct{}, len(errs))
+	out := errs[:0]
+	for _, err := range errs {
+		key := err.Error()
+		var pe *ParseError
+		if errors.As(err, &pe) {
+			key = strings.Join([]string{pe.AppError.Code, pe.AppError.Message, pe.AppError.URL, strconv.Itoa(pe.AppError.Line), pe.AppError.Snippet}, "\x00")
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, err)
+	}
+	return out
+}
+
+// groupName is the best-effort name of a custom_proxy_group entry, used when
+// the entry itself failed to parse.
+func (d rawDirective) groupName() string {
+	if d.object != nil {
+		if v := mappingValue(d.object, "name"); v != nil {
+			return v.Value
+		}
+		return ""
+	}
+	name, _, _ := strings.Cut(d.Value, "`")
+	return name
+}
+
+var yamlLineRe = regexp.MustCompile(`line (\d+):`)
+
+// yamlErrorLine extracts the first line number from a yaml.v3 error.
+func yamlErrorLine(err error) int {
+	m := yamlLineRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
@@ -0,0 +1,111 @@
+package profile
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	varNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	varRefRe  = regexp.MustCompile(`\$\{([^{}]*)\}`)
+)
+
+// ValidVarName reports whether name can be declared under `vars:` and
+// referenced as ${name}.
+func ValidVarName(name string) bool { return varNameRe.MatchString(name) }
+
+// resolveVars applies request overrides to the declared defaults. Only
+// declared variables can be overridden, so a mistyped override fails instead
+// of being silently ignored.
+func resolveVars(declared, overrides map[string]string) (map[string]string, error) {
+	vars := make(map[string]string, len(declared))
+	for name, v := range declared {
+		if !ValidVarName(name) {
+			return nil, fmt.Errorf("vars 名称不合法：%s", name)
+		}
+		vars[name] = v
+	}
+	for name, v := range overrides {
+		if _, ok := vars[name]; !ok {
+			return nil, fmt.Errorf("变量未在 profile vars 中声明：%s", name)
+		}
+		vars[name] = v
+	}
+	return vars, nil
+}
+
+// expandVars replaces every ${NAME} in s. Values are inserted verbatim (no
+// recursive expansion).
+func expandVars(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var err error
+	out := varRefRe.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		v, ok := vars[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("未定义的变量：${%s}", name)
+		}
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// expandAll expands ${NAME} in each field in place.
+func expandAll(vars map[string]string, fields ...*string) error {
+	for _, f := range fields {
+		v, err := expandVars(*f, vars)
+		if err != nil {
+			return err
+		}
+		*f = v
+	}
+	return nil
+}
+
+// expandGroupDirective expands a version 1 group directive that has already
+// been split into o, field by field, so a value containing "`", "[]" or "="
+// stays inside its field instead of adding members or attributes. It returns
+// the expanded directive text (raw unchanged when it references no variable).
+func expandGroupDirective(o *groupObject, raw string, vars map[string]string) (string, error) {
+	if !strings.Contains(raw, "${") {
+		return raw, nil
+	}
+	if strings.Contains(o.Type, "${") {
+		// The type decides which positional fields exist, so it is fixed
+		// before expansion.
+		return "", fmt.Errorf("组类型不能引用变量：%s", o.Type)
+	}
+	if err := expandGroupObject(o, vars); err != nil {
+		return "", err
+	}
+	return groupDirective(*o), nil
+}
+
+// expandRulesetDirective is expandGroupDirective for a version 1 ruleset
+// directive: a value containing "," cannot add options.
+func expandRulesetDirective(o *rulesetObject, raw string, vars map[string]string) (string, error) {
+	if !strings.Contains(raw, "${") {
+		return raw, nil
+	}
+	if err := expandAll(vars, &o.Action, &o.URL, &o.Inline, &o.Behavior, &o.Format, &o.Interval); err != nil {
+		return "", err
+	}
+	return rulesetDirective(*o), nil
+}
+
+func expandGroupObject(o *groupObject, vars map[string]string) error {
+	members := make([]*string, len(o.Members))
+	for i := range o.Members {
+		members[i] = &o.Members[i]
+	}
+	return expandAll(vars, append([]*string{
+		&o.Name, &o.Type, &o.Regex, &o.URL, &o.Interval, &o.Tolerance, &o.Strategy,
+		&o.Icon, &o.Hidden, &o.Lazy, &o.Timeout, &o.DisableUDP, &o.IncludeAllProviders, &o.DialerProxy,
+	}, members...)...)
+}
@@ -9,9 +9,10 @@ import (
 
 	"github.com/John-Robertt/subconverter-go/internal/compiler"
 	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/rules"
 )
 
-func renderClash(res *compiler.Result) (Blocks, error) {
+func renderClash(res *compiler.Result, rw ruleWriter) (Blocks, error) {
 	proxyNames := make(map[string]string, len(res.Proxies))
 	for _, p := range res.Proxies {
 		proxyNames[p.ID] = p.Name
@@ -26,8 +27,33 @@ func renderClash(res *compiler.Result) (Blocks, error) {
 		proxyLines = append(proxyLines, lines...)
 	}
 
+	proxyByID := make(map[string]model.Proxy, len(res.Proxies))
+	usedNames := make(map[string]struct{}, len(res.Proxies)+len(res.Groups))
+	for _, p := range res.Proxies {
+		proxyByID[p.ID] = p
+		usedNames[p.Name] = struct{}{}
+	}
+	for _, g := range res.Groups {
+		usedNames[g.Name] = struct{}{}
+	}
+
 	groupLines := make([]string, 0, len(res.Groups)*6)
 	for _, g := range res.Groups {
+		if g.Type == "relay" && g.Options.DialerProxy {
+			lines, exit, err := renderClashDialerRelay(g, proxyByID, proxyNames, usedNames)
+			if err != nil {
+				return Blocks{}, err
+			}
+			proxyLines = append(proxyLines, lines...)
+			groupLines = append(groupLines,
+				"- name: "+yamlDQ(g.Name),
+				"  type: "+yamlDQ("select"),
+				"  proxies:",
+				"    - "+yamlDQ(exit),
+			)
+			groupLines = append(groupLines, clashGroupOptionLines(g.Options)...)
+			continue
+		}
 		groupLines = append(groupLines, "- name: "+yamlDQ(g.Name))
 		groupLines = append(groupLines, "  type: "+yamlDQ(g.Type))
 		groupLines = append(groupLines, "  proxies:")
@@ -38,13 +64,18 @@ func renderClash(res *compiler.Result) (Blocks, error) {
 			}
 			groupLines = append(groupLines, "    - "+yamlDQ(name))
 		}
-		if g.Type == "url-test" {
+		switch g.Type {
+		case "url-test", "fallback", "load-balance":
 			groupLines = append(groupLines, "  url: "+yamlDQ(g.TestURL))
 			groupLines = append(groupLines, "  interval: "+strconv.Itoa(g.IntervalSec))
 			if g.HasTolerance {
 				groupLines = append(groupLines, "  tolerance: "+strconv.Itoa(g.ToleranceMS))
 			}
+			if g.Strategy != "" {
+				groupLines = append(groupLines, "  strategy: "+yamlDQ(g.Strategy))
+			}
 		}
+		groupLines = append(groupLines, clashGroupOptionLines(g.Options)...)
 	}
 
 	ruleProvidersBlock, providerNames, err := renderClashRuleProviders(res.RulesetRefs)
@@ -53,11 +84,29 @@ func renderClash(res *compiler.Result) (Blocks, error) {
 	}
 
 	ruleLines := make([]string, 0, len(res.RulesetRefs)+len(res.Rules))
+	appendRule := func(r model.Rule) error {
+		line, ok, err := rw.line(r, r.Action)
+		if err != nil || !ok {
+			return err
+		}
+		ruleLines = append(ruleLines, "- "+yamlDQ(line))
+		return nil
+	}
 	for i, rs := range res.RulesetRefs {
+		if rs.Inline {
+			for _, r := range rs.Rules {
+				if err := appendRule(r); err != nil {
+					return Blocks{}, err
+				}
+			}
+			continue
+		}
 		ruleLines = append(ruleLines, "- "+yamlDQ("RULE-SET,"+providerNames[i]+","+rs.Action))
 	}
 	for _, r := range res.Rules {
-		ruleLines = append(ruleLines, "- "+yamlDQ(ruleToClashString(r)))
+		if err := appendRule(r); err != nil {
+			return Blocks{}, err
+		}
 	}
 
 	return Blocks{
@@ -68,6 +117,34 @@ func renderClash(res *compiler.Result) (Blocks, error) {
 	}, nil
 }
 
+// renderClashDialerRelay expresses a relay group the way mihomo does after
+// dropping type: relay: every hop after the entry is re-declared as
+// "<GROUP>/<HOP>" with dialer-proxy set to the previous hop, and the group
+// becomes a select over the exit copy (see relayHopCopies for the
+// restrictions). It returns the copies' lines and the exit copy's name.
+func renderClashDialerRelay(g model.Group, proxyByID map[string]model.Proxy, proxyNames map[string]string, usedNames map[string]struct{}) ([]string, string, error) {
+	copies, err := relayHopCopies(TargetClash, g, proxyByID, usedNames)
+	if err != nil {
+		return nil, "", err
+	}
+	prev, err := clashMemberName(g.Members[0], proxyNames)
+	if err != nil {
+		return nil, "", err
+	}
+
+	lines := make([]string, 0, len(copies)*7)
+	for _, p := range copies {
+		pl, err := renderClashProxy(p, proxyNames)
+		if err != nil {
+			return nil, "", err
+		}
+		lines = append(lines, pl...)
+		lines = append(lines, "  dialer-proxy: "+yamlDQ(prev))
+		prev = p.Name
+	}
+	return lines, prev, nil
+}
+
 func renderClashProxy(p model.Proxy, proxyNames map[string]string) ([]string, error) {
 	lines := []string{"- name: " + yamlDQ(p.Name)}
 	switch p.Type {
@@ -128,12 +205,14 @@ func renderClashProxy(p model.Proxy, proxyNames map[string]string) ([]string, er
 		if !ok {
 			return nil, &RenderError{AppError: model.AppError{
 				Code:    "CHAIN_PROXY_NOT_FOUND",
-				Message: "链式代理引用的订阅节点不存在",
+				Message: "链式代理引用的节点不存在",
 				Stage:   "render",
 				Snippet: p.ViaProxyID,
 			}}
 		}
 		lines = append(lines, "  dialer-proxy: "+yamlDQ(viaName))
+	} else if p.ViaGroup != "" {
+		lines = append(lines, "  dialer-proxy: "+yamlDQ(p.ViaGroup))
 	}
 	return lines, nil
 }
@@ -164,16 +243,15 @@ func yamlDQ(s string) string {
 }
 
 func renderClashRuleProviders(refs []compiler.RulesetRef) (block string, providerNames []string, err error) {
-	// Keep YAML valid even when the profile does not define any rulesets.
-	if len(refs) == 0 {
-		return "{}", nil, nil
-	}
-
 	used := make(map[string]int, len(refs))
 	providerNames = make([]string, len(refs))
 	lines := make([]string, 0, len(refs)*6)
 
 	for i, rs := range refs {
+		// Inline rulesets are expanded into rules and need no provider.
+		if rs.Inline {
+			continue
+		}
 		if strings.TrimSpace(rs.URL) == "" {
 			return "", nil, &RenderError{
 				AppError: model.AppError{
@@ -198,15 +276,32 @@ func renderClashRuleProviders(refs []compiler.RulesetRef) (block string, provide
 		name := clashRuleProviderName(rs.URL, used)
 		providerNames[i] = name
 
+		behavior := rs.Behavior
+		if behavior == "" {
+			behavior = rules.BehaviorClassical
+		}
+		format := rs.Format
+		if format == "" {
+			format = rules.FormatText
+		}
+		interval := rs.IntervalSec
+		if interval <= 0 {
+			interval = 86400
+		}
+
 		// Minimal provider config per https://wiki.metacubex.one/config/rule-providers/.
 		lines = append(lines, name+":")
 		lines = append(lines, "  type: http")
-		lines = append(lines, "  behavior: classical")
+		lines = append(lines, "  behavior: "+behavior)
 		lines = append(lines, "  url: "+yamlDQ(rs.URL))
-		lines = append(lines, "  interval: 86400")
-		lines = append(lines, "  format: text")
+		lines = append(lines, "  interval: "+strconv.Itoa(interval))
+		lines = append(lines, "  format: "+format)
 	}
 
+	// Keep YAML valid even when the profile does not define any remote rulesets.
+	if len(lines) == 0 {
+		return "{}", providerNames, nil
+	}
 	return strings.Join(lines, "\n"), providerNames, nil
 }
 
@@ -257,3 +352,27 @@ func sanitizeClashRuleProviderName(s string) string {
 	}
 	return out
 }
+
+// clashGroupOptionLines renders group attributes (mihomo/Stash keys) in a fixed order.
+func clashGroupOptionLines(o model.GroupOptions) []string {
+	lines := make([]string, 0, 6)
+	if o.HasLazy {
+		lines = append(lines, "  lazy: "+strconv.FormatBool(o.Lazy))
+	}
+	if o.TimeoutMS > 0 {
+		lines = append(lines, "  timeout: "+strconv.Itoa(o.TimeoutMS))
+	}
+	if o.DisableUDP {
+		lines = append(lines, "  disable-udp: true")
+	}
+	if o.IncludeAllProviders {
+		lines = append(lines, "  include-all-providers: true")
+	}
+	if o.Hidden {
+		lines = append(lines, "  hidden: true")
+	}
+	if o.Icon != "" {
+		lines = append(lines, "  icon: "+yamlDQ(o.Icon))
+	}
+	return lines
+}
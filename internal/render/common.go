@@ -4,31 +4,10 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/John-Robertt/subconverter-go/internal/compiler"
 	"github.com/John-Robertt/subconverter-go/internal/model"
 )
 
-func ruleToClashString(r model.Rule) string {
-	if r.Type == "MATCH" {
-		return fmt.Sprintf("MATCH,%s", r.Action)
-	}
-	if (r.Type == "IP-CIDR" || r.Type == "IP-CIDR6") && r.NoResolve {
-		return fmt.Sprintf("%s,%s,%s,no-resolve", r.Type, r.Value, r.Action)
-	}
-	return fmt.Sprintf("%s,%s,%s", r.Type, r.Value, r.Action)
-}
-
-func ruleToSurgeString(r model.Rule) string {
-	typ := r.Type
-	if typ == "MATCH" {
-		typ = "FINAL"
-		return fmt.Sprintf("%s,%s", typ, r.Action)
-	}
-	if (r.Type == "IP-CIDR" || r.Type == "IP-CIDR6") && r.NoResolve {
-		return fmt.Sprintf("%s,%s,%s,no-resolve", typ, r.Value, r.Action)
-	}
-	return fmt.Sprintf("%s,%s,%s", typ, r.Value, r.Action)
-}
-
 // parseSSObfsPlugin validates and extracts the v1 supported ss plugin.
 //
 // Supported: simple-obfs / obfs-local
@@ -95,3 +74,84 @@ func invalidMemberRefError(kind string, value string) error {
 		},
 	}
 }
+
+// relayHopCopies validates a relay group for targets that express it by
+// nesting (Surge/Shadowrocket underlying-proxy, mihomo dialer-proxy) and
+// returns the copies "<GROUP>/<HOP>" of hops 2..N, in order. Each copy dials
+// through the previous hop; the entry hop is used as is.
+// Only the entry hop may be a policy group (a group has no per-proxy dialer),
+// and later hops must not be chained proxies: their own via would have to be
+// replaced by the previous hop, bypassing their egress.
+func relayHopCopies(target Target, g model.Group, proxyByID map[string]model.Proxy, usedNames map[string]struct{}) ([]model.Proxy, error) {
+	if len(g.Members) < 2 {
+		return nil, &RenderError{AppError: model.AppError{
+			Code:    "INVALID_ARGUMENT",
+			Message: "relay 组至少需要 2 个跳点",
+			Stage:   "render",
+			Snippet: g.Name,
+		}}
+	}
+	copies := make([]model.Proxy, 0, len(g.Members)-1)
+	for _, hop := range g.Members[1:] {
+		if hop.Kind != model.MemberRefProxy {
+			return nil, &RenderError{AppError: model.AppError{
+				Code:    "UNSUPPORTED_TARGET_FEATURE",
+				Message: fmt.Sprintf("target=%s 的 relay 组只有第一跳可以是策略组", target),
+				Stage:   "render",
+				Snippet: g.Name + ": " + hop.Value,
+			}}
+		}
+		p, ok := proxyByID[hop.Value]
+		if !ok {
+			return nil, missingProxyRefError(hop.Value)
+		}
+		if p.ViaProxyID != "" || p.ViaGroup != "" {
+			return nil, &RenderError{AppError: model.AppError{
+				Code:    "UNSUPPORTED_TARGET_FEATURE",
+				Message: fmt.Sprintf("target=%s 的 relay 组中，第一跳之后不能是链式派生节点", target),
+				Stage:   "render",
+				Snippet: g.Name + ": " + p.Name,
+				Hint:    "a hop dials through the previous hop, which would replace the proxy's own chain; use it as the entry hop or relay the original node",
+			}}
+		}
+		p.Name = g.Name + "/" + p.Name
+		if _, ok := usedNames[p.Name]; ok {
+			return nil, &RenderError{AppError: model.AppError{
+				Code:    "PROFILE_VALIDATE_ERROR",
+				Message: fmt.Sprintf("relay 展开的节点名与现有名称冲突：%s", p.Name),
+				Stage:   "render",
+				Snippet: g.Name,
+			}}
+		}
+		usedNames[p.Name] = struct{}{}
+		copies = append(copies, p)
+	}
+	return copies, nil
+}
+
+// rejectRelayGroups fails for targets without a relay/chain group syntax.
+func rejectRelayGroups(target Target, groups []model.Group) error {
+	for _, g := range groups {
+		if g.Type == "relay" {
+			return &RenderError{AppError: model.AppError{
+				Code:    "UNSUPPORTED_TARGET_FEATURE",
+				Message: fmt.Sprintf("target=%s 当前不支持 relay 策略组", target),
+				Stage:   "render",
+				Snippet: g.Name,
+			}}
+		}
+	}
+	return nil
+}
+
+func unsupportedRulesetError(target Target, rs compiler.RulesetRef, what string) error {
+	return &RenderError{
+		AppError: model.AppError{
+			Code:    "UNSUPPORTED_TARGET_FEATURE",
+			Message: fmt.Sprintf("target=%s 不支持 ruleset %s", target, what),
+			Stage:   "render",
+			Snippet: rs.Raw,
+			Hint:    "use inline: to let the server expand the ruleset, or drop the option for this target",
+		},
+	}
+}
@@ -0,0 +1,111 @@
+package render
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/compiler"
+	"github.com/John-Robertt/subconverter-go/internal/model"
+)
+
+// renderExtras fills the blocks behind the optional anchors: inline name
+// lists (written the way the target's group lines reference them) and the
+// sections generated from profile hosts / mitm_hostnames / proxy_providers.
+func renderExtras(target Target, res *compiler.Result, blocks *Blocks) error {
+	proxyRep := make(map[string]string, len(res.Proxies))
+	for _, p := range res.Proxies {
+		var (
+			rep string
+			err error
+		)
+		switch target {
+		case TargetClash:
+			rep = p.Name
+		case TargetQuanx:
+			rep, err = quanxTag(p.Name)
+		default:
+			rep, err = surgeProxyName(p.Name)
+		}
+		if err != nil {
+			return err
+		}
+		proxyRep[p.ID] = rep
+	}
+	memberName := func(m model.MemberRef) (string, error) {
+		switch target {
+		case TargetClash:
+			name, err := clashMemberName(m, proxyRep)
+			return yamlDQ(name), err
+		case TargetQuanx:
+			return quanxMemberName(m, proxyRep)
+		default:
+			return surgeMemberName(m, proxyRep)
+		}
+	}
+
+	names := make([]string, 0, len(res.Proxies))
+	for _, p := range res.Proxies {
+		name, err := memberName(model.MemberRef{Kind: model.MemberRefProxy, Value: p.ID})
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	blocks.ProxyNames = strings.Join(names, ", ")
+
+	blocks.GroupMembers = make(map[string]string, len(res.Groups))
+	for _, g := range res.Groups {
+		members := make([]string, 0, len(g.Members))
+		for _, m := range g.Members {
+			name, err := memberName(m)
+			if err != nil {
+				return err
+			}
+			members = append(members, name)
+		}
+		blocks.GroupMembers[g.Name] = strings.Join(members, ", ")
+	}
+
+	blocks.MITMHostnames = strings.Join(res.MITMHostnames, ", ")
+
+	hostLines := make([]string, 0, len(res.Hosts))
+	for _, h := range res.Hosts {
+		switch target {
+		case TargetClash:
+			hostLines = append(hostLines, yamlDQ(h.Domain)+": "+yamlDQ(h.Value))
+		case TargetQuanx:
+			hostLines = append(hostLines, "address=/"+h.Domain+"/"+h.Value)
+		default:
+			hostLines = append(hostLines, h.Domain+" = "+h.Value)
+		}
+	}
+	blocks.Hosts = strings.Join(hostLines, "\n")
+
+	if target == TargetClash {
+		// Keep the YAML mappings valid when the profile defines none.
+		if blocks.Hosts == "" {
+			blocks.Hosts = "{}"
+		}
+		blocks.ProxyProviders = renderClashProxyProviders(res.ProxyProviders)
+	}
+	return nil
+}
+
+func renderClashProxyProviders(providers []model.ProxyProvider) string {
+	if len(providers) == 0 {
+		return "{}"
+	}
+	lines := make([]string, 0, len(providers)*4)
+	for _, p := range providers {
+		interval := p.IntervalSec
+		if interval <= 0 {
+			interval = 3600
+		}
+		// Minimal provider config per https://wiki.metacubex.one/config/proxy-providers/.
+		lines = append(lines, yamlDQ(p.Name)+":")
+		lines = append(lines, "  type: http")
+		lines = append(lines, "  url: "+yamlDQ(p.URL))
+		lines = append(lines, "  interval: "+strconv.Itoa(interval))
+	}
+	return strings.Join(lines, "\n")
+}
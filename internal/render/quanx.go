@@ -7,19 +7,33 @@ import (
 
 	"github.com/John-Robertt/subconverter-go/internal/compiler"
 	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/rules"
 )
 
-func renderQuanx(res *compiler.Result) (Blocks, error) {
+// quanxPolicyTypes maps group types to QuanX [policy] types. QuanX has no
+// consistent hashing, so both load-balance strategies become round-robin.
+var quanxPolicyTypes = map[string]string{
+	"select":       "static",
+	"url-test":     "url-latency-benchmark",
+	"fallback":     "available",
+	"load-balance": "round-robin",
+}
+
+func renderQuanx(res *compiler.Result, rw ruleWriter) (Blocks, error) {
 	for _, p := range res.Proxies {
-		if p.ViaProxyID != "" {
+		if p.ViaProxyID != "" || p.ViaGroup != "" {
 			return Blocks{}, &RenderError{AppError: model.AppError{
 				Code:    "UNSUPPORTED_TARGET_FEATURE",
 				Message: "target=quanx 当前不支持 proxy_chain",
 				Stage:   "render",
+				Hint:    "Quantumult X has no per-server underlying proxy (tls-host only overrides SNI); use target=clash/surge/shadowrocket",
 				Snippet: p.Name,
 			}}
 		}
 	}
+	if err := rejectRelayGroups(TargetQuanx, res.Groups); err != nil {
+		return Blocks{}, err
+	}
 
 	// Precompute representable proxy tags to keep references consistent.
 	proxyTagRep := make(map[string]string, len(res.Proxies))
@@ -65,10 +79,12 @@ func renderQuanx(res *compiler.Result) (Blocks, error) {
 		if err := quanxPolicyNameOK(g.Name); err != nil {
 			return Blocks{}, err
 		}
-		switch g.Type {
-		case "select":
+		policyType, ok := quanxPolicyTypes[g.Type]
+		switch {
+		case ok:
 			var b strings.Builder
-			b.WriteString("static=")
+			b.WriteString(policyType)
+			b.WriteByte('=')
 			b.WriteString(g.Name)
 			for _, m := range g.Members {
 				memberName, err := quanxMemberName(m, proxyTagRep)
@@ -78,25 +94,20 @@ func renderQuanx(res *compiler.Result) (Blocks, error) {
 				b.WriteString(", ")
 				b.WriteString(memberName)
 			}
-			groupLines = append(groupLines, b.String())
-		case "url-test":
-			var b strings.Builder
-			b.WriteString("url-latency-benchmark=")
-			b.WriteString(g.Name)
-			for _, m := range g.Members {
-				memberName, err := quanxMemberName(m, proxyTagRep)
-				if err != nil {
-					return Blocks{}, err
-				}
-				b.WriteString(", ")
-				b.WriteString(memberName)
+			// round-robin has no health check parameters on QuanX.
+			if g.Type == "url-test" || g.Type == "fallback" {
+				b.WriteString(", check-interval=")
+				b.WriteString(strconv.Itoa(g.IntervalSec))
 			}
-			b.WriteString(", check-interval=")
-			b.WriteString(strconv.Itoa(g.IntervalSec))
 			if g.HasTolerance {
 				b.WriteString(", tolerance=")
 				b.WriteString(strconv.Itoa(g.ToleranceMS))
 			}
+			// img-url is the only group attribute QuanX supports.
+			if g.Options.Icon != "" {
+				b.WriteString(", img-url=")
+				b.WriteString(g.Options.Icon)
+			}
 			groupLines = append(groupLines, b.String())
 		default:
 			return Blocks{}, &RenderError{
@@ -114,7 +125,24 @@ func renderQuanx(res *compiler.Result) (Blocks, error) {
 	if len(res.RulesetRefs) > 0 {
 		// Make tags unique even when multiple rulesets share the same action/policy.
 		tagCounts := make(map[string]int, len(res.RulesetRefs))
+		remoteSeen := false
 		for _, rs := range res.RulesetRefs {
+			if rs.Inline {
+				// Expanded rules land in [filter_local], which QuanX evaluates
+				// before every [filter_remote] entry: an inline ruleset after a
+				// remote one would silently jump ahead of it.
+				if remoteSeen {
+					return Blocks{}, &RenderError{AppError: model.AppError{
+						Code:    "UNSUPPORTED_TARGET_FEATURE",
+						Message: "target=quanx 不支持 inline: ruleset 排在远程 ruleset 之后",
+						Stage:   "render",
+						Snippet: rs.Raw,
+						Hint:    "QuanX evaluates [filter_local] before [filter_remote]; list inline: rulesets first, or make this one remote",
+					}}
+				}
+				continue
+			}
+			remoteSeen = true
 			if strings.ContainsAny(rs.URL, "\r\n\x00") || strings.Contains(rs.URL, ",") {
 				return Blocks{}, &RenderError{
 					AppError: model.AppError{
@@ -127,6 +155,15 @@ func renderQuanx(res *compiler.Result) (Blocks, error) {
 				}
 			}
 
+			// [filter_remote] only understands classical text lists; interval has no
+			// per-resource equivalent and is dropped.
+			if rs.Behavior != "" && rs.Behavior != rules.BehaviorClassical {
+				return Blocks{}, unsupportedRulesetError(TargetQuanx, rs, "behavior="+rs.Behavior)
+			}
+			if rs.Format != "" && rs.Format != rules.FormatText {
+				return Blocks{}, unsupportedRulesetError(TargetQuanx, rs, "format="+rs.Format)
+			}
+
 			policy, err := quanxActionName(rs.Action)
 			if err != nil {
 				return Blocks{}, err
@@ -146,13 +183,31 @@ func renderQuanx(res *compiler.Result) (Blocks, error) {
 		}
 	}
 
-	ruleLines := make([]string, 0, len(res.Rules))
-	for _, r := range res.Rules {
+	// Inline rulesets have no [filter_remote] entry: their expanded rules go to
+	// [filter_local] ahead of the profile rules. They all precede the remote
+	// rulesets (checked above), so the profile's ruleset order is kept.
+	localRules := make([]model.Rule, 0, len(res.Rules))
+	for _, rs := range res.RulesetRefs {
+		if rs.Inline {
+			localRules = append(localRules, rs.Rules...)
+		}
+	}
+	localRules = append(localRules, res.Rules...)
+
+	ruleLines := make([]string, 0, len(localRules))
+	for _, r := range localRules {
 		action, err := quanxActionName(r.Action)
 		if err != nil {
 			return Blocks{}, err
 		}
-		ruleLines = append(ruleLines, ruleToQuanxString(r, action))
+		line, ok, err := rw.line(r, action)
+		if err != nil {
+			return Blocks{}, err
+		}
+		if !ok {
+			continue
+		}
+		ruleLines = append(ruleLines, line)
 	}
 
 	return Blocks{
@@ -252,18 +307,3 @@ func quanxActionName(action string) (string, error) {
 	}
 	return action, nil
 }
-
-func ruleToQuanxString(r model.Rule, action string) string {
-	typ := r.Type
-	switch typ {
-	case "MATCH":
-		return fmt.Sprintf("FINAL,%s", action)
-	case "IP-CIDR6":
-		typ = "IP6-CIDR"
-	}
-
-	if (typ == "IP-CIDR" || typ == "IP6-CIDR") && r.NoResolve {
-		return fmt.Sprintf("%s,%s,%s,no-resolve", typ, r.Value, action)
-	}
-	return fmt.Sprintf("%s,%s,%s", typ, r.Value, action)
-}
@@ -1,6 +1,7 @@
 package render
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -23,6 +24,13 @@ type Blocks struct {
 	RuleProviders string // optional: used by Clash rule-providers
 	Rulesets      string // optional: used by targets that support remote ruleset sections (e.g. QuanX)
 	Rules         string
+
+	// Optional anchors (see docs/spec/SPEC_TEMPLATE_ANCHORS.md).
+	ProxyNames     string            // inline: every proxy, comma-separated
+	GroupMembers   map[string]string // inline: group name -> its members, comma-separated
+	MITMHostnames  string            // inline: comma-separated
+	Hosts          string
+	ProxyProviders string // Clash only
 }
 
 type RenderError struct {
@@ -42,7 +50,21 @@ func (e *RenderError) Error() string {
 
 func (e *RenderError) Unwrap() error { return e.Cause }
 
+// Options tunes rendering without changing the compiled IR.
+type Options struct {
+	// UnsupportedRules decides what happens to rules the target cannot express:
+	// UnsupportedRulesFail (default) or UnsupportedRulesDrop.
+	UnsupportedRules string
+	// CollectErrors (diagnostics) keeps rendering past unsupported rules and
+	// returns all of them (errors.Join, in rule order) instead of the first.
+	CollectErrors bool
+}
+
 func Render(target Target, res *compiler.Result) (Blocks, error) {
+	return RenderWithOptions(target, res, Options{})
+}
+
+func RenderWithOptions(target Target, res *compiler.Result, opt Options) (Blocks, error) {
 	if res == nil {
 		return Blocks{}, &RenderError{
 			AppError: model.AppError{
@@ -55,15 +77,35 @@ func Render(target Target, res *compiler.Result) (Blocks, error) {
 	if err := validateRenderInput(res); err != nil {
 		return Blocks{}, err
 	}
+	switch opt.UnsupportedRules {
+	case "", UnsupportedRulesFail, UnsupportedRulesDrop:
+	default:
+		return Blocks{}, &RenderError{
+			AppError: model.AppError{
+				Code:    "INVALID_ARGUMENT",
+				Message: fmt.Sprintf("不支持的 unsupported_rules：%s", opt.UnsupportedRules),
+				Stage:   "render",
+			},
+		}
+	}
+	rw := newRuleWriter(target, opt.UnsupportedRules)
+	var collected []error
+	if opt.CollectErrors {
+		rw.collected = &collected
+	}
+	var (
+		blocks Blocks
+		err    error
+	)
 	switch target {
 	case TargetClash:
-		return renderClash(res)
+		blocks, err = renderClash(res, rw)
 	case TargetSurge:
-		return renderSurgeLike(res, true)
+		blocks, err = renderSurgeLike(res, true, rw)
 	case TargetShadowrocket:
-		return renderSurgeLike(res, false)
+		blocks, err = renderSurgeLike(res, false, rw)
 	case TargetQuanx:
-		return renderQuanx(res)
+		blocks, err = renderQuanx(res, rw)
 	default:
 		return Blocks{}, &RenderError{
 			AppError: model.AppError{
@@ -73,6 +115,16 @@ func Render(target Target, res *compiler.Result) (Blocks, error) {
 			},
 		}
 	}
+	if len(collected) > 0 {
+		return Blocks{}, errors.Join(append(collected, err)...)
+	}
+	if err != nil {
+		return Blocks{}, err
+	}
+	if err := renderExtras(target, res, &blocks); err != nil {
+		return Blocks{}, err
+	}
+	return blocks, nil
 }
 
 func validateRenderInput(res *compiler.Result) error {
@@ -104,30 +156,64 @@ func validateRenderInput(res *compiler.Result) error {
 		seenProxyIDs[p.ID] = struct{}{}
 		proxyByID[p.ID] = p
 	}
+	groupNames := make(map[string]struct{}, len(res.Groups))
+	for _, g := range res.Groups {
+		groupNames[g.Name] = struct{}{}
+	}
 	for _, p := range res.Proxies {
+		if p.ViaGroup != "" {
+			if p.ViaProxyID != "" {
+				return &RenderError{
+					AppError: model.AppError{
+						Code:    "INVALID_ARGUMENT",
+						Message: "render input 的 ViaProxyID 与 ViaGroup 不能同时设置",
+						Stage:   "render",
+						Snippet: p.ID,
+					},
+				}
+			}
+			if _, ok := groupNames[p.ViaGroup]; !ok {
+				return &RenderError{
+					AppError: model.AppError{
+						Code:    "CHAIN_GROUP_NOT_FOUND",
+						Message: "render input 的 ViaGroup 引用不存在",
+						Stage:   "render",
+						Snippet: p.ViaGroup,
+					},
+				}
+			}
+			continue
+		}
 		if strings.TrimSpace(p.ViaProxyID) == "" {
 			continue
 		}
-		via, ok := proxyByID[p.ViaProxyID]
-		if !ok {
-			return &RenderError{
-				AppError: model.AppError{
-					Code:    "CHAIN_PROXY_NOT_FOUND",
-					Message: "render input 的 ViaProxyID 引用不存在",
-					Stage:   "render",
-					Snippet: p.ViaProxyID,
-				},
+		// Follow the hops: each must exist and the chain must end at a
+		// proxy dialed directly or through a group.
+		seen := map[string]struct{}{p.ID: {}}
+		for id := p.ViaProxyID; id != ""; {
+			via, ok := proxyByID[id]
+			if !ok {
+				return &RenderError{
+					AppError: model.AppError{
+						Code:    "CHAIN_PROXY_NOT_FOUND",
+						Message: "render input 的 ViaProxyID 引用不存在",
+						Stage:   "render",
+						Snippet: id,
+					},
+				}
 			}
-		}
-		if strings.TrimSpace(via.ViaProxyID) != "" {
-			return &RenderError{
-				AppError: model.AppError{
-					Code:    "INVALID_ARGUMENT",
-					Message: "render input 的 ViaProxyID 只能引用原始订阅节点",
-					Stage:   "render",
-					Snippet: p.ID,
-				},
+			if _, ok := seen[id]; ok {
+				return &RenderError{
+					AppError: model.AppError{
+						Code:    "CHAIN_CYCLE",
+						Message: "render input 的 ViaProxyID 存在循环",
+						Stage:   "render",
+						Snippet: p.ID,
+					},
+				}
 			}
+			seen[id] = struct{}{}
+			id = via.ViaProxyID
 		}
 	}
 	return nil
@@ -2,6 +2,7 @@ package render
 
 import (
 	"errors"
+	"slices"
 	"strings"
 	"testing"
 
@@ -255,6 +256,45 @@ func TestRender_Surge_DerivedHTTPProxyUsesUnderlyingProxy(t *testing.T) {
 	}
 }
 
+func TestRender_MultiHopAndDialerGroupChains(t *testing.T) {
+	res := &compiler.Result{
+		Proxies: []model.Proxy{
+			{ID: "sub1", Type: "ss", Name: "HK", Server: "hk.example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+			{ID: "d1", Type: "http", Name: "HOP via POOL", Server: "hop.example.com", Port: 8080, ViaGroup: "POOL"},
+			{ID: "d2", Type: "socks5", Name: "EGRESS via HOP via POOL", Server: "egress.example.com", Port: 1080, ViaProxyID: "d1"},
+		},
+		Groups: []model.Group{
+			{Name: "POOL", Type: "select", Members: []model.MemberRef{proxyRef("sub1")}},
+			{Name: "CHAIN-EGRESS", Type: "select", Members: []model.MemberRef{proxyRef("d2")}},
+		},
+		Rules: []model.Rule{{Type: "MATCH", Action: "CHAIN-EGRESS"}},
+	}
+
+	clash, err := Render(TargetClash, res)
+	if err != nil {
+		t.Fatalf("clash: unexpected error: %v", err)
+	}
+	if !strings.Contains(clash.Proxies, `dialer-proxy: "POOL"`) || !strings.Contains(clash.Proxies, `dialer-proxy: "HOP via POOL"`) {
+		t.Fatalf("clash proxies missing chain hops, got:\n%s", clash.Proxies)
+	}
+
+	surge, err := Render(TargetSurge, res)
+	if err != nil {
+		t.Fatalf("surge: unexpected error: %v", err)
+	}
+	if !strings.Contains(surge.Proxies, "HOP via POOL = http, hop.example.com, 8080, underlying-proxy=POOL") ||
+		!strings.Contains(surge.Proxies, "EGRESS via HOP via POOL = socks5, egress.example.com, 1080, underlying-proxy=HOP via POOL") {
+		t.Fatalf("surge proxies missing chain hops, got:\n%s", surge.Proxies)
+	}
+
+	res.Proxies[1].ViaGroup = "MISSING"
+	_, err = Render(TargetClash, res)
+	var re *RenderError
+	if !errors.As(err, &re) || re.AppError.Code != "CHAIN_GROUP_NOT_FOUND" {
+		t.Fatalf("expected CHAIN_GROUP_NOT_FOUND, got %T: %v", err, err)
+	}
+}
+
 func TestRender_Surge_RejectsCommaInProxyCredentials(t *testing.T) {
 	res := &compiler.Result{
 		Proxies: []model.Proxy{
@@ -274,23 +314,26 @@ func TestRender_Surge_RejectsCommaInProxyCredentials(t *testing.T) {
 	}
 }
 
-func TestRender_Shadowrocket_RejectsProxyChain(t *testing.T) {
+func TestRender_Shadowrocket_ProxyChainUsesUnderlyingProxy(t *testing.T) {
 	res := &compiler.Result{
 		Proxies: []model.Proxy{
 			{ID: "sub1", Type: "ss", Name: "HK", Server: "hk.example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
 			{ID: "d1", Type: "http", Name: "CORP-HTTP via HK", Server: "proxy.example.com", Port: 8080, ViaProxyID: "sub1"},
+			{ID: "d2", Type: "socks5", Name: "EGRESS", Server: "egress.example.com", Port: 1080, ViaGroup: "CHAIN-CORP-HTTP"},
 		},
 		Groups: []model.Group{{Name: "CHAIN-CORP-HTTP", Type: "select", Members: []model.MemberRef{proxyRef("d1")}}},
 		Rules:  []model.Rule{{Type: "MATCH", Action: "CHAIN-CORP-HTTP"}},
 	}
 
-	_, err := Render(TargetShadowrocket, res)
-	var re *RenderError
-	if !errors.As(err, &re) {
-		t.Fatalf("expected *RenderError, got %T: %v", err, err)
+	blocks, err := Render(TargetShadowrocket, res)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
 	}
-	if re.AppError.Code != "UNSUPPORTED_TARGET_FEATURE" {
-		t.Fatalf("code=%q, want=%q", re.AppError.Code, "UNSUPPORTED_TARGET_FEATURE")
+	if !strings.Contains(blocks.Proxies, "CORP-HTTP via HK = http, proxy.example.com, 8080, underlying-proxy=HK") {
+		t.Fatalf("chained proxy should reference its hop, got:\n%s", blocks.Proxies)
+	}
+	if !strings.Contains(blocks.Proxies, "EGRESS = socks5, egress.example.com, 1080, underlying-proxy=CHAIN-CORP-HTTP") {
+		t.Fatalf("dialer group chain should reference the group, got:\n%s", blocks.Proxies)
 	}
 }
 
@@ -321,3 +364,535 @@ func proxyRef(id string) model.MemberRef {
 func builtinRef(name string) model.MemberRef {
 	return model.MemberRef{Kind: model.MemberRefBuiltin, Value: name}
 }
+
+func TestRender_InlineRulesetExpandedInPlace(t *testing.T) {
+	res := &compiler.Result{
+		Proxies: []model.Proxy{
+			{ID: "p1", Type: "ss", Name: "n1", Server: "example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+		},
+		Groups: []model.Group{
+			{Name: "PROXY", Type: "select", Members: []model.MemberRef{proxyRef("p1")}},
+		},
+		RulesetRefs: []compiler.RulesetRef{
+			{Raw: "DIRECT,https://example.com/a.list", Action: "DIRECT", URL: "https://example.com/a.list"},
+			{Raw: "PROXY,inline:https://example.com/b.list", Action: "PROXY", URL: "https://example.com/b.list", Inline: true, Rules: []model.Rule{
+				{Type: "DOMAIN-SUFFIX", Value: "example.com", Action: "PROXY"},
+			}},
+		},
+		Rules: []model.Rule{
+			{Type: "MATCH", Action: "DIRECT"},
+		},
+	}
+
+	clash, err := Render(TargetClash, res)
+	if err != nil {
+		t.Fatalf("clash: unexpected error: %v", err)
+	}
+	if strings.Contains(clash.RuleProviders, "b.list") {
+		t.Fatalf("inline ruleset should not become a provider, got:\n%s", clash.RuleProviders)
+	}
+	wantClash := "- \"RULE-SET,a,DIRECT\"\n- \"DOMAIN-SUFFIX,example.com,PROXY\"\n- \"MATCH,DIRECT\""
+	if clash.Rules != wantClash {
+		t.Fatalf("clash rules=\n%s\nwant=\n%s", clash.Rules, wantClash)
+	}
+
+	surge, err := Render(TargetSurge, res)
+	if err != nil {
+		t.Fatalf("surge: unexpected error: %v", err)
+	}
+	wantSurge := "RULE-SET,https://example.com/a.list,DIRECT\nDOMAIN-SUFFIX,example.com,PROXY\nFINAL,DIRECT"
+	if surge.Rules != wantSurge {
+		t.Fatalf("surge rules=\n%s\nwant=\n%s", surge.Rules, wantSurge)
+	}
+
+	// QuanX evaluates [filter_local] before [filter_remote], so an inline
+	// ruleset after a remote one cannot keep its place.
+	_, err = Render(TargetQuanx, res)
+	var re *RenderError
+	if !errors.As(err, &re) || re.AppError.Code != "UNSUPPORTED_TARGET_FEATURE" || re.AppError.Snippet != res.RulesetRefs[1].Raw {
+		t.Fatalf("quanx: expected UNSUPPORTED_TARGET_FEATURE for inline after remote, got %T: %v", err, err)
+	}
+
+	res.RulesetRefs[0], res.RulesetRefs[1] = res.RulesetRefs[1], res.RulesetRefs[0]
+	quanx, err := Render(TargetQuanx, res)
+	if err != nil {
+		t.Fatalf("quanx: unexpected error: %v", err)
+	}
+	if quanx.Rulesets != "https://example.com/a.list, tag=DIRECT, force-policy=direct, enabled=true" {
+		t.Fatalf("quanx filter_remote=\n%s", quanx.Rulesets)
+	}
+	if strings.Contains(quanx.Rulesets, "b.list") {
+		t.Fatalf("inline ruleset should not be in filter_remote, got:\n%s", quanx.Rulesets)
+	}
+	if !strings.HasPrefix(quanx.Rules, "HOST-SUFFIX,example.com,PROXY\n") {
+		t.Fatalf("inline rules should lead filter_local, got:\n%s", quanx.Rules)
+	}
+}
+
+// The behavior=domain contract: the server never fetches or rewrites a
+// remote domain list, so Clash and Surge-like targets reference the very
+// same URL and the list must be written in a syntax every consuming client
+// reads (see SPEC_PROFILE_YAML 2.7). Mihomo-only "+.example.com" entries do
+// not match on Surge; use `targets:` with per-target URLs or `inline:`.
+func TestRender_DomainSetReferencesRulesetURLVerbatim(t *testing.T) {
+	const listURL = "https://example.com/surge-domains.txt"
+	res := &compiler.Result{
+		Groups: []model.Group{{Name: "PROXY", Type: "select", Members: []model.MemberRef{{Kind: model.MemberRefBuiltin, Value: "DIRECT"}}}},
+		RulesetRefs: []compiler.RulesetRef{
+			{Raw: "PROXY," + listURL + ",behavior=domain", Action: "PROXY", URL: listURL, Behavior: "domain"},
+		},
+		Rules: []model.Rule{{Type: "MATCH", Action: "DIRECT"}},
+	}
+	clash, err := Render(TargetClash, res)
+	if err != nil {
+		t.Fatalf("clash: unexpected error: %v", err)
+	}
+	if !strings.Contains(clash.RuleProviders, `url: "`+listURL+`"`) {
+		t.Fatalf("clash provider should reference the list URL, got:\n%s", clash.RuleProviders)
+	}
+	for _, target := range []Target{TargetSurge, TargetShadowrocket} {
+		out, err := Render(target, res)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", target, err)
+		}
+		if want := "DOMAIN-SET," + listURL + ",PROXY\n"; !strings.HasPrefix(out.Rules, want) {
+			t.Fatalf("%s: want %q, got:\n%s", target, want, out.Rules)
+		}
+	}
+}
+
+func TestRender_RulesetBehaviorFormatInterval(t *testing.T) {
+	res := &compiler.Result{
+		Proxies: []model.Proxy{
+			{ID: "p1", Type: "ss", Name: "n1", Server: "example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+		},
+		Groups: []model.Group{
+			{Name: "PROXY", Type: "select", Members: []model.MemberRef{proxyRef("p1")}},
+		},
+		RulesetRefs: []compiler.RulesetRef{
+			{Raw: "PROXY,https://example.com/gfw.txt,behavior=domain,interval=3600", Action: "PROXY", URL: "https://example.com/gfw.txt", Behavior: "domain", IntervalSec: 3600},
+		},
+		Rules: []model.Rule{
+			{Type: "MATCH", Action: "DIRECT"},
+		},
+	}
+
+	clash, err := Render(TargetClash, res)
+	if err != nil {
+		t.Fatalf("clash: unexpected error: %v", err)
+	}
+	for _, want := range []string{"  behavior: domain", "  interval: 3600", "  format: text"} {
+		if !strings.Contains(clash.RuleProviders, want) {
+			t.Fatalf("clash providers missing %q, got:\n%s", want, clash.RuleProviders)
+		}
+	}
+
+	surge, err := Render(TargetSurge, res)
+	if err != nil {
+		t.Fatalf("surge: unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(surge.Rules, "DOMAIN-SET,https://example.com/gfw.txt,PROXY,update-interval=3600\n") {
+		t.Fatalf("surge should use DOMAIN-SET, got:\n%s", surge.Rules)
+	}
+
+	sr, err := Render(TargetShadowrocket, res)
+	if err != nil {
+		t.Fatalf("shadowrocket: unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(sr.Rules, "DOMAIN-SET,https://example.com/gfw.txt,PROXY\n") {
+		t.Fatalf("shadowrocket should use DOMAIN-SET without interval, got:\n%s", sr.Rules)
+	}
+
+	_, err = Render(TargetQuanx, res)
+	var re *RenderError
+	if !errors.As(err, &re) || re.AppError.Code != "UNSUPPORTED_TARGET_FEATURE" {
+		t.Fatalf("quanx: expected UNSUPPORTED_TARGET_FEATURE, got %T: %v", err, err)
+	}
+
+	res.RulesetRefs[0].Behavior = "ipcidr"
+	res.RulesetRefs[0].Format = "mrs"
+	_, err = Render(TargetSurge, res)
+	if !errors.As(err, &re) || re.AppError.Code != "UNSUPPORTED_TARGET_FEATURE" {
+		t.Fatalf("surge: expected UNSUPPORTED_TARGET_FEATURE for mrs, got %T: %v", err, err)
+	}
+}
+
+func TestRender_RuleTypeCapabilities(t *testing.T) {
+	newRes := func(r model.Rule) *compiler.Result {
+		return &compiler.Result{
+			Proxies: []model.Proxy{
+				{ID: "p1", Type: "ss", Name: "n1", Server: "example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+			},
+			Groups: []model.Group{
+				{Name: "PROXY", Type: "select", Members: []model.MemberRef{proxyRef("p1")}},
+			},
+			Rules: []model.Rule{r, {Type: "MATCH", Action: "DIRECT"}},
+		}
+	}
+	logical := model.Rule{Type: "AND", Action: "PROXY", Sub: []model.Rule{
+		{Type: "DOMAIN-SUFFIX", Value: "example.com"},
+		{Type: "NETWORK", Value: "UDP"},
+	}}
+
+	clash, err := Render(TargetClash, newRes(logical))
+	if err != nil {
+		t.Fatalf("clash: unexpected error: %v", err)
+	}
+	if !strings.Contains(clash.Rules, `"AND,((DOMAIN-SUFFIX,example.com),(NETWORK,UDP)),PROXY"`) {
+		t.Fatalf("clash logical rule, got:\n%s", clash.Rules)
+	}
+
+	surge, err := Render(TargetSurge, newRes(logical))
+	if err != nil {
+		t.Fatalf("surge: unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(surge.Rules, "AND,((DOMAIN-SUFFIX,example.com),(PROTOCOL,UDP)),PROXY\n") {
+		t.Fatalf("surge should translate NETWORK -> PROTOCOL, got:\n%s", surge.Rules)
+	}
+
+	surge, err = Render(TargetSurge, newRes(model.Rule{Type: "DST-PORT", Value: "443", Action: "PROXY"}))
+	if err != nil {
+		t.Fatalf("surge: unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(surge.Rules, "DEST-PORT,443,PROXY\n") {
+		t.Fatalf("surge should translate DST-PORT -> DEST-PORT, got:\n%s", surge.Rules)
+	}
+
+	for _, tc := range []struct {
+		target Target
+		rule   model.Rule
+	}{
+		{TargetShadowrocket, logical}, // NETWORK operand is not supported
+		{TargetQuanx, model.Rule{Type: "DST-PORT", Value: "443", Action: "PROXY"}},
+		{TargetSurge, model.Rule{Type: "GEOSITE", Value: "google", Action: "PROXY"}},
+	} {
+		_, err := Render(tc.target, newRes(tc.rule))
+		var re *RenderError
+		if !errors.As(err, &re) || re.AppError.Code != "UNSUPPORTED_RULE_TYPE" {
+			t.Fatalf("target=%s rule=%s: expected UNSUPPORTED_RULE_TYPE, got %T: %v", tc.target, tc.rule.Type, err, err)
+		}
+	}
+}
+
+func TestRender_RuleDialectAndUnsupportedPolicy(t *testing.T) {
+	res := &compiler.Result{
+		Proxies: []model.Proxy{
+			{ID: "p1", Type: "ss", Name: "n1", Server: "example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+		},
+		Groups: []model.Group{
+			{Name: "PROXY", Type: "select", Members: []model.MemberRef{proxyRef("p1"), builtinRef("DIRECT")}},
+		},
+		Rules: []model.Rule{
+			{Type: "DOMAIN", Value: "a.example.com", Action: "PROXY"},
+			{Type: "DOMAIN-KEYWORD", Value: "google", Action: "PROXY"},
+			{Type: "GEOIP", Value: "CN", Action: "DIRECT", NoResolve: true},
+			{Type: "PROCESS-NAME", Value: "curl", Action: "DIRECT"},
+			{Type: "MATCH", Action: "PROXY"},
+		},
+	}
+
+	surge, err := Render(TargetSurge, res)
+	if err != nil {
+		t.Fatalf("surge: unexpected error: %v", err)
+	}
+	if !strings.Contains(surge.Rules, "GEOIP,CN,DIRECT,no-resolve\n") {
+		t.Fatalf("surge should keep no-resolve after ACTION, got:\n%s", surge.Rules)
+	}
+
+	// QuanX can express neither PROCESS-NAME nor GEOIP no-resolve: fail by default.
+	_, err = Render(TargetQuanx, res)
+	var re *RenderError
+	if !errors.As(err, &re) || re.AppError.Code != "UNSUPPORTED_RULE_TYPE" {
+		t.Fatalf("quanx: expected UNSUPPORTED_RULE_TYPE, got %T: %v", err, err)
+	}
+	if re.AppError.Snippet != "GEOIP,CN,DIRECT,no-resolve" {
+		t.Fatalf("snippet=%q", re.AppError.Snippet)
+	}
+
+	quanx, err := RenderWithOptions(TargetQuanx, res, Options{UnsupportedRules: UnsupportedRulesDrop})
+	if err != nil {
+		t.Fatalf("quanx drop: unexpected error: %v", err)
+	}
+	want := "HOST,a.example.com,PROXY\nHOST-KEYWORD,google,PROXY\nFINAL,PROXY"
+	if quanx.Rules != want {
+		t.Fatalf("quanx rules=\n%s\nwant=\n%s", quanx.Rules, want)
+	}
+
+	// Diagnostics reports every unsupported rule, in rule order.
+	_, err = RenderWithOptions(TargetQuanx, res, Options{CollectErrors: true})
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != 2 {
+		t.Fatalf("quanx collect: expected 2 joined errors, got %T: %v", err, err)
+	}
+	if !errors.As(joined.Unwrap()[1], &re) || re.AppError.Snippet != "PROCESS-NAME,curl,DIRECT" {
+		t.Fatalf("quanx collect: unexpected second error: %v", joined.Unwrap()[1])
+	}
+
+	_, err = RenderWithOptions(TargetClash, res, Options{UnsupportedRules: "ignore"})
+	if !errors.As(err, &re) || re.AppError.Code != "INVALID_ARGUMENT" {
+		t.Fatalf("expected INVALID_ARGUMENT for unknown policy, got %T: %v", err, err)
+	}
+}
+
+func TestRender_FallbackAndLoadBalanceGroups(t *testing.T) {
+	res := &compiler.Result{
+		Proxies: []model.Proxy{
+			{ID: "p1", Type: "ss", Name: "n1", Server: "example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+			{ID: "p2", Type: "ss", Name: "n2", Server: "example.org", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+		},
+		Groups: []model.Group{
+			{Name: "FB", Type: "fallback", Members: []model.MemberRef{proxyRef("p1"), proxyRef("p2")}, TestURL: "http://t.example/204", IntervalSec: 300},
+			{Name: "LB", Type: "load-balance", Members: []model.MemberRef{proxyRef("p1"), proxyRef("p2")}, TestURL: "http://t.example/204", IntervalSec: 600, Strategy: model.LoadBalanceConsistentHashing},
+			{Name: "RR", Type: "load-balance", Members: []model.MemberRef{proxyRef("p1")}, TestURL: "http://t.example/204", IntervalSec: 600, Strategy: model.LoadBalanceRoundRobin},
+		},
+		Rules: []model.Rule{{Type: "MATCH", Action: "FB"}},
+	}
+
+	clash, err := Render(TargetClash, res)
+	if err != nil {
+		t.Fatalf("clash: unexpected error: %v", err)
+	}
+	wantClash := `- name: "LB"
+  type: "load-balance"
+  proxies:
+    - "n1"
+    - "n2"
+  url: "http://t.example/204"
+  interval: 600
+  strategy: "consistent-hashing"`
+	if !strings.Contains(clash.Groups, wantClash) || !strings.Contains(clash.Groups, `type: "fallback"`) || !strings.Contains(clash.Groups, `strategy: "round-robin"`) {
+		t.Fatalf("clash groups:\n%s", clash.Groups)
+	}
+
+	surge, err := Render(TargetSurge, res)
+	if err != nil {
+		t.Fatalf("surge: unexpected error: %v", err)
+	}
+	wantSurge := "FB = fallback, n1, n2, url=http://t.example/204, interval=300\n" +
+		"LB = load-balance, n1, n2, url=http://t.example/204, interval=600, persistent=true\n" +
+		"RR = load-balance, n1, url=http://t.example/204, interval=600"
+	if surge.Groups != wantSurge {
+		t.Fatalf("surge groups=\n%s\nwant=\n%s", surge.Groups, wantSurge)
+	}
+
+	shadowrocket, err := Render(TargetShadowrocket, res)
+	if err != nil {
+		t.Fatalf("shadowrocket: unexpected error: %v", err)
+	}
+	if strings.Contains(shadowrocket.Groups, "persistent") {
+		t.Fatalf("shadowrocket groups should not carry persistent:\n%s", shadowrocket.Groups)
+	}
+
+	quanx, err := Render(TargetQuanx, res)
+	if err != nil {
+		t.Fatalf("quanx: unexpected error: %v", err)
+	}
+	wantQuanx := "available=FB, n1, n2, check-interval=300\n" +
+		"round-robin=LB, n1, n2\n" +
+		"round-robin=RR, n1"
+	if quanx.Groups != wantQuanx {
+		t.Fatalf("quanx groups=\n%s\nwant=\n%s", quanx.Groups, wantQuanx)
+	}
+}
+
+func TestRender_RelayGroups(t *testing.T) {
+	res := &compiler.Result{
+		Proxies: []model.Proxy{
+			{ID: "p1", Type: "ss", Name: "HK", Server: "hk.example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+			{ID: "p2", Type: "ss", Name: "JP", Server: "jp.example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+			{ID: "p3", Type: "socks5", Name: "US", Server: "us.example.com", Port: 1080},
+		},
+		Groups: []model.Group{
+			{Name: "ENTRY", Type: "select", Members: []model.MemberRef{proxyRef("p1"), proxyRef("p2")}},
+			{Name: "CHAIN", Type: "relay", Members: []model.MemberRef{{Kind: model.MemberRefGroup, Value: "ENTRY"}, proxyRef("p2"), proxyRef("p3")}},
+		},
+		Rules: []model.Rule{{Type: "MATCH", Action: "CHAIN"}},
+	}
+
+	clash, err := Render(TargetClash, res)
+	if err != nil {
+		t.Fatalf("clash: unexpected error: %v", err)
+	}
+	wantClash := `- name: "CHAIN"
+  type: "relay"
+  proxies:
+    - "ENTRY"
+    - "JP"
+    - "US"`
+	if !strings.Contains(clash.Groups, wantClash) {
+		t.Fatalf("clash groups:\n%s", clash.Groups)
+	}
+
+	surge, err := Render(TargetSurge, res)
+	if err != nil {
+		t.Fatalf("surge: unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"CHAIN/JP = ss, jp.example.com, 8388, encrypt-method=aes-128-gcm, password=pass, underlying-proxy=ENTRY",
+		"CHAIN/US = socks5, us.example.com, 1080, underlying-proxy=CHAIN/JP",
+	} {
+		if !strings.Contains(surge.Proxies, want) {
+			t.Fatalf("surge proxies missing %q:\n%s", want, surge.Proxies)
+		}
+	}
+	if !strings.Contains(surge.Groups, "CHAIN = select, CHAIN/US") {
+		t.Fatalf("surge groups:\n%s", surge.Groups)
+	}
+
+	shadowrocket, err := Render(TargetShadowrocket, res)
+	if err != nil {
+		t.Fatalf("shadowrocket: unexpected error: %v", err)
+	}
+	if !strings.Contains(shadowrocket.Proxies, "CHAIN/US = socks5, us.example.com, 1080, underlying-proxy=CHAIN/JP") ||
+		!strings.Contains(shadowrocket.Groups, "CHAIN = select, CHAIN/US") {
+		t.Fatalf("shadowrocket proxies:\n%s\ngroups:\n%s", shadowrocket.Proxies, shadowrocket.Groups)
+	}
+
+	// dialer-proxy renders the chain the way mihomo recommends over type: relay.
+	dialer := *res
+	dialer.Groups = slices.Clone(res.Groups)
+	dialer.Groups[1].Options.DialerProxy = true
+	clashDialer, err := Render(TargetClash, &dialer)
+	if err != nil {
+		t.Fatalf("clash dialer-proxy: unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"- name: \"CHAIN/JP\"\n  type: ss\n  server: \"jp.example.com\"",
+		"  dialer-proxy: \"ENTRY\"\n- name: \"CHAIN/US\"",
+		"  dialer-proxy: \"CHAIN/JP\"",
+	} {
+		if !strings.Contains(clashDialer.Proxies, want) {
+			t.Fatalf("clash dialer-proxy proxies missing %q:\n%s", want, clashDialer.Proxies)
+		}
+	}
+	wantDialerGroup := `- name: "CHAIN"
+  type: "select"
+  proxies:
+    - "CHAIN/US"`
+	if !strings.Contains(clashDialer.Groups, wantDialerGroup) || strings.Contains(clashDialer.Groups, `"relay"`) {
+		t.Fatalf("clash dialer-proxy groups:\n%s", clashDialer.Groups)
+	}
+
+	// A chained proxy keeps its own egress, so it cannot be a later hop.
+	chained := *res
+	chained.Proxies = append(slices.Clone(res.Proxies), model.Proxy{ID: "p4", Type: "socks5", Name: "US via HK", Server: "us.example.com", Port: 1080, ViaProxyID: "p1"})
+	chained.Groups = []model.Group{{Name: "CHAIN", Type: "relay", Members: []model.MemberRef{proxyRef("p2"), proxyRef("p4")}}}
+	_, err = Render(TargetSurge, &chained)
+	var re *RenderError
+	if !errors.As(err, &re) || re.AppError.Code != "UNSUPPORTED_TARGET_FEATURE" {
+		t.Fatalf("surge chained hop: expected UNSUPPORTED_TARGET_FEATURE, got %T: %v", err, err)
+	}
+
+	// Only the entry hop may be a group when hops are nested; QuanX has no
+	// relay form at all.
+	bad := *res
+	bad.Groups = []model.Group{res.Groups[0], {Name: "CHAIN", Type: "relay", Members: []model.MemberRef{proxyRef("p1"), {Kind: model.MemberRefGroup, Value: "ENTRY"}}, Options: model.GroupOptions{DialerProxy: true}}}
+	for _, target := range []Target{TargetClash, TargetSurge, TargetShadowrocket, TargetQuanx} {
+		in := res
+		if target != TargetQuanx {
+			in = &bad
+		}
+		_, err := Render(target, in)
+		var re *RenderError
+		if !errors.As(err, &re) || re.AppError.Code != "UNSUPPORTED_TARGET_FEATURE" {
+			t.Fatalf("%s: expected UNSUPPORTED_TARGET_FEATURE, got %T: %v", target, err, err)
+		}
+	}
+}
+
+func TestRender_GroupOptions(t *testing.T) {
+	res := &compiler.Result{
+		Proxies: []model.Proxy{
+			{ID: "p1", Type: "ss", Name: "n1", Server: "example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+		},
+		Groups: []model.Group{
+			{Name: "AUTO", Type: "url-test", Members: []model.MemberRef{proxyRef("p1")}, TestURL: "http://t.example/204", IntervalSec: 300, Options: model.GroupOptions{
+				Hidden: true, Icon: "https://example.com/auto.png", Lazy: true, HasLazy: true, TimeoutMS: 2500, DisableUDP: true, IncludeAllProviders: true,
+			}},
+		},
+		Rules: []model.Rule{{Type: "MATCH", Action: "AUTO"}},
+	}
+
+	clash, err := Render(TargetClash, res)
+	if err != nil {
+		t.Fatalf("clash: unexpected error: %v", err)
+	}
+	wantClash := `  interval: 300
+  lazy: true
+  timeout: 2500
+  disable-udp: true
+  include-all-providers: true
+  hidden: true
+  icon: "https://example.com/auto.png"`
+	if !strings.HasSuffix(clash.Groups, wantClash) {
+		t.Fatalf("clash groups:\n%s", clash.Groups)
+	}
+
+	surge, err := Render(TargetSurge, res)
+	if err != nil {
+		t.Fatalf("surge: unexpected error: %v", err)
+	}
+	if want := "AUTO = url-test, n1, url=http://t.example/204, interval=300, timeout=3, hidden=true, icon-url=https://example.com/auto.png"; surge.Groups != want {
+		t.Fatalf("surge groups=%q, want=%q", surge.Groups, want)
+	}
+
+	shadowrocket, err := Render(TargetShadowrocket, res)
+	if err != nil {
+		t.Fatalf("shadowrocket: unexpected error: %v", err)
+	}
+	if want := "AUTO = url-test, n1, url=http://t.example/204, interval=300"; shadowrocket.Groups != want {
+		t.Fatalf("shadowrocket groups=%q, want=%q", shadowrocket.Groups, want)
+	}
+
+	quanx, err := Render(TargetQuanx, res)
+	if err != nil {
+		t.Fatalf("quanx: unexpected error: %v", err)
+	}
+	if want := "url-latency-benchmark=AUTO, n1, check-interval=300, img-url=https://example.com/auto.png"; quanx.Groups != want {
+		t.Fatalf("quanx groups=%q, want=%q", quanx.Groups, want)
+	}
+}
+
+func TestRender_AnchorExtras(t *testing.T) {
+	res := &compiler.Result{
+		Proxies: []model.Proxy{
+			{ID: "p1", Type: "ss", Name: "HK 1", Server: "hk.example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+			{ID: "p2", Type: "ss", Name: "a,b", Server: "sg.example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "pass"},
+		},
+		Groups:         []model.Group{{Name: "PROXY", Type: "select", Members: []model.MemberRef{proxyRef("p1"), proxyRef("p2"), builtinRef("DIRECT")}}},
+		Rules:          []model.Rule{{Type: "MATCH", Action: "PROXY"}},
+		Hosts:          []model.Host{{Domain: "router.lan", Value: "192.168.1.1"}},
+		MITMHostnames:  []string{"*.example.com", "api.example.org"},
+		ProxyProviders: []model.ProxyProvider{{Name: "airport", URL: "https://example.com/nodes.yaml"}},
+	}
+
+	cases := []struct {
+		target                    Target
+		names, members, hosts, pp string
+	}{
+		{TargetClash, `"HK 1", "a,b"`, `"HK 1", "a,b", "DIRECT"`, `"router.lan": "192.168.1.1"`, "\"airport\":\n  type: http\n  url: \"https://example.com/nodes.yaml\"\n  interval: 3600"},
+		{TargetSurge, `HK 1, "a,b"`, `HK 1, "a,b", DIRECT`, "router.lan = 192.168.1.1", ""},
+		{TargetQuanx, `HK 1, "a,b"`, `HK 1, "a,b", direct`, "address=/router.lan/192.168.1.1", ""},
+	}
+	for _, tc := range cases {
+		blocks, err := Render(tc.target, res)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.target, err)
+		}
+		if blocks.ProxyNames != tc.names {
+			t.Fatalf("%s: ProxyNames=%q, want=%q", tc.target, blocks.ProxyNames, tc.names)
+		}
+		if got := blocks.GroupMembers["PROXY"]; got != tc.members {
+			t.Fatalf("%s: GroupMembers=%q, want=%q", tc.target, got, tc.members)
+		}
+		if blocks.Hosts != tc.hosts {
+			t.Fatalf("%s: Hosts=%q, want=%q", tc.target, blocks.Hosts, tc.hosts)
+		}
+		if blocks.ProxyProviders != tc.pp {
+			t.Fatalf("%s: ProxyProviders=%q, want=%q", tc.target, blocks.ProxyProviders, tc.pp)
+		}
+		if blocks.MITMHostnames != "*.example.com, api.example.org" {
+			t.Fatalf("%s: MITMHostnames=%q", tc.target, blocks.MITMHostnames)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/rules"
+)
+
+// Unsupported rule policies (profile: unsupported_rules).
+const (
+	// UnsupportedRulesFail rejects the whole render with UNSUPPORTED_RULE_TYPE (default).
+	UnsupportedRulesFail = "fail"
+	// UnsupportedRulesDrop omits rules the target cannot express, never emitting an approximation.
+	UnsupportedRulesDrop = "drop"
+)
+
+// ruleSyntax describes how one target spells one canonical rule type.
+type ruleSyntax struct {
+	keyword   string
+	noResolve bool // target accepts the trailing no-resolve option for this type
+}
+
+// ruleDialects is the central per-target rule translation table.
+//
+// Keys are canonical (Clash classical) rule types as produced by internal/rules. A target
+// missing from an entry cannot express the type; what happens then is decided by the
+// unsupported rule policy. Renderers must not format rule lines on their own.
+var ruleDialects = map[string]map[Target]ruleSyntax{
+	"DOMAIN":         {TargetClash: {keyword: "DOMAIN"}, TargetSurge: {keyword: "DOMAIN"}, TargetShadowrocket: {keyword: "DOMAIN"}, TargetQuanx: {keyword: "HOST"}},
+	"DOMAIN-SUFFIX":  {TargetClash: {keyword: "DOMAIN-SUFFIX"}, TargetSurge: {keyword: "DOMAIN-SUFFIX"}, TargetShadowrocket: {keyword: "DOMAIN-SUFFIX"}, TargetQuanx: {keyword: "HOST-SUFFIX"}},
+	"DOMAIN-KEYWORD": {TargetClash: {keyword: "DOMAIN-KEYWORD"}, TargetSurge: {keyword: "DOMAIN-KEYWORD"}, TargetShadowrocket: {keyword: "DOMAIN-KEYWORD"}, TargetQuanx: {keyword: "HOST-KEYWORD"}},
+	"DOMAIN-REGEX":   {TargetClash: {keyword: "DOMAIN-REGEX"}},
+	"GEOSITE":        {TargetClash: {keyword: "GEOSITE"}},
+	"GEOIP":          {TargetClash: {keyword: "GEOIP", noResolve: true}, TargetSurge: {keyword: "GEOIP", noResolve: true}, TargetShadowrocket: {keyword: "GEOIP", noResolve: true}, TargetQuanx: {keyword: "GEOIP"}},
+	"IP-CIDR":        {TargetClash: {keyword: "IP-CIDR", noResolve: true}, TargetSurge: {keyword: "IP-CIDR", noResolve: true}, TargetShadowrocket: {keyword: "IP-CIDR", noResolve: true}, TargetQuanx: {keyword: "IP-CIDR", noResolve: true}},
+	"IP-CIDR6":       {TargetClash: {keyword: "IP-CIDR6", noResolve: true}, TargetSurge: {keyword: "IP-CIDR6", noResolve: true}, TargetShadowrocket: {keyword: "IP-CIDR6", noResolve: true}, TargetQuanx: {keyword: "IP6-CIDR", noResolve: true}},
+	"IP-ASN":         {TargetClash: {keyword: "IP-ASN", noResolve: true}, TargetSurge: {keyword: "IP-ASN", noResolve: true}, TargetShadowrocket: {keyword: "IP-ASN", noResolve: true}, TargetQuanx: {keyword: "IP-ASN"}},
+	"SRC-IP-CIDR":    {TargetClash: {keyword: "SRC-IP-CIDR"}, TargetSurge: {keyword: "SRC-IP"}},
+	"DST-PORT":       {TargetClash: {keyword: "DST-PORT"}, TargetSurge: {keyword: "DEST-PORT"}, TargetShadowrocket: {keyword: "DST-PORT"}},
+	"SRC-PORT":       {TargetClash: {keyword: "SRC-PORT"}, TargetSurge: {keyword: "SRC-PORT"}},
+	"NETWORK":        {TargetClash: {keyword: "NETWORK"}, TargetSurge: {keyword: "PROTOCOL"}},
+	"PROCESS-NAME":   {TargetClash: {keyword: "PROCESS-NAME"}, TargetSurge: {keyword: "PROCESS-NAME"}},
+	"PROCESS-PATH":   {TargetClash: {keyword: "PROCESS-PATH"}},
+	"URL-REGEX":      {TargetClash: {keyword: "URL-REGEX"}, TargetSurge: {keyword: "URL-REGEX"}, TargetShadowrocket: {keyword: "URL-REGEX"}},
+	"AND":            {TargetClash: {keyword: "AND"}, TargetSurge: {keyword: "AND"}, TargetShadowrocket: {keyword: "AND"}},
+	"OR":             {TargetClash: {keyword: "OR"}, TargetSurge: {keyword: "OR"}, TargetShadowrocket: {keyword: "OR"}},
+	"NOT":            {TargetClash: {keyword: "NOT"}, TargetSurge: {keyword: "NOT"}, TargetShadowrocket: {keyword: "NOT"}},
+	"MATCH":          {TargetClash: {keyword: "MATCH"}, TargetSurge: {keyword: "FINAL"}, TargetShadowrocket: {keyword: "FINAL"}, TargetQuanx: {keyword: "FINAL"}},
+}
+
+// ruleWriter renders rule lines for one target under one unsupported rule policy.
+type ruleWriter struct {
+	target Target
+	policy string
+	// collected, when set, receives rule errors instead of failing the render
+	// (Options.CollectErrors); the rule is left out.
+	collected *[]error
+}
+
+func newRuleWriter(target Target, policy string) ruleWriter {
+	if policy == "" {
+		policy = UnsupportedRulesFail
+	}
+	return ruleWriter{target: target, policy: policy}
+}
+
+// line renders r with the target-specific policy name action.
+// ok=false means the rule was dropped by the unsupported rule policy.
+func (w ruleWriter) line(r model.Rule, action string) (line string, ok bool, err error) {
+	var s string
+	if r.Type == "MATCH" {
+		syn, err := w.syntax(r)
+		if err == nil {
+			s = syn.keyword + "," + action
+		}
+		return w.decide(r, s, err)
+	}
+
+	cond, err := w.condition(model.Rule{Type: r.Type, Value: r.Value, Sub: r.Sub})
+	if err == nil {
+		// Top-level no-resolve goes after ACTION: TYPE,VALUE,ACTION,no-resolve.
+		s = cond + "," + action
+		if r.NoResolve {
+			err = w.checkNoResolve(r)
+			s += ",no-resolve"
+		}
+	}
+	return w.decide(r, s, err)
+}
+
+func (w ruleWriter) decide(r model.Rule, s string, err error) (string, bool, error) {
+	if err == nil {
+		return s, true, nil
+	}
+	if w.policy == UnsupportedRulesDrop {
+		return "", false, nil
+	}
+	var re *RenderError
+	if errors.As(err, &re) {
+		re.AppError.Snippet = rules.FormatRule(r)
+	}
+	if w.collected != nil {
+		*w.collected = append(*w.collected, err)
+		return "", false, nil
+	}
+	return "", false, err
+}
+
+// condition renders the ACTION-less part of a rule; logical rules recurse into their
+// operands ("AND,((A),(B))"), each of which must be expressible on the target as well.
+// Inside an operand no-resolve stays with its condition: (IP-CIDR,1.1.1.1/32,no-resolve).
+func (w ruleWriter) condition(r model.Rule) (string, error) {
+	syn, err := w.syntax(r)
+	if err != nil {
+		return "", err
+	}
+	if len(r.Sub) == 0 {
+		s := syn.keyword + "," + r.Value
+		if r.NoResolve {
+			if err := w.checkNoResolve(r); err != nil {
+				return "", err
+			}
+			s += ",no-resolve"
+		}
+		return s, nil
+	}
+	subs := make([]string, 0, len(r.Sub))
+	for _, sub := range r.Sub {
+		s, err := w.condition(sub)
+		if err != nil {
+			return "", err
+		}
+		subs = append(subs, "("+s+")")
+	}
+	return syn.keyword + ",(" + strings.Join(subs, ",") + ")", nil
+}
+
+func (w ruleWriter) syntax(r model.Rule) (ruleSyntax, error) {
+	syn, ok := ruleDialects[r.Type][w.target]
+	if !ok {
+		return ruleSyntax{}, &RenderError{
+			AppError: model.AppError{
+				Code:    "UNSUPPORTED_RULE_TYPE",
+				Message: fmt.Sprintf("target=%s 不支持规则类型：%s", w.target, r.Type),
+				Stage:   "render",
+				Hint:    "remove the rule, or set unsupported_rules: drop in the profile",
+			},
+		}
+	}
+	return syn, nil
+}
+
+func (w ruleWriter) checkNoResolve(r model.Rule) error {
+	if ruleDialects[r.Type][w.target].noResolve {
+		return nil
+	}
+	return &RenderError{
+		AppError: model.AppError{
+			Code:    "UNSUPPORTED_RULE_TYPE",
+			Message: fmt.Sprintf("target=%s 的 %s 规则不支持 no-resolve", w.target, r.Type),
+			Stage:   "render",
+			Hint:    "remove no-resolve, or set unsupported_rules: drop in the profile",
+		},
+	}
+}
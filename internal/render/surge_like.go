@@ -7,22 +7,14 @@ import (
 
 	"github.com/John-Robertt/subconverter-go/internal/compiler"
 	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/rules"
 )
 
-func renderSurgeLike(res *compiler.Result, isSurge bool) (Blocks, error) {
-	if !isSurge {
-		for _, p := range res.Proxies {
-			if p.ViaProxyID != "" {
-				return Blocks{}, &RenderError{AppError: model.AppError{
-					Code:    "UNSUPPORTED_TARGET_FEATURE",
-					Message: "target=shadowrocket 当前不支持 proxy_chain",
-					Stage:   "render",
-					Snippet: p.Name,
-				}}
-			}
-		}
+func renderSurgeLike(res *compiler.Result, isSurge bool, rw ruleWriter) (Blocks, error) {
+	target := TargetShadowrocket
+	if isSurge {
+		target = TargetSurge
 	}
-
 	extraBuiltins := 0
 	if !isSurge {
 		// Surge 内置 DIRECT/REJECT；在 [Proxy] 段重复声明会触发 “策略不可以使用内部策略名”。
@@ -53,29 +45,34 @@ func renderSurgeLike(res *compiler.Result, isSurge bool) (Blocks, error) {
 		proxyLines = append(proxyLines, line)
 	}
 
+	proxyByID := make(map[string]model.Proxy, len(res.Proxies))
+	usedNames := make(map[string]struct{}, len(res.Proxies)+len(res.Groups))
+	for _, p := range res.Proxies {
+		proxyByID[p.ID] = p
+		usedNames[p.Name] = struct{}{}
+	}
+	for _, g := range res.Groups {
+		usedNames[g.Name] = struct{}{}
+	}
+
 	groupLines := make([]string, 0, len(res.Groups))
 	for _, g := range res.Groups {
 		if err := surgeGroupNameOK(g.Name); err != nil {
 			return Blocks{}, err
 		}
 		switch g.Type {
-		case "select":
-			var b strings.Builder
-			b.WriteString(g.Name)
-			b.WriteString(" = select")
-			for _, m := range g.Members {
-				memberName, err := surgeMemberName(m, proxyNameRep)
-				if err != nil {
-					return Blocks{}, err
-				}
-				b.WriteString(", ")
-				b.WriteString(memberName)
+		case "relay":
+			lines, groupLine, err := renderSurgeRelay(target, g, proxyByID, proxyNameRep, usedNames)
+			if err != nil {
+				return Blocks{}, err
 			}
-			groupLines = append(groupLines, b.String())
-		case "url-test":
+			proxyLines = append(proxyLines, lines...)
+			groupLines = append(groupLines, groupLine+surgeGroupOptions(g.Options))
+		case "select", "url-test", "fallback", "load-balance":
 			var b strings.Builder
 			b.WriteString(g.Name)
-			b.WriteString(" = url-test")
+			b.WriteString(" = ")
+			b.WriteString(g.Type)
 			for _, m := range g.Members {
 				memberName, err := surgeMemberName(m, proxyNameRep)
 				if err != nil {
@@ -84,14 +81,24 @@ func renderSurgeLike(res *compiler.Result, isSurge bool) (Blocks, error) {
 				b.WriteString(", ")
 				b.WriteString(memberName)
 			}
-			b.WriteString(", url=")
-			b.WriteString(g.TestURL)
-			b.WriteString(", interval=")
-			b.WriteString(strconv.Itoa(g.IntervalSec))
+			if g.Type != "select" {
+				b.WriteString(", url=")
+				b.WriteString(g.TestURL)
+				b.WriteString(", interval=")
+				b.WriteString(strconv.Itoa(g.IntervalSec))
+			}
 			if g.HasTolerance {
 				b.WriteString(", tolerance=")
 				b.WriteString(strconv.Itoa(g.ToleranceMS))
 			}
+			// Surge keeps a hostname on the same member with persistent=true,
+			// which is what consistent-hashing means on Clash.
+			if isSurge && g.Strategy == model.LoadBalanceConsistentHashing {
+				b.WriteString(", persistent=true")
+			}
+			if isSurge {
+				b.WriteString(surgeGroupOptions(g.Options))
+			}
 			groupLines = append(groupLines, b.String())
 		default:
 			return Blocks{}, &RenderError{
@@ -107,10 +114,34 @@ func renderSurgeLike(res *compiler.Result, isSurge bool) (Blocks, error) {
 
 	ruleLines := make([]string, 0, len(res.Rules))
 
+	appendRule := func(r model.Rule) error {
+		// Validate action representability for Surge-like formats.
+		if r.Action != "DIRECT" && r.Action != "REJECT" {
+			if err := surgeGroupNameOK(r.Action); err != nil {
+				return err
+			}
+		}
+		line, ok, err := rw.line(r, r.Action)
+		if err != nil || !ok {
+			return err
+		}
+		ruleLines = append(ruleLines, line)
+		return nil
+	}
+
 	// ruleset: render as remote references (RULE-SET) instead of expanding into
 	// individual rule lines. This keeps the output small and lets clients fetch
-	// rulesets directly.
+	// rulesets directly. Inline rulesets were expanded by the server and are
+	// rendered in place.
 	for _, rs := range res.RulesetRefs {
+		if rs.Inline {
+			for _, r := range rs.Rules {
+				if err := appendRule(r); err != nil {
+					return Blocks{}, err
+				}
+			}
+			continue
+		}
 		if rs.URL == "" {
 			return Blocks{}, &RenderError{
 				AppError: model.AppError{
@@ -137,20 +168,33 @@ func renderSurgeLike(res *compiler.Result, isSurge bool) (Blocks, error) {
 				return Blocks{}, err
 			}
 		}
-		ruleLines = append(ruleLines, "RULE-SET,"+rs.URL+","+rs.Action)
+		// Surge-like clients only read plain-text lists: classical lists via RULE-SET,
+		// domain lists via DOMAIN-SET; there is no plain CIDR list form.
+		if rs.Format != "" && rs.Format != rules.FormatText {
+			return Blocks{}, unsupportedRulesetError(target, rs, "format="+rs.Format)
+		}
+		kind := "RULE-SET"
+		switch rs.Behavior {
+		case "", rules.BehaviorClassical:
+		case rules.BehaviorDomain:
+			kind = "DOMAIN-SET"
+		default:
+			return Blocks{}, unsupportedRulesetError(target, rs, "behavior="+rs.Behavior)
+		}
+		line := kind + "," + rs.URL + "," + rs.Action
+		// Only Surge documents a per-ruleset update-interval; Shadowrocket drops it.
+		if isSurge && rs.IntervalSec > 0 {
+			line += ",update-interval=" + strconv.Itoa(rs.IntervalSec)
+		}
+		ruleLines = append(ruleLines, line)
 	}
 
 	for _, r := range res.Rules {
-		// Validate action representability for Surge-like formats.
-		if r.Action != "DIRECT" && r.Action != "REJECT" {
-			if err := surgeGroupNameOK(r.Action); err != nil {
-				return Blocks{}, err
-			}
+		if err := appendRule(r); err != nil {
+			return Blocks{}, err
 		}
-		ruleLines = append(ruleLines, ruleToSurgeString(r))
 	}
 
-	_ = isSurge // stage7 handles managed-config line
 	return Blocks{
 		Proxies: strings.Join(proxyLines, "\n"),
 		Groups:  strings.Join(groupLines, "\n"),
@@ -199,16 +243,67 @@ func renderSurgeLikeProxyLine(p model.Proxy, proxyNameRep map[string]string) (st
 		if !ok {
 			return "", &RenderError{AppError: model.AppError{
 				Code:    "CHAIN_PROXY_NOT_FOUND",
-				Message: "链式代理引用的订阅节点不存在",
+				Message: "链式代理引用的节点不存在",
 				Stage:   "render",
 				Snippet: p.ViaProxyID,
 			}}
 		}
 		line += ", underlying-proxy=" + viaName
+	} else if p.ViaGroup != "" {
+		line += ", underlying-proxy=" + p.ViaGroup
 	}
 	return line, nil
 }
 
+// surgeGroupOptions renders the group attributes Surge understands. Surge's
+// timeout is in seconds, so milliseconds are rounded up. lazy, disable-udp and
+// include-all-providers have no Surge equivalent and are dropped.
+func surgeGroupOptions(o model.GroupOptions) string {
+	var b strings.Builder
+	if o.TimeoutMS > 0 {
+		b.WriteString(", timeout=")
+		b.WriteString(strconv.Itoa((o.TimeoutMS + 999) / 1000))
+	}
+	if o.Hidden {
+		b.WriteString(", hidden=true")
+	}
+	if o.Icon != "" {
+		b.WriteString(", icon-url=")
+		b.WriteString(o.Icon)
+	}
+	return b.String()
+}
+
+// renderSurgeRelay expresses a relay group with underlying-proxy nesting: every
+// hop after the entry is re-declared as "<GROUP>/<HOP>" dialing through the
+// previous hop, and the group becomes a select over the exit copy (see
+// relayHopCopies for the restrictions).
+func renderSurgeRelay(target Target, g model.Group, proxyByID map[string]model.Proxy, proxyNameRep map[string]string, usedNames map[string]struct{}) ([]string, string, error) {
+	copies, err := relayHopCopies(target, g, proxyByID, usedNames)
+	if err != nil {
+		return nil, "", err
+	}
+	prev, err := surgeMemberName(g.Members[0], proxyNameRep)
+	if err != nil {
+		return nil, "", err
+	}
+
+	lines := make([]string, 0, len(copies))
+	for _, p := range copies {
+		rep, err := surgeProxyName(p.Name)
+		if err != nil {
+			return nil, "", err
+		}
+		line, err := renderSurgeLikeProxyLine(p, map[string]string{p.ID: rep})
+		if err != nil {
+			return nil, "", err
+		}
+		lines = append(lines, line+", underlying-proxy="+prev)
+		prev = rep
+	}
+	return lines, g.Name + " = select, " + prev, nil
+}
+
 func validateSurgeProxyCredential(value, field string) error {
 	if value == "" {
 		return nil
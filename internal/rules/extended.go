@@ -0,0 +1,327 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+)
+
+// cutLogical reports whether line is a logical rule (AND/OR/NOT) and returns the
+// normalized type plus everything after the first comma.
+func cutLogical(line string) (typ string, rest string, ok bool) {
+	head, rest, found := strings.Cut(line, ",")
+	if !found {
+		return "", "", false
+	}
+	typ = strings.ToUpper(strings.TrimSpace(head))
+	switch typ {
+	case "AND", "OR", "NOT":
+		// "NOT,x" without parentheses falls through to parseRuleFields, which reports it.
+		if strings.HasPrefix(strings.TrimSpace(rest), "(") {
+			return typ, rest, true
+		}
+	}
+	return "", "", false
+}
+
+// parseLogicalRule parses "((SUB),(SUB),...)[,ACTION]" for a logical rule type.
+//
+// Sub-rules are conditions without ACTION and may themselves be logical rules.
+// When requireAction is false the trailing ACTION is optional (ruleset lines / sub-rules).
+func parseLogicalRule(typ string, rest string, requireAction bool) (model.Rule, error) {
+	hint := fmt.Sprintf("expected: %s,((TYPE,VALUE),(TYPE,VALUE)),ACTION", typ)
+	if typ == "NOT" {
+		hint = "expected: NOT,((TYPE,VALUE)),ACTION"
+	}
+
+	rest = strings.TrimSpace(rest)
+	end, err := matchParen(rest)
+	if err != nil {
+		return model.Rule{}, &RuleError{Code: "RULE_PARSE_ERROR", Message: "逻辑规则括号不匹配", Hint: hint, Cause: err}
+	}
+
+	r := model.Rule{Type: typ}
+	tail := strings.TrimSpace(rest[end+1:])
+	switch {
+	case tail == "":
+		if requireAction {
+			return model.Rule{}, &RuleError{Code: "RULE_PARSE_ERROR", Message: "规则缺少 ACTION", Hint: hint}
+		}
+	case strings.HasPrefix(tail, ","):
+		r.Action = strings.TrimSpace(tail[1:])
+		if r.Action == "" || strings.Contains(r.Action, ",") {
+			return model.Rule{}, &RuleError{Code: "RULE_PARSE_ERROR", Message: "逻辑规则 ACTION 不合法", Hint: hint}
+		}
+	default:
+		return model.Rule{}, &RuleError{Code: "RULE_PARSE_ERROR", Message: "逻辑规则括号后存在多余内容", Hint: hint}
+	}
+
+	operands, err := splitOperands(rest[1:end])
+	if err != nil {
+		return model.Rule{}, &RuleError{Code: "RULE_PARSE_ERROR", Message: "逻辑规则子规则格式不合法", Hint: hint, Cause: err}
+	}
+	switch {
+	case typ == "NOT" && len(operands) != 1:
+		return model.Rule{}, &RuleError{Code: "RULE_PARSE_ERROR", Message: "NOT 规则必须且只能包含 1 条子规则", Hint: hint}
+	case typ != "NOT" && len(operands) < 2:
+		return model.Rule{}, &RuleError{Code: "RULE_PARSE_ERROR", Message: fmt.Sprintf("%s 规则至少需要 2 条子规则", typ), Hint: hint}
+	}
+
+	for _, op := range operands {
+		sub, err := parseCondition(op)
+		if err != nil {
+			return model.Rule{}, err
+		}
+		r.Sub = append(r.Sub, sub)
+	}
+	return r, nil
+}
+
+// parseCondition parses a logical sub-rule: "TYPE,VALUE[,no-resolve]" or a nested logical rule.
+func parseCondition(s string) (model.Rule, error) {
+	if typ, rest, ok := cutLogical(s); ok {
+		r, err := parseLogicalRule(typ, rest, false)
+		if err != nil {
+			return model.Rule{}, err
+		}
+		if r.Action != "" {
+			return model.Rule{}, &RuleError{Code: "RULE_PARSE_ERROR", Message: "子规则不能包含 ACTION"}
+		}
+		return r, nil
+	}
+
+	parts := splitRuleFields(s)
+	typ := strings.ToUpper(parts[0])
+	if typ == "MATCH" {
+		return model.Rule{}, &RuleError{Code: "RULE_PARSE_ERROR", Message: "子规则不能是 MATCH"}
+	}
+	// Reuse the full-line validators by inserting a placeholder ACTION.
+	const placeholder = "DIRECT"
+	switch {
+	case len(parts) == 2:
+		parts = append(parts, placeholder)
+	case len(parts) == 3 && strings.EqualFold(parts[2], "no-resolve"):
+		parts = []string{parts[0], parts[1], placeholder, parts[2]}
+	case len(parts) >= 3:
+		return model.Rule{}, &RuleError{Code: "RULE_PARSE_ERROR", Message: "子规则不能包含 ACTION", Hint: "expected: (TYPE,VALUE[,no-resolve])"}
+	}
+	r, err := parseRuleFields(parts)
+	if err != nil {
+		return model.Rule{}, err
+	}
+	r.Action = ""
+	return r, nil
+}
+
+// matchParen returns the index of the parenthesis closing s[0].
+func matchParen(s string) (int, error) {
+	if !strings.HasPrefix(s, "(") {
+		return 0, fmt.Errorf("expected '('")
+	}
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unbalanced parentheses")
+}
+
+// splitOperands splits "(A),(B),..." into the inner texts "A", "B", ...
+func splitOperands(s string) ([]string, error) {
+	var out []string
+	s = strings.TrimSpace(s)
+	for s != "" {
+		end, err := matchParen(s)
+		if err != nil {
+			return nil, err
+		}
+		inner := strings.TrimSpace(s[1:end])
+		if inner == "" {
+			return nil, fmt.Errorf("empty sub-rule")
+		}
+		out = append(out, inner)
+
+		s = strings.TrimSpace(s[end+1:])
+		if s == "" {
+			break
+		}
+		if !strings.HasPrefix(s, ",") {
+			return nil, fmt.Errorf("expected ',' between sub-rules")
+		}
+		s = strings.TrimSpace(s[1:])
+		if s == "" {
+			return nil, fmt.Errorf("trailing ','")
+		}
+	}
+	return out, nil
+}
+
+func parsePort(typ string, parts []string) (model.Rule, error) {
+	r, err := parseSimple3(typ, parts)
+	if err != nil {
+		return model.Rule{}, err
+	}
+	if err := validatePortRange(r.Value); err != nil {
+		return model.Rule{}, &RuleError{
+			Code:    "RULE_PARSE_ERROR",
+			Message: fmt.Sprintf("%s 的端口不合法", typ),
+			Hint:    "expected: 1-65535 port, or range like 8000-9000",
+			Cause:   err,
+		}
+	}
+	return r, nil
+}
+
+func validatePortRange(s string) error {
+	lo, hi, isRange := strings.Cut(s, "-")
+	a, err := parsePortNumber(lo)
+	if err != nil {
+		return err
+	}
+	if !isRange {
+		return nil
+	}
+	b, err := parsePortNumber(hi)
+	if err != nil {
+		return err
+	}
+	if a > b {
+		return fmt.Errorf("port range start %d > end %d", a, b)
+	}
+	return nil
+}
+
+func parsePortNumber(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 || n > 65535 {
+		return 0, fmt.Errorf("port out of range: %d", n)
+	}
+	return n, nil
+}
+
+func parseNetwork(parts []string) (model.Rule, error) {
+	r, err := parseSimple3("NETWORK", parts)
+	if err != nil {
+		return model.Rule{}, err
+	}
+	r.Value = strings.ToUpper(r.Value)
+	if r.Value != "TCP" && r.Value != "UDP" {
+		return model.Rule{}, &RuleError{
+			Code:    "RULE_PARSE_ERROR",
+			Message: "NETWORK 的值仅支持 TCP/UDP",
+			Hint:    "expected: NETWORK,TCP|UDP,ACTION",
+		}
+	}
+	return r, nil
+}
+
+func parseSrcIPCidr(parts []string) (model.Rule, error) {
+	r, err := parseSimple3("SRC-IP-CIDR", parts)
+	if err != nil {
+		return model.Rule{}, err
+	}
+	if validateIPv4CIDR(r.Value) != nil && validateIPv6CIDR(r.Value) != nil {
+		return model.Rule{}, &RuleError{
+			Code:    "RULE_PARSE_ERROR",
+			Message: "SRC-IP-CIDR 的 CIDR 不合法",
+			Hint:    "expected: IPv4/IPv6 CIDR, e.g. 192.168.1.0/24",
+		}
+	}
+	return r, nil
+}
+
+func parseIPASN(parts []string) (model.Rule, error) {
+	return parseWithNoResolve("IP-ASN", "ASN", parts, func(v string) error {
+		if n, err := strconv.ParseUint(v, 10, 32); err != nil || n == 0 {
+			return &RuleError{
+				Code:    "RULE_PARSE_ERROR",
+				Message: "IP-ASN 的 ASN 不合法",
+				Hint:    "expected: decimal AS number, e.g. 13335",
+			}
+		}
+		return nil
+	})
+}
+
+func parseGeoIP(parts []string) (model.Rule, error) {
+	return parseWithNoResolve("GEOIP", "CC", parts, nil)
+}
+
+// parseWithNoResolve parses "TYPE,VALUE,ACTION[,no-resolve]" for IP-based types
+// other than IP-CIDR(6), which keep their dedicated validators.
+func parseWithNoResolve(typ string, valueName string, parts []string, validate func(string) error) (model.Rule, error) {
+	hint := fmt.Sprintf("expected: %s,%s,ACTION[,no-resolve]", typ, valueName)
+	noResolve := false
+	if len(parts) == 4 {
+		if !strings.EqualFold(parts[3], "no-resolve") {
+			return model.Rule{}, &RuleError{
+				Code:    "RULE_PARSE_ERROR",
+				Message: fmt.Sprintf("%s 的可选项仅支持 no-resolve", typ),
+				Hint:    hint,
+			}
+		}
+		noResolve = true
+		parts = parts[:3]
+	}
+	if len(parts) == 3 && strings.EqualFold(parts[2], "no-resolve") {
+		return model.Rule{}, &RuleError{
+			Code:    "RULE_PARSE_ERROR",
+			Message: fmt.Sprintf("%s 缺少 ACTION（不允许仅写 no-resolve）", typ),
+			Hint:    hint,
+		}
+	}
+	r, err := parseSimple3(typ, parts)
+	if err != nil {
+		return model.Rule{}, err
+	}
+	if validate != nil {
+		if err := validate(r.Value); err != nil {
+			return model.Rule{}, err
+		}
+	}
+	r.NoResolve = noResolve
+	return r, nil
+}
+
+// FormatRule returns the canonical Clash classical form of r (used for snippets and diagnostics).
+func FormatRule(r model.Rule) string {
+	s := formatCondition(r)
+	if r.Type == "MATCH" {
+		return "MATCH," + r.Action
+	}
+	if r.Action == "" {
+		return s
+	}
+	if len(r.Sub) == 0 && r.NoResolve {
+		// TYPE,VALUE,ACTION,no-resolve
+		return strings.TrimSuffix(s, ",no-resolve") + "," + r.Action + ",no-resolve"
+	}
+	return s + "," + r.Action
+}
+
+func formatCondition(r model.Rule) string {
+	if len(r.Sub) > 0 {
+		subs := make([]string, 0, len(r.Sub))
+		for _, sub := range r.Sub {
+			subs = append(subs, "("+formatCondition(sub)+")")
+		}
+		return r.Type + ",(" + strings.Join(subs, ",") + ")"
+	}
+	s := r.Type + "," + r.Value
+	if r.NoResolve {
+		s += ",no-resolve"
+	}
+	return s
+}
@@ -1,6 +1,10 @@
 package rules
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+)
 
 func FuzzParseInlineRule(f *testing.F) {
 	seed := []string{
@@ -12,12 +16,18 @@ func FuzzParseInlineRule(f *testing.F) {
 		"DOMAIN-SUFFIX,example.com,PROXY",
 		"DOMAIN-KEYWORD,google,REJECT",
 		"GEOIP,CN,DIRECT",
+		"GEOIP,CN,DIRECT,no-resolve",
 		"PROCESS-NAME,WeChat,PROXY",
 		"URL-REGEX,^https?://,PROXY",
 		"IP-CIDR,1.2.3.0/24,DIRECT",
 		"IP-CIDR,1.2.3.0/24,DIRECT,no-resolve",
 		"IP-CIDR6,2001:db8::/32,REJECT",
 		"IP-CIDR6,2001:db8::/32,REJECT,no-resolve",
+		"DST-PORT,8000-9000,PROXY",
+		"IP-ASN,13335,PROXY,no-resolve",
+		"NETWORK,UDP,REJECT",
+		"AND,((DOMAIN,example.com),(NETWORK,UDP)),REJECT",
+		"NOT,((OR,((DST-PORT,80),(DST-PORT,443)))),DIRECT",
 	}
 	for _, s := range seed {
 		f.Add(s)
@@ -35,11 +45,29 @@ func FuzzParseInlineRule(f *testing.F) {
 		if r.Action == "" {
 			t.Fatalf("empty rule action")
 		}
-		if r.Type != "MATCH" && r.Value == "" {
-			t.Fatalf("empty rule value for type=%q", r.Type)
+		checkCondition(t, r)
+	})
+}
+
+func checkCondition(t *testing.T, r model.Rule) {
+	t.Helper()
+	switch r.Type {
+	case "AND", "OR", "NOT":
+		if len(r.Sub) == 0 {
+			t.Fatalf("logical rule without operands: %+v", r)
 		}
-		if r.NoResolve && r.Type != "IP-CIDR" && r.Type != "IP-CIDR6" {
-			t.Fatalf("no-resolve on non-cidr rule: type=%q", r.Type)
+		for _, sub := range r.Sub {
+			if sub.Action != "" {
+				t.Fatalf("sub-rule with action: %+v", sub)
+			}
+			checkCondition(t, sub)
 		}
-	})
+		return
+	}
+	if r.Type != "MATCH" && r.Value == "" {
+		t.Fatalf("empty rule value for type=%q", r.Type)
+	}
+	if r.NoResolve && r.Type != "IP-CIDR" && r.Type != "IP-CIDR6" && r.Type != "IP-ASN" && r.Type != "GEOIP" {
+		t.Fatalf("no-resolve on non-ip rule: type=%q", r.Type)
+	}
 }
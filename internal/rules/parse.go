@@ -43,22 +43,49 @@ func ParseInlineRule(line string) (model.Rule, error) {
 }
 
 func parseRuleLine(line string) (model.Rule, error) {
+	if typ, rest, ok := cutLogical(line); ok {
+		return parseLogicalRule(typ, rest, true)
+	}
+	return parseRuleFields(splitRuleFields(line))
+}
+
+func splitRuleFields(line string) []string {
 	parts := strings.Split(line, ",")
 	for i := range parts {
 		parts[i] = strings.TrimSpace(parts[i])
 	}
+	return parts
+}
+
+func parseRuleFields(parts []string) (model.Rule, error) {
 	if len(parts) == 0 || parts[0] == "" {
 		return model.Rule{}, &RuleError{Code: "RULE_PARSE_ERROR", Message: "规则类型不能为空"}
 	}
 
 	typ := strings.ToUpper(parts[0])
 	switch typ {
-	case "DOMAIN", "DOMAIN-SUFFIX", "DOMAIN-KEYWORD", "GEOIP", "PROCESS-NAME", "URL-REGEX":
+	case "GEOIP":
+		return parseGeoIP(parts)
+	case "DOMAIN", "DOMAIN-SUFFIX", "DOMAIN-KEYWORD", "DOMAIN-REGEX", "GEOSITE", "PROCESS-NAME", "PROCESS-PATH", "URL-REGEX":
 		return parseSimple3(typ, parts)
 	case "IP-CIDR":
 		return parseIPCidr(parts)
 	case "IP-CIDR6":
 		return parseIPCidr6(parts)
+	case "SRC-IP-CIDR":
+		return parseSrcIPCidr(parts)
+	case "IP-ASN":
+		return parseIPASN(parts)
+	case "DST-PORT", "SRC-PORT":
+		return parsePort(typ, parts)
+	case "NETWORK":
+		return parseNetwork(parts)
+	case "AND", "OR", "NOT":
+		return model.Rule{}, &RuleError{
+			Code:    "RULE_PARSE_ERROR",
+			Message: fmt.Sprintf("%s 规则缺少括号子规则", typ),
+			Hint:    "expected: AND,((TYPE,VALUE),(TYPE,VALUE)),ACTION",
+		}
 	case "MATCH":
 		if len(parts) != 2 || parts[1] == "" {
 			return model.Rule{}, &RuleError{
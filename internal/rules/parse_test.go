@@ -2,7 +2,10 @@ package rules
 
 import (
 	"errors"
+	"reflect"
 	"testing"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
 )
 
 func TestParseInlineRule_RequireAction(t *testing.T) {
@@ -28,7 +31,7 @@ func TestParseInlineRule_IPCIDR_NoResolveWithoutAction_Error(t *testing.T) {
 }
 
 func TestParseInlineRule_UnsupportedType(t *testing.T) {
-	_, err := ParseInlineRule("DST-PORT,443,DIRECT")
+	_, err := ParseInlineRule("USER-AGENT,curl*,DIRECT")
 	var re *RuleError
 	if !errors.As(err, &re) {
 		t.Fatalf("expected *RuleError, got %T: %v", err, err)
@@ -37,3 +40,84 @@ func TestParseInlineRule_UnsupportedType(t *testing.T) {
 		t.Fatalf("code=%q, want=%q", re.Code, "UNSUPPORTED_RULE_TYPE")
 	}
 }
+
+func TestParseInlineRule_ExtendedTypes(t *testing.T) {
+	cases := []struct {
+		line string
+		want model.Rule
+	}{
+		{"DST-PORT,443,PROXY", model.Rule{Type: "DST-PORT", Value: "443", Action: "PROXY"}},
+		{"SRC-PORT,8000-9000,DIRECT", model.Rule{Type: "SRC-PORT", Value: "8000-9000", Action: "DIRECT"}},
+		{"SRC-IP-CIDR,192.168.1.0/24,DIRECT", model.Rule{Type: "SRC-IP-CIDR", Value: "192.168.1.0/24", Action: "DIRECT"}},
+		{"IP-ASN,13335,PROXY,no-resolve", model.Rule{Type: "IP-ASN", Value: "13335", Action: "PROXY", NoResolve: true}},
+		{"GEOSITE,google,PROXY", model.Rule{Type: "GEOSITE", Value: "google", Action: "PROXY"}},
+		{"PROCESS-PATH,/usr/bin/curl,DIRECT", model.Rule{Type: "PROCESS-PATH", Value: "/usr/bin/curl", Action: "DIRECT"}},
+		{"DOMAIN-REGEX,^ad\\.,REJECT", model.Rule{Type: "DOMAIN-REGEX", Value: "^ad\\.", Action: "REJECT"}},
+		{"network,udp,REJECT", model.Rule{Type: "NETWORK", Value: "UDP", Action: "REJECT"}},
+		{"GEOIP,CN,DIRECT,no-resolve", model.Rule{Type: "GEOIP", Value: "CN", Action: "DIRECT", NoResolve: true}},
+	}
+	for _, tc := range cases {
+		got, err := ParseInlineRule(tc.line)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tc.line, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%q: got=%+v, want=%+v", tc.line, got, tc.want)
+		}
+	}
+
+	for _, line := range []string{
+		"DST-PORT,0,PROXY",
+		"DST-PORT,9000-8000,PROXY",
+		"NETWORK,ICMP,PROXY",
+		"SRC-IP-CIDR,not-a-cidr,DIRECT",
+		"IP-ASN,abc,PROXY",
+		"IP-ASN,13335,no-resolve",
+	} {
+		if _, err := ParseInlineRule(line); err == nil {
+			t.Fatalf("%q: expected error", line)
+		}
+	}
+}
+
+func TestParseInlineRule_Logical(t *testing.T) {
+	got, err := ParseInlineRule("OR,((AND,((DOMAIN-SUFFIX,example.com),(NETWORK,UDP))),(NOT,((DST-PORT,443)))),REJECT")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := model.Rule{
+		Type:   "OR",
+		Action: "REJECT",
+		Sub: []model.Rule{
+			{Type: "AND", Sub: []model.Rule{
+				{Type: "DOMAIN-SUFFIX", Value: "example.com"},
+				{Type: "NETWORK", Value: "UDP"},
+			}},
+			{Type: "NOT", Sub: []model.Rule{
+				{Type: "DST-PORT", Value: "443"},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%+v\nwant=%+v", got, want)
+	}
+	if s := FormatRule(got); s != "OR,((AND,((DOMAIN-SUFFIX,example.com),(NETWORK,UDP))),(NOT,((DST-PORT,443)))),REJECT" {
+		t.Fatalf("FormatRule=%q", s)
+	}
+
+	for _, line := range []string{
+		"AND,((DOMAIN,a.com),(DOMAIN,b.com))",
+		"AND,((DOMAIN,a.com)),DIRECT",
+		"NOT,((DOMAIN,a.com),(DOMAIN,b.com)),DIRECT",
+		"AND,((DOMAIN,a.com),(DOMAIN,b.com),DIRECT",
+		"AND,((DOMAIN,a.com,PROXY),(DOMAIN,b.com)),DIRECT",
+		"AND,((MATCH),(DOMAIN,b.com)),DIRECT",
+		"OR,((DOMAIN,a.com) (DOMAIN,b.com)),DIRECT",
+	} {
+		_, err := ParseInlineRule(line)
+		var re *RuleError
+		if !errors.As(err, &re) || re.Code != "RULE_PARSE_ERROR" {
+			t.Fatalf("%q: expected RULE_PARSE_ERROR, got %v", line, err)
+		}
+	}
+}
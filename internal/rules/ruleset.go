@@ -0,0 +1,271 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseError is a rule error located in a fetched ruleset document.
+type ParseError struct {
+	AppError model.AppError
+	Cause    error
+}
+
+func (e *ParseError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	if e.Cause == nil {
+		return fmt.Sprintf("%s: %s", e.AppError.Code, e.AppError.Message)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.AppError.Code, e.AppError.Message, e.Cause)
+}
+
+func (e *ParseError) Unwrap() error { return e.Cause }
+
+// Ruleset behaviors and formats (mihomo rule-provider vocabulary).
+const (
+	BehaviorClassical = "classical"
+	BehaviorDomain    = "domain"
+	BehaviorIPCIDR    = "ipcidr"
+
+	FormatText = "text"
+	FormatYAML = "yaml"
+	FormatMRS  = "mrs"
+)
+
+// ParseRulesetText parses the content of a ruleset and stamps every rule with the
+// ruleset ACTION from the profile directive.
+//
+// behavior selects the line grammar (empty means classical):
+//   - classical: Clash classical lines; ACTION may be omitted (TYPE,VALUE[,no-resolve]).
+//     When a line does carry an ACTION it is overridden by the directive ACTION, matching
+//     client RULE-SET semantics. MATCH is rejected: it would terminate rule evaluation in
+//     the middle of the expanded list.
+//   - domain: one domain per line; "+.example.com" / ".example.com" -> DOMAIN-SUFFIX, otherwise DOMAIN.
+//     Entries with mihomo "*" wildcards (one label each) become an equivalent DOMAIN-REGEX.
+//   - ipcidr: one CIDR per line -> IP-CIDR / IP-CIDR6.
+//
+// format selects the container (empty means text): text is one entry per line, yaml is a
+// "payload:" string list. The binary mrs format cannot be expanded.
+func ParseRulesetText(sourceURL string, content string, action string, behavior string, format string) ([]model.Rule, error) {
+	if behavior == "" {
+		behavior = BehaviorClassical
+	}
+	var parseEntry func(string) (model.Rule, error)
+	switch behavior {
+	case BehaviorClassical:
+		parseEntry = func(s string) (model.Rule, error) { return parseRulesetLine(s, action) }
+	case BehaviorDomain:
+		parseEntry = func(s string) (model.Rule, error) { return parseDomainEntry(s, action) }
+	case BehaviorIPCIDR:
+		parseEntry = func(s string) (model.Rule, error) { return parseIPCIDREntry(s, action) }
+	default:
+		return nil, newRulesetParseError(sourceURL, 0, behavior, &RuleError{Code: "RULESET_PARSE_ERROR", Message: fmt.Sprintf("不支持的 ruleset behavior：%s", behavior)})
+	}
+
+	content = strings.TrimPrefix(content, "\uFEFF")
+	var entries []rulesetEntry
+	switch format {
+	case "", FormatText:
+		entries = splitTextEntries(content)
+	case FormatYAML:
+		var err error
+		entries, err = splitYAMLPayload(content)
+		if err != nil {
+			return nil, newRulesetParseError(sourceURL, 0, "", err)
+		}
+	default:
+		return nil, newRulesetParseError(sourceURL, 0, format, &RuleError{Code: "RULESET_PARSE_ERROR", Message: fmt.Sprintf("ruleset format 无法展开：%s", format)})
+	}
+
+	out := make([]model.Rule, 0, len(entries))
+	for _, e := range entries {
+		r, err := parseEntry(e.text)
+		if err != nil {
+			return nil, newRulesetParseError(sourceURL, e.line, e.text, err)
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+type rulesetEntry struct {
+	line int
+	text string
+}
+
+func splitTextEntries(content string) []rulesetEntry {
+	lines := strings.Split(content, "\n")
+	out := make([]rulesetEntry, 0, len(lines))
+	for i, line := range lines {
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		out = append(out, rulesetEntry{line: i + 1, text: line})
+	}
+	return out
+}
+
+func splitYAMLPayload(content string) ([]rulesetEntry, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, &RuleError{Code: "RULESET_PARSE_ERROR", Message: "ruleset YAML 解析失败", Cause: err}
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, &RuleError{Code: "RULESET_PARSE_ERROR", Message: "ruleset YAML 顶层必须是 mapping", Hint: "expected: payload: [...]"}
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "payload" {
+			continue
+		}
+		seq := root.Content[i+1]
+		if seq.Kind != yaml.SequenceNode {
+			return nil, &RuleError{Code: "RULESET_PARSE_ERROR", Message: "ruleset YAML 的 payload 必须是列表"}
+		}
+		out := make([]rulesetEntry, 0, len(seq.Content))
+		for _, item := range seq.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, &RuleError{Code: "RULESET_PARSE_ERROR", Message: "ruleset YAML 的 payload 项必须是字符串"}
+			}
+			text := strings.TrimSpace(item.Value)
+			if text == "" {
+				continue
+			}
+			out = append(out, rulesetEntry{line: item.Line, text: text})
+		}
+		return out, nil
+	}
+	return nil, &RuleError{Code: "RULESET_PARSE_ERROR", Message: "ruleset YAML 缺少 payload", Hint: "expected: payload: [...]"}
+}
+
+func parseDomainEntry(s string, action string) (model.Rule, error) {
+	suffix := false
+	switch {
+	case strings.HasPrefix(s, "+."):
+		suffix, s = true, strings.TrimPrefix(s, "+.")
+	case strings.HasPrefix(s, "."):
+		suffix, s = true, strings.TrimPrefix(s, ".")
+	}
+	if s == "" || strings.ContainsAny(s, ", \t") {
+		return model.Rule{}, &RuleError{
+			Code:    "RULE_PARSE_ERROR",
+			Message: "domain ruleset 条目不合法",
+			Hint:    "expected: example.com | +.example.com | .example.com | *.example.com",
+		}
+	}
+	if strings.Contains(s, "*") {
+		return model.Rule{Type: "DOMAIN-REGEX", Value: domainWildcardRegex(s, suffix), Action: action}, nil
+	}
+	if suffix {
+		return model.Rule{Type: "DOMAIN-SUFFIX", Value: s, Action: action}, nil
+	}
+	return model.Rule{Type: "DOMAIN", Value: s, Action: action}, nil
+}
+
+// domainWildcardRegex translates a mihomo domain entry with "*" labels into
+// an equivalent DOMAIN-REGEX: "*" stands for exactly one label, and suffix
+// entries also match any number of extra leading labels.
+func domainWildcardRegex(s string, suffix bool) string {
+	labels := strings.Split(s, ".")
+	for i, l := range labels {
+		if l == "*" {
+			labels[i] = `[^.]+`
+			continue
+		}
+		labels[i] = strings.ReplaceAll(regexp.QuoteMeta(l), `\*`, `[^.]*`)
+	}
+	prefix := "^"
+	if suffix {
+		prefix = `^(?:.+\.)?`
+	}
+	return prefix + strings.Join(labels, `\.`) + "$"
+}
+
+func parseIPCIDREntry(s string, action string) (model.Rule, error) {
+	if validateIPv4CIDR(s) == nil {
+		return model.Rule{Type: "IP-CIDR", Value: s, Action: action}, nil
+	}
+	if validateIPv6CIDR(s) == nil {
+		return model.Rule{Type: "IP-CIDR6", Value: s, Action: action}, nil
+	}
+	return model.Rule{}, &RuleError{
+		Code:    "RULE_PARSE_ERROR",
+		Message: "ipcidr ruleset 条目不合法",
+		Hint:    "expected: IPv4/IPv6 CIDR, e.g. 1.2.3.0/24",
+	}
+}
+
+func parseRulesetLine(line string, action string) (model.Rule, error) {
+	if typ, rest, ok := cutLogical(line); ok {
+		r, err := parseLogicalRule(typ, rest, false)
+		if err != nil {
+			return model.Rule{}, err
+		}
+		r.Action = action
+		return r, nil
+	}
+
+	parts := splitRuleFields(line)
+	typ := strings.ToUpper(parts[0])
+	if typ == "MATCH" {
+		return model.Rule{}, &RuleError{
+			Code:    "RULE_PARSE_ERROR",
+			Message: "ruleset 内不允许 MATCH 规则",
+			Hint:    "MATCH 只能写在 profile.rule 的最后一条",
+		}
+	}
+
+	noResolve := len(parts) >= 3 && strings.EqualFold(parts[len(parts)-1], "no-resolve")
+	switch {
+	case len(parts) == 2:
+		parts = append(parts, action)
+	case len(parts) == 3 && noResolve:
+		parts = []string{parts[0], parts[1], action, parts[2]}
+	case len(parts) == 3:
+		parts[2] = action
+	case len(parts) == 4 && noResolve:
+		parts[2] = action
+	}
+
+	r, err := parseRuleFields(parts)
+	if err != nil {
+		return model.Rule{}, err
+	}
+	r.Action = action
+	return r, nil
+}
+
+func newRulesetParseError(sourceURL string, lineNo int, line string, err error) error {
+	app := model.AppError{
+		Code:    "RULE_PARSE_ERROR",
+		Message: "ruleset 规则解析失败",
+		Stage:   "parse_ruleset",
+		URL:     sourceURL,
+		Line:    lineNo,
+		Snippet: truncateSnippet(line, 200),
+	}
+	var re *RuleError
+	if errors.As(err, &re) {
+		app.Code = re.Code
+		app.Message = re.Message
+		app.Hint = re.Hint
+	}
+	return &ParseError{AppError: app, Cause: err}
+}
+
+func truncateSnippet(s string, max int) string {
+	s = strings.ReplaceAll(s, "\r", "")
+	s = strings.ReplaceAll(s, "\n", "")
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
@@ -0,0 +1,134 @@
+package rules
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+)
+
+func TestParseRulesetText_OK(t *testing.T) {
+	text := "\uFEFF# comment\r\n" +
+		"DOMAIN-SUFFIX,example.com\r\n" +
+		"\r\n" +
+		"IP-CIDR,1.2.3.0/24,no-resolve\r\n" +
+		"DOMAIN,a.example.com,REJECT\r\n" +
+		"IP-CIDR6,2001:db8::/32,DIRECT,no-resolve\r\n"
+
+	got, err := ParseRulesetText("https://example.com/a.list", text, "PROXY", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("rules=%d, want=4: %+v", len(got), got)
+	}
+	for _, r := range got {
+		if r.Action != "PROXY" {
+			t.Fatalf("action=%q, want=%q (%+v)", r.Action, "PROXY", r)
+		}
+	}
+	if got[0].Type != "DOMAIN-SUFFIX" || got[0].Value != "example.com" {
+		t.Fatalf("rule0=%+v", got[0])
+	}
+	if got[1].Type != "IP-CIDR" || !got[1].NoResolve {
+		t.Fatalf("rule1=%+v", got[1])
+	}
+	if got[3].Type != "IP-CIDR6" || !got[3].NoResolve {
+		t.Fatalf("rule3=%+v", got[3])
+	}
+}
+
+func TestParseRulesetText_ErrorHasLocation(t *testing.T) {
+	text := "DOMAIN,example.com\nMATCH\n"
+	_, err := ParseRulesetText("https://example.com/a.list", text, "PROXY", "", "")
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected *ParseError, got %T: %v", err, err)
+	}
+	if pe.AppError.Code != "RULE_PARSE_ERROR" {
+		t.Fatalf("code=%q, want=%q", pe.AppError.Code, "RULE_PARSE_ERROR")
+	}
+	if pe.AppError.Stage != "parse_ruleset" || pe.AppError.Line != 2 {
+		t.Fatalf("stage=%q line=%d, want parse_ruleset line 2", pe.AppError.Stage, pe.AppError.Line)
+	}
+	if pe.AppError.URL != "https://example.com/a.list" {
+		t.Fatalf("url=%q", pe.AppError.URL)
+	}
+}
+
+func TestParseRulesetText_UnsupportedType(t *testing.T) {
+	_, err := ParseRulesetText("https://example.com/a.list", "USER-AGENT,foo*\n", "PROXY", "", "")
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected *ParseError, got %T: %v", err, err)
+	}
+	if pe.AppError.Code != "UNSUPPORTED_RULE_TYPE" {
+		t.Fatalf("code=%q, want=%q", pe.AppError.Code, "UNSUPPORTED_RULE_TYPE")
+	}
+}
+
+func TestParseRulesetText_DomainAndIPCIDRBehaviors(t *testing.T) {
+	got, err := ParseRulesetText("https://example.com/d.yaml", "payload:\n  - '+.google.com'\n  - 'example.com'\n  - '.github.com'\n", "PROXY", BehaviorDomain, FormatYAML)
+	if err != nil {
+		t.Fatalf("domain yaml: unexpected error: %v", err)
+	}
+	if len(got) != 3 || got[0].Type != "DOMAIN-SUFFIX" || got[0].Value != "google.com" || got[1].Type != "DOMAIN" || got[2].Type != "DOMAIN-SUFFIX" {
+		t.Fatalf("domain rules=%+v", got)
+	}
+
+	got, err = ParseRulesetText("https://example.com/ip.txt", "1.2.3.0/24\n2001:db8::/32\n", "DIRECT", BehaviorIPCIDR, FormatText)
+	if err != nil {
+		t.Fatalf("ipcidr text: unexpected error: %v", err)
+	}
+	if len(got) != 2 || got[0].Type != "IP-CIDR" || got[1].Type != "IP-CIDR6" || got[1].Action != "DIRECT" {
+		t.Fatalf("ipcidr rules=%+v", got)
+	}
+
+	_, err = ParseRulesetText("https://example.com/d.yaml", "payload:\n  - '+.google.com'\n  - 'bad entry'\n", "PROXY", BehaviorDomain, FormatYAML)
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected *ParseError, got %T: %v", err, err)
+	}
+	if pe.AppError.Line != 3 {
+		t.Fatalf("line=%d, want=3", pe.AppError.Line)
+	}
+}
+
+func TestParseRulesetText_DomainWildcards(t *testing.T) {
+	// A mihomo-style domain list (see the mihomo rule-provider docs).
+	list := "" +
+		"payload:\n" +
+		"  - '.blogger.com'\n" +
+		"  - '*.*.microsoft.com'\n" +
+		"  - 'books.itunes.apple.com'\n" +
+		"  - '*.googlevideo.com'\n" +
+		"  - '+.*.cdn.example.net'\n"
+	got, err := ParseRulesetText("https://example.com/d.yaml", list, "PROXY", BehaviorDomain, FormatYAML)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []model.Rule{
+		{Type: "DOMAIN-SUFFIX", Value: "blogger.com", Action: "PROXY"},
+		{Type: "DOMAIN-REGEX", Value: `^[^.]+\.[^.]+\.microsoft\.com$`, Action: "PROXY"},
+		{Type: "DOMAIN", Value: "books.itunes.apple.com", Action: "PROXY"},
+		{Type: "DOMAIN-REGEX", Value: `^[^.]+\.googlevideo\.com$`, Action: "PROXY"},
+		{Type: "DOMAIN-REGEX", Value: `^(?:.+\.)?[^.]+\.cdn\.example\.net$`, Action: "PROXY"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("rules=%+v\nwant=%+v", got, want)
+	}
+
+	re := regexp.MustCompile(got[3].Value)
+	for domain, match := range map[string]bool{
+		"r1.googlevideo.com":    true,
+		"googlevideo.com":       false,
+		"a.r1.googlevideo.com":  false,
+		"r1.googlevideo.com.cn": false,
+	} {
+		if re.MatchString(domain) != match {
+			t.Fatalf("%s: match=%v, want %v", domain, !match, match)
+		}
+	}
+}
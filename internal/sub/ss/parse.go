@@ -30,7 +30,19 @@ func (e *ParseError) Error() string {
 
 func (e *ParseError) Unwrap() error { return e.Cause }
 
+// ParseSubscriptionText parses a subscription and stops at the first bad line.
 func ParseSubscriptionText(sourceURL string, content string) ([]model.Proxy, error) {
+	return parseSubscriptionText(sourceURL, content, false)
+}
+
+// ParseSubscriptionTextAll is ParseSubscriptionText for diagnostics: bad lines
+// are skipped, and the proxies of the good lines are returned together with
+// every line error (errors.Join, in line order).
+func ParseSubscriptionTextAll(sourceURL string, content string) ([]model.Proxy, error) {
+	return parseSubscriptionText(sourceURL, content, true)
+}
+
+func parseSubscriptionText(sourceURL string, content string, collect bool) ([]model.Proxy, error) {
 	s := stripUTF8BOM(content)
 	s = strings.TrimSpace(s)
 	if s == "" {
@@ -41,7 +53,7 @@ func ParseSubscriptionText(sourceURL string, content string) ([]model.Proxy, err
 	// 1) if it looks like a raw list (ss:// or other supported raw formats), parse directly
 	// 2) else treat as base64 list and decode.
 	if looksLikeRawList(s) {
-		return parseRawList(sourceURL, s)
+		return parseRawList(sourceURL, s, collect)
 	}
 
 	decoded, err := decodeSubscriptionBase64(s)
@@ -53,7 +65,7 @@ func ParseSubscriptionText(sourceURL string, content string) ([]model.Proxy, err
 	if decoded == "" {
 		return nil, newParseError(sourceURL, 0, "", "SUB_PARSE_ERROR", "订阅内容为空", "", nil)
 	}
-	return parseRawList(sourceURL, decoded)
+	return parseRawList(sourceURL, decoded, collect)
 }
 
 func looksLikeRawList(s string) bool {
@@ -112,10 +124,13 @@ func normalizeKVValue(v string) (string, error) {
 	return strconv.Unquote(v)
 }
 
-func parseRawList(sourceURL, raw string) ([]model.Proxy, error) {
+// parseRawList parses one proxy per line. Unless collect, the first bad line
+// fails the whole list.
+func parseRawList(sourceURL, raw string, collect bool) ([]model.Proxy, error) {
 	// Use \n split and trim trailing \r to be CRLF-compatible.
 	lines := strings.Split(raw, "\n")
 	out := make([]model.Proxy, 0, len(lines))
+	var errs []error
 	for i, line := range lines {
 		orig := line
 		line = strings.TrimSpace(line)
@@ -126,41 +141,45 @@ func parseRawList(sourceURL, raw string) ([]model.Proxy, error) {
 			continue
 		}
 
-		var (
-			p   model.Proxy
-			err error
-			ok  bool
-		)
-		switch {
-		case strings.HasPrefix(line, "ss://"):
-			p, err = parseSSURI(sourceURL, i+1, line)
-			if err != nil {
-				return nil, err
-			}
-		default:
-			p, ok, err = parseShadowrocketSSLine(sourceURL, i+1, line)
-			if err != nil {
+		p, err := parseLine(sourceURL, i+1, line, orig)
+		if err != nil {
+			if !collect {
 				return nil, err
 			}
-			if !ok {
-				p, ok, err = parseSurgeShadowsocksLine(sourceURL, i+1, line)
-				if err != nil {
-					return nil, err
-				}
-			}
-			if !ok {
-				return nil, newParseError(sourceURL, i+1, truncateSnippet(orig, 200), "SUB_UNSUPPORTED_SCHEME", "不支持的订阅行格式", "expected: ss://... | <name>=ss,... | shadowsocks = ...", nil)
-			}
+			errs = append(errs, err)
+			continue
 		}
-
 		out = append(out, p)
 	}
+	if len(errs) > 0 {
+		return out, errors.Join(errs...)
+	}
 	if len(out) == 0 {
 		return nil, newParseError(sourceURL, 0, "", "SUB_PARSE_ERROR", "订阅中没有任何可用节点", "", nil)
 	}
 	return out, nil
 }
 
+func parseLine(sourceURL string, lineNo int, line, orig string) (model.Proxy, error) {
+	if strings.HasPrefix(line, "ss://") {
+		return parseSSURI(sourceURL, lineNo, line)
+	}
+	p, ok, err := parseShadowrocketSSLine(sourceURL, lineNo, line)
+	if err != nil {
+		return model.Proxy{}, err
+	}
+	if !ok {
+		p, ok, err = parseSurgeShadowsocksLine(sourceURL, lineNo, line)
+		if err != nil {
+			return model.Proxy{}, err
+		}
+	}
+	if !ok {
+		return model.Proxy{}, newParseError(sourceURL, lineNo, truncateSnippet(orig, 200), "SUB_UNSUPPORTED_SCHEME", "不支持的订阅行格式", "expected: ss://... | <name>=ss,... | shadowsocks = ...", nil)
+	}
+	return p, nil
+}
+
 func parseShadowrocketSSLine(sourceURL string, lineNo int, line string) (model.Proxy, bool, error) {
 	// Shadowrocket subscription line:
 	//   <name>=ss, <server>, <port>, encrypt-method=<cipher>, password=<password>, [obfs=<mode>], [obfs-host=<host>], ...
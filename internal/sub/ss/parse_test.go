@@ -36,6 +36,39 @@ func TestParseSubscriptionText_RawList(t *testing.T) {
 	}
 }
 
+func TestParseSubscriptionTextAll_CollectsEveryBadLine(t *testing.T) {
+	raw := strings.Join([]string{
+		"ss://YWVzLTEyOC1nY206cGFzcw==@example.com:8388#Node%201",
+		"vmess://abc",
+		"ss://YWVzLTEyOC1nY206cDI=@example.com:8389#Node%202",
+		"ss://bad",
+	}, "\n")
+
+	if _, err := ParseSubscriptionText("https://example.com/sub.txt", raw); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	proxies, err := ParseSubscriptionTextAll("https://example.com/sub.txt", raw)
+	if len(proxies) != 2 {
+		t.Fatalf("len=%d, want=2", len(proxies))
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("expected joined errors, got %T: %v", err, err)
+	}
+	var lines []int
+	for _, e := range joined.Unwrap() {
+		var pe *ParseError
+		if !errors.As(e, &pe) {
+			t.Fatalf("expected *ParseError, got %T: %v", e, e)
+		}
+		lines = append(lines, pe.AppError.Line)
+	}
+	if len(lines) != 2 || lines[0] != 2 || lines[1] != 4 {
+		t.Fatalf("lines=%v, want [2 4]", lines)
+	}
+}
+
 func TestParseSubscriptionText_Base64List(t *testing.T) {
 	raw := "ss://YWVzLTEyOC1nY206cGFzcw==@example.com:8388#Node%201\n"
 	b64 := base64.StdEncoding.EncodeToString([]byte(raw))
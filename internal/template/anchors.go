@@ -2,6 +2,7 @@ package template
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/John-Robertt/subconverter-go/internal/model"
@@ -14,8 +15,21 @@ const (
 	AnchorRulesets      = "#@RULESETS@#"
 	AnchorRuleProviders = "#@RULE_PROVIDERS@#"
 	AnchorRules         = "#@RULES@#"
+
+	// Optional standalone anchors.
+	AnchorHosts          = "#@HOSTS@#"
+	AnchorProxyProviders = "#@PROXY_PROVIDERS@#"
+
+	// Optional inline anchors: replaced wherever they appear in a line, any
+	// number of times.
+	AnchorProxyNames    = "#@PROXY_NAMES@#"
+	AnchorMITMHostnames = "#@MITM_HOSTNAMES@#"
+	// AnchorGroupMembersPrefix starts #@GROUP_MEMBERS:<group name>@#.
+	AnchorGroupMembersPrefix = "#@GROUP_MEMBERS:"
 )
 
+var inlineAnchorRe = regexp.MustCompile(`#@(?:PROXY_NAMES|MITM_HOSTNAMES|GROUP_MEMBERS:(.+?))@#`)
+
 type AnchorOptions struct {
 	Target      render.Target
 	TemplateURL string
@@ -45,6 +59,16 @@ func InjectAnchors(templateText string, blocks render.Blocks, opt AnchorOptions)
 		return "", err
 	}
 
+	// Inline anchors are replaced in the template text only, never in the
+	// injected blocks.
+	for i, line := range lines {
+		out, err := replaceInlineAnchors(line, blocks, opt.TemplateURL)
+		if err != nil {
+			return "", err
+		}
+		lines[i] = out
+	}
+
 	lines[pos.proxiesLine] = indentBlock(lines[pos.proxiesLine], blocks.Proxies)
 	lines[pos.groupsLine] = indentBlock(lines[pos.groupsLine], blocks.Groups)
 	if pos.ruleProvidersLine != -1 {
@@ -54,6 +78,12 @@ func InjectAnchors(templateText string, blocks render.Blocks, opt AnchorOptions)
 		lines[pos.rulesetsLine] = indentBlock(lines[pos.rulesetsLine], blocks.Rulesets)
 	}
 	lines[pos.rulesLine] = indentBlock(lines[pos.rulesLine], blocks.Rules)
+	if pos.hostsLine != -1 {
+		lines[pos.hostsLine] = indentBlock(lines[pos.hostsLine], blocks.Hosts)
+	}
+	if pos.proxyProvidersLine != -1 {
+		lines[pos.proxyProvidersLine] = indentBlock(lines[pos.proxyProvidersLine], blocks.ProxyProviders)
+	}
 
 	out := strings.Join(lines, "\n")
 	if !endsWithNewline {
@@ -71,11 +101,14 @@ type anchorPos struct {
 	ruleProvidersLine int
 	rulesetsLine      int
 	rulesLine         int
+
+	hostsLine          int
+	proxyProvidersLine int
 }
 
 func findAndValidateAnchors(lines []string, target render.Target, templateURL string) (anchorPos, error) {
-	pos := anchorPos{proxiesLine: -1, groupsLine: -1, ruleProvidersLine: -1, rulesetsLine: -1, rulesLine: -1}
-	countP, countG, countRP, countRS, countR := 0, 0, 0, 0, 0
+	pos := anchorPos{proxiesLine: -1, groupsLine: -1, ruleProvidersLine: -1, rulesetsLine: -1, rulesLine: -1, hostsLine: -1, proxyProvidersLine: -1}
+	countP, countG, countRP, countRS, countR, countH, countPP := 0, 0, 0, 0, 0, 0, 0
 
 	section := ""
 	for i, line := range lines {
@@ -95,6 +128,12 @@ func findAndValidateAnchors(lines []string, target render.Target, templateURL st
 		if strings.Contains(line, AnchorRules) && strings.TrimSpace(line) != AnchorRules {
 			return anchorPos{}, anchorNotStandalone(templateURL, line, AnchorRules)
 		}
+		if strings.Contains(line, AnchorHosts) && strings.TrimSpace(line) != AnchorHosts {
+			return anchorPos{}, anchorNotStandalone(templateURL, line, AnchorHosts)
+		}
+		if strings.Contains(line, AnchorProxyProviders) && strings.TrimSpace(line) != AnchorProxyProviders {
+			return anchorPos{}, anchorNotStandalone(templateURL, line, AnchorProxyProviders)
+		}
 
 		trim := strings.TrimSpace(line)
 		if sec, ok := parseSectionHeader(trim); ok {
@@ -157,6 +196,21 @@ func findAndValidateAnchors(lines []string, target render.Target, templateURL st
 					return anchorPos{}, sectionError(templateURL, fmt.Sprintf("%s 必须位于 [filter_local] 段内", AnchorRules))
 				}
 			}
+		case AnchorHosts:
+			countH++
+			pos.hostsLine = i
+			if (target == render.TargetSurge || target == render.TargetShadowrocket) && section != "host" {
+				return anchorPos{}, sectionError(templateURL, fmt.Sprintf("%s 必须位于 [Host] 段内", AnchorHosts))
+			}
+			if target == render.TargetQuanx && section != "dns" {
+				return anchorPos{}, sectionError(templateURL, fmt.Sprintf("%s 必须位于 [dns] 段内", AnchorHosts))
+			}
+		case AnchorProxyProviders:
+			countPP++
+			pos.proxyProvidersLine = i
+			if target != render.TargetClash {
+				return anchorPos{}, sectionError(templateURL, fmt.Sprintf("%s 仅支持 Clash 模板（target=clash）", AnchorProxyProviders))
+			}
 		}
 	}
 
@@ -187,12 +241,23 @@ func findAndValidateAnchors(lines []string, target render.Target, templateURL st
 	if countR > 1 {
 		return anchorPos{}, anchorDup(templateURL, AnchorRules)
 	}
+	if countH > 1 {
+		return anchorPos{}, anchorDup(templateURL, AnchorHosts)
+	}
+	if countPP > 1 {
+		return anchorPos{}, anchorDup(templateURL, AnchorProxyProviders)
+	}
 
 	// Clash YAML minimal check: anchor indent should not be 0.
 	if target == render.TargetClash {
 		if leadingWhitespace(lines[pos.proxiesLine]) == "" || leadingWhitespace(lines[pos.groupsLine]) == "" || leadingWhitespace(lines[pos.ruleProvidersLine]) == "" || leadingWhitespace(lines[pos.rulesLine]) == "" {
 			return anchorPos{}, sectionError(templateURL, "Clash 模板锚点缩进不能为 0（应位于对应列表下方）")
 		}
+		for _, i := range []int{pos.hostsLine, pos.proxyProvidersLine} {
+			if i != -1 && leadingWhitespace(lines[i]) == "" {
+				return anchorPos{}, sectionError(templateURL, "Clash 模板锚点缩进不能为 0（应位于对应列表下方）")
+			}
+		}
 	}
 
 	if target == render.TargetQuanx {
@@ -204,6 +269,36 @@ func findAndValidateAnchors(lines []string, target render.Target, templateURL st
 	return pos, nil
 }
 
+// replaceInlineAnchors replaces the inline anchors in one template line.
+func replaceInlineAnchors(line string, blocks render.Blocks, templateURL string) (string, error) {
+	if !strings.Contains(line, "#@") {
+		return line, nil
+	}
+	var err error
+	out := inlineAnchorRe.ReplaceAllStringFunc(line, func(anchor string) string {
+		switch anchor {
+		case AnchorProxyNames:
+			return blocks.ProxyNames
+		case AnchorMITMHostnames:
+			return blocks.MITMHostnames
+		}
+		group := strings.TrimSuffix(strings.TrimPrefix(anchor, AnchorGroupMembersPrefix), "@#")
+		members, ok := blocks.GroupMembers[group]
+		if !ok && err == nil {
+			err = &TemplateError{AppError: model.AppError{
+				Code:    "TEMPLATE_SECTION_ERROR",
+				Message: fmt.Sprintf("锚点 %s 引用的策略组不存在", anchor),
+				Stage:   "validate_template",
+				URL:     templateURL,
+				Snippet: line,
+				Hint:    "use the name of a group in the generated output",
+			}}
+		}
+		return members
+	})
+	return out, err
+}
+
 func anchorMissing(templateURL, anchor string) error {
 	return &TemplateError{
 		AppError: model.AppError{
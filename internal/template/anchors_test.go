@@ -201,3 +201,65 @@ func hasBareLF(s string) bool {
 	}
 	return false
 }
+
+func TestInjectAnchors_OptionalAnchors(t *testing.T) {
+	templateText := "" +
+		"[Proxy]\n" +
+		"#@PROXIES@#\n" +
+		"[Proxy Group]\n" +
+		"#@GROUPS@#\n" +
+		"ALL = select, #@PROXY_NAMES@#\n" +
+		"MINE = fallback, #@GROUP_MEMBERS:PROXY@#, url=http://t.example/204\n" +
+		"[Rule]\n" +
+		"#@RULES@#\n" +
+		"[Host]\n" +
+		"#@HOSTS@#\n" +
+		"[MITM]\n" +
+		"hostname = #@MITM_HOSTNAMES@#\n"
+
+	blocks := render.Blocks{
+		Proxies:       "A = ss, a.com, 1, encrypt-method=aes-128-gcm, password=p",
+		Groups:        "PROXY = select, A, DIRECT",
+		Rules:         "FINAL,PROXY",
+		ProxyNames:    "A, B",
+		GroupMembers:  map[string]string{"PROXY": "A, DIRECT"},
+		Hosts:         "router.lan = 192.168.1.1",
+		MITMHostnames: "*.example.com, api.example.org",
+	}
+	out, err := InjectAnchors(templateText, blocks, AnchorOptions{Target: render.TargetSurge})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"ALL = select, A, B\n",
+		"MINE = fallback, A, DIRECT, url=http://t.example/204\n",
+		"[Host]\nrouter.lan = 192.168.1.1\n",
+		"hostname = *.example.com, api.example.org\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output missing %q, got:\n%s", want, out)
+		}
+	}
+
+	// Unknown group in a member anchor.
+	_, err = InjectAnchors(strings.Replace(templateText, "GROUP_MEMBERS:PROXY", "GROUP_MEMBERS:NOPE", 1), blocks, AnchorOptions{Target: render.TargetSurge})
+	var te *TemplateError
+	if !errors.As(err, &te) || te.AppError.Code != "TEMPLATE_SECTION_ERROR" {
+		t.Fatalf("expected TEMPLATE_SECTION_ERROR, got %T: %v", err, err)
+	}
+
+	// Section checks for the standalone anchors.
+	for _, tc := range []struct {
+		target render.Target
+		text   string
+	}{
+		{render.TargetSurge, strings.Replace(templateText, "[Host]\n", "", 1)},
+		{render.TargetSurge, templateText + "#@PROXY_PROVIDERS@#\n"},
+		{render.TargetClash, "proxies:\n  #@PROXIES@#\nproxy-groups:\n  #@GROUPS@#\nrule-providers:\n  #@RULE_PROVIDERS@#\nrules:\n  #@RULES@#\nhosts:\n#@HOSTS@#\n"},
+	} {
+		_, err := InjectAnchors(tc.text, blocks, AnchorOptions{Target: tc.target})
+		if !errors.As(err, &te) || te.AppError.Code != "TEMPLATE_SECTION_ERROR" {
+			t.Fatalf("%s: expected TEMPLATE_SECTION_ERROR, got %T: %v", tc.target, err, err)
+		}
+	}
+}
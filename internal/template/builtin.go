@@ -0,0 +1,48 @@
+package template
+
+import (
+	"embed"
+	"fmt"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/profile"
+	"github.com/John-Robertt/subconverter-go/internal/render"
+)
+
+// BuiltinPrefix marks a template reference that is served from the binary
+// instead of being fetched (e.g. "builtin:default").
+const BuiltinPrefix = profile.BuiltinTemplatePrefix
+
+//go:embed builtin
+var builtinFS embed.FS
+
+var builtinFiles = map[render.Target]string{
+	render.TargetClash:        "clash.yaml",
+	render.TargetSurge:        "surge.conf",
+	render.TargetShadowrocket: "shadowrocket.conf",
+	render.TargetQuanx:        "quanx.conf",
+}
+
+// IsBuiltin reports whether ref names a built-in template.
+func IsBuiltin(ref string) bool { return strings.HasPrefix(ref, BuiltinPrefix) }
+
+// Builtin returns the built-in template ref ("builtin:<name>") for target.
+// Only profile.BuiltinDefaultTemplate exists.
+func Builtin(target render.Target, ref string) (string, error) {
+	file, ok := builtinFiles[target]
+	if !ok || ref != profile.BuiltinDefaultTemplate {
+		return "", &TemplateError{AppError: model.AppError{
+			Code:    "INVALID_ARGUMENT",
+			Message: fmt.Sprintf("内置模板不存在：%s（target=%s）", ref, target),
+			Stage:   "validate_template",
+			URL:     ref,
+			Hint:    "expected: " + profile.BuiltinDefaultTemplate,
+		}}
+	}
+	b, err := builtinFS.ReadFile("builtin/" + file)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,35 @@
+package template
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/John-Robertt/subconverter-go/internal/render"
+)
+
+func TestBuiltin_DefaultTemplatesInject(t *testing.T) {
+	blocks := render.Blocks{
+		Proxies:        "P",
+		Groups:         "G",
+		RuleProviders:  "{}",
+		Rulesets:       "",
+		Rules:          "R",
+		Hosts:          "{}",
+		ProxyProviders: "{}",
+	}
+	for _, target := range []render.Target{render.TargetClash, render.TargetSurge, render.TargetShadowrocket, render.TargetQuanx} {
+		text, err := Builtin(target, "builtin:default")
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		if _, err := Inject(text, blocks, AnchorOptions{Target: target, TemplateURL: "builtin:default"}); err != nil {
+			t.Fatalf("%s: built-in template does not inject: %v", target, err)
+		}
+	}
+
+	_, err := Builtin(render.TargetClash, "builtin:fancy")
+	var te *TemplateError
+	if !errors.As(err, &te) || te.AppError.Code != "INVALID_ARGUMENT" {
+		t.Fatalf("expected INVALID_ARGUMENT, got %T: %v", err, err)
+	}
+}
@@ -0,0 +1,228 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/render"
+	"gopkg.in/yaml.v3"
+)
+
+// Inject fills templateText with blocks. A Clash template without any
+// standalone line anchor is treated as a plain Clash config and merged
+// structurally (MergeClashYAML); every other template goes through
+// InjectAnchors.
+func Inject(templateText string, blocks render.Blocks, opt AnchorOptions) (string, error) {
+	if opt.Target == render.TargetClash && templateText != "" && !hasStandaloneAnchor(templateText, AnchorProxies, AnchorGroups, AnchorRuleProviders, AnchorRules) {
+		return MergeClashYAML(templateText, blocks, opt)
+	}
+	return InjectAnchors(templateText, blocks, opt)
+}
+
+// hasStandaloneAnchor reports whether one of anchors fills a line by itself;
+// a comment that merely mentions an anchor does not count.
+func hasStandaloneAnchor(text string, anchors ...string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		if slices.Contains(anchors, strings.TrimSpace(line)) {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeClashYAML parses a plain Clash YAML template and writes the generated
+// sections into it:
+//   - proxies / proxy-groups / rules are replaced;
+//   - rule-providers, and proxy-providers / hosts when the profile defines
+//     any, are merged by key (generated entries replace template entries of
+//     the same name in place, new ones are appended).
+//
+// Missing keys are inserted next to the other generated keys, in the order
+// of the sections above. The optional block anchors (#@HOSTS@#,
+// #@PROXY_PROVIDERS@#) are rejected: the merge fills those keys itself.
+// Everything else,
+// including comments, is re-emitted as parsed with 2-space indentation;
+// inline anchors are replaced before parsing.
+func MergeClashYAML(templateText string, blocks render.Blocks, opt AnchorOptions) (string, error) {
+	if hasStandaloneAnchor(templateText, AnchorHosts, AnchorProxyProviders) {
+		return "", &TemplateError{
+			AppError: model.AppError{
+				Code:    "TEMPLATE_SECTION_ERROR",
+				Message: "不含 #@PROXIES@# 等行锚点的 Clash 模板不能使用 #@HOSTS@# / #@PROXY_PROVIDERS@#",
+				Stage:   "validate_template",
+				URL:     opt.TemplateURL,
+				Hint:    "structural merge fills hosts / proxy-providers itself; remove the anchor, or add #@PROXIES@# / #@GROUPS@# / #@RULE_PROVIDERS@# / #@RULES@# to use anchor mode",
+			},
+		}
+	}
+	newline := detectNewline(templateText)
+	lines := strings.Split(strings.ReplaceAll(templateText, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		out, err := replaceInlineAnchors(line, blocks, opt.TemplateURL)
+		if err != nil {
+			return "", err
+		}
+		lines[i] = out
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &doc); err != nil {
+		return "", &TemplateError{
+			AppError: model.AppError{
+				Code:    "TEMPLATE_SECTION_ERROR",
+				Message: "Clash 模板 YAML 解析失败",
+				Stage:   "validate_template",
+				URL:     opt.TemplateURL,
+				Hint:    "a Clash template without #@...@# anchors must be valid YAML",
+			},
+			Cause: err,
+		}
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return "", sectionError(opt.TemplateURL, "Clash 模板顶层必须是 YAML 对象")
+	}
+	root := doc.Content[0]
+
+	sections := []struct {
+		key, block string
+		merge      bool
+	}{
+		{"proxies", blocks.Proxies, false},
+		{"proxy-groups", blocks.Groups, false},
+		{"proxy-providers", blocks.ProxyProviders, true},
+		{"rule-providers", blocks.RuleProviders, true},
+		{"hosts", blocks.Hosts, true},
+		{"rules", blocks.Rules, false},
+	}
+	order := make([]string, len(sections))
+	for i, s := range sections {
+		order[i] = s.key
+	}
+	for i, s := range sections {
+		generated, err := blockNode(s.block, s.merge)
+		if err != nil {
+			// Renderer bug: surfaces as INTERNAL_ERROR.
+			return "", fmt.Errorf("generated %s is not valid YAML: %w", s.key, err)
+		}
+		if s.merge {
+			if len(generated.Content) == 0 {
+				continue
+			}
+			if cur := mappingValueNode(root, s.key); cur != nil && cur.Kind == yaml.MappingNode {
+				mergeMapping(cur, generated)
+				continue
+			}
+		}
+		if !replaceMappingValue(root, s.key, generated) {
+			insertMappingValue(root, s.key, generated, order[:i], order[i+1:])
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return "", fmt.Errorf("encode clash template: %w", err)
+	}
+	_ = enc.Close()
+
+	out := buf.String()
+	if newline == "\r\n" {
+		out = strings.ReplaceAll(out, "\n", "\r\n")
+	}
+	return out, nil
+}
+
+// blockNode parses a generated block: a sequence for the list sections, a
+// mapping for the merged ones. An empty block yields an empty collection.
+func blockNode(block string, mapping bool) (*yaml.Node, error) {
+	empty := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+	if mapping {
+		empty = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	if strings.TrimSpace(block) == "" {
+		return empty, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(block), &doc); err != nil {
+		return nil, err
+	}
+	n := doc.Content[0]
+	if (mapping && n.Kind != yaml.MappingNode) || (!mapping && n.Kind != yaml.SequenceNode) {
+		return nil, fmt.Errorf("unexpected YAML node kind %d", n.Kind)
+	}
+	// "{}" placeholders would otherwise keep the flow style after merging.
+	n.Style &^= yaml.FlowStyle
+	return n, nil
+}
+
+func mappingValueNode(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue replaces the value of key, keeping the comments of the
+// key, or appends key when m lacks it.
+func setMappingValue(m *yaml.Node, key string, value *yaml.Node) {
+	if !replaceMappingValue(m, key, value) {
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	}
+}
+
+// replaceMappingValue replaces the value of key, keeping the comments of the
+// key, and reports whether m has key.
+func replaceMappingValue(m *yaml.Node, key string, value *yaml.Node) bool {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			value.LineComment = m.Content[i+1].LineComment
+			m.Content[i+1] = value
+			return true
+		}
+	}
+	return false
+}
+
+// insertMappingValue adds key right after the last present key of before,
+// else right before the first present key of after, else at the end.
+func insertMappingValue(m *yaml.Node, key string, value *yaml.Node, before, after []string) {
+	at := len(m.Content)
+	if i := lastKeyIndex(m, before); i >= 0 {
+		at = i + 2
+	} else if i := firstKeyIndex(m, after); i >= 0 {
+		at = i
+	}
+	m.Content = slices.Insert(m.Content, at, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func lastKeyIndex(m *yaml.Node, keys []string) int {
+	idx := -1
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if slices.Contains(keys, m.Content[i].Value) {
+			idx = i
+		}
+	}
+	return idx
+}
+
+func firstKeyIndex(m *yaml.Node, keys []string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if slices.Contains(keys, m.Content[i].Value) {
+			return i
+		}
+	}
+	return -1
+}
+
+func mergeMapping(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		setMappingValue(dst, src.Content[i].Value, src.Content[i+1])
+	}
+	dst.Style &^= yaml.FlowStyle
+}
@@ -0,0 +1,98 @@
+package template
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/John-Robertt/subconverter-go/internal/render"
+)
+
+func TestInject_ClashTemplateWithoutAnchorsIsMerged(t *testing.T) {
+	templateText := "" +
+		"# my config\n" +
+		"mixed-port: 7890\n" +
+		"dns: {enable: true, nameserver: [223.5.5.5]} # keep flow\n" +
+		"proxies: [{name: old, type: ss, server: old.example.com, port: 1, cipher: aes-128-gcm, password: p}]\n" +
+		"proxy-groups:\n" +
+		"  - {name: OLD, type: select, proxies: [old]}\n" +
+		"  - {name: ALL, type: select, proxies: [#@PROXY_NAMES@#]}\n" +
+		"rule-providers: {keep: {type: http, behavior: domain, url: \"https://example.com/keep.yaml\", interval: 86400}}\n" +
+		"rules:\n" +
+		"  - MATCH,OLD\n"
+
+	blocks := render.Blocks{
+		Proxies:        "- name: \"HK\"\n  type: ss\n  server: \"hk.example.com\"\n  port: 8388\n  cipher: \"aes-128-gcm\"\n  password: \"p\"",
+		Groups:         "- name: \"PROXY\"\n  type: \"select\"\n  proxies:\n    - \"HK\"",
+		RuleProviders:  "LAN.list:\n  type: http\n  behavior: classical\n  url: \"https://example.com/LAN.list\"\n  interval: 86400\n  format: text",
+		Rules:          "- \"RULE-SET,LAN.list,DIRECT\"\n- \"MATCH,PROXY\"",
+		Hosts:          "{}",
+		ProxyProviders: "{}",
+		ProxyNames:     `"HK"`,
+	}
+	out, err := Inject(templateText, blocks, AnchorOptions{Target: render.TargetClash})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"# my config\nmixed-port: 7890\n",
+		"dns: {enable: true, nameserver: [223.5.5.5]} # keep flow\n",
+		"proxies:\n  - name: \"HK\"\n",
+		"proxy-groups:\n  - name: \"PROXY\"\n",
+		"rule-providers:\n  keep: {type: http",
+		"  LAN.list:\n    type: http\n",
+		"rules:\n  - \"RULE-SET,LAN.list,DIRECT\"\n  - \"MATCH,PROXY\"\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output missing %q, got:\n%s", want, out)
+		}
+	}
+	for _, gone := range []string{"old.example.com", "OLD", "hosts", "proxy-providers"} {
+		if strings.Contains(out, gone) {
+			t.Fatalf("output should not contain %q, got:\n%s", gone, out)
+		}
+	}
+
+	again, err := Inject(templateText, blocks, AnchorOptions{Target: render.TargetClash})
+	if err != nil || again != out {
+		t.Fatalf("output is not deterministic: %v", err)
+	}
+}
+
+func TestInject_ClashMergeAddsMissingKeysAndRejectsBadYAML(t *testing.T) {
+	blocks := render.Blocks{
+		Proxies:        "- name: \"HK\"\n  type: ss",
+		Groups:         "",
+		RuleProviders:  "{}",
+		Rules:          "- \"MATCH,DIRECT\"",
+		Hosts:          "\"router.lan\": \"192.168.1.1\"",
+		ProxyProviders: "{}",
+	}
+	out, err := Inject("port: 7890\r\n", blocks, AnchorOptions{Target: render.TargetClash})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "port: 7890\r\nproxies:\r\n  - name: \"HK\"\r\n    type: ss\r\nproxy-groups: []\r\nhosts:\r\n  \"router.lan\": \"192.168.1.1\"\r\nrules:\r\n  - \"MATCH,DIRECT\"\r\n"
+	if out != want {
+		t.Fatalf("got:\n%q\nwant:\n%q", out, want)
+	}
+
+	// Generated keys keep the documented order around the template's keys;
+	// a comment mentioning an anchor does not switch to anchor mode.
+	out, err = Inject("# generated by #@RULES@# etc.\nport: 7890\nrules: [MATCH,OLD]\ndns: {}\n", blocks, AnchorOptions{Target: render.TargetClash})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want = "# generated by #@RULES@# etc.\nport: 7890\nproxies:\n  - name: \"HK\"\n    type: ss\nproxy-groups: []\nhosts:\n  \"router.lan\": \"192.168.1.1\"\nrules:\n  - \"MATCH,DIRECT\"\ndns: {}\n"
+	if out != want {
+		t.Fatalf("got:\n%q\nwant:\n%q", out, want)
+	}
+
+	for _, text := range []string{"proxies: [\n", "- just a list\n", "hosts:\n  #@HOSTS@#\n", "proxy-providers:\n  #@PROXY_PROVIDERS@#\n"} {
+		_, err := Inject(text, blocks, AnchorOptions{Target: render.TargetClash})
+		var te *TemplateError
+		if !errors.As(err, &te) || te.AppError.Code != "TEMPLATE_SECTION_ERROR" {
+			t.Fatalf("%q: expected TEMPLATE_SECTION_ERROR, got %T: %v", text, err, err)
+		}
+	}
+}
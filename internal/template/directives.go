@@ -0,0 +1,266 @@
+package template
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/John-Robertt/subconverter-go/internal/fetch"
+	"github.com/John-Robertt/subconverter-go/internal/model"
+	"github.com/John-Robertt/subconverter-go/internal/render"
+)
+
+const (
+	directiveIf      = "#@IF "
+	directiveElse    = "#@ELSE@#"
+	directiveEndif   = "#@ENDIF@#"
+	directiveInclude = "#@INCLUDE "
+)
+
+var varDirectiveRe = regexp.MustCompile(`#@VAR ([^@]*)@#`)
+
+// PreprocessOptions configures Preprocess.
+type PreprocessOptions struct {
+	Target      render.Target
+	TemplateURL string
+	// Vars are the resolved profile vars (defaults plus request overrides).
+	Vars map[string]string
+	// LoadInclude fetches #@INCLUDE@# snippets. Nil rejects them.
+	LoadInclude fetch.Loader
+}
+
+// Preprocess evaluates the template directives before anchors are injected.
+// Includes and conditionals are handled in one top-down pass (snippets in
+// inactive branches are not fetched), then vars are replaced:
+//
+//	#@INCLUDE <url>@#          standalone; replaced by the snippet (indented like the line)
+//	#@IF <cond>@# / #@ELSE@# / #@ENDIF@#   standalone; may nest
+//	#@VAR <name>@#             inline; replaced by the value of a profile var
+//
+// A condition is `<key>=<v1>|<v2>...` or `<key>!=...`, where key is `target`
+// or a profile var name. Rules are defined in docs/spec/SPEC_TEMPLATE_ANCHORS.md.
+func Preprocess(templateText string, opt PreprocessOptions) (string, error) {
+	if !strings.Contains(templateText, "#@INCLUDE") && !strings.Contains(templateText, "#@IF") &&
+		!strings.Contains(templateText, "#@ELSE") && !strings.Contains(templateText, "#@ENDIF") &&
+		!strings.Contains(templateText, "#@VAR") {
+		return templateText, nil
+	}
+
+	newline := detectNewline(templateText)
+	normalized := strings.ReplaceAll(templateText, "\r\n", "\n")
+	endsWithNewline := strings.HasSuffix(normalized, "\n")
+
+	inc := &includeExpander{opt: opt, cache: make(map[string]string)}
+	lines, err := inc.expand(strings.Split(strings.TrimSuffix(normalized, "\n"), "\n"), opt.TemplateURL, []string{opt.TemplateURL})
+	if err != nil {
+		return "", err
+	}
+	if len(inc.conds) > 0 {
+		return "", directiveError(opt.TemplateURL, "#@IF@# 缺少对应的 #@ENDIF@#", "")
+	}
+	for i, line := range lines {
+		if lines[i], err = replaceVars(line, opt); err != nil {
+			return "", err
+		}
+	}
+
+	out := strings.Join(lines, "\n")
+	if endsWithNewline {
+		out += "\n"
+	}
+	if newline == "\r\n" {
+		out = strings.ReplaceAll(out, "\n", "\r\n")
+	}
+	return out, nil
+}
+
+// includeExpander expands includes and evaluates the conditionals in one
+// pass, so snippets in inactive branches are never fetched. The condition
+// stack spans the whole include tree, as if the snippets were inlined.
+type includeExpander struct {
+	opt   PreprocessOptions
+	cache map[string]string
+	count int
+	conds []condFrame
+}
+
+func (e *includeExpander) active() bool {
+	return len(e.conds) == 0 || e.conds[len(e.conds)-1].active()
+}
+
+func (e *includeExpander) expand(lines []string, origin string, stack []string) ([]string, error) {
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		trim := strings.TrimSpace(line)
+		if ok, err := e.conditional(line, origin); err != nil {
+			return nil, err
+		} else if ok {
+			continue
+		}
+		if !strings.Contains(line, directiveInclude) {
+			if e.active() {
+				out = append(out, line)
+			}
+			continue
+		}
+		if !strings.HasPrefix(trim, directiveInclude) || !strings.HasSuffix(trim, "@#") {
+			return nil, directiveNotStandalone(origin, line)
+		}
+		if !e.active() {
+			continue
+		}
+		ref := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(trim, directiveInclude), "@#"))
+		u, err := fetch.ResolveIncludeURL(origin, ref)
+		if err != nil {
+			return nil, includeError(origin, "#@INCLUDE@# URL 不合法", ref, err)
+		}
+		if slices.Contains(stack, u) {
+			return nil, includeError(origin, "#@INCLUDE@# 存在循环引用", strings.Join(append(slices.Clone(stack), u), " -> "), nil)
+		}
+		if len(stack) > fetch.MaxIncludeDepth || e.count >= fetch.MaxIncludes {
+			return nil, includeError(origin, fmt.Sprintf("#@INCLUDE@# 超出限制（深度 %d，总数 %d）", fetch.MaxIncludeDepth, fetch.MaxIncludes), u, nil)
+		}
+		if e.opt.LoadInclude == nil {
+			return nil, includeError(origin, "当前调用方式不支持 #@INCLUDE@#", u, nil)
+		}
+		e.count++
+
+		text, ok := e.cache[u]
+		if !ok {
+			if text, err = e.opt.LoadInclude(u); err != nil {
+				return nil, err
+			}
+			e.cache[u] = text
+		}
+		snippet := strings.Split(strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), "\n")
+		sub, err := e.expand(snippet, u, append(stack, u))
+		if err != nil {
+			return nil, err
+		}
+		indent := leadingWhitespace(line)
+		for _, s := range sub {
+			if s != "" {
+				s = indent + s
+			}
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+type condFrame struct {
+	parentActive, cond, inElse bool
+}
+
+func (f condFrame) active() bool { return f.parentActive && f.cond != f.inElse }
+
+// conditional applies an #@IF@# / #@ELSE@# / #@ENDIF@# line to the condition
+// stack and reports whether line was such a directive.
+func (e *includeExpander) conditional(line, origin string) (bool, error) {
+	trim := strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(trim, directiveIf) && strings.HasSuffix(trim, "@#"):
+		cond, err := evalCondition(strings.TrimSuffix(strings.TrimPrefix(trim, directiveIf), "@#"), e.opt, origin)
+		if err != nil {
+			return false, err
+		}
+		e.conds = append(e.conds, condFrame{parentActive: e.active(), cond: cond})
+	case trim == directiveElse:
+		if len(e.conds) == 0 || e.conds[len(e.conds)-1].inElse {
+			return false, directiveError(origin, "#@ELSE@# 没有对应的 #@IF@#", line)
+		}
+		e.conds[len(e.conds)-1].inElse = true
+	case trim == directiveEndif:
+		if len(e.conds) == 0 {
+			return false, directiveError(origin, "#@ENDIF@# 没有对应的 #@IF@#", line)
+		}
+		e.conds = e.conds[:len(e.conds)-1]
+	case strings.Contains(line, "#@IF") || strings.Contains(line, "#@ELSE") || strings.Contains(line, "#@ENDIF"):
+		return false, directiveNotStandalone(origin, line)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func evalCondition(expr string, opt PreprocessOptions, origin string) (bool, error) {
+	expr = strings.TrimSpace(expr)
+	key, values, ok := strings.Cut(expr, "=")
+	negate := false
+	if ok && strings.HasSuffix(key, "!") {
+		key, negate = strings.TrimSuffix(key, "!"), true
+	}
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return false, directiveError(origin, "#@IF@# 条件不合法", expr)
+	}
+
+	var actual string
+	if key == "target" {
+		actual = string(opt.Target)
+	} else if v, ok := opt.Vars[key]; ok {
+		actual = v
+	} else {
+		return false, directiveError(origin, fmt.Sprintf("#@IF@# 引用的变量未在 profile vars 中声明：%s", key), expr)
+	}
+
+	match := false
+	for _, v := range strings.Split(values, "|") {
+		if strings.TrimSpace(v) == actual {
+			match = true
+			break
+		}
+	}
+	return match != negate, nil
+}
+
+func replaceVars(line string, opt PreprocessOptions) (string, error) {
+	if !strings.Contains(line, "#@VAR") {
+		return line, nil
+	}
+	var err error
+	out := varDirectiveRe.ReplaceAllStringFunc(line, func(ref string) string {
+		name := strings.TrimSpace(varDirectiveRe.FindStringSubmatch(ref)[1])
+		v, ok := opt.Vars[name]
+		switch {
+		case err != nil:
+		case !ok:
+			err = directiveError(opt.TemplateURL, fmt.Sprintf("#@VAR@# 引用的变量未在 profile vars 中声明：%s", name), line)
+		case strings.ContainsAny(v, "\r\n\x00"):
+			// The value must stay inside this line.
+			err = directiveError(opt.TemplateURL, fmt.Sprintf("#@VAR@# 变量值不能包含换行或控制字符：%s", name), line)
+		}
+		return v
+	})
+	return out, err
+}
+
+func directiveNotStandalone(templateURL, line string) error {
+	return directiveError(templateURL, "模板指令必须独占一行", line)
+}
+
+func directiveError(templateURL, msg, snippet string) error {
+	return &TemplateError{
+		AppError: model.AppError{
+			Code:    "TEMPLATE_SECTION_ERROR",
+			Message: msg,
+			Stage:   "validate_template",
+			URL:     templateURL,
+			Snippet: snippet,
+		},
+	}
+}
+
+func includeError(templateURL, msg, snippet string, cause error) error {
+	return &TemplateError{
+		AppError: model.AppError{
+			Code:    "TEMPLATE_INCLUDE_ERROR",
+			Message: msg,
+			Stage:   "validate_template",
+			URL:     templateURL,
+			Snippet: snippet,
+		},
+		Cause: cause,
+	}
+}
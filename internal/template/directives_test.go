@@ -0,0 +1,148 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/John-Robertt/subconverter-go/internal/render"
+)
+
+func TestPreprocess_Directives(t *testing.T) {
+	snippets := map[string]string{
+		"https://example.com/t/dns.conf":         "[DNS]\r\nserver = #@VAR DNS@#\r\n#@INCLUDE common/tail.conf@#\r\n",
+		"https://example.com/t/common/tail.conf": "# tail\n",
+	}
+	loads := 0
+	load := func(u string) (string, error) {
+		loads++
+		s, ok := snippets[u]
+		if !ok {
+			return "", fmt.Errorf("not found: %s", u)
+		}
+		return s, nil
+	}
+	templateText := "" +
+		"[General]\n" +
+		"#@IF target=surge|shadowrocket@#\n" +
+		"surge-only = true\n" +
+		"#@IF TUN!=on@#\n" +
+		"tun = off\n" +
+		"#@ELSE@#\n" +
+		"tun = on\n" +
+		"#@ENDIF@#\n" +
+		"#@ELSE@#\n" +
+		"other = true\n" +
+		"#@ENDIF@#\n" +
+		"  #@INCLUDE dns.conf@#\n" +
+		"#@INCLUDE dns.conf@#\n"
+
+	opt := PreprocessOptions{
+		Target:      render.TargetSurge,
+		TemplateURL: "https://example.com/t/main.conf",
+		Vars:        map[string]string{"DNS": "1.1.1.1", "TUN": "on"},
+		LoadInclude: load,
+	}
+	out, err := Preprocess(templateText, opt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "" +
+		"[General]\n" +
+		"surge-only = true\n" +
+		"tun = on\n" +
+		"  [DNS]\n" +
+		"  server = 1.1.1.1\n" +
+		"  # tail\n" +
+		"[DNS]\n" +
+		"server = 1.1.1.1\n" +
+		"# tail\n"
+	if out != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+	}
+	if loads != 2 {
+		t.Fatalf("loads=%d, want=2 (snippets are fetched once)", loads)
+	}
+
+	opt.Target = render.TargetQuanx
+	if out, err := Preprocess(templateText, opt); err != nil || !strings.HasPrefix(out, "[General]\nother = true\n") {
+		t.Fatalf("quanx: err=%v out:\n%s", err, out)
+	}
+
+	// Templates without directives are returned unchanged.
+	if out, err := Preprocess("a #@PROXY_NAMES@#\r\n", PreprocessOptions{}); err != nil || out != "a #@PROXY_NAMES@#\r\n" {
+		t.Fatalf("unchanged: err=%v out=%q", err, out)
+	}
+}
+
+func TestPreprocess_Errors(t *testing.T) {
+	snippets := map[string]string{
+		"https://example.com/a.conf": "#@INCLUDE b.conf@#\n",
+		"https://example.com/b.conf": "#@INCLUDE a.conf@#\n",
+	}
+	opt := PreprocessOptions{
+		Target:      render.TargetClash,
+		TemplateURL: "https://example.com/main.yaml",
+		Vars:        map[string]string{"X": "1", "BAD": "1\n[Rule]\nMATCH,REJECT"},
+		LoadInclude: func(u string) (string, error) { return snippets[u], nil },
+	}
+	for text, code := range map[string]string{
+		"#@IF target=clash@#\n":            "TEMPLATE_SECTION_ERROR",
+		"#@ENDIF@#\n":                      "TEMPLATE_SECTION_ERROR",
+		"#@IF X=1@#\n#@ELSE@#\n#@ELSE@#\n": "TEMPLATE_SECTION_ERROR",
+		"#@IF NOPE=1@#\n#@ENDIF@#\n":       "TEMPLATE_SECTION_ERROR",
+		"#@IF target@#\n#@ENDIF@#\n":       "TEMPLATE_SECTION_ERROR",
+		"a: #@VAR NOPE@#\n":                "TEMPLATE_SECTION_ERROR",
+		"a: #@VAR BAD@#\n":                 "TEMPLATE_SECTION_ERROR", // would inject lines
+		"a: 1 #@ENDIF@#\n":                 "TEMPLATE_SECTION_ERROR",
+		"#@INCLUDE a.conf@#\n":             "TEMPLATE_INCLUDE_ERROR",
+		"#@INCLUDE ftp://x/a.conf@#\n":     "TEMPLATE_INCLUDE_ERROR",
+	} {
+		_, err := Preprocess(text, opt)
+		var te *TemplateError
+		if !errors.As(err, &te) || te.AppError.Code != code {
+			t.Fatalf("%q: expected %s, got %T: %v", text, code, err, err)
+		}
+	}
+
+	// Inline templates have no base URL for relative includes.
+	opt.TemplateURL = ""
+	_, err := Preprocess("#@INCLUDE a.conf@#\n", opt)
+	var te *TemplateError
+	if !errors.As(err, &te) || te.AppError.Code != "TEMPLATE_INCLUDE_ERROR" {
+		t.Fatalf("expected TEMPLATE_INCLUDE_ERROR, got %T: %v", err, err)
+	}
+}
+
+func TestPreprocess_IncludeInInactiveBranchNotLoaded(t *testing.T) {
+	var loaded []string
+	opt := PreprocessOptions{
+		Target:      render.TargetClash,
+		TemplateURL: "https://example.com/t/main.yaml",
+		LoadInclude: func(u string) (string, error) {
+			loaded = append(loaded, u)
+			if strings.HasSuffix(u, "/surge.conf") {
+				return "", fmt.Errorf("unreachable: %s", u)
+			}
+			return "dns: {}\n", nil
+		},
+	}
+	text := "" +
+		"#@IF target=surge@#\n" +
+		"#@INCLUDE surge.conf@#\n" +
+		"#@ELSE@#\n" +
+		"#@INCLUDE clash.yaml@#\n" +
+		"#@ENDIF@#\n"
+	out, err := Preprocess(text, opt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "dns: {}\n" {
+		t.Fatalf("got %q", out)
+	}
+	if want := []string{"https://example.com/t/clash.yaml"}; !slices.Equal(loaded, want) {
+		t.Fatalf("loaded=%v, want=%v", loaded, want)
+	}
+}
@@ -2,12 +2,29 @@ package template
 
 import (
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/John-Robertt/subconverter-go/internal/model"
 )
 
-const managedConfigPrefix = "#!MANAGED-CONFIG"
+const (
+	managedConfigPrefix = "#!MANAGED-CONFIG"
+
+	// DefaultManagedConfigInterval is the update interval (seconds) used when
+	// neither the template nor the profile sets one.
+	DefaultManagedConfigInterval = 86400
+)
+
+// ManagedConfigOptions are the profile overrides for the managed-config line.
+type ManagedConfigOptions struct {
+	// IntervalSec replaces interval= when > 0.
+	IntervalSec int
+	// Strict replaces strict= when HasStrict is set.
+	Strict    bool
+	HasStrict bool
+}
 
 // EnsureSurgeManagedConfig ensures the first non-empty line is:
 //
@@ -15,6 +32,13 @@ const managedConfigPrefix = "#!MANAGED-CONFIG"
 //
 // Rules are defined in docs/spec/SPEC_TEMPLATE_ANCHORS.md.
 func EnsureSurgeManagedConfig(text string, currentConvertURL string, templateURL string) (string, error) {
+	return EnsureSurgeManagedConfigWithOptions(text, currentConvertURL, templateURL, ManagedConfigOptions{})
+}
+
+// EnsureSurgeManagedConfigWithOptions is EnsureSurgeManagedConfig with the
+// interval / strict parameters taken from opt: they replace the template's
+// values, and an inserted line uses them instead of the defaults.
+func EnsureSurgeManagedConfigWithOptions(text string, currentConvertURL string, templateURL string, opt ManagedConfigOptions) (string, error) {
 	if strings.TrimSpace(currentConvertURL) == "" {
 		return "", &TemplateError{
 			AppError: model.AppError{
@@ -71,10 +95,16 @@ func EnsureSurgeManagedConfig(text string, currentConvertURL string, templateURL
 		if err != nil {
 			return "", err
 		}
-		lines[managedLine] = rewritten
+		lines[managedLine] = setManagedConfigParams(rewritten, opt)
 	} else {
-		// Insert with v1 default params.
-		defaultLine := fmt.Sprintf("%s %s interval=86400", managedConfigPrefix, currentConvertURL)
+		interval := DefaultManagedConfigInterval
+		if opt.IntervalSec > 0 {
+			interval = opt.IntervalSec
+		}
+		defaultLine := fmt.Sprintf("%s %s interval=%d", managedConfigPrefix, currentConvertURL, interval)
+		if opt.HasStrict {
+			defaultLine += fmt.Sprintf(" strict=%t", opt.Strict)
+		}
 		lines = append([]string{defaultLine}, lines...)
 	}
 
@@ -88,6 +118,87 @@ func EnsureSurgeManagedConfig(text string, currentConvertURL string, templateURL
 	return out, nil
 }
 
+// EnsureShadowrocketUpdateURL ensures [General] carries
+//
+//	update-url = <currentConvertURL>
+//
+// An existing update-url line is rewritten in place; otherwise the line is
+// inserted right after the [General] header (or a new [General] section is
+// prepended). Rules are defined in docs/spec/SPEC_TEMPLATE_ANCHORS.md.
+func EnsureShadowrocketUpdateURL(text string, currentConvertURL string, templateURL string) (string, error) {
+	if strings.TrimSpace(currentConvertURL) == "" {
+		return "", &TemplateError{
+			AppError: model.AppError{
+				Code:    "INVALID_ARGUMENT",
+				Message: "currentConvertURL 不能为空",
+				Stage:   "validate_template",
+				URL:     templateURL,
+			},
+		}
+	}
+
+	newline := detectNewline(text)
+	normalized := strings.ReplaceAll(text, "\r\n", "\n")
+	endsWithNewline := strings.HasSuffix(normalized, "\n")
+	lines := strings.Split(normalized, "\n")
+
+	general, updateLine := -1, -1
+	section := ""
+	for i, line := range lines {
+		trim := strings.TrimSpace(line)
+		if name, ok := parseSectionHeader(trim); ok {
+			section = name
+			if name == "general" {
+				if general != -1 {
+					return "", updateURLAmbiguous(templateURL, "模板包含多个 [General] 段")
+				}
+				general = i
+			}
+			continue
+		}
+		if section != "general" {
+			continue
+		}
+		if key, _, ok := strings.Cut(trim, "="); ok && strings.EqualFold(strings.TrimSpace(key), "update-url") {
+			if updateLine != -1 {
+				return "", updateURLAmbiguous(templateURL, "[General] 包含多条 update-url")
+			}
+			updateLine = i
+		}
+	}
+
+	entry := "update-url = " + currentConvertURL
+	switch {
+	case updateLine != -1:
+		lines[updateLine] = leadingWhitespace(lines[updateLine]) + entry
+	case general != -1:
+		lines = slices.Insert(lines, general+1, entry)
+	default:
+		lines = append([]string{"[General]", entry, ""}, lines...)
+	}
+
+	out := strings.Join(lines, "\n")
+	if !endsWithNewline {
+		out = strings.TrimSuffix(out, "\n")
+	}
+	if newline == "\r\n" {
+		out = strings.ReplaceAll(out, "\n", "\r\n")
+	}
+	return out, nil
+}
+
+func updateURLAmbiguous(templateURL, msg string) error {
+	return &TemplateError{
+		AppError: model.AppError{
+			Code:    "TEMPLATE_SECTION_ERROR",
+			Message: msg,
+			Stage:   "validate_template",
+			URL:     templateURL,
+			Hint:    "Shadowrocket update-url 必须唯一且位于 [General] 段",
+		},
+	}
+}
+
 func isManagedConfigLine(line string) bool {
 	trimLeft := strings.TrimLeft(line, " \t")
 	return strings.HasPrefix(trimLeft, managedConfigPrefix)
@@ -139,6 +250,43 @@ func rewriteManagedConfigURL(line string, newURL string, templateURL string) (st
 	return lead + managedConfigPrefix + rewritten, nil
 }
 
+// setManagedConfigParams replaces (or appends) the interval= / strict=
+// parameters set in opt; other parameters keep their order and spacing.
+func setManagedConfigParams(line string, opt ManagedConfigOptions) string {
+	if opt.IntervalSec > 0 {
+		line = setLineParam(line, "interval", strconv.Itoa(opt.IntervalSec))
+	}
+	if opt.HasStrict {
+		line = setLineParam(line, "strict", strconv.FormatBool(opt.Strict))
+	}
+	return line
+}
+
+func setLineParam(line, key, value string) string {
+	// Tokens are scanned with their offsets so the URL (which may itself
+	// contain "key=") is never touched.
+	tok := 0
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(line) && line[j] != ' ' && line[j] != '\t' {
+			j++
+		}
+		// Token 0 is the prefix and token 1 the URL; parameters follow.
+		if tok >= 2 {
+			if k, _, ok := strings.Cut(line[i:j], "="); ok && strings.EqualFold(k, key) {
+				return line[:i] + key + "=" + value + line[j:]
+			}
+		}
+		tok++
+		i = j
+	}
+	return strings.TrimRight(line, " \t") + " " + key + "=" + value
+}
+
 func managedConfigAmbiguous(templateURL, msg string) error {
 	return &TemplateError{
 		AppError: model.AppError{
@@ -99,3 +99,77 @@ func TestEnsureSurgeManagedConfig_MissingURL_Error(t *testing.T) {
 		t.Fatalf("code=%q, want=%q", te.AppError.Code, "TEMPLATE_SECTION_ERROR")
 	}
 }
+
+func TestEnsureSurgeManagedConfigWithOptions_Insert(t *testing.T) {
+	out, err := EnsureSurgeManagedConfigWithOptions("[General]\n", "http://example.com/sub?x=1", "https://example.com/surge.conf", ManagedConfigOptions{IntervalSec: 3600, Strict: true, HasStrict: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "#!MANAGED-CONFIG http://example.com/sub?x=1 interval=3600 strict=true\n[General]\n"; out != want {
+		t.Fatalf("got:\n%q\nwant:\n%q", out, want)
+	}
+}
+
+func TestEnsureSurgeManagedConfigWithOptions_OverrideParams(t *testing.T) {
+	in := "" +
+		"#!MANAGED-CONFIG http://old/sub interval=123  foo=bar\n" +
+		"[General]\n"
+
+	// The URL itself contains "interval=" (a var) and must not be touched.
+	out, err := EnsureSurgeManagedConfigWithOptions(in, "http://new/sub?var.interval=5", "https://example.com/surge.conf", ManagedConfigOptions{IntervalSec: 600, HasStrict: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(out, "#!MANAGED-CONFIG http://new/sub?var.interval=5 interval=600  foo=bar strict=false\n") {
+		t.Fatalf("managed-config params not rewritten, got:\n%s", out)
+	}
+}
+
+func TestEnsureShadowrocketUpdateURL(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "insert after [General]",
+			in:   "[general]\nloglevel = notify\n\n[Rule]\nFINAL,DIRECT\n",
+			want: "[general]\nupdate-url = http://new/sub\nloglevel = notify\n\n[Rule]\nFINAL,DIRECT\n",
+		},
+		{
+			name: "rewrite existing",
+			in:   "[General]\n  update-url=http://old/sub\n[Rule]\nupdate-url = kept\n",
+			want: "[General]\n  update-url = http://new/sub\n[Rule]\nupdate-url = kept\n",
+		},
+		{
+			name: "prepend [General]",
+			in:   "[Rule]\r\nFINAL,DIRECT\r\n",
+			want: "[General]\r\nupdate-url = http://new/sub\r\n\r\n[Rule]\r\nFINAL,DIRECT\r\n",
+		},
+	}
+	for _, tc := range cases {
+		out, err := EnsureShadowrocketUpdateURL(tc.in, "http://new/sub", "https://example.com/sr.conf")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if out != tc.want {
+			t.Fatalf("%s: got:\n%q\nwant:\n%q", tc.name, out, tc.want)
+		}
+	}
+}
+
+func TestEnsureShadowrocketUpdateURL_Duplicate_Error(t *testing.T) {
+	in := "" +
+		"[General]\n" +
+		"update-url = http://a/sub\n" +
+		"update-url = http://b/sub\n"
+
+	_, err := EnsureShadowrocketUpdateURL(in, "http://new/sub", "https://example.com/sr.conf")
+	var te *TemplateError
+	if !errors.As(err, &te) {
+		t.Fatalf("expected *TemplateError, got %T: %v", err, err)
+	}
+	if te.AppError.Code != "TEMPLATE_SECTION_ERROR" {
+		t.Fatalf("code=%q, want=%q", te.AppError.Code, "TEMPLATE_SECTION_ERROR")
+	}
+}